@@ -11,12 +11,15 @@ import (
 	"fmt"
 	"strings"
 	"syscall/js"
+	"time"
 
 	// For sqlite3.Conn type
 	sqlite3driver "github.com/ncruces/go-sqlite3/driver" // Named import for driver.Conn interface
 	_ "github.com/ncruces/go-sqlite3/embed"              // Embeds the SQLite WASM for go-sqlite3
 	serdes "github.com/ncruces/go-sqlite3/ext/serdes"    // Named import for serdes
 	"github.com/ncruces/go-sqlite3/vfs/memdb"
+
+	"gnolledgegraph/internal/db/schema"
 )
 
 var (
@@ -32,29 +35,10 @@ func initializeSchemaInternal() error {
 		return fmt.Errorf("database not initialized")
 	}
 
-	schema := `
-        CREATE TABLE IF NOT EXISTS entities (
-            name TEXT PRIMARY KEY,
-            entity_type TEXT NOT NULL
-        );
-        CREATE TABLE IF NOT EXISTS observations (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            entity_name TEXT NOT NULL,
-            content TEXT NOT NULL,
-            FOREIGN KEY(entity_name) REFERENCES entities(name) ON DELETE CASCADE
-        );
-        CREATE TABLE IF NOT EXISTS relations (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            from_entity TEXT NOT NULL,
-            to_entity TEXT NOT NULL,
-            relation_type TEXT NOT NULL,
-            FOREIGN KEY(from_entity) REFERENCES entities(name) ON DELETE CASCADE,
-            FOREIGN KEY(to_entity) REFERENCES entities(name) ON DELETE CASCADE
-        );
-    `
-	_, err := db.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("failed to create/check schema: %w", err)
+	// Apply the same versioned migrations as the server so that databases
+	// synced in either direction share one schema definition.
+	if err := schema.Migrate(db); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
 	}
 	fmt.Println("Go: Knowledge graph schema checked/created.")
 	return nil
@@ -161,7 +145,7 @@ func createEntity(this js.Value, args []js.Value) any {
 	defer tx.Rollback()
 
 	var exists int
-	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ?", payload.Name).Scan(&exists)
+	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ? AND deleted_at IS NULL", payload.Name).Scan(&exists)
 	if err != nil && err != sql.ErrNoRows {
 		return makeResult(nil, fmt.Errorf("failed to check if entity exists: %w", err), args[0])
 	}
@@ -169,6 +153,12 @@ func createEntity(this js.Value, args []js.Value) any {
 		return makeResult(nil, fmt.Errorf("entity '%s' already exists. Entity names must be unique", payload.Name), args[0])
 	}
 
+	// A deleted entity of the same name is removed for good to make room.
+	_, err = tx.Exec("DELETE FROM entities WHERE name = ? AND deleted_at IS NOT NULL", payload.Name)
+	if err != nil {
+		return makeResult(nil, fmt.Errorf("failed to purge deleted entity: %w", err), args[0])
+	}
+
 	_, err = tx.Exec("INSERT INTO entities (name, entity_type) VALUES (?, ?)", payload.Name, payload.Type)
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to insert entity: %w", err), args[0])
@@ -214,7 +204,7 @@ func createRelation(this js.Value, args []js.Value) any {
 	defer tx.Rollback()
 
 	var fromExists, toExists int
-	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ?", payload.FromEntity).Scan(&fromExists)
+	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ? AND deleted_at IS NULL", payload.FromEntity).Scan(&fromExists)
 	if err != nil && err != sql.ErrNoRows {
 		return makeResult(nil, fmt.Errorf("error checking 'from' entity: %w", err), args[0])
 	}
@@ -222,7 +212,7 @@ func createRelation(this js.Value, args []js.Value) any {
 		return makeResult(nil, fmt.Errorf("'From' entity '%s' does not exist", payload.FromEntity), args[0])
 	}
 
-	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ?", payload.ToEntity).Scan(&toExists)
+	err = tx.QueryRow("SELECT 1 FROM entities WHERE name = ? AND deleted_at IS NULL", payload.ToEntity).Scan(&toExists)
 	if err != nil && err != sql.ErrNoRows {
 		return makeResult(nil, fmt.Errorf("error checking 'to' entity: %w", err), args[0])
 	}
@@ -258,7 +248,7 @@ func addObservation(this js.Value, args []js.Value) any {
 	}
 
 	var entityExists int
-	err = db.QueryRow("SELECT 1 FROM entities WHERE name = ?", payload.EntityName).Scan(&entityExists)
+	err = db.QueryRow("SELECT 1 FROM entities WHERE name = ? AND deleted_at IS NULL", payload.EntityName).Scan(&entityExists)
 	if err != nil && err != sql.ErrNoRows {
 		return makeResult(nil, fmt.Errorf("error checking entity for observation: %w", err), args[0])
 	}
@@ -298,7 +288,7 @@ type GraphData struct {
 func getGraphData(this js.Value, args []js.Value) any {
 	var graph GraphData
 
-	rows, err := db.Query("SELECT name, entity_type FROM entities ORDER BY name")
+	rows, err := db.Query("SELECT name, entity_type FROM entities WHERE deleted_at IS NULL ORDER BY name")
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to query entities: %w", err))
 	}
@@ -313,7 +303,7 @@ func getGraphData(this js.Value, args []js.Value) any {
 	}
 	rows.Close()
 
-	rows, err = db.Query("SELECT id, from_entity, to_entity, relation_type FROM relations ORDER BY id")
+	rows, err = db.Query("SELECT id, from_entity, to_entity, relation_type FROM relations WHERE deleted_at IS NULL ORDER BY id")
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to query relations: %w", err))
 	}
@@ -328,7 +318,7 @@ func getGraphData(this js.Value, args []js.Value) any {
 	}
 	rows.Close()
 
-	rows, err = db.Query("SELECT id, entity_name, content FROM observations ORDER BY entity_name, id")
+	rows, err = db.Query("SELECT id, entity_name, content FROM observations WHERE deleted_at IS NULL ORDER BY entity_name, id")
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to query observations: %w", err))
 	}
@@ -362,10 +352,11 @@ func searchNodes(this js.Value, args []js.Value) any {
 	rows, err := db.Query(`
         SELECT DISTINCT e.name, e.entity_type
         FROM entities e
-        LEFT JOIN observations o ON e.name = o.entity_name
-        WHERE LOWER(e.name) LIKE ?
+        LEFT JOIN observations o ON e.name = o.entity_name AND o.deleted_at IS NULL
+        WHERE e.deleted_at IS NULL
+          AND (LOWER(e.name) LIKE ?
            OR LOWER(e.entity_type) LIKE ?
-           OR LOWER(o.content) LIKE ?
+           OR LOWER(o.content) LIKE ?)
         ORDER BY e.name
     `, searchPattern, searchPattern, searchPattern)
 	if err != nil {
@@ -394,7 +385,7 @@ func searchNodes(this js.Value, args []js.Value) any {
 		query := fmt.Sprintf(`
             SELECT id, from_entity, to_entity, relation_type
             FROM relations
-            WHERE from_entity IN (%s) OR to_entity IN (%s)
+            WHERE (from_entity IN (%s) OR to_entity IN (%s)) AND deleted_at IS NULL
             ORDER BY id
         `, placeholders, placeholders)
 
@@ -442,7 +433,7 @@ func openNodes(this js.Value, args []js.Value) any {
 	placeholders := strings.Join(qMarks, ",")
 
 	var entities []Entity
-	queryEntities := fmt.Sprintf("SELECT name, entity_type FROM entities WHERE name IN (%s) ORDER BY name", placeholders)
+	queryEntities := fmt.Sprintf("SELECT name, entity_type FROM entities WHERE name IN (%s) AND deleted_at IS NULL ORDER BY name", placeholders)
 	rows, err := db.Query(queryEntities, interfaceSlice...)
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to query entities for openNodes: %w", err), args[0])
@@ -470,7 +461,7 @@ func openNodes(this js.Value, args []js.Value) any {
 		queryRelations := fmt.Sprintf(`
             SELECT id, from_entity, to_entity, relation_type
             FROM relations
-            WHERE from_entity IN (%s) OR to_entity IN (%s)
+            WHERE (from_entity IN (%s) OR to_entity IN (%s)) AND deleted_at IS NULL
             ORDER BY id
         `, relPlaceholders, relPlaceholders)
 		relRows, errRel := db.Query(queryRelations, allArgs...)
@@ -491,6 +482,12 @@ func openNodes(this js.Value, args []js.Value) any {
 	return makeResult(map[string]any{"graphData": GraphData{Entities: entities, Relations: relations, Observations: []Observation{}}}, nil)
 }
 
+// deletionTime is the deleted_at value for tombstones, in the server's
+// timestamp format.
+func deletionTime() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
 type DeleteEntitiesPayload struct {
 	EntityNames []string `json:"entityNames"`
 }
@@ -520,21 +517,26 @@ func deleteEntities(this js.Value, args []js.Value) any {
 		interfaceSlice = append(interfaceSlice, name)
 	}
 
-	allArgsRelations := append(interfaceSlice, interfaceSlice...)
-	queryRels := fmt.Sprintf("DELETE FROM relations WHERE from_entity IN (%s) OR to_entity IN (%s)", qMarks, qMarks)
+	// Deleted rows are kept as tombstones, sharing one timestamp, so the
+	// server can restore them once the database is synced.
+	deletedAt := deletionTime()
+	tombstoneArgs := append([]any{deletedAt}, interfaceSlice...)
+
+	allArgsRelations := append(append([]any{deletedAt}, interfaceSlice...), interfaceSlice...)
+	queryRels := fmt.Sprintf("UPDATE relations SET deleted_at = ? WHERE (from_entity IN (%s) OR to_entity IN (%s)) AND deleted_at IS NULL", qMarks, qMarks)
 	_, err = tx.Exec(queryRels, allArgsRelations...)
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to delete relations for entities: %w", err), args[0])
 	}
 
-	queryObs := fmt.Sprintf("DELETE FROM observations WHERE entity_name IN (%s)", qMarks)
-	_, err = tx.Exec(queryObs, interfaceSlice...)
+	queryObs := fmt.Sprintf("UPDATE observations SET deleted_at = ? WHERE entity_name IN (%s) AND deleted_at IS NULL", qMarks)
+	_, err = tx.Exec(queryObs, tombstoneArgs...)
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to delete observations for entities: %w", err), args[0])
 	}
 
-	queryEnt := fmt.Sprintf("DELETE FROM entities WHERE name IN (%s)", qMarks)
-	_, err = tx.Exec(queryEnt, interfaceSlice...)
+	queryEnt := fmt.Sprintf("UPDATE entities SET deleted_at = ? WHERE name IN (%s) AND deleted_at IS NULL", qMarks)
+	_, err = tx.Exec(queryEnt, tombstoneArgs...)
 	if err != nil {
 		return makeResult(nil, fmt.Errorf("failed to delete entities: %w", err), args[0])
 	}
@@ -575,9 +577,10 @@ func deleteRelations(this js.Value, args []js.Value) any {
 	defer tx.Rollback()
 
 	deletedCount := 0
+	deletedAt := deletionTime()
 	for _, rel := range payload.Relations {
-		_, err = tx.Exec("DELETE FROM relations WHERE from_entity = ? AND to_entity = ? AND relation_type = ?",
-			rel.From, rel.To, rel.RelationType)
+		_, err = tx.Exec("UPDATE relations SET deleted_at = ? WHERE from_entity = ? AND to_entity = ? AND relation_type = ? AND deleted_at IS NULL",
+			deletedAt, rel.From, rel.To, rel.RelationType)
 		if err != nil {
 			return makeResult(nil, fmt.Errorf("failed to delete relation (%s-%s->%s): %w", rel.From, rel.RelationType, rel.To, err), args[0])
 		}
@@ -618,18 +621,19 @@ func deleteObservations(this js.Value, args []js.Value) any {
 	defer tx.Rollback()
 
 	var firstEntityName string
+	deletedAt := deletionTime()
 	for i, del := range payload.Deletions {
 		if i == 0 {
 			firstEntityName = del.EntityName
 		}
 		if len(del.Observations) > 0 {
 			qMarks := strings.Repeat("?,", len(del.Observations)-1) + "?"
-			argsForExec := make([]any, 0, len(del.Observations)+1)
-			argsForExec = append(argsForExec, del.EntityName)
+			argsForExec := make([]any, 0, len(del.Observations)+2)
+			argsForExec = append(argsForExec, deletedAt, del.EntityName)
 			for _, obsContent := range del.Observations {
 				argsForExec = append(argsForExec, obsContent)
 			}
-			query := fmt.Sprintf("DELETE FROM observations WHERE entity_name = ? AND content IN (%s)", qMarks)
+			query := fmt.Sprintf("UPDATE observations SET deleted_at = ? WHERE entity_name = ? AND content IN (%s) AND deleted_at IS NULL", qMarks)
 			_, err = tx.Exec(query, argsForExec...)
 			if err != nil {
 				return makeResult(nil, fmt.Errorf("failed to delete observations for '%s': %w", del.EntityName, err), args[0])
@@ -728,6 +732,12 @@ func importDB(this js.Value, args []js.Value) any {
 		return makeResult(nil, fmt.Errorf("failed to ping imported database: %w", err))
 	}
 
+	// Upgrade databases exported by older servers and reject newer ones.
+	err = initializeSchemaInternal()
+	if err != nil {
+		return makeResult(nil, fmt.Errorf("imported database schema: %w", err))
+	}
+
 	fmt.Println("Go: importDB successful.")
 	return makeResult(map[string]any{"dbKeyName": currentDbName}, nil)
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"gnolledgegraph/internal/db"
+)
+
+// runDiff implements `knowledge-graph diff`. Each side is a database file,
+// a JSON export, or a snapshot name or time of the --db-path database;
+// "live" (the default for the second side) is its current graph.
+func runDiff(args []string) error {
+	fset := flag.NewFlagSet("diff", flag.ExitOnError)
+	dbPath := fset.String("db-path", "kg.db", "path to sqlite database for live, snapshot and time arguments")
+	asJSON := fset.Bool("json", false, "print the diff as JSON instead of a text report")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff [flags] <from> [<to>]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Each side is a .db file, a JSON export, a snapshot name, a time, or \"live\" (default for <to>).\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fset.PrintDefaults()
+	}
+	fset.Parse(args)
+	if fset.NArg() < 1 || fset.NArg() > 2 {
+		fset.Usage()
+		return fmt.Errorf("diff needs one or two graphs to compare")
+	}
+	to := "live"
+	if fset.NArg() == 2 {
+		to = fset.Arg(1)
+	}
+
+	// The database is only opened when a side refers to it
+	var sqldb *sql.DB
+	load := func(arg string) (*db.Graph, error) {
+		if _, err := os.Stat(arg); err == nil {
+			data, err := os.ReadFile(arg)
+			if err != nil {
+				return nil, err
+			}
+			g, err := db.ParseGraph(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			return g, nil
+		}
+		if sqldb == nil {
+			var err error
+			if sqldb, err = db.Init(*dbPath); err != nil {
+				return nil, err
+			}
+		}
+		if arg == "live" {
+			arg = ""
+		}
+		return db.GraphAsOf(sqldb, arg)
+	}
+	defer func() {
+		if sqldb != nil {
+			sqldb.Close()
+		}
+	}()
+
+	fromGraph, err := load(fset.Arg(0))
+	if err != nil {
+		return err
+	}
+	toGraph, err := load(to)
+	if err != nil {
+		return err
+	}
+
+	diff := db.DiffGraphs(fromGraph, toGraph)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	fmt.Print(diff.Report())
+	return nil
+}
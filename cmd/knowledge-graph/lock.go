@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"gnolledgegraph/internal/mcp"
+)
+
+// A server with an HTTP listener records where it can be reached in a
+// lock file next to its database, so that stdio processes started with
+// --connect auto find it instead of opening the database themselves. It
+// creates the lock before opening the database, with its process ID
+// only, and adds the URL once it listens.
+
+const (
+	// serverStartTimeout is how long --connect auto waits for the server
+	// it started, which may be migrating the database.
+	serverStartTimeout = 30 * time.Second
+	// serverProbeTimeout bounds checking that a recorded server answers.
+	serverProbeTimeout = 2 * time.Second
+)
+
+// serverLock is the content of the lock file.
+type serverLock struct {
+	PID int    `json:"pid"`
+	URL string `json:"url"`
+}
+
+func lockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// claimLock creates the lock file of dbPath for this process, so that of
+// two servers started at once only one opens the database. If another
+// server holds it, claimLock waits for that server to be up and returns
+// its lock with claimed false. A lock left by a process that died, or by
+// one that does not come up in time, is replaced.
+func claimLock(dbPath string) (lock serverLock, claimed bool, err error) {
+	for {
+		f, err := os.OpenFile(lockPath(dbPath), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			lock = serverLock{PID: os.Getpid()}
+			data, _ := json.Marshal(lock)
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lockPath(dbPath))
+				return serverLock{}, false, err
+			}
+			return lock, true, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return serverLock{}, false, err
+		}
+		if lock, ok := awaitServer(dbPath); ok {
+			return lock, false, nil
+		}
+		if err := os.Remove(lockPath(dbPath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return serverLock{}, false, err
+		}
+	}
+}
+
+// writeLock records this process as the server of dbPath, reachable at
+// mcpURL.
+func writeLock(dbPath, mcpURL string) error {
+	data, err := json.Marshal(serverLock{PID: os.Getpid(), URL: mcpURL})
+	if err != nil {
+		return err
+	}
+	// Readers never see a half-written file
+	tmp := lockPath(dbPath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, lockPath(dbPath))
+}
+
+// removeLock removes the lock file if this process wrote it.
+func removeLock(dbPath string) {
+	if lock, err := readLock(dbPath); err == nil && lock.PID == os.Getpid() {
+		os.Remove(lockPath(dbPath))
+	}
+}
+
+func readLock(dbPath string) (serverLock, error) {
+	var lock serverLock
+	data, err := os.ReadFile(lockPath(dbPath))
+	if err != nil {
+		return lock, err
+	}
+	return lock, json.Unmarshal(data, &lock)
+}
+
+// runningServer returns the lock of the server of dbPath if it is up. A
+// lock left behind by a server that died is ignored, and so is one whose
+// port now belongs to something else.
+func runningServer(dbPath string) (serverLock, bool) {
+	lock, err := readLock(dbPath)
+	if err != nil || lock.URL == "" || !processAlive(lock.PID) {
+		return lock, false
+	}
+	return lock, answers(lock.URL)
+}
+
+// awaitServer is runningServer for a server that may be starting: while
+// the lock has no URL yet, or cannot be read because it is being
+// written, it waits up to serverStartTimeout for the URL.
+func awaitServer(dbPath string) (serverLock, bool) {
+	deadline := time.Now().Add(serverStartTimeout)
+	for {
+		lock, err := readLock(dbPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return lock, false
+		case err == nil && !processAlive(lock.PID):
+			return lock, false
+		case err == nil && lock.URL != "":
+			return lock, answers(lock.URL)
+		case time.Now().After(deadline):
+			return lock, false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// answers reports whether this server answers initialize at url.
+func answers(url string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), serverProbeTimeout)
+	defer cancel()
+	client, err := mcp.DialHTTP(ctx, url, mcp.ClientOptions{ClientInfo: mcp.ClientInfo{Name: "knowledge-graph-probe", Version: "1.0.0"}})
+	if err != nil {
+		return false
+	}
+	defer client.Close()
+	return client.Server().ServerInfo.Name == mcp.ServerName
+}
+
+// connectURL resolves --connect to the MCP endpoint to forward stdio to.
+// A server URL without a path gets /mcp. "auto" finds the server of
+// dbPath from its lock file, or starts one with serverArgs and waits for
+// it.
+func connectURL(connect, dbPath string, serverArgs []string) (string, error) {
+	if connect != "auto" {
+		endpoint, err := mcp.EndpointURL(connect)
+		if err != nil {
+			return "", fmt.Errorf("--connect wants an http(s) URL or auto: %v", err)
+		}
+		return endpoint, nil
+	}
+	if lock, ok := awaitServer(dbPath); ok {
+		return lock.URL, nil
+	}
+	return startServer(dbPath, serverArgs)
+}
+
+// startServer starts a server for dbPath in the background, logging to a
+// file next to the database, and returns its MCP endpoint once it is up.
+// The server keeps running after this process exits.
+func startServer(dbPath string, serverArgs []string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	logPath := dbPath + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, serverArgs...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("starting %s: %v", filepath.Base(exe), err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.After(serverStartTimeout)
+	for {
+		if lock, ok := runningServer(dbPath); ok {
+			return lock.URL, nil
+		}
+		select {
+		case err := <-exited:
+			// Another process may have started a server at the same time
+			if lock, ok := runningServer(dbPath); ok {
+				return lock.URL, nil
+			}
+			return "", fmt.Errorf("the server exited (%v); see %s", err, logPath)
+		case <-deadline:
+			return "", fmt.Errorf("the server did not start within %s; see %s", serverStartTimeout, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"embed"
 	"io/fs"
@@ -33,7 +39,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow any origin
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Mcp-Session-Id, MCP-Protocol-Version, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id")
 
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
@@ -45,6 +52,33 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// provenanceMiddleware attributes writes made while serving a request to
+// the caller: the HTTP basic auth user, or a fingerprint of the API key sent
+// as a bearer token or X-API-Key. Keys themselves are never stored.
+func provenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := requestActor(r); actor != "" {
+			r = r.WithContext(db.WithProvenance(r.Context(), db.Provenance{Actor: actor}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestActor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])[:12]
+}
+
 func init() {
 	// serve .wasm with the proper MIME type for instantiateStreaming()
 	mime.AddExtensionType(".wasm", "application/wasm")
@@ -52,65 +86,159 @@ func init() {
 	mime.AddExtensionType(".js", "application/javascript")
 }
 
-// handleStdioMCP handles MCP communication over stdin/stdout
-func handleStdioMCP(database *sql.DB) {
-	scanner := bufio.NewScanner(os.Stdin)
-	encoder := json.NewEncoder(os.Stdout)
+// serveStdio serves one MCP client on stdin and stdout until stdin is
+// closed or ctx is done.
+func serveStdio(ctx context.Context, database *sql.DB) {
+	if err := mcp.ServeStdio(ctx, database, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+		log.Printf("stdio MCP: %v", err)
+	}
+	log.Printf("stdio MCP transport closed")
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+// forwardStdio proxies stdio MCP to the server at url until stdin is
+// closed or the process is interrupted.
+func forwardStdio(url string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("forwarding stdio MCP to %s", url)
+	if err := mcp.ProxyStdio(ctx, url, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+		log.Printf("stdio MCP: %v", err)
+	}
+}
 
-		var req mcp.JSONRPCRequest
-		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			log.Printf("stdio MCP: invalid JSON: %v", err)
-			continue
-		}
+// stringsFlag collects the values of a flag given several times.
+type stringsFlag []string
 
-		// Process the request using existing MCP handler logic
-		// req.ID will be nil if the original request JSON had no "id" or "id": null.
-		// Such requests are Notifications as per JSON-RPC 2.0.
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
 
-		// Only generate a response if it's not a notification.
-		// However, HandleJSONRPCMethod is designed to always return a response structure.
-		// The decision to send it back should be here.
-		if req.ID != nil {
-			response := mcp.HandleJSONRPCMethod(database, req)
-			if err := encoder.Encode(response); err != nil {
-				log.Printf("stdio MCP: failed to encode response: %v", err)
+// serverArgs returns the flags for a server started by --connect auto: the
+// ones set on the command line, without the stdio ones.
+func serverArgs(dbPath string) []string {
+	args := []string{"--enable-stdio=false", "--db-path", dbPath}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "connect", "enable-stdio", "stdio-only", "db-path", "ignore-running-server":
+			return
+		}
+		if values, ok := f.Value.(*stringsFlag); ok {
+			for _, v := range *values {
+				args = append(args, "--"+f.Name+"="+v)
 			}
-		} else {
-			// It's a notification, do not send a response.
-			// Optionally, log that a notification was received and processed if needed.
-			// log.Printf("stdio MCP: received notification, method: %s, no response sent", req.Method)
-			// Depending on whether methods invoked by notifications are expected to do something,
-			// you might still call a handler but just not send the JSONRPCResponse.
-			// For now, we assume HandleJSONRPCMethod might have side effects even for notifications
-			// if specific methods are designed that way, but no JSON response is sent back.
-			// If methods called via notification should truly do nothing or are not expected,
-			// then mcp.HandleJSONRPCMethod(database, req) could also be inside the if req.ID != nil block.
-			// Let's assume for now that some processing might occur, but no response.
-			// To be safe and ensure methods are still called if they are notifications:
-			_ = mcp.HandleJSONRPCMethod(database, req) // Process but discard response for notifications
+			return
 		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// removeLockOnSignal removes the lock file of dbPath and exits when the
+// process is interrupted or terminated.
+func removeLockOnSignal(dbPath string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	removeLock(dbPath)
+	os.Exit(1)
+}
+
+// purgeInterval is how often tombstones past the purge window are removed.
+const purgeInterval = time.Hour
+
+// purgeTombstones permanently removes deleted rows once they are older than
+// purgeAfter, at startup and then every purgeInterval.
+func purgeTombstones(database *sql.DB, purgeAfter time.Duration) {
+	ctx := db.WithProvenance(context.Background(), db.Provenance{Source: "purge"})
+	for {
+		n, err := db.PurgeTombstones(ctx, database, time.Now().Add(-purgeAfter))
+		if err != nil {
+			log.Printf("purging deleted entities: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted rows older than %s", n, purgeAfter)
+		}
+		time.Sleep(purgeInterval)
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("stdio MCP: scanner error: %v", err)
+// takeSnapshots takes an automatic snapshot whenever the latest one is
+// older than every, and prunes automatic snapshots older than keep (0
+// keeps them forever).
+func takeSnapshots(database *sql.DB, every, keep time.Duration) {
+	ctx := db.WithProvenance(context.Background(), db.Provenance{Source: "snapshot"})
+	for {
+		latest, err := db.LatestAutomaticSnapshot(database)
+		if err != nil {
+			log.Printf("reading snapshots: %v", err)
+		} else if time.Since(latest) >= every {
+			if s, err := db.CreateSnapshot(ctx, database, ""); err != nil {
+				log.Printf("taking automatic snapshot: %v", err)
+			} else {
+				log.Printf("took automatic snapshot %s", s.Name)
+			}
+		}
+		if keep > 0 {
+			if _, err := db.PruneSnapshots(ctx, database, time.Now().Add(-keep)); err != nil {
+				log.Printf("pruning automatic snapshots: %v", err)
+			}
+		}
+		wait := every
+		if wait > purgeInterval {
+			wait = purgeInterval
+		}
+		time.Sleep(wait)
 	}
 }
 
 func main() {
+	// subcommands
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := runSnapshot(os.Args[2:]); err != nil {
+			log.Fatalf("snapshot: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:]); err != nil {
+			log.Fatalf("diff: %v", err)
+		}
+		return
+	}
 
 	// flags
 	port := flag.Int("port", 8080, "HTTP port")
 	dbPath := flag.String("db-path", "kg.db", "path to sqlite database")
 	enableStdio := flag.Bool("enable-stdio", true, "enable stdio MCP transport alongside HTTP server")
+	stdioOnly := flag.Bool("stdio-only", false, "serve MCP on stdin and stdout only, without the HTTP server, and exit when stdin is closed")
+	ignoreRunning := flag.Bool("ignore-running-server", false, "open --db-path even when another server is serving it; by default stdio MCP is forwarded to that server, and without stdio the server refuses to start")
+	connect := flag.String("connect", "", "forward stdio MCP to the server at this URL instead of opening --db-path, or \"auto\" for the server running on --db-path, starting one if there is none")
+	legacySSE := flag.Bool("legacy-sse", false, "also serve the old HTTP+SSE MCP transport at /sse and /messages for clients without Streamable HTTP support")
+	promptsDir := flag.String("prompts-dir", "", "directory of JSON MCP prompt templates to offer next to the built-in prompts")
+	confirmDeletesOver := flag.Int("confirm-deletes-over", mcp.DefaultConfirmThreshold, "ask MCP clients that support elicitation to confirm deletes removing more than this many entities, observations and relations (-1 never asks)")
+	purgeAfter := flag.Duration("purge-after", db.DefaultPurgeAfter, "how long deleted entities stay restorable before they are purged (0 keeps them forever)")
+	snapshotEvery := flag.Duration("snapshot-every", 24*time.Hour, "how often to take an automatic snapshot of the graph (0 disables); automatic snapshots are kept for --purge-after")
+	var federate stringsFlag
+	flag.Var(&federate, "federate", "also answer MCP search_nodes and open_nodes from the memory server at name=URL or name=command (repeatable); writes stay local")
+	localOrigin := flag.String("federation-name", mcp.DefaultLocalOrigin, "name tagging this server's entities in federated results")
+	slowQuery := flag.Duration("slow-query", db.DefaultSlowQueryThreshold, "log statements taking longer than this as slow queries, to stderr and MCP clients that asked for logs (negative disables)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s migrate status|up|down [--db-path path] [--to version]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s snapshot list|create|restore [--db-path path] [name]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s diff [--db-path path] [--json] <from> [<to>]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Runs the Knowledge Graph server, providing dual API access:\n")
 		fmt.Fprintf(os.Stderr, "  - Original Go API: mounted at /api/\n")
 		fmt.Fprintf(os.Stderr, "  - Python FastAPI Compatibility API: mounted at / (root)\n\n")
@@ -119,11 +247,83 @@ func main() {
 	}
 	flag.Parse()
 
+	// Proxies leave the database to the server they forward to
+	if *connect != "" {
+		url, err := connectURL(*connect, *dbPath, serverArgs(*dbPath))
+		if err != nil {
+			log.Fatalf("connect: %v", err)
+		}
+		forwardStdio(url)
+		return
+	}
+	// One server per database: a second one shares the first. A server
+	// with an HTTP listener takes the lock before opening the database,
+	// so that of two started at once only one opens it
+	var lock serverLock
+	var shared bool
+	if *stdioOnly {
+		lock, shared = awaitServer(*dbPath)
+	} else {
+		var claimed bool
+		var err error
+		lock, claimed, err = claimLock(*dbPath)
+		if err != nil {
+			log.Printf("creating %s: %v", lockPath(*dbPath), err)
+		}
+		shared = !claimed && err == nil
+		if claimed {
+			go removeLockOnSignal(*dbPath)
+		}
+	}
+	if shared && !*ignoreRunning {
+		if !*stdioOnly && !*enableStdio {
+			log.Fatalf("%s is already served by process %d at %s; use --ignore-running-server to open it anyway", *dbPath, lock.PID, lock.URL)
+		}
+		log.Printf("%s is already served by process %d", *dbPath, lock.PID)
+		forwardStdio(lock.URL)
+		return
+	}
+	if shared {
+		log.Printf("warning: %s is also served by process %d at %s, which keeps its lock file", *dbPath, lock.PID, lock.URL)
+	}
+
 	// 1) init sqlite + schema
+	db.SetSlowQueryThreshold(*slowQuery)
 	sqldb, err := db.Init(*dbPath)
 	if err != nil {
 		log.Fatalf("db.Init: %v", err)
 	}
+	if *purgeAfter > 0 {
+		go purgeTombstones(sqldb, *purgeAfter)
+	}
+	if *snapshotEvery > 0 {
+		go takeSnapshots(sqldb, *snapshotEvery, *purgeAfter)
+	}
+	if *promptsDir != "" {
+		mcp.SetPromptsDir(*promptsDir)
+	}
+	mcp.SetConfirmThreshold(*confirmDeletesOver)
+	mcp.SetLocalOrigin(*localOrigin)
+	for _, remote := range federate {
+		name, target, ok := strings.Cut(remote, "=")
+		if !ok {
+			log.Fatalf("--federate wants name=URL or name=command, got %q", remote)
+		}
+		if err := mcp.Federate(name, target); err != nil {
+			log.Fatalf("--federate: %v", err)
+		}
+	}
+
+	if *stdioOnly {
+		// Requests being handled are cancelled on interrupt, then the
+		// database is closed
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("starting stdio MCP transport without HTTP server")
+		serveStdio(ctx, sqldb)
+		sqldb.Close()
+		return
+	}
 
 	// setup embedded static assets for frontend
 	staticFiles, err := fs.Sub(embeddedWebFS, "web")
@@ -141,12 +341,14 @@ func main() {
 	http.Handle("/api/", api.NewHandler(sqldb, *dbPath))
 
 	// 4) mount MCP endpoints according to MCP specification
-	mcpHandler := mcp.NewMCPHandler(sqldb)
-	http.Handle("/sse", mcpHandler)      // SSE connection endpoint
-	http.Handle("/messages", mcpHandler) // POST messages endpoint
+	http.Handle("/mcp", mcp.NewStreamableHandler(sqldb)) // Streamable HTTP
 
 	// Legacy endpoints for backward compatibility
-	http.Handle("/mcp", mcpHandler) // Legacy combined endpoint
+	if *legacySSE {
+		sseHandler := mcp.NewMCPHandler(sqldb)
+		http.Handle("/sse", sseHandler)      // SSE connection endpoint
+		http.Handle("/messages", sseHandler) // POST messages endpoint
+	}
 	http.Handle("/mcp/legacy", mcp.NewHandler(sqldb))
 
 	// 5) serve generated OpenAPI JSON
@@ -160,52 +362,56 @@ func main() {
 		_, _ = w.Write(data)
 	})
 
-	// 6) start stdio MCP transport
-	// If enableStdio is true, this will be the main blocking call if the HTTP server
-	// is not started or fails.
-	if *enableStdio {
-		log.Printf("starting stdio MCP transport")
-		// If only stdio is desired, the HTTP server part below can be skipped
-		// or made conditional based on another flag.
-		// For now, we'll allow both but ensure stdio can run even if HTTP fails.
-		go handleStdioMCP(sqldb) // Run stdio handler in a goroutine to allow HTTP server to also start
-	}
-
-	// 7) start HTTP server
+	// 6) start HTTP server
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("attempting to listen on %s for HTTP server", addr)
 
 	// Wrap DefaultServeMux with CORS middleware
-	handlerWithCors := corsMiddleware(http.DefaultServeMux)
+	handlerWithCors := corsMiddleware(provenanceMiddleware(http.DefaultServeMux))
 
-	// Start HTTP server. If --enable-stdio is the primary mode,
-	// an error here (like "address already in use") shouldn't kill the stdio transport.
-	err = http.ListenAndServe(addr, handlerWithCors)
+	// An error here (like "address already in use" when an MCP client
+	// launches a second instance) does not stop the stdio transport: the
+	// process forwards stdio to the server holding the lock, or keeps
+	// serving stdio itself until stdin is closed
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Printf("HTTP server ListenAndServe error: %v", err)
-		// If stdio is not enabled, this is a fatal error.
-		// If stdio IS enabled, the program might continue running for stdio.
-		// However, if stdio was also in a goroutine, the main thread needs to block.
-		// The original logic for stdio was to run it in a goroutine and then fatal on HTTP error.
-		// Let's adjust: if stdio is enabled, we don't fatal here.
-		// The stdio goroutine will keep the process alive.
-		// If stdio is NOT enabled, then this is a fatal error.
+		removeLock(*dbPath)
 		if !*enableStdio {
 			log.Fatalf("HTTP server failed to start and stdio not enabled: %v", err)
 		}
-		// If stdio is enabled, we log the error and the stdio goroutine (if started)
-		// will keep the application alive. If stdio was NOT started in a goroutine
-		// and was intended to be the main loop, this logic needs more refinement
-		// based on whether HTTP is primary or secondary.
-
-		// For the current problem: "address already in use" when launched by Claude for stdio.
-		// We want the stdio part to continue.
-		// The `handleStdioMCP` is now in a goroutine.
-		// If HTTP server fails, and stdio is enabled, we need main to not exit.
-		// A simple way is to block indefinitely if stdio is enabled and HTTP failed.
-		if *enableStdio {
-			log.Println("HTTP server failed to start, but stdio MCP is enabled and running. Process will remain alive for stdio.")
-			select {} // Block forever to keep stdio transport alive
+		if lock, ok := runningServer(*dbPath); ok {
+			log.Printf("HTTP server failed to start, %s is served by process %d: %v", *dbPath, lock.PID, err)
+			sqldb.Close()
+			forwardStdio(lock.URL)
+			return
+		}
+		log.Printf("HTTP server failed to start, serving stdio MCP only until stdin is closed: %v", err)
+		serveStdio(context.Background(), sqldb)
+		return
+	}
+	// Record where stdio proxies find this server
+	if !shared {
+		mcpURL := fmt.Sprintf("http://127.0.0.1:%d/mcp", listener.Addr().(*net.TCPAddr).Port)
+		if err := writeLock(*dbPath, mcpURL); err != nil {
+			log.Printf("writing %s: %v", lockPath(*dbPath), err)
 		}
 	}
+
+	// 7) start stdio MCP transport next to the HTTP server
+	stdioDone := make(chan struct{})
+	if *enableStdio {
+		log.Printf("starting stdio MCP transport")
+		go func() {
+			defer close(stdioDone)
+			serveStdio(context.Background(), sqldb)
+		}()
+	}
+
+	err = http.Serve(listener, handlerWithCors)
+	removeLock(*dbPath)
+	if !*enableStdio {
+		log.Fatalf("HTTP server stopped and stdio not enabled: %v", err)
+	}
+	log.Printf("HTTP server stopped, serving stdio MCP only until stdin is closed: %v", err)
+	<-stdioDone
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/db/schema"
+)
+
+// runMigrate implements `knowledge-graph migrate status|up|down`.
+func runMigrate(args []string) error {
+	fset := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbPath := fset.String("db-path", "kg.db", "path to sqlite database")
+	to := fset.Int("to", -1, "target schema version (default: latest for up, one step back for down)")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s migrate status|up|down [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fset.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing migrate command")
+	}
+	cmd := args[0]
+	fset.Parse(args[1:])
+
+	sqldb, err := db.Open(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer sqldb.Close()
+
+	current, err := schema.Version(sqldb)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "status":
+		fmt.Printf("database: %s\n", *dbPath)
+		fmt.Printf("current version: %d\n", current)
+		fmt.Printf("latest version:  %d\n", schema.Latest())
+		if current > schema.Latest() {
+			fmt.Println("warning: database is newer than this binary")
+		}
+		for _, m := range schema.Migrations {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("  %3d  %-8s %s\n", m.Version, state, m.Name)
+		}
+	case "up":
+		target := schema.Latest()
+		if *to >= 0 {
+			target = *to
+		}
+		applied, err := schema.Up(sqldb, target)
+		for _, m := range applied {
+			fmt.Printf("applied %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Printf("already at version %d\n", current)
+		}
+	case "down":
+		target := current - 1
+		if *to >= 0 {
+			target = *to
+		}
+		reverted, err := schema.Down(sqldb, target)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Printf("nothing to revert at version %d\n", current)
+		}
+	default:
+		fset.Usage()
+		return fmt.Errorf("unknown migrate command %q", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in a process group of its own, so that it outlives
+// the process that started it and the Ctrl+C sent to that process's
+// console.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	// Opening a process that has exited fails
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
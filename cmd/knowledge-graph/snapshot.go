@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"gnolledgegraph/internal/db"
+)
+
+// runSnapshot implements `knowledge-graph snapshot list|create|restore`.
+func runSnapshot(args []string) error {
+	fset := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dbPath := fset.String("db-path", "kg.db", "path to sqlite database")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s snapshot list|create|restore [flags] [name]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fset.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing snapshot command")
+	}
+	cmd := args[0]
+	fset.Parse(args[1:])
+
+	sqldb, err := db.Init(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer sqldb.Close()
+
+	ctx := db.WithProvenance(context.Background(), db.Provenance{Source: "cli"})
+	switch cmd {
+	case "list":
+		snapshots, err := db.ListSnapshots(sqldb)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("no snapshots")
+		}
+		for _, s := range snapshots {
+			kind := "named"
+			if s.Automatic {
+				kind = "auto"
+			}
+			fmt.Printf("%s  %-5s  %s\n", s.TakenAt, kind, s.Name)
+		}
+	case "create":
+		if fset.NArg() != 1 {
+			fset.Usage()
+			return fmt.Errorf("snapshot create needs a name")
+		}
+		s, err := db.CreateSnapshot(ctx, sqldb, fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created snapshot %s at %s\n", s.Name, s.TakenAt)
+	case "restore":
+		if fset.NArg() != 1 {
+			fset.Usage()
+			return fmt.Errorf("snapshot restore needs a name")
+		}
+		backup, err := db.RestoreSnapshot(ctx, sqldb, fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored snapshot %s; the previous graph was saved as %s\n", fset.Arg(0), backup.Name)
+	default:
+		fset.Usage()
+		return fmt.Errorf("unknown snapshot command %q", cmd)
+	}
+	return nil
+}
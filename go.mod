@@ -4,6 +4,8 @@ go 1.24
 
 require github.com/mattn/go-sqlite3 v1.14.28
 
+require github.com/klauspost/compress v1.18.0
+
 require github.com/ncruces/go-sqlite3 v0.26.0 // Now a direct dependency
 
 require (
@@ -1,15 +1,94 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 
 	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
 )
 
+// writeContext attributes writes made while serving r to the REST API, on
+// top of any caller identity already in the request context.
+func writeContext(r *http.Request) context.Context {
+	return db.WithProvenance(r.Context(), db.Provenance{Source: "rest"})
+}
+
+// asOfStatus is the status for a failed read: a bad as_of value is the
+// client's fault.
+func asOfStatus(err error) int {
+	if errors.Is(err, db.ErrInvalidAsOf) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// traverseStatus is the status for a failed traversal or path search:
+// only bad options and missing entities are the client's fault.
+func traverseStatus(err error) int {
+	if errors.Is(err, db.ErrInvalidTraversal) || errors.Is(err, db.ErrEntityNotFound) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// exportEncoding picks the compression for /api/export_db from an
+// Accept-Encoding header: zstd, then gzip, or "" for none.
+func exportEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{"zstd", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// etagMatches reports whether an If-None-Match header names the export
+// with this checksum, in any encoding.
+func etagMatches(header, checksum string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" {
+			return true
+		}
+		tag = strings.Trim(tag, `"`)
+		if tag == checksum || tag == checksum+"-gzip" || tag == checksum+"-zstd" {
+			return true
+		}
+	}
+	return false
+}
+
+// importDrainTimeout bounds how long an import waits for in-flight
+// queries before giving up.
+const importDrainTimeout = 30 * time.Second
+
 // now captures the on-disk sqlite file path
 func NewHandler(database *sql.DB, dbPath string) http.Handler {
 	mux := http.NewServeMux()
@@ -25,11 +104,34 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			http.Error(w, "Cannot read body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		if err := os.WriteFile(dbPath, data, 0o644); err != nil {
-			http.Error(w, "Cannot write DB file: "+err.Error(), http.StatusInternalServerError)
+		// Validated, staged and swapped in under the pool; see db.ImportDatabase
+		ctx, cancel := context.WithTimeout(r.Context(), importDrainTimeout)
+		defer cancel()
+		backup, err := db.ImportDatabase(ctx, database, data)
+		if errors.Is(err, db.ErrInvalidImport) {
+			logs.Log(logs.Warning, "sync", "rejected database import", map[string]interface{}{"error": err.Error()})
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			// The client went away; nothing was imported
+			logs.Log(logs.Info, "sync", "database import cancelled", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			logs.Log(logs.Error, "sync", "database import timed out", map[string]interface{}{"error": err.Error()})
+			http.Error(w, "Cannot import DB: "+err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-		// Optionally you could re-open the database here
+		if err != nil {
+			logs.Log(logs.Error, "sync", "database import failed", map[string]interface{}{"error": err.Error()})
+			http.Error(w, "Cannot import DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logs.Log(logs.Info, "sync", "imported database; the previous one was kept", map[string]interface{}{
+			"backup": backup,
+			"bytes":  len(data),
+		})
 		w.WriteHeader(http.StatusNoContent)
 	})
 
@@ -39,18 +141,121 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		// Copy through SQLite so concurrent writes cannot tear the download
+		dir, err := os.MkdirTemp("", "kg-export-*")
+		if err != nil {
+			http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, filepath.Base(dbPath))
+		if err := db.ExportDatabase(r.Context(), database, path); err != nil {
+			http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		sum := sha256.New()
+		size, err := io.Copy(sum, f)
+		if err != nil {
+			http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		checksum := hex.EncodeToString(sum.Sum(nil))
+
+		encoding := exportEncoding(r.Header.Get("Accept-Encoding"))
+		etag := `"` + checksum + `"`
+		if encoding != "" {
+			etag = `"` + checksum + "-" + encoding + `"`
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Checksum-SHA256", checksum)
+		w.Header().Set("Vary", "Accept-Encoding")
+		if etagMatches(r.Header.Get("If-None-Match"), checksum) {
+			logs.Log(logs.Debug, "sync", "database export not modified", map[string]interface{}{"checksum": checksum})
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		logs.Log(logs.Debug, "sync", "exported database", map[string]interface{}{
+			"checksum": checksum,
+			"bytes":    size,
+			"encoding": encoding,
+		})
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/octet-stream")
-		http.ServeFile(w, r, dbPath)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(dbPath)+`"`)
+		var out io.Writer = w
+		switch encoding {
+		case "zstd":
+			w.Header().Set("Content-Encoding", "zstd")
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				http.Error(w, "Cannot export DB: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer zw.Close()
+			out = zw
+		case "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			defer gw.Close()
+			out = gw
+		default:
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		io.Copy(out, f)
+	})
+
+	// POST /api/diff  ←  what importing an uploaded DB blob (or JSON export) would change
+	mux.HandleFunc("/api/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Cannot read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		uploaded, err := db.ParseGraph(data)
+		if err != nil {
+			http.Error(w, "Cannot read graph: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Compare against the live graph, or a snapshot or past time
+		current, err := db.GraphAsOf(database, r.URL.Query().Get("as_of"))
+		if err != nil {
+			http.Error(w, err.Error(), asOfStatus(err))
+			return
+		}
+
+		diff := db.DiffGraphs(current, uploaded)
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, diff.Report())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
 	})
+
 	mux.HandleFunc("/api/read_graph", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		entities, relations, observations, err := db.ReadGraph(database)
+		entities, relations, observations, err := db.ReadGraphAsOf(database, r.URL.Query().Get("as_of"))
 		if err != nil {
-			http.Error(w, "Failed to read graph: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to read graph: "+err.Error(), asOfStatus(err))
 			return
 		}
 
@@ -85,7 +290,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 		}
 
 		for _, entity := range req.Entities {
-			if err := db.CreateEntity(database, entity.Name, entity.Type); err != nil {
+			if _, err := db.CreateEntityContext(writeContext(r), database, entity.Name, entity.Type); err != nil {
 				http.Error(w, "Failed to create entity: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -117,7 +322,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 
 		var createdIDs []int64
 		for _, relation := range req.Relations {
-			id, err := db.CreateRelation(database, relation.From, relation.To, relation.Type)
+			id, err := db.CreateRelationContext(writeContext(r), database, relation.From, relation.To, relation.Type)
 			if err != nil {
 				http.Error(w, "Failed to create relation: "+err.Error(), http.StatusInternalServerError)
 				return
@@ -151,7 +356,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			return
 		}
 
-		added, err := db.AddObservations(database, req.Observations)
+		added, err := db.AddObservationsContext(writeContext(r), database, req.Observations)
 		if err != nil {
 			http.Error(w, "Failed to add observations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -180,7 +385,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			return
 		}
 
-		err := db.DeleteEntities(database, req.EntityNames)
+		err := db.DeleteEntitiesContext(writeContext(r), database, req.EntityNames)
 		if err != nil {
 			http.Error(w, "Failed to delete entities: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -193,6 +398,34 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 		})
 	})
 
+	mux.HandleFunc("/api/restore_entities", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			EntityNames []string `json:"entityNames"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restored, err := db.RestoreEntitiesContext(writeContext(r), database, req.EntityNames)
+		if err != nil {
+			http.Error(w, "Failed to restore entities: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"restored": restored,
+		})
+	})
+
 	mux.HandleFunc("/api/delete_observations", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -211,7 +444,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			return
 		}
 
-		err := db.DeleteObservations(database, req.Deletions)
+		err := db.DeleteObservationsContext(writeContext(r), database, req.Deletions)
 		if err != nil {
 			http.Error(w, "Failed to delete observations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -240,7 +473,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			return
 		}
 
-		err := db.DeleteRelations(database, req.Relations)
+		err := db.DeleteRelationsContext(writeContext(r), database, req.Relations)
 		if err != nil {
 			http.Error(w, "Failed to delete relations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -257,14 +490,43 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 		}
 
 		query := r.URL.Query().Get("query")
-		if query == "" {
+
+		var opts db.SearchOptions
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			opts.Limit = n
+		}
+		if since := r.URL.Query().Get("since"); since != "" {
+			t, err := db.ParseTime(since)
+			if err != nil {
+				http.Error(w, "Invalid since parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.Since = t
+		}
+		if until := r.URL.Query().Get("until"); until != "" {
+			t, err := db.ParseTime(until)
+			if err != nil {
+				http.Error(w, "Invalid until parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.Until = t
+		}
+		opts.AsOf = r.URL.Query().Get("as_of")
+
+		// An empty query is only meaningful as "everything changed in a window"
+		if query == "" && opts.Since.IsZero() && opts.Until.IsZero() {
 			http.Error(w, "Missing query parameter", http.StatusBadRequest)
 			return
 		}
 
-		entities, relations, err := db.SearchNodes(database, query)
+		entities, relations, err := db.SearchNodesWithOptions(database, query, opts)
 		if err != nil {
-			http.Error(w, "Failed to search nodes: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to search nodes: "+err.Error(), asOfStatus(err))
 			return
 		}
 
@@ -286,6 +548,7 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 
 		var req struct {
 			Names []string `json:"names"`
+			AsOf  string   `json:"as_of"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -293,9 +556,9 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 			return
 		}
 
-		entities, relations, err := db.OpenNodes(database, req.Names)
+		entities, relations, err := db.OpenNodesAsOf(database, req.Names, req.AsOf)
 		if err != nil {
-			http.Error(w, "Failed to open nodes: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to open nodes: "+err.Error(), asOfStatus(err))
 			return
 		}
 
@@ -309,5 +572,67 @@ func NewHandler(database *sql.DB, dbPath string) http.Handler {
 		})
 	})
 
+	mux.HandleFunc("/api/entity_history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing name parameter", http.StatusBadRequest)
+			return
+		}
+
+		changes, err := db.EntityHistory(database, name)
+		if err != nil {
+			http.Error(w, "Failed to read entity history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Name    string      `json:"name"`
+			Changes []db.Change `json:"changes"`
+		}{
+			Name:    name,
+			Changes: changes,
+		})
+	})
+
+	mux.HandleFunc("/api/traverse_graph", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Names         []string `json:"names"`
+			Depth         int      `json:"depth"`
+			RelationTypes []string `json:"relation_types"`
+			Direction     string   `json:"direction"`
+			MaxNodes      int      `json:"max_nodes"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		subgraph, err := db.TraverseContext(r.Context(), database, req.Names, db.TraverseOptions{
+			Depth:         req.Depth,
+			RelationTypes: req.RelationTypes,
+			Direction:     req.Direction,
+			MaxNodes:      req.MaxNodes,
+		})
+		if err != nil {
+			http.Error(w, "Failed to traverse graph: "+err.Error(), traverseStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subgraph)
+	})
+
 	return mux
 }
@@ -2,14 +2,22 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
+
 	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
 )
 
 func setupTestAPI(t *testing.T) (*sql.DB, http.Handler) {
@@ -27,6 +35,7 @@ func setupTestAPI(t *testing.T) (*sql.DB, http.Handler) {
 	t.Cleanup(func() {
 		database.Close()
 		os.Remove(tmpfile.Name())
+		os.Remove(tmpfile.Name() + db.BackupSuffix)
 	})
 
 	handler := NewHandler(database, tmpfile.Name())
@@ -233,6 +242,269 @@ func TestCreateRelationsAPIWrongMethod(t *testing.T) {
 	}
 }
 
+func TestEntityHistoryAPI(t *testing.T) {
+	database, handler := setupTestAPI(t)
+
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateObservation(database, "Alice", "Software engineer")
+
+	req := httptest.NewRequest("DELETE", "/api/delete_entities", bytes.NewBufferString(`{"entityNames": ["Alice"]}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to delete entity: status %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/entity_history?name=Alice", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Name    string      `json:"name"`
+		Changes []db.Change `json:"changes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	ops := []string{db.OpCreateEntity, db.OpAddObservation, db.OpDeleteObservation, db.OpDeleteEntity}
+	if len(response.Changes) != len(ops) {
+		t.Fatalf("Expected %d changes, got %+v", len(ops), response.Changes)
+	}
+	for i, op := range ops {
+		if response.Changes[i].Operation != op {
+			t.Errorf("Change %d: expected %s, got %s", i, op, response.Changes[i].Operation)
+		}
+	}
+	if last := response.Changes[3]; last.Source != "rest" {
+		t.Errorf("Expected deletion through the REST API to be attributed to it, got %+v", last)
+	}
+
+	req = httptest.NewRequest("GET", "/api/entity_history", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without name, got %d", w.Code)
+	}
+}
+
+func TestReadGraphAsOfAPI(t *testing.T) {
+	database, handler := setupTestAPI(t)
+
+	db.CreateEntity(database, "Alice", "person")
+	if _, err := db.CreateSnapshot(context.Background(), database, "before-bob"); err != nil {
+		t.Fatal(err)
+	}
+	db.CreateEntity(database, "Bob", "person")
+
+	req := httptest.NewRequest("GET", "/api/read_graph?as_of=before-bob", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Entities []db.Entity `json:"entities"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Entities) != 1 || response.Entities[0].Name != "Alice" {
+		t.Errorf("Expected only Alice in the snapshot, got %+v", response.Entities)
+	}
+
+	req = httptest.NewRequest("POST", "/api/open_nodes", bytes.NewBufferString(`{"names": ["Bob"], "as_of": "before-bob"}`))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Entities) != 0 {
+		t.Errorf("Expected Bob not to exist yet, got %+v", response.Entities)
+	}
+
+	req = httptest.NewRequest("GET", "/api/search_nodes?query=a&as_of=someday", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown as_of, got %d", w.Code)
+	}
+}
+
+func TestDiffAPI(t *testing.T) {
+	database, handler := setupTestAPI(t)
+
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateEntity(database, "Acme", "company")
+
+	upload := `{"entities": [{"name": "Alice", "entityType": "person", "observations": ["Likes tea"]}], "relations": []}`
+	req := httptest.NewRequest("POST", "/api/diff", bytes.NewBufferString(upload))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var diff db.GraphDiff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(diff.RemovedEntities) != 1 || diff.RemovedEntities[0].Name != "Acme" {
+		t.Errorf("Expected Acme removed, got %+v", diff.RemovedEntities)
+	}
+	if len(diff.AddedObservations) != 1 {
+		t.Errorf("Expected one added observation, got %+v", diff.AddedObservations)
+	}
+
+	req = httptest.NewRequest("POST", "/api/diff?format=text", bytes.NewBufferString(upload))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte("  - Acme (company)")) {
+		t.Errorf("Expected a text report, got %q", w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/diff", bytes.NewBufferString("garbage"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for garbage, got %d", w.Code)
+	}
+}
+
+func TestImportDBAPI(t *testing.T) {
+	database, handler := setupTestAPI(t)
+	db.CreateEntity(database, "Alice", "person")
+
+	// Round-trip the server's own database after a change
+	req := httptest.NewRequest("GET", "/api/export_db", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	exported := w.Body.Bytes()
+	db.CreateEntity(database, "Bob", "person")
+
+	req = httptest.NewRequest("POST", "/api/import_db", bytes.NewReader(exported))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	entities, _, _, err := db.ReadGraph(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Alice" {
+		t.Errorf("Expected only Alice after import, got %+v", entities)
+	}
+
+	req = httptest.NewRequest("POST", "/api/import_db", bytes.NewBufferString("not a database"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid upload, got %d", w.Code)
+	}
+
+	// A client that goes away while queries drain is not a server error
+	conn, err := database.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var errorsLogged []string
+	unsubscribe := logs.Subscribe(func(r logs.Record) {
+		if r.Level >= logs.Error {
+			errorsLogged = append(errorsLogged, r.String())
+		}
+	})
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = httptest.NewRequest("POST", "/api/import_db", bytes.NewReader(exported)).WithContext(ctx)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code == http.StatusInternalServerError || len(errorsLogged) > 0 {
+		t.Errorf("Expected a cancelled import to be no error, got %d and %v", w.Code, errorsLogged)
+	}
+}
+
+func TestExportDBAPI(t *testing.T) {
+	database, handler := setupTestAPI(t)
+	db.CreateEntity(database, "Alice", "person")
+
+	req := httptest.NewRequest("GET", "/api/export_db", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	sum := sha256.Sum256(w.Body.Bytes())
+	checksum := hex.EncodeToString(sum[:])
+	if got := w.Header().Get("X-Checksum-SHA256"); got != checksum {
+		t.Errorf("Expected checksum header %s, got %s", checksum, got)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != `"`+checksum+`"` {
+		t.Errorf("Expected the checksum as ETag, got %s", etag)
+	}
+	g, err := db.ParseGraph(w.Body.Bytes())
+	if err != nil || len(g.Entities) != 1 {
+		t.Fatalf("Expected a database with Alice, got %+v, %v", g, err)
+	}
+
+	// Unchanged graph: nothing to download
+	req = httptest.NewRequest("GET", "/api/export_db", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for an unchanged database, got %d", w.Code)
+	}
+
+	// Compressed downloads decompress to the same database
+	for _, encoding := range []string{"gzip", "zstd"} {
+		req = httptest.NewRequest("GET", "/api/export_db", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("Expected Content-Encoding %s, got %q", encoding, got)
+			continue
+		}
+		var r io.Reader
+		if encoding == "gzip" {
+			r, err = gzip.NewReader(w.Body)
+		} else {
+			r, err = zstd.NewReader(w.Body)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sha256.Sum256(data) != sum {
+			t.Errorf("%s download does not match the checksum", encoding)
+		}
+	}
+
+	db.CreateEntity(database, "Bob", "person")
+	req = httptest.NewRequest("GET", "/api/export_db", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after a change, got %d", w.Code)
+	}
+}
+
 func TestIntegrationWorkflow(t *testing.T) {
 	_, handler := setupTestAPI(t)
 
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+
+	"gnolledgegraph/internal/db"
 )
 
 // OpenAPISpec generates the OpenAPI 3.1 specification for the API
@@ -32,6 +34,15 @@ func OpenAPISpec() map[string]interface{} {
 				"get": map[string]interface{}{
 					"operationId": "compat_read_graph",
 					"summary":     "Read the complete knowledge graph",
+					"parameters": []map[string]interface{}{
+						{
+							"name":        "asOf",
+							"in":          "query",
+							"required":    false,
+							"description": "Answer from the graph as it was at this snapshot name or time (RFC 3339 or YYYY-MM-DD).",
+							"schema":      map[string]interface{}{"type": "string"},
+						},
+					},
 					"responses": map[string]interface{}{
 						"200": map[string]interface{}{
 							"description": "Graph data for the entire knowledge graph",
@@ -43,6 +54,9 @@ func OpenAPISpec() map[string]interface{} {
 								},
 							},
 						},
+						"400": map[string]interface{}{
+							"description": "asOf names no snapshot and is not a time",
+						},
 						"500": map[string]interface{}{
 							"description": "Internal server error",
 						},
@@ -242,9 +256,31 @@ func OpenAPISpec() map[string]interface{} {
 						"content": map[string]interface{}{
 							"application/json": map[string]interface{}{
 								"schema": map[string]interface{}{
-									"type":       "object",
-									"properties": map[string]interface{}{"query": map[string]interface{}{"type": "string"}},
-									"required":   []string{"query"},
+									"type": "object",
+									"properties": map[string]interface{}{
+										"query": map[string]interface{}{
+											"type":        "string",
+											"description": "Search terms. With full-text search enabled, supports \"phrases\", prefix* and AND/OR/NOT.",
+										},
+										"limit": map[string]interface{}{
+											"type":        "integer",
+											"minimum":     0,
+											"description": "Maximum number of entities to return, best matches first. 0 or omitted means no limit.",
+										},
+										"since": map[string]interface{}{
+											"type":        "string",
+											"description": "Only entities created or changed at or after this time (RFC 3339 or YYYY-MM-DD). With an empty query, lists every entity changed since then, newest first.",
+										},
+										"until": map[string]interface{}{
+											"type":        "string",
+											"description": "Only entities last changed before this time (RFC 3339 or YYYY-MM-DD).",
+										},
+										"asOf": map[string]interface{}{
+											"type":        "string",
+											"description": "Answer from the graph as it was at this snapshot name or time (RFC 3339 or YYYY-MM-DD). Historical searches use substring matching.",
+										},
+									},
+									"description": "query is required unless since or until is given.",
 								},
 								"examples": map[string]interface{}{
 									"example1": map[string]interface{}{
@@ -263,7 +299,7 @@ func OpenAPISpec() map[string]interface{} {
 								},
 							},
 						},
-						"400": map[string]interface{}{"description": "Invalid request body"},
+						"400": map[string]interface{}{"description": "Invalid request body, time window or asOf"},
 						"500": map[string]interface{}{"description": "Internal server error"},
 					},
 				},
@@ -277,9 +313,15 @@ func OpenAPISpec() map[string]interface{} {
 						"content": map[string]interface{}{
 							"application/json": map[string]interface{}{
 								"schema": map[string]interface{}{
-									"type":       "object",
-									"properties": map[string]interface{}{"names": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
-									"required":   []string{"names"},
+									"type": "object",
+									"properties": map[string]interface{}{
+										"names": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+										"asOf": map[string]interface{}{
+											"type":        "string",
+											"description": "Answer from the graph as it was at this snapshot name or time (RFC 3339 or YYYY-MM-DD).",
+										},
+									},
+									"required": []string{"names"},
 								},
 								"examples": map[string]interface{}{
 									"example1": map[string]interface{}{
@@ -298,6 +340,193 @@ func OpenAPISpec() map[string]interface{} {
 								},
 							},
 						},
+						"400": map[string]interface{}{"description": "Invalid request body or asOf"},
+						"500": map[string]interface{}{"description": "Internal server error"},
+					},
+				},
+			},
+			"/traverse_graph": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "compat_traverse_graph",
+					"summary":     "Retrieve the subgraph within N hops of the given entities",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"names":         map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Seed entity names"},
+										"depth":         map[string]interface{}{"type": "integer", "minimum": 1, "default": 1, "description": "Number of hops from the seeds"},
+										"relationTypes": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Only follow relations of these types"},
+										"direction":     map[string]interface{}{"type": "string", "enum": []string{"outgoing", "incoming", "both"}, "default": "both"},
+										"maxNodes":      map[string]interface{}{"type": "integer", "minimum": 1, "default": db.DefaultMaxNodes, "description": "Maximum number of entities returned"},
+									},
+									"required": []string{"names"},
+								},
+								"examples": map[string]interface{}{
+									"example1": map[string]interface{}{
+										"value": map[string]interface{}{"names": []string{"Python"}, "depth": 2, "direction": "outgoing"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Entities reached by the traversal and the relations between them",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"entities": map[string]interface{}{
+												"type":  "array",
+												"items": map[string]interface{}{"$ref": "#/components/schemas/CompatibleEntity"},
+											},
+											"relations": map[string]interface{}{
+												"type":  "array",
+												"items": map[string]interface{}{"$ref": "#/components/schemas/CompatibleRelation"},
+											},
+											"truncated": map[string]interface{}{"type": "boolean", "description": "Set when maxNodes stopped the traversal early"},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{"description": "Invalid request body or direction"},
+					},
+				},
+			},
+			"/find_path": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "compat_find_path",
+					"summary":     "Find the shortest paths between two entities",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"from":          map[string]interface{}{"type": "string"},
+										"to":            map[string]interface{}{"type": "string"},
+										"maxDepth":      map[string]interface{}{"type": "integer", "minimum": 1, "default": db.DefaultMaxPathDepth, "description": "Maximum path length in hops"},
+										"relationTypes": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Only use relations of these types"},
+										"direction":     map[string]interface{}{"type": "string", "enum": []string{"outgoing", "incoming", "both"}, "default": "both"},
+										"k":             map[string]interface{}{"type": "integer", "minimum": 1, "default": 1, "description": "Number of paths to return, shortest first"},
+									},
+									"required": []string{"from", "to"},
+								},
+								"examples": map[string]interface{}{
+									"example1": map[string]interface{}{
+										"value": map[string]interface{}{"from": "Python", "to": "Wagtail", "k": 3},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Paths from shortest to longest; empty when the entities are not connected within maxDepth",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"paths": map[string]interface{}{
+												"type":  "array",
+												"items": map[string]interface{}{"$ref": "#/components/schemas/CompatiblePath"},
+											},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{"description": "Invalid request body, direction or unknown entity"},
+						"504": map[string]interface{}{"description": "Path search timed out"},
+					},
+				},
+			},
+			"/entity_history": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "compat_entity_history",
+					"summary":     "Timeline of changes to an entity, its observations and its relations",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":       "object",
+									"properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+									"required":   []string{"name"},
+								},
+								"examples": map[string]interface{}{
+									"example1": map[string]interface{}{
+										"value": map[string]interface{}{"name": "Python"},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Changes oldest first; also available for deleted entities",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"name": map[string]interface{}{"type": "string"},
+											"changes": map[string]interface{}{
+												"type":  "array",
+												"items": map[string]interface{}{"$ref": "#/components/schemas/Change"},
+											},
+										},
+									},
+								},
+							},
+						},
+						"400": map[string]interface{}{"description": "Invalid request body or missing name"},
+						"500": map[string]interface{}{"description": "Internal server error"},
+					},
+				},
+			},
+			"/restore_entities": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "compat_restore_entities",
+					"summary":     "Restore deleted entities with the observations and relations deleted with them",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type":       "object",
+									"properties": map[string]interface{}{"entityNames": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+									"required":   []string{"entityNames"},
+								},
+								"examples": map[string]interface{}{
+									"example1": map[string]interface{}{
+										"value": map[string]interface{}{"entityNames": []string{"OldEntity"}},
+									},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Names of the entities that were restored; names that were not deleted or were already purged are left out",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"restored": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+										},
+									},
+								},
+							},
+						},
 						"400": map[string]interface{}{"description": "Invalid request body"},
 						"500": map[string]interface{}{"description": "Internal server error"},
 					},
@@ -471,6 +700,11 @@ func OpenAPISpec() map[string]interface{} {
 							"type":  "array",
 							"items": map[string]interface{}{"type": "string"},
 						},
+						"createdAt": map[string]interface{}{"type": "string", "format": "date-time", "description": "When the row was written; absent for rows older than timestamp tracking"},
+						"updatedAt": map[string]interface{}{"type": "string", "format": "date-time", "description": "Moves when observations are added or removed"},
+						"source":    map[string]interface{}{"type": "string", "description": "Client that wrote the row: MCP clientInfo name, or rest"},
+						"sessionId": map[string]interface{}{"type": "string", "description": "MCP session that wrote the row"},
+						"actor":     map[string]interface{}{"type": "string", "description": "Authenticated user, or key:<fingerprint> for API keys"},
 					},
 					"required": []string{"name", "entityType"},
 				},
@@ -487,9 +721,50 @@ func OpenAPISpec() map[string]interface{} {
 						"relationType": map[string]interface{}{ // Camel case
 							"type": "string",
 						},
+						"createdAt": map[string]interface{}{"type": "string", "format": "date-time", "description": "When the row was written; absent for rows older than timestamp tracking"},
+						"updatedAt": map[string]interface{}{"type": "string", "format": "date-time"},
+						"source":    map[string]interface{}{"type": "string", "description": "Client that wrote the row: MCP clientInfo name, or rest"},
+						"sessionId": map[string]interface{}{"type": "string", "description": "MCP session that wrote the row"},
+						"actor":     map[string]interface{}{"type": "string", "description": "Authenticated user, or key:<fingerprint> for API keys"},
 					},
 					"required": []string{"from", "to", "relationType"},
 				},
+				"CompatiblePath": map[string]interface{}{
+					"type":        "object",
+					"description": "A simple path between two entities.",
+					"properties": map[string]interface{}{
+						"entities": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+						"hops": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"from":         map[string]interface{}{"type": "string"},
+									"to":           map[string]interface{}{"type": "string"},
+									"relationType": map[string]interface{}{"type": "string"},
+									"direction":    map[string]interface{}{"type": "string", "enum": []string{"outgoing", "incoming"}, "description": "incoming when the hop walks the relation backwards"},
+								},
+							},
+						},
+						"length": map[string]interface{}{"type": "integer"},
+					},
+				},
+				"Change": map[string]interface{}{
+					"type":        "object",
+					"description": "One entry of the append-only changelog.",
+					"properties": map[string]interface{}{
+						"id":            map[string]interface{}{"type": "integer"},
+						"at":            map[string]interface{}{"type": "string", "format": "date-time"},
+						"operation":     map[string]interface{}{"type": "string", "enum": []string{"create_entity", "delete_entity", "create_relation", "delete_relation", "add_observation", "delete_observation"}},
+						"entityName":    map[string]interface{}{"type": "string"},
+						"relatedEntity": map[string]interface{}{"type": "string", "description": "Target of a relation change"},
+						"before":        map[string]interface{}{"type": "object", "description": "The row before the change; absent for creations"},
+						"after":         map[string]interface{}{"type": "object", "description": "The row after the change; absent for deletions"},
+						"source":        map[string]interface{}{"type": "string"},
+						"sessionId":     map[string]interface{}{"type": "string"},
+						"actor":         map[string]interface{}{"type": "string"},
+					},
+				},
 				"CompatibleKnowledgeGraph": map[string]interface{}{
 					"type":        "object",
 					"description": "The full knowledge graph with entities and relations.",
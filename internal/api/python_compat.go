@@ -1,8 +1,10 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -45,9 +47,9 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		entities, relations, observations, err := db.ReadGraph(database)
+		entities, relations, observations, err := db.ReadGraphAsOf(database, r.URL.Query().Get("asOf"))
 		if err != nil {
-			http.Error(w, "Failed to read graph: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to read graph: "+err.Error(), asOfStatus(err))
 			return
 		}
 
@@ -85,8 +87,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 
 		// First, check for existing entities to handle conflicts gracefully
 		for _, entity := range req.Entities {
-			var exists bool
-			err := database.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE name = ?)`, entity.Name).Scan(&exists)
+			exists, err := db.EntityExists(database, entity.Name)
 			if err != nil {
 				http.Error(w, "Database error checking entity existence: "+err.Error(), http.StatusInternalServerError)
 				return
@@ -110,7 +111,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 		for _, entity := range req.Entities {
 			// Create entity (db.CreateEntity uses INSERT OR IGNORE, so no error on duplicate here,
 			// but we've already checked above for explicit conflict reporting)
-			if err := db.CreateEntity(database, entity.Name, entity.Type); err != nil {
+			if _, err := db.CreateEntityContext(writeContext(r), database, entity.Name, entity.Type); err != nil {
 				// This error would be for issues other than duplicates, e.g., DB connection
 				http.Error(w, "Failed to create entity '"+entity.Name+"': "+err.Error(), http.StatusInternalServerError)
 				return
@@ -118,7 +119,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 
 			// Create observations
 			for _, obsContent := range entity.Observations {
-				if _, err := db.CreateObservation(database, entity.Name, obsContent); err != nil {
+				if _, err := db.CreateObservationContext(writeContext(r), database, entity.Name, obsContent); err != nil {
 					http.Error(w, "Failed to create observation for '"+entity.Name+"': "+err.Error(), http.StatusInternalServerError)
 					return
 				}
@@ -152,13 +153,12 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 
 		for _, relation := range req.Relations {
 			// Validate that referenced entities exist
-			var fromExists, toExists bool
-			err := database.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE name = ?)`, relation.From).Scan(&fromExists)
+			fromExists, err := db.EntityExists(database, relation.From)
 			if err != nil {
 				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			err = database.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE name = ?)`, relation.To).Scan(&toExists)
+			toExists, err := db.EntityExists(database, relation.To)
 			if err != nil {
 				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 				return
@@ -174,7 +174,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			}
 
 			// Create relation
-			if _, err := db.CreateRelation(database, relation.From, relation.To, relation.Type); err != nil {
+			if _, err := db.CreateRelationContext(writeContext(r), database, relation.From, relation.To, relation.Type); err != nil {
 				http.Error(w, "Failed to create relation: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -225,7 +225,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			}
 		}
 
-		added, err := db.AddObservations(database, dbObservations)
+		added, err := db.AddObservationsContext(writeContext(r), database, dbObservations)
 		if err != nil {
 			http.Error(w, "Failed to add observations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -267,6 +267,10 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 
 		var req struct {
 			Query string `json:"query"`
+			Limit int    `json:"limit"`
+			Since string `json:"since"`
+			Until string `json:"until"`
+			AsOf  string `json:"asOf"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -274,14 +278,37 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		if req.Query == "" {
+		if req.Limit < 0 {
+			http.Error(w, "Invalid limit field", http.StatusBadRequest)
+			return
+		}
+
+		opts := db.SearchOptions{Limit: req.Limit, AsOf: req.AsOf}
+		if req.Since != "" {
+			t, err := db.ParseTime(req.Since)
+			if err != nil {
+				http.Error(w, "Invalid since field: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.Since = t
+		}
+		if req.Until != "" {
+			t, err := db.ParseTime(req.Until)
+			if err != nil {
+				http.Error(w, "Invalid until field: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.Until = t
+		}
+
+		if req.Query == "" && req.Since == "" && req.Until == "" {
 			http.Error(w, "Missing query field", http.StatusBadRequest)
 			return
 		}
 
-		entities, relations, err := db.SearchNodes(database, req.Query)
+		entities, relations, err := db.SearchNodesWithOptions(database, req.Query, opts)
 		if err != nil {
-			http.Error(w, "Failed to search nodes: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to search nodes: "+err.Error(), asOfStatus(err))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -304,6 +331,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 
 		var req struct {
 			Names []string `json:"names"`
+			AsOf  string   `json:"asOf"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -311,9 +339,9 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		entities, relations, err := db.OpenNodes(database, req.Names)
+		entities, relations, err := db.OpenNodesAsOf(database, req.Names, req.AsOf)
 		if err != nil {
-			http.Error(w, "Failed to open nodes: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to open nodes: "+err.Error(), asOfStatus(err))
 			return
 		}
 
@@ -344,7 +372,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		err := db.DeleteEntities(database, req.EntityNames)
+		err := db.DeleteEntitiesContext(writeContext(r), database, req.EntityNames)
 		if err != nil {
 			http.Error(w, "Failed to delete entities: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -377,7 +405,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		err := db.DeleteObservations(database, req.Deletions)
+		err := db.DeleteObservationsContext(writeContext(r), database, req.Deletions)
 		if err != nil {
 			http.Error(w, "Failed to delete observations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -423,7 +451,7 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 			})
 		}
 
-		err := db.DeleteRelations(database, dbRelations)
+		err := db.DeleteRelationsContext(writeContext(r), database, dbRelations)
 		if err != nil {
 			http.Error(w, "Failed to delete relations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -433,6 +461,152 @@ func NewPythonCompatHandler(database *sql.DB) http.Handler {
 		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 	})
 
+	// 10. POST /traverse_graph - Subgraph within N hops of the given entities
+	mux.HandleFunc("/traverse_graph", func(w http.ResponseWriter, r *http.Request) {
+		addCORSHeaders(w)
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Names         []string `json:"names"`
+			Depth         int      `json:"depth"`
+			RelationTypes []string `json:"relationTypes"`
+			Direction     string   `json:"direction"`
+			MaxNodes      int      `json:"maxNodes"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		subgraph, err := db.TraverseContext(r.Context(), database, req.Names, db.TraverseOptions{
+			Depth:         req.Depth,
+			RelationTypes: req.RelationTypes,
+			Direction:     req.Direction,
+			MaxNodes:      req.MaxNodes,
+		})
+		if err != nil {
+			http.Error(w, "Failed to traverse graph: "+err.Error(), traverseStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subgraph)
+	})
+
+	// 11. POST /find_path - Shortest paths between two entities
+	mux.HandleFunc("/find_path", func(w http.ResponseWriter, r *http.Request) {
+		addCORSHeaders(w)
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			From          string   `json:"from"`
+			To            string   `json:"to"`
+			MaxDepth      int      `json:"maxDepth"`
+			RelationTypes []string `json:"relationTypes"`
+			Direction     string   `json:"direction"`
+			K             int      `json:"k"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		paths, err := db.FindPaths(r.Context(), database, req.From, req.To, db.PathOptions{
+			MaxDepth:      req.MaxDepth,
+			RelationTypes: req.RelationTypes,
+			Direction:     req.Direction,
+			K:             req.K,
+		})
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "Path search timed out", http.StatusGatewayTimeout)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to find path: "+err.Error(), traverseStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Paths []db.Path `json:"paths"`
+		}{Paths: paths})
+	})
+
+	// 12. POST /entity_history - Timeline of changes to one entity
+	mux.HandleFunc("/entity_history", func(w http.ResponseWriter, r *http.Request) {
+		addCORSHeaders(w)
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Name string `json:"name"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Name == "" {
+			http.Error(w, "Missing name field", http.StatusBadRequest)
+			return
+		}
+
+		changes, err := db.EntityHistory(database, req.Name)
+		if err != nil {
+			http.Error(w, "Failed to read entity history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Name    string      `json:"name"`
+			Changes []db.Change `json:"changes"`
+		}{
+			Name:    req.Name,
+			Changes: changes,
+		})
+	})
+
+	// 13. POST /restore_entities - Undo the deletion of entities
+	mux.HandleFunc("/restore_entities", func(w http.ResponseWriter, r *http.Request) {
+		addCORSHeaders(w)
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			EntityNames []string `json:"entityNames"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restored, err := db.RestoreEntitiesContext(writeContext(r), database, req.EntityNames)
+		if err != nil {
+			http.Error(w, "Failed to restore entities: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"restored": restored,
+		})
+	})
+
 	// Serve static frontend assets from embedded FS or disk as fallback.
 	var fileServer http.Handler
 	if StaticFS != nil {
@@ -417,6 +417,102 @@ func TestPythonSearchNodes(t *testing.T) {
 	}
 }
 
+func TestPythonSearchNodesLimit(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	db.CreateEntity(database, "Python", "Language")
+	db.CreateEntity(database, "Go", "Language")
+	db.CreateEntity(database, "Rust", "Language")
+
+	handler := NewPythonCompatHandler(database)
+
+	body := []byte(`{"query": "language", "limit": 2}`)
+	req := httptest.NewRequest("POST", "/search_nodes", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Entities []db.Entity `json:"entities"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Entities) != 2 {
+		t.Errorf("Expected 2 entities, got %d", len(response.Entities))
+	}
+
+	// Negative limits are rejected
+	req = httptest.NewRequest("POST", "/search_nodes", bytes.NewReader([]byte(`{"query": "language", "limit": -1}`)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestPythonSearchNodesSince(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	handler := NewPythonCompatHandler(database)
+
+	body := []byte(`{"entities": [{"name": "Python", "entityType": "Language", "observations": ["Dynamic"]}]}`)
+	req := httptest.NewRequest("POST", "/create_entities", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create entity: %d %s", w.Code, w.Body.String())
+	}
+
+	search := func(body string) []db.Entity {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/search_nodes", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", body, w.Code, w.Body.String())
+		}
+		var response struct {
+			Entities []db.Entity `json:"entities"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response.Entities
+	}
+
+	// Rows written through the REST API are stamped
+	entities := search(`{"query": "python", "since": "2000-01-01"}`)
+	if len(entities) != 1 {
+		t.Fatalf("Expected Python in the window, got %+v", entities)
+	}
+	if entities[0].CreatedAt == "" || entities[0].Source != "rest" {
+		t.Errorf("Expected timestamps and rest source, got %+v", entities[0])
+	}
+
+	// An empty query lists everything changed in the window
+	if entities := search(`{"query": "", "since": "2000-01-01"}`); len(entities) != 1 {
+		t.Errorf("Expected recent changes listing, got %+v", entities)
+	}
+	if entities := search(`{"query": "python", "since": "2999-01-01"}`); len(entities) != 0 {
+		t.Errorf("Expected nothing changed in the future, got %+v", entities)
+	}
+
+	req = httptest.NewRequest("POST", "/search_nodes", bytes.NewReader([]byte(`{"query": "python", "since": "last tuesday"}`)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid since, got %d", w.Code)
+	}
+}
+
 func TestPythonOpenNodes(t *testing.T) {
 	database := setupTestDB(t)
 	defer database.Close()
@@ -600,6 +696,178 @@ func TestPythonDeleteRelations(t *testing.T) {
 	}
 }
 
+func TestPythonTraverseGraph(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	db.CreateEntity(database, "Python", "Language")
+	db.CreateEntity(database, "Django", "Framework")
+	db.CreateEntity(database, "Wagtail", "CMS")
+	db.CreateRelation(database, "Python", "Django", "hasFramework")
+	db.CreateRelation(database, "Wagtail", "Django", "builtOn")
+
+	handler := NewPythonCompatHandler(database)
+
+	body := []byte(`{"names": ["Python"], "depth": 2, "direction": "both"}`)
+	req := httptest.NewRequest("POST", "/traverse_graph", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var subgraph db.Subgraph
+	if err := json.NewDecoder(w.Body).Decode(&subgraph); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(subgraph.Entities) != 3 || len(subgraph.Relations) != 2 {
+		t.Errorf("Expected 3 entities and 2 relations, got %+v", subgraph)
+	}
+
+	// Only outgoing edges from Python stop at Django
+	body = []byte(`{"names": ["Python"], "depth": 2, "direction": "outgoing"}`)
+	req = httptest.NewRequest("POST", "/traverse_graph", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	subgraph = db.Subgraph{}
+	json.NewDecoder(w.Body).Decode(&subgraph)
+	if len(subgraph.Entities) != 2 {
+		t.Errorf("Expected 2 entities for outgoing traversal, got %+v", subgraph.Entities)
+	}
+
+	body = []byte(`{"names": ["Python"], "direction": "sideways"}`)
+	req = httptest.NewRequest("POST", "/traverse_graph", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid direction, got %d", w.Code)
+	}
+
+	// Failing to read the graph is not the client's fault
+	database.Close()
+	body = []byte(`{"names": ["Python"]}`)
+	req = httptest.NewRequest("POST", "/traverse_graph", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 when the database fails, got %d", w.Code)
+	}
+}
+
+func TestPythonFindPath(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	db.CreateEntity(database, "Python", "Language")
+	db.CreateEntity(database, "Django", "Framework")
+	db.CreateEntity(database, "Wagtail", "CMS")
+	db.CreateRelation(database, "Python", "Django", "hasFramework")
+	db.CreateRelation(database, "Wagtail", "Django", "builtOn")
+
+	handler := NewPythonCompatHandler(database)
+
+	body := []byte(`{"from": "Python", "to": "Wagtail"}`)
+	req := httptest.NewRequest("POST", "/find_path", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Paths []db.Path `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Paths) != 1 || response.Paths[0].Length != 2 {
+		t.Fatalf("Expected one path of length 2, got %+v", response.Paths)
+	}
+	if hop := response.Paths[0].Hops[1]; hop.RelationType != "builtOn" || hop.Direction != db.DirectionIncoming {
+		t.Errorf("Expected second hop to walk builtOn backwards, got %+v", hop)
+	}
+
+	// Missing entity
+	req = httptest.NewRequest("POST", "/find_path", bytes.NewReader([]byte(`{"from": "Python", "to": "Rust"}`)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for missing entity, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/find_path", bytes.NewReader([]byte(`{"from": "Python", "to": "Wagtail", "direction": "sideways"}`)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid direction, got %d", w.Code)
+	}
+
+	// Failing to read the graph is not the client's fault
+	database.Close()
+	req = httptest.NewRequest("POST", "/find_path", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 when the database fails, got %d", w.Code)
+	}
+}
+
+func TestPythonRestoreEntities(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	db.CreateEntity(database, "Python", "Language")
+	db.CreateEntity(database, "Django", "Framework")
+	db.CreateObservation(database, "Python", "Dynamically typed")
+	db.CreateRelation(database, "Python", "Django", "hasFramework")
+	db.DeleteEntities(database, []string{"Python"})
+
+	handler := NewPythonCompatHandler(database)
+
+	// A deleted entity can no longer be related to
+	body := []byte(`{"relations": [{"from": "Django", "to": "Python", "relationType": "writtenIn"}]}`)
+	req := httptest.NewRequest("POST", "/create_relations", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for deleted entity, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/restore_entities", bytes.NewReader([]byte(`{"entityNames": ["Python"]}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Restored []string `json:"restored"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Restored) != 1 || response.Restored[0] != "Python" {
+		t.Errorf("Expected Python restored, got %v", response.Restored)
+	}
+
+	entities, relations, err := db.OpenNodes(database, []string{"Python"})
+	if err != nil {
+		t.Fatalf("Failed to open nodes: %v", err)
+	}
+	if len(entities) != 1 || len(relations) != 1 {
+		t.Errorf("Expected Python back with its relation, got %+v %+v", entities, relations)
+	}
+}
+
 func TestPythonCORSHeaders(t *testing.T) {
 	database := setupTestDB(t)
 	defer database.Close()
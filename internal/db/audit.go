@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// Operations recorded in the changelog.
+const (
+	OpCreateEntity      = "create_entity"
+	OpDeleteEntity      = "delete_entity"
+	OpCreateRelation    = "create_relation"
+	OpDeleteRelation    = "delete_relation"
+	OpAddObservation    = "add_observation"
+	OpDeleteObservation = "delete_observation"
+
+	// Deleted rows are restored or, once they are past the purge window
+	// or replaced by a new entity of the same name, purged for good.
+	OpRestoreEntity      = "restore_entity"
+	OpRestoreRelation    = "restore_relation"
+	OpRestoreObservation = "restore_observation"
+	OpPurgeEntity        = "purge_entity"
+	OpPurgeRelation      = "purge_relation"
+	OpPurgeObservation   = "purge_observation"
+)
+
+// Change is one changelog entry. Before is absent for creations and After
+// for deletions; both hold the row as it is returned by the read APIs.
+type Change struct {
+	ID        int64  `json:"id"`
+	At        string `json:"at"`
+	Operation string `json:"operation"`
+	// EntityName is the entity the change belongs to: the entity itself, the
+	// owner of an observation, or the source of a relation. RelatedEntity is
+	// the target of a relation.
+	EntityName    string          `json:"entityName"`
+	RelatedEntity string          `json:"relatedEntity,omitempty"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	Provenance
+}
+
+// recordChange appends to the changelog as part of the caller's transaction,
+// attributed to the provenance in ctx.
+func recordChange(ctx context.Context, ex execer, ts, operation, entityName, relatedEntity string, before, after interface{}) error {
+	beforeJSON, err := changeJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := changeJSON(after)
+	if err != nil {
+		return err
+	}
+	p := ProvenanceFrom(ctx)
+	_, err = ex.ExecContext(ctx,
+		`INSERT INTO changelog(at, operation, entity_name, related_entity, before, after, source, session_id, actor)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ts, operation, entityName, nullable(relatedEntity), beforeJSON, afterJSON,
+		nullable(p.Source), nullable(p.SessionID), nullable(p.Actor),
+	)
+	return err
+}
+
+func changeJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// EntityHistory returns every recorded change to the named entity, its
+// observations and the relations touching it, oldest first. It works for
+// deleted entities too.
+func EntityHistory(db *sql.DB, name string) ([]Change, error) {
+	rows, err := db.Query(`SELECT `+changeColumns+`
+		FROM changelog
+		WHERE entity_name = ? OR related_entity = ?
+		ORDER BY id`, name, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanChanges(rows)
+}
+
+// changeColumns is the select list read by scanChanges.
+const changeColumns = `id, at, operation, entity_name, COALESCE(related_entity, ''),
+	COALESCE(before, ''), COALESCE(after, ''),
+	COALESCE(source, ''), COALESCE(session_id, ''), COALESCE(actor, '')`
+
+func scanChanges(rows *sql.Rows) ([]Change, error) {
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		var before, after string
+		if err := rows.Scan(&c.ID, &c.At, &c.Operation, &c.EntityName, &c.RelatedEntity,
+			&before, &after, &c.Source, &c.SessionID, &c.Actor); err != nil {
+			return nil, err
+		}
+		if before != "" {
+			c.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			c.After = json.RawMessage(after)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestEntityHistory(t *testing.T) {
+	db := setupTestDB(t)
+
+	ctx := WithProvenance(context.Background(), Provenance{Source: "agent-a", SessionID: "session_1"})
+	CreateEntityContext(ctx, db, "Alice", "person")
+	CreateEntityContext(ctx, db, "Acme", "company")
+	CreateEntityContext(ctx, db, "Alice", "person") // no-op, not recorded
+	CreateRelationContext(ctx, db, "Alice", "Acme", "works_at")
+	CreateObservationContext(ctx, db, "Alice", "Likes tea")
+
+	other := WithProvenance(context.Background(), Provenance{Source: "agent-b", Actor: "bob"})
+	err := DeleteObservationsContext(other, db, []struct {
+		EntityName   string   `json:"entityName"`
+		Observations []string `json:"observations"`
+	}{{EntityName: "Alice", Observations: []string{"Likes tea"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateObservationContext(other, db, "Alice", "Likes coffee")
+	if err := DeleteEntitiesContext(other, db, []string{"Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := EntityHistory(db, "Alice")
+	if err != nil {
+		t.Fatalf("EntityHistory() failed: %v", err)
+	}
+
+	want := []struct {
+		op     string
+		source string
+	}{
+		{OpCreateEntity, "agent-a"},
+		{OpCreateRelation, "agent-a"},
+		{OpAddObservation, "agent-a"},
+		{OpDeleteObservation, "agent-b"},
+		{OpAddObservation, "agent-b"},
+		// the cascade is recorded before the entity itself
+		{OpDeleteRelation, "agent-b"},
+		{OpDeleteObservation, "agent-b"},
+		{OpDeleteEntity, "agent-b"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d changes, got %d: %+v", len(want), len(history), history)
+	}
+	for i, w := range want {
+		c := history[i]
+		if c.Operation != w.op || c.Source != w.source {
+			t.Errorf("Change %d: expected %s by %s, got %s by %s", i, w.op, w.source, c.Operation, c.Source)
+		}
+		if c.At == "" {
+			t.Errorf("Change %d has no timestamp", i)
+		}
+	}
+
+	// Before and after carry the row
+	var created Entity
+	if err := json.Unmarshal(history[0].After, &created); err != nil || created.Type != "person" {
+		t.Errorf("Expected created entity in after, got %s (%v)", history[0].After, err)
+	}
+	if history[0].Before != nil {
+		t.Errorf("Expected no before on creation, got %s", history[0].Before)
+	}
+	var removed Observation
+	if err := json.Unmarshal(history[3].Before, &removed); err != nil || removed.Content != "Likes tea" {
+		t.Errorf("Expected removed observation in before, got %s (%v)", history[3].Before, err)
+	}
+	if history[7].Actor != "bob" || history[7].After != nil {
+		t.Errorf("Expected deletion by bob without after, got %+v", history[7])
+	}
+
+	// Relations show up in both endpoints' history
+	acme, err := EntityHistory(db, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acme) != 3 || acme[1].Operation != OpCreateRelation || acme[1].RelatedEntity != "Acme" {
+		t.Errorf("Expected Acme's history to include the relation, got %+v", acme)
+	}
+
+	// The changelog cannot be rewritten
+	if _, err := db.Exec(`DELETE FROM changelog`); err == nil {
+		t.Error("Expected changelog deletes to be rejected")
+	}
+	if _, err := db.Exec(`UPDATE changelog SET actor = 'mallory'`); err == nil {
+		t.Error("Expected changelog updates to be rejected")
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// What Complete suggests values for.
+const (
+	CompleteEntityNames   = "entityNames"
+	CompleteEntityTypes   = "entityTypes"
+	CompleteRelationTypes = "relationTypes"
+)
+
+// completionSources select the candidate values of each kind as value,
+// with uses counting how often each is used: the relations and
+// observations of an entity, the entities of a type, the relations of a
+// relation type.
+var completionSources = map[string]string{
+	CompleteEntityNames: `
+		WITH uses(name, n) AS (
+			SELECT from_entity, COUNT(*) FROM relations WHERE deleted_at IS NULL GROUP BY from_entity
+			UNION ALL
+			SELECT to_entity, COUNT(*) FROM relations WHERE deleted_at IS NULL GROUP BY to_entity
+			UNION ALL
+			SELECT entity_name, COUNT(*) FROM observations WHERE deleted_at IS NULL GROUP BY entity_name
+		)
+		SELECT e.name AS value, COALESCE(SUM(u.n), 0) AS uses
+		FROM entities e LEFT JOIN uses u ON u.name = e.name
+		WHERE e.deleted_at IS NULL AND e.name LIKE ? ESCAPE '\'
+		GROUP BY e.name`,
+	CompleteEntityTypes: `
+		SELECT entity_type AS value, COUNT(*) AS uses
+		FROM entities
+		WHERE deleted_at IS NULL AND entity_type LIKE ? ESCAPE '\'
+		GROUP BY entity_type`,
+	CompleteRelationTypes: `
+		SELECT relation_type AS value, COUNT(*) AS uses
+		FROM relations
+		WHERE deleted_at IS NULL AND relation_type LIKE ? ESCAPE '\'
+		GROUP BY relation_type`,
+}
+
+// Complete suggests up to limit values of kind for what a user has typed
+// so far, and returns how many values match in all. Values starting with
+// prefix come first, then values containing it, then values containing
+// its characters in order (so "acp" finds "Acme Corp"), each ranked by how
+// often the value is used. Matching ignores ASCII case.
+func Complete(ctx context.Context, db *sql.DB, kind, prefix string, limit int) ([]string, int, error) {
+	source, ok := completionSources[kind]
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot complete %q", kind)
+	}
+	escaped := escapeLike(prefix)
+	var fuzzy strings.Builder
+	fuzzy.WriteString("%")
+	for _, r := range prefix {
+		fuzzy.WriteString(escapeLike(string(r)))
+		fuzzy.WriteString("%")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT value, COUNT(*) OVER () FROM (`+source+`)
+		ORDER BY CASE
+			WHEN value LIKE ? ESCAPE '\' THEN 0
+			WHEN value LIKE ? ESCAPE '\' THEN 1
+			ELSE 2
+		END, uses DESC, value
+		LIMIT ?`,
+		fuzzy.String(), escaped+"%", "%"+escaped+"%", limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	total := 0
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value, &total); err != nil {
+			return nil, 0, err
+		}
+		values = append(values, value)
+	}
+	return values, total, rows.Err()
+}
+
+// escapeLike quotes the LIKE wildcards in s, for patterns with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
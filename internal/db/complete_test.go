@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	CreateEntity(db, "Acme Corp", "organization")
+	CreateEntity(db, "Acme Labs", "organization")
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Carol", "person")
+	CreateEntity(db, "Old Acme", "organization")
+	CreateEntity(db, "100%_Pure", "brand")
+	CreateRelation(db, "Alice", "Acme Labs", "works_at")
+	CreateRelation(db, "Bob", "Acme Labs", "works_at")
+	CreateRelation(db, "Carol", "Acme Corp", "works_at")
+	CreateRelation(db, "Alice", "Bob", "knows")
+	CreateObservation(db, "Old Acme", "closed in 1999")
+	CreateObservation(db, "Old Acme", "made anvils")
+	CreateObservation(db, "Old Acme", "had a famous customer")
+	CreateEntity(db, "Gone", "person")
+	DeleteEntities(db, []string{"Gone"})
+
+	tests := []struct {
+		name   string
+		kind   string
+		prefix string
+		limit  int
+		want   []string
+		total  int
+	}{
+		// Acme Labs has two relations, Acme Corp one
+		{"prefix by use", CompleteEntityNames, "acme", 10, []string{"Acme Labs", "Acme Corp", "Old Acme"}, 3},
+		{"fuzzy after substring", CompleteEntityNames, "acp", 10, []string{"Acme Corp"}, 1},
+		{"limit", CompleteEntityNames, "a", 2, []string{"Acme Labs", "Alice"}, 5},
+		{"deleted entities", CompleteEntityNames, "gon", 10, []string{}, 0},
+		{"wildcards are literal", CompleteEntityNames, "100%_", 10, []string{"100%_Pure"}, 1},
+		{"types by use", CompleteEntityTypes, "", 10, []string{"organization", "person", "brand"}, 3},
+		{"relation types", CompleteRelationTypes, "w", 10, []string{"works_at", "knows"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, total, err := Complete(ctx, db, tt.kind, tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tt.want) || total != tt.total {
+				t.Errorf("Expected %v of %d, got %v of %d", tt.want, tt.total, values, total)
+			}
+		})
+	}
+
+	if _, _, err := Complete(ctx, db, "colors", "", 10); err == nil {
+		t.Error("Expected an unknown kind to fail")
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrObservationsChanged is returned by ReplaceObservationsContext when an
+// observation to replace was deleted in the meantime.
+var ErrObservationsChanged = errors.New("observations changed since they were read")
+
+// EntityObservationsContext returns the live observations of an entity,
+// oldest first.
+func EntityObservationsContext(ctx context.Context, db *sql.DB, entityName string) ([]Observation, error) {
+	if err := requireEntity(ctx, db, entityName); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT `+observationColumns+` FROM observations
+		WHERE deleted_at IS NULL AND entity_name = ? ORDER BY id`, entityName)
+	if err != nil {
+		return nil, err
+	}
+	return scanObservations(rows)
+}
+
+// ReplaceObservationsContext replaces the observations of entityName with
+// the given IDs by contents, in one transaction. Observations whose
+// content is among contents are kept as they are; the others are
+// tombstoned and recorded in the changelog like any delete, so they stay
+// in the entity's history. It returns the observations removed and added,
+// and fails with ErrObservationsChanged if one of ids is no longer live.
+func ReplaceObservationsContext(ctx context.Context, db *sql.DB, entityName string, ids []int64, contents []string) (removed, added []Observation, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := requireEntity(ctx, tx, entityName); err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]int, len(contents))
+	for _, c := range contents {
+		wanted[c]++
+	}
+
+	var drop []interface{}
+	for _, id := range ids {
+		var content string
+		err := tx.QueryRowContext(ctx, `SELECT content FROM observations
+			WHERE id = ? AND entity_name = ? AND deleted_at IS NULL`, id, entityName).Scan(&content)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, fmt.Errorf("%w: observation %d of %s", ErrObservationsChanged, id, entityName)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if wanted[content] > 0 {
+			wanted[content]--
+			continue
+		}
+		drop = append(drop, id)
+	}
+
+	ts := FormatTime(now())
+	if len(drop) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(drop)), ",")
+		removed, err = softDeleteObservations(ctx, tx, ts, `id IN (`+placeholders+`)`, drop...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE entities SET updated_at = ? WHERE name = ?`, ts, entityName); err != nil {
+			return nil, nil, err
+		}
+	}
+	p := ProvenanceFrom(ctx)
+	for _, c := range contents {
+		if wanted[c] == 0 {
+			continue
+		}
+		wanted[c]--
+		id, err := insertObservation(ctx, tx, entityName, c, ts)
+		if err != nil {
+			return nil, nil, err
+		}
+		added = append(added, Observation{ID: id, EntityName: entityName, Content: c, CreatedAt: ts, UpdatedAt: ts, Provenance: p})
+	}
+
+	if err := commit(db, tx); err != nil {
+		return nil, nil, err
+	}
+	return removed, added, nil
+}
+
+// MergeObservations merges exact and near duplicates in contents, in
+// order. Two observations are near duplicates when, ignoring case and
+// punctuation, one is the other with words added around it ("likes tea"
+// and "likes tea with milk"), or their words overlap by at least
+// mergeSimilarity, and the words only one of them has include no
+// negation: "is a manager" and "is not a manager" are kept apart. The
+// longer of the two is kept, at the place of the first. Blank
+// observations are dropped.
+func MergeObservations(contents []string) []string {
+	var kept []string
+	var keptWords [][]string
+	for _, c := range contents {
+		words := observationWords(c)
+		if len(words) == 0 {
+			if strings.TrimSpace(c) != "" {
+				kept = append(kept, c)
+				keptWords = append(keptWords, nil)
+			}
+			continue
+		}
+		merged := false
+		for i, other := range keptWords {
+			if other != nil && nearDuplicate(words, other) {
+				if len(words) > len(other) {
+					kept[i], keptWords[i] = c, words
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			kept = append(kept, c)
+			keptWords = append(keptWords, words)
+		}
+	}
+	return kept
+}
+
+// mergeSimilarity is the share of distinct words two observations must
+// have in common to be merged.
+const mergeSimilarity = 0.8
+
+// observationWords folds an observation to its lower-case words.
+func observationWords(content string) []string {
+	return strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// negations are the words that turn an observation into its opposite.
+// "t" is what remains of "n't" once "isn't" is split into words.
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "none": true, "nobody": true,
+	"nothing": true, "nowhere": true, "neither": true, "nor": true,
+	"without": true, "cannot": true, "non": true, "t": true,
+}
+
+func nearDuplicate(a, b []string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if differByNegation(a, b) {
+		return false
+	}
+	// a is a run of words in b
+	run := strings.Join(a, " ")
+	for i := 0; i+len(a) <= len(b); i++ {
+		if strings.Join(b[i:i+len(a)], " ") == run {
+			return true
+		}
+	}
+
+	set := make(map[string]bool, len(a))
+	for _, w := range a {
+		set[w] = true
+	}
+	union := len(set)
+	common := 0
+	seen := make(map[string]bool, len(b))
+	for _, w := range b {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		if set[w] {
+			common++
+		} else {
+			union++
+		}
+	}
+	return float64(common) >= mergeSimilarity*float64(union)
+}
+
+// differByNegation reports whether a negation is among the words that
+// only one of a and b has.
+func differByNegation(a, b []string) bool {
+	inA := make(map[string]bool, len(a))
+	for _, w := range a {
+		inA[w] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, w := range b {
+		inB[w] = true
+		if negations[w] && !inA[w] {
+			return true
+		}
+	}
+	for _, w := range a {
+		if negations[w] && !inB[w] {
+			return true
+		}
+	}
+	return false
+}
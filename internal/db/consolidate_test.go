@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergeObservations(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"exact", []string{"Likes tea", "likes tea.", "Plays chess"}, []string{"Likes tea", "Plays chess"}},
+		{"longer wins in place", []string{"likes tea", "plays chess", "likes tea with milk"}, []string{"likes tea with milk", "plays chess"}},
+		{"reworded", []string{"Alice works at Acme Corp in Berlin", "Alice works in Berlin at Acme Corp"}, []string{"Alice works at Acme Corp in Berlin"}},
+		{"different facts", []string{"born in 1990", "born in Paris"}, []string{"born in 1990", "born in Paris"}},
+		{"negated run", []string{"manager", "not a manager"}, []string{"manager", "not a manager"}},
+		{"negated reword", []string{"Is on the payments team", "Is not on the payments team"}, []string{"Is on the payments team", "Is not on the payments team"}},
+		{"contraction", []string{"Alice likes tea", "Alice doesn't like tea"}, []string{"Alice likes tea", "Alice doesn't like tea"}},
+		{"blank", []string{"  ", "likes tea", "!!"}, []string{"likes tea", "!!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeObservations(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReplaceObservations(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	CreateEntity(db, "Alice", "person")
+	for _, c := range []string{"likes tea", "Likes tea.", "plays chess"} {
+		CreateObservation(db, "Alice", c)
+	}
+
+	observations, err := EntityObservationsContext(ctx, db, "Alice")
+	if err != nil || len(observations) != 3 {
+		t.Fatalf("Expected 3 observations, got %v, %v", observations, err)
+	}
+	ids := []int64{observations[0].ID, observations[1].ID, observations[2].ID}
+
+	// Unchanged observations are kept as they are
+	removed, added, err := ReplaceObservationsContext(ctx, db, "Alice", ids, []string{"likes tea", "plays chess and go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0].Content != "Likes tea." || removed[1].Content != "plays chess" {
+		t.Errorf("Unexpected removed observations %+v", removed)
+	}
+	if len(added) != 1 || added[0].Content != "plays chess and go" {
+		t.Errorf("Unexpected added observations %+v", added)
+	}
+	observations, _ = EntityObservationsContext(ctx, db, "Alice")
+	if len(observations) != 2 || observations[0].ID != ids[0] {
+		t.Errorf("Expected the kept and the new observation, got %+v", observations)
+	}
+
+	// The originals stay in the history
+	changes, err := EntityHistory(db, "Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletes := 0
+	for _, c := range changes {
+		if c.Operation == OpDeleteObservation {
+			deletes++
+		}
+	}
+	if deletes != 2 {
+		t.Errorf("Expected 2 observation deletes in the history, got %d", deletes)
+	}
+
+	// Observations deleted in the meantime abort the replacement
+	if _, _, err := ReplaceObservationsContext(ctx, db, "Alice", ids, []string{"nothing"}); !errors.Is(err, ErrObservationsChanged) {
+		t.Errorf("Expected ErrObservationsChanged, got %v", err)
+	}
+	if observations, _ := EntityObservationsContext(ctx, db, "Alice"); len(observations) != 2 {
+		t.Errorf("Expected a failed replacement to change nothing, got %+v", observations)
+	}
+	if _, err := EntityObservationsContext(ctx, db, "Nobody"); err == nil {
+		t.Error("Expected an error for a missing entity")
+	}
+}
@@ -1,41 +1,106 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mattn/go-sqlite3"
+
+	"gnolledgegraph/internal/db/schema"
 )
 
-func Init(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+// Open opens the sqlite database at path without touching its schema.
+// Foreign keys are enabled through the DSN so that every pooled connection
+// enforces them, not just the first one.
+func Open(path string) (*sql.DB, error) {
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=on"
+	} else {
+		dsn += "?_foreign_keys=on"
+	}
+	file := path
+	if i := strings.Index(file, "?"); i >= 0 {
+		file = file[:i]
+	}
+	return sql.OpenDB(&connector{path: file, dsn: dsn}), nil
+}
+
+// connector opens the connections of a pool returned by Open. It lets
+// ImportDatabase hold new connections back while it swaps the file under
+// the pool, so every holder of the *sql.DB sees the new database.
+type connector struct {
+	path string
+	dsn  string
+	// gate is held for writing while the file is being swapped
+	gate sync.RWMutex
+	// conns counts the connections opened by Connect and not yet closed.
+	// The pool's own count includes connections still waiting on gate.
+	conns atomic.Int64
+	// importing serializes imports
+	importing sync.Mutex
+	// watchers receive the changes committed through the pool
+	watchers watchers
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+	conn, err := c.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	c.conns.Add(1)
+	return &countedConn{timedConn: conn.(timedConn), connector: c}, nil
+}
+
+// countedConn is a connection counted in its connector's conns.
+type countedConn struct {
+	timedConn
+	connector *connector
+	closed    bool
+}
+
+func (c *countedConn) Close() error {
+	if !c.closed {
+		c.closed = true
+		c.connector.conns.Add(-1)
+	}
+	return c.timedConn.Close()
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c
+}
+
+func (c *connector) Open(dsn string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(dsn)
 	if err != nil {
 		return nil, err
 	}
-	// enable foreign keys
-	if _, err := db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
+	return timedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// Init opens the database and applies any pending schema migrations.
+// It fails if the database was created by a newer binary.
+func Init(path string) (*sql.DB, error) {
+	db, err := Open(path)
+	if err != nil {
 		return nil, err
 	}
 	// run schema migrations
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS entities (
-			name TEXT PRIMARY KEY,
-			entity_type TEXT NOT NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS relations (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			from_entity TEXT NOT NULL REFERENCES entities(name),
-			to_entity TEXT NOT NULL REFERENCES entities(name),
-			relation_type TEXT NOT NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS observations (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			entity_name TEXT NOT NULL REFERENCES entities(name),
-			content TEXT NOT NULL
-		);`,
-	}
-	for _, s := range stmts {
-		if _, err := db.Exec(s); err != nil {
-			return nil, err
-		}
+	if err := schema.Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// full-text search index, when FTS5 is compiled in
+	if err := initSearchIndex(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 	return db, nil
 }
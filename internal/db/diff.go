@@ -0,0 +1,247 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GraphDiff lists what changes between two graphs. Entities are matched
+// by name, observations by entity and content, and relations by their
+// endpoints and type, so an edited observation or relation shows up as
+// one removed and one added.
+type GraphDiff struct {
+	AddedEntities       []Entity       `json:"addedEntities"`
+	RemovedEntities     []Entity       `json:"removedEntities"`
+	ModifiedEntities    []EntityChange `json:"modifiedEntities"`
+	AddedObservations   []Observation  `json:"addedObservations"`
+	RemovedObservations []Observation  `json:"removedObservations"`
+	AddedRelations      []Relation     `json:"addedRelations"`
+	RemovedRelations    []Relation     `json:"removedRelations"`
+}
+
+// EntityChange is an entity present in both graphs whose type differs.
+type EntityChange struct {
+	Name   string `json:"name"`
+	Before Entity `json:"before"`
+	After  Entity `json:"after"`
+}
+
+// Empty reports whether the two graphs are the same.
+func (d *GraphDiff) Empty() bool {
+	return len(d.AddedEntities) == 0 && len(d.RemovedEntities) == 0 && len(d.ModifiedEntities) == 0 &&
+		len(d.AddedObservations) == 0 && len(d.RemovedObservations) == 0 &&
+		len(d.AddedRelations) == 0 && len(d.RemovedRelations) == 0
+}
+
+// DiffGraphs returns the changes that turn from into to. The result is
+// sorted by name so that equal inputs give equal output.
+func DiffGraphs(from, to *Graph) *GraphDiff {
+	d := &GraphDiff{
+		AddedEntities:       []Entity{},
+		RemovedEntities:     []Entity{},
+		ModifiedEntities:    []EntityChange{},
+		AddedObservations:   []Observation{},
+		RemovedObservations: []Observation{},
+		AddedRelations:      []Relation{},
+		RemovedRelations:    []Relation{},
+	}
+
+	fromEntities := make(map[string]Entity, len(from.Entities))
+	for _, e := range from.Entities {
+		fromEntities[e.Name] = e
+	}
+	toEntities := make(map[string]bool, len(to.Entities))
+	for _, e := range to.Entities {
+		toEntities[e.Name] = true
+		before, ok := fromEntities[e.Name]
+		switch {
+		case !ok:
+			d.AddedEntities = append(d.AddedEntities, e)
+		case before.Type != e.Type:
+			d.ModifiedEntities = append(d.ModifiedEntities, EntityChange{Name: e.Name, Before: before, After: e})
+		}
+	}
+	for _, e := range from.Entities {
+		if !toEntities[e.Name] {
+			d.RemovedEntities = append(d.RemovedEntities, e)
+		}
+	}
+
+	// Observations and relations may repeat, so they are counted rather
+	// than just looked up.
+	fromObs := make(map[[2]string][]Observation)
+	for _, o := range from.Observations {
+		key := [2]string{o.EntityName, o.Content}
+		fromObs[key] = append(fromObs[key], o)
+	}
+	for _, o := range to.Observations {
+		key := [2]string{o.EntityName, o.Content}
+		if matches := fromObs[key]; len(matches) > 0 {
+			fromObs[key] = matches[1:]
+			continue
+		}
+		d.AddedObservations = append(d.AddedObservations, o)
+	}
+	for _, matches := range fromObs {
+		d.RemovedObservations = append(d.RemovedObservations, matches...)
+	}
+
+	fromRels := make(map[[3]string][]Relation)
+	for _, r := range from.Relations {
+		key := [3]string{r.From, r.To, r.Type}
+		fromRels[key] = append(fromRels[key], r)
+	}
+	for _, r := range to.Relations {
+		key := [3]string{r.From, r.To, r.Type}
+		if matches := fromRels[key]; len(matches) > 0 {
+			fromRels[key] = matches[1:]
+			continue
+		}
+		d.AddedRelations = append(d.AddedRelations, r)
+	}
+	for _, matches := range fromRels {
+		d.RemovedRelations = append(d.RemovedRelations, matches...)
+	}
+
+	for _, entities := range [][]Entity{d.AddedEntities, d.RemovedEntities} {
+		sort.Slice(entities, func(i, j int) bool { return entities[i].Name < entities[j].Name })
+	}
+	sort.Slice(d.ModifiedEntities, func(i, j int) bool { return d.ModifiedEntities[i].Name < d.ModifiedEntities[j].Name })
+	for _, observations := range [][]Observation{d.AddedObservations, d.RemovedObservations} {
+		sort.Slice(observations, func(i, j int) bool {
+			a, b := observations[i], observations[j]
+			if a.EntityName != b.EntityName {
+				return a.EntityName < b.EntityName
+			}
+			return a.Content < b.Content
+		})
+	}
+	for _, relations := range [][]Relation{d.AddedRelations, d.RemovedRelations} {
+		sort.Slice(relations, func(i, j int) bool {
+			a, b := relations[i], relations[j]
+			if a.From != b.From {
+				return a.From < b.From
+			}
+			if a.To != b.To {
+				return a.To < b.To
+			}
+			return a.Type < b.Type
+		})
+	}
+	return d
+}
+
+// Report renders d as a plain-text summary, one change per line: "+" for
+// added, "-" for removed and "~" for modified.
+func (d *GraphDiff) Report() string {
+	if d.Empty() {
+		return "No differences.\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Entities: %d added, %d removed, %d modified\n",
+		len(d.AddedEntities), len(d.RemovedEntities), len(d.ModifiedEntities))
+	for _, e := range d.AddedEntities {
+		fmt.Fprintf(&b, "  + %s (%s)\n", e.Name, e.Type)
+	}
+	for _, e := range d.RemovedEntities {
+		fmt.Fprintf(&b, "  - %s (%s)\n", e.Name, e.Type)
+	}
+	for _, c := range d.ModifiedEntities {
+		fmt.Fprintf(&b, "  ~ %s (%s -> %s)\n", c.Name, c.Before.Type, c.After.Type)
+	}
+	fmt.Fprintf(&b, "Observations: %d added, %d removed\n", len(d.AddedObservations), len(d.RemovedObservations))
+	for _, o := range d.AddedObservations {
+		fmt.Fprintf(&b, "  + %s: %s\n", o.EntityName, o.Content)
+	}
+	for _, o := range d.RemovedObservations {
+		fmt.Fprintf(&b, "  - %s: %s\n", o.EntityName, o.Content)
+	}
+	fmt.Fprintf(&b, "Relations: %d added, %d removed\n", len(d.AddedRelations), len(d.RemovedRelations))
+	for _, r := range d.AddedRelations {
+		fmt.Fprintf(&b, "  + %s -[%s]-> %s\n", r.From, r.Type, r.To)
+	}
+	for _, r := range d.RemovedRelations {
+		fmt.Fprintf(&b, "  - %s -[%s]-> %s\n", r.From, r.Type, r.To)
+	}
+	return b.String()
+}
+
+// sqliteHeader starts every sqlite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// ParseGraph reads a graph from a sqlite database file (such as kg.db or
+// an /api/export_db download) or from a JSON export in the read_graph
+// format of either API. Observations may be listed separately, embedded
+// in their entities, or both.
+func ParseGraph(data []byte) (*Graph, error) {
+	if bytes.HasPrefix(data, sqliteHeader) {
+		return parseDatabase(data)
+	}
+	var g Graph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("neither a sqlite database nor a JSON graph: %w", err)
+	}
+	if g.Entities == nil {
+		g.Entities = []Entity{}
+	}
+	if g.Relations == nil {
+		g.Relations = []Relation{}
+	}
+	if len(g.Observations) == 0 {
+		g.Observations = []Observation{}
+		for _, e := range g.Entities {
+			for _, content := range e.Observations {
+				g.Observations = append(g.Observations, Observation{EntityName: e.Name, Content: content})
+			}
+		}
+	}
+	return &g, nil
+}
+
+// parseDatabase reads the graph out of a database image. The image is
+// copied to a temporary file and migrated there, so databases written by
+// older versions can be compared too.
+func parseDatabase(data []byte) (*Graph, error) {
+	f, err := os.CreateTemp("", "kg-diff-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	// Refuse unrelated databases before migrating creates the tables
+	raw, err := Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	isGraph, err := hasGraphTables(raw)
+	raw.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !isGraph {
+		return nil, fmt.Errorf("database has no knowledge graph tables")
+	}
+
+	sqldb, err := Init(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer sqldb.Close()
+	entities, relations, observations, err := readGraph(context.Background(), sqldb)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{Entities: entities, Relations: relations, Observations: observations}, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiffGraphs(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+	if _, err := CreateSnapshot(context.Background(), db, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	DeleteEntities(db, []string{"Acme"})
+	CreateEntity(db, "Acme", "charity")
+	CreateEntity(db, "Bob", "person")
+	CreateObservation(db, "Alice", "Likes coffee")
+	CreateRelation(db, "Bob", "Alice", "knows")
+
+	before, err := GraphAsOf(db, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := GraphAsOf(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := DiffGraphs(before, after)
+	if len(d.AddedEntities) != 1 || d.AddedEntities[0].Name != "Bob" {
+		t.Errorf("Expected Bob added, got %+v", d.AddedEntities)
+	}
+	if len(d.RemovedEntities) != 0 {
+		t.Errorf("Expected no entities removed, got %+v", d.RemovedEntities)
+	}
+	if len(d.ModifiedEntities) != 1 || d.ModifiedEntities[0].Before.Type != "company" || d.ModifiedEntities[0].After.Type != "charity" {
+		t.Errorf("Expected Acme to change type, got %+v", d.ModifiedEntities)
+	}
+	if len(d.AddedObservations) != 1 || d.AddedObservations[0].Content != "Likes coffee" || len(d.RemovedObservations) != 0 {
+		t.Errorf("Expected one added observation, got %+v %+v", d.AddedObservations, d.RemovedObservations)
+	}
+	if len(d.AddedRelations) != 1 || d.AddedRelations[0].Type != "knows" {
+		t.Errorf("Expected knows added, got %+v", d.AddedRelations)
+	}
+	if len(d.RemovedRelations) != 1 || d.RemovedRelations[0].Type != "works_at" {
+		t.Errorf("Expected works_at removed with the old Acme, got %+v", d.RemovedRelations)
+	}
+
+	report := d.Report()
+	for _, line := range []string{
+		"Entities: 1 added, 0 removed, 1 modified",
+		"  + Bob (person)",
+		"  ~ Acme (company -> charity)",
+		"  + Alice: Likes coffee",
+		"  + Bob -[knows]-> Alice",
+		"  - Alice -[works_at]-> Acme",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, report)
+		}
+	}
+
+	// Reversing the sides swaps added and removed
+	back := DiffGraphs(after, before)
+	if len(back.RemovedEntities) != 1 || len(back.RemovedObservations) != 1 || len(back.AddedRelations) != 1 {
+		t.Errorf("Unexpected reverse diff %+v", back)
+	}
+	if d := DiffGraphs(after, after); !d.Empty() || d.Report() != "No differences.\n" {
+		t.Errorf("Expected no differences, got %+v", d)
+	}
+}
+
+func TestParseGraph(t *testing.T) {
+	db := setupTestDB(t)
+	CreateEntity(db, "Alice", "person")
+	CreateObservation(db, "Alice", "Likes tea")
+
+	// A database file, as uploaded by the web interface
+	path := t.TempDir() + "/copy.db"
+	if _, err := db.Exec(`VACUUM INTO ?`, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ParseGraph(data)
+	if err != nil {
+		t.Fatalf("ParseGraph() failed on a database: %v", err)
+	}
+	if len(g.Entities) != 1 || len(g.Observations) != 1 {
+		t.Errorf("Expected Alice and her observation, got %+v", g)
+	}
+
+	// A Python-format export embeds observations in entities
+	g, err = ParseGraph([]byte(`{"entities": [{"name": "Alice", "entityType": "person", "observations": ["Likes tea"]}],
+		"relations": [{"from": "Alice", "to": "Alice", "relationType": "knows"}]}`))
+	if err != nil {
+		t.Fatalf("ParseGraph() failed on JSON: %v", err)
+	}
+	if len(g.Observations) != 1 || g.Observations[0].EntityName != "Alice" || len(g.Relations) != 1 {
+		t.Errorf("Unexpected graph from JSON: %+v", g)
+	}
+
+	if _, err := ParseGraph([]byte("not a graph")); err == nil {
+		t.Error("Expected garbage to be rejected")
+	}
+}
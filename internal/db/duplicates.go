@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// NearDuplicates returns groups of live entities whose names only differ
+// in case, spacing, punctuation or a plural "s", such as "Alice Smith",
+// "alice_smith" and "AliceSmith". Groups are ordered by their first name,
+// and names within a group alphabetically.
+func NearDuplicates(db *sql.DB) ([][]Entity, error) {
+	rows, err := db.Query(`SELECT name, entity_type, ` + metaColumns("") + ` FROM entities WHERE deleted_at IS NULL ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]Entity)
+	var keys []string
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(entityTargets(&e)...); err != nil {
+			return nil, err
+		}
+		key := duplicateKey(e.Name)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var duplicates [][]Entity
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			duplicates = append(duplicates, groups[key])
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool { return duplicates[i][0].Name < duplicates[j][0].Name })
+	return duplicates, nil
+}
+
+// duplicateKey folds a name to the letters and digits that matter.
+func duplicateKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	key := b.String()
+	if len(key) > 3 && strings.HasSuffix(key, "s") && !strings.HasSuffix(key, "ss") {
+		key = key[:len(key)-1]
+	}
+	return key
+}
@@ -0,0 +1,28 @@
+package db
+
+import "testing"
+
+func TestNearDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	for _, name := range []string{"Alice Smith", "alice_smith", "AliceSmith", "Project", "projects", "Bob", "Boss", "Bos", "Gone"} {
+		CreateEntity(db, name, "thing")
+	}
+	CreateEntity(db, "gone", "thing")
+	DeleteEntities(db, []string{"gone"})
+
+	groups, err := NearDuplicates(db)
+	if err != nil {
+		t.Fatalf("NearDuplicates() failed: %v", err)
+	}
+	var got [][]string
+	for _, g := range groups {
+		var names []string
+		for _, e := range g {
+			names = append(names, e.Name)
+		}
+		got = append(got, names)
+	}
+	if len(got) != 2 || len(got[0]) != 3 || got[0][0] != "Alice Smith" || len(got[1]) != 2 || got[1][0] != "Project" {
+		t.Errorf("Expected the Alice Smith and Project groups, got %v", got)
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/logs"
+)
+
+// watchPollInterval is how often watchers look for changes committed by
+// other processes sharing the database file; writes made through the
+// same *sql.DB are delivered right after they commit.
+var watchPollInterval = 2 * time.Second
+
+// ChangeSet is a batch of committed changes, in changelog order. Reset is
+// set instead when the database was replaced by ImportDatabase and
+// anything may have changed.
+type ChangeSet struct {
+	Changes []Change
+	Reset   bool
+}
+
+// watchers delivers the changelog of one connector to its watchers. The
+// zero value is ready to use.
+type watchers struct {
+	mu     sync.Mutex
+	fns    map[int]func(ChangeSet)
+	nextFn int
+	// lastID is the newest changelog entry delivered
+	lastID int64
+	reset  bool
+	wake   chan struct{}
+	stop   chan struct{}
+}
+
+// Watch calls fn with every change committed to db from now on, whether
+// through this *sql.DB or another process, until the returned func is
+// called. db must come from Open or Init. Calls are made one at a time
+// from a single goroutine, so fn should not block.
+func Watch(db *sql.DB, fn func(ChangeSet)) (func(), error) {
+	c, ok := db.Driver().(*connector)
+	if !ok {
+		return nil, errors.New("database was not opened by db.Open")
+	}
+	w := &c.watchers
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fns == nil {
+		w.fns = make(map[int]func(ChangeSet))
+	}
+	if len(w.fns) == 0 {
+		lastID, err := lastChangeID(db)
+		if err != nil {
+			return nil, err
+		}
+		w.lastID = lastID
+		w.wake = make(chan struct{}, 1)
+		w.stop = make(chan struct{})
+		go w.run(db, w.wake, w.stop)
+	}
+	id := w.nextFn
+	w.nextFn++
+	w.fns[id] = fn
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.fns, id)
+			if len(w.fns) == 0 {
+				close(w.stop)
+			}
+		})
+	}, nil
+}
+
+// commit commits tx and wakes the watchers of db.
+func commit(db *sql.DB, tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if c, ok := db.Driver().(*connector); ok {
+		c.watchers.notify(false)
+	}
+	return nil
+}
+
+// notify wakes the delivery goroutine, if any. With reset, the next
+// delivery reports the database as replaced.
+func (w *watchers) notify(reset bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.fns) == 0 {
+		return
+	}
+	w.reset = w.reset || reset
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watchers) run(db *sql.DB, wake, stop chan struct{}) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+		if err := w.deliver(db); err != nil {
+			logs.Log(logs.Error, "db", "watching changes", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+// deliver reads the changelog past lastID and hands it to every watcher.
+func (w *watchers) deliver(db *sql.DB) error {
+	w.mu.Lock()
+	lastID, reset := w.lastID, w.reset
+	w.reset = false
+	w.mu.Unlock()
+
+	var set ChangeSet
+	if reset {
+		// The new file has its own changelog; start over at its end
+		newest, err := lastChangeID(db)
+		if err != nil {
+			return err
+		}
+		lastID = newest
+		set.Reset = true
+	} else {
+		changes, err := changesAfter(db, lastID)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			lastID = changes[len(changes)-1].ID
+			set.Changes = changes
+		} else {
+			// Another process may have imported a file with a shorter
+			// changelog
+			newest, err := lastChangeID(db)
+			if err != nil || newest >= lastID {
+				return err
+			}
+			lastID = newest
+			set.Reset = true
+		}
+	}
+
+	w.mu.Lock()
+	w.lastID = lastID
+	fns := make([]func(ChangeSet), 0, len(w.fns))
+	for _, fn := range w.fns {
+		fns = append(fns, fn)
+	}
+	w.mu.Unlock()
+
+	for _, fn := range fns {
+		fn(set)
+	}
+	return nil
+}
+
+func lastChangeID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM changelog`).Scan(&id)
+	return id, err
+}
+
+// changesAfter returns the changelog entries newer than id, oldest first.
+func changesAfter(db *sql.DB, id int64) ([]Change, error) {
+	rows, err := db.Query(`SELECT `+changeColumns+` FROM changelog WHERE id > ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanChanges(rows)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	db := setupTestDB(t)
+	previous := watchPollInterval
+	watchPollInterval = 20 * time.Millisecond
+	t.Cleanup(func() { watchPollInterval = previous })
+
+	CreateEntity(db, "Before", "person")
+	sets := make(chan ChangeSet, 10)
+	stop, err := Watch(db, func(set ChangeSet) { sets <- set })
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+	defer stop()
+	next := func() ChangeSet {
+		t.Helper()
+		select {
+		case set := <-sets:
+			return set
+		case <-time.After(2 * time.Second):
+			t.Fatal("no changes delivered")
+			return ChangeSet{}
+		}
+	}
+
+	// Writes through the pool are delivered, and nothing from before
+	CreateEntity(db, "Alice", "person")
+	CreateObservation(db, "Alice", "likes tea")
+	var ops []string
+	for len(ops) < 2 {
+		for _, c := range next().Changes {
+			ops = append(ops, c.Operation+" "+c.EntityName)
+		}
+	}
+	if len(ops) != 2 || ops[0] != "create_entity Alice" || ops[1] != "add_observation Alice" {
+		t.Errorf("Unexpected changes %v", ops)
+	}
+
+	// So are writes by another connection to the same file
+	other, err := Open(db.Driver().(*connector).path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`INSERT INTO changelog(at, operation, entity_name) VALUES('2024-01-01T00:00:00.000Z', 'create_entity', 'Elsewhere')`); err != nil {
+		t.Fatal(err)
+	}
+	if set := next(); len(set.Changes) != 1 || set.Changes[0].EntityName != "Elsewhere" {
+		t.Errorf("Expected the other connection's change, got %+v", set)
+	}
+
+	// An import replaces everything
+	backup, err := ImportDatabase(context.Background(), db, databaseImage(t, "Bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(backup) })
+	if set := next(); !set.Reset {
+		t.Errorf("Expected a reset after import, got %+v", set)
+	}
+	CreateEntity(db, "Carol", "person")
+	if set := next(); len(set.Changes) != 1 || set.Changes[0].EntityName != "Carol" {
+		t.Errorf("Expected Carol after the import, got %+v", set)
+	}
+
+	stop()
+	CreateEntity(db, "Dave", "person")
+	select {
+	case set := <-sets:
+		t.Errorf("Expected nothing after stop, got %+v", set)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
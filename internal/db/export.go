@@ -0,0 +1,14 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ExportDatabase writes a transactionally consistent copy of db to path,
+// which must not exist yet. Writes made while the copy runs are either
+// all in it or not at all.
+func ExportDatabase(ctx context.Context, db *sql.DB, path string) error {
+	_, err := db.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
+}
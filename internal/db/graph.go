@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -11,28 +12,62 @@ type Entity struct {
 	Name         string   `json:"name"`
 	Type         string   `json:"entityType"`
 	Observations []string `json:"observations,omitempty"`
+	// Score and Matches are only set by SearchNodes when the full-text
+	// index is available: the BM25 relevance and the highlighted
+	// observations that matched the query.
+	Score   float64  `json:"score,omitempty"`
+	Matches []string `json:"matches,omitempty"`
+	// UpdatedAt moves when observations are added or removed.
+	CreatedAt string `json:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	Provenance
 }
 
 type Relation struct {
-	ID   int64  `json:"id"`
-	From string `json:"from"`
-	To   string `json:"to"`
-	Type string `json:"relationType"`
+	ID        int64  `json:"id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Type      string `json:"relationType"`
+	CreatedAt string `json:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	Provenance
 }
 
 type Observation struct {
 	ID         int64  `json:"id"`
 	EntityName string `json:"entity_name"`
 	Content    string `json:"content"`
+	CreatedAt  string `json:"createdAt,omitempty"`
+	UpdatedAt  string `json:"updatedAt,omitempty"`
+	Provenance
+}
+
+// relationColumns is the select list read by scanRelation.
+var relationColumns = `id, from_entity, to_entity, relation_type, ` + metaColumns("")
+
+// observationColumns is the select list read by scanObservations.
+var observationColumns = `id, entity_name, content, ` + metaColumns("")
+
+// scanRelation reads a row selected with relationColumns.
+func scanRelation(rows *sql.Rows) (Relation, error) {
+	var r Relation
+	dest := append([]interface{}{&r.ID, &r.From, &r.To, &r.Type}, metaTargets(&r.CreatedAt, &r.UpdatedAt, &r.Provenance)...)
+	err := rows.Scan(dest...)
+	return r, err
 }
 
 // ReadGraph loads all entities, relations and observations
 func ReadGraph(db *sql.DB) ([]Entity, []Relation, []Observation, error) {
+	return readGraph(context.Background(), db)
+}
+
+func readGraph(ctx context.Context, ex execer) ([]Entity, []Relation, []Observation, error) {
 	// 1) Read entities and observations in one go
-	rows, err := db.Query(`
-		SELECT e.name, e.entity_type, o.id, o.content
+	rows, err := ex.QueryContext(ctx, `
+		SELECT e.name, e.entity_type, `+metaColumns("e")+`, o.id, o.content, `+metaColumns("o")+`
 		FROM entities e
-		LEFT JOIN observations o ON e.name = o.entity_name
+		LEFT JOIN observations o ON e.name = o.entity_name AND o.deleted_at IS NULL
+		WHERE e.deleted_at IS NULL
 		ORDER BY e.name, o.id
 	`)
 	if err != nil {
@@ -46,45 +81,44 @@ func ReadGraph(db *sql.DB) ([]Entity, []Relation, []Observation, error) {
 	observationMap := make(map[int64]bool)
 
 	for rows.Next() {
-		var name, entityType string
+		var e Entity
+		var o Observation
 		var obsID sql.NullInt64
 		var obsContent sql.NullString
-		if err := rows.Scan(&name, &entityType, &obsID, &obsContent); err != nil {
+		dest := append(entityTargets(&e), &obsID, &obsContent)
+		dest = append(dest, metaTargets(&o.CreatedAt, &o.UpdatedAt, &o.Provenance)...)
+		if err := rows.Scan(dest...); err != nil {
 			return nil, nil, nil, err
 		}
 
-		if _, exists := entityMap[name]; !exists {
-			entities = append(entities, Entity{
-				Name:         name,
-				Type:         entityType,
-				Observations: []string{},
-			})
-			entityMap[name] = &entities[len(entities)-1]
+		if _, exists := entityMap[e.Name]; !exists {
+			e.Observations = []string{}
+			entities = append(entities, e)
+			entityMap[e.Name] = &entities[len(entities)-1]
 		}
 
 		if obsContent.Valid && obsID.Valid && !observationMap[obsID.Int64] {
-			entity := entityMap[name]
+			entity := entityMap[e.Name]
 			entity.Observations = append(entity.Observations, obsContent.String)
-			observations = append(observations, Observation{
-				ID:         obsID.Int64,
-				EntityName: name,
-				Content:    obsContent.String,
-			})
+			o.ID = obsID.Int64
+			o.EntityName = e.Name
+			o.Content = obsContent.String
+			observations = append(observations, o)
 			observationMap[obsID.Int64] = true
 		}
 	}
 
 	// 2) Read relations
 	var relations []Relation
-	rows, err = db.Query(`SELECT id, from_entity, to_entity, relation_type FROM relations`)
+	rows, err = ex.QueryContext(ctx, `SELECT `+relationColumns+` FROM relations WHERE deleted_at IS NULL`)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r Relation
-		if err := rows.Scan(&r.ID, &r.From, &r.To, &r.Type); err != nil {
+		r, err := scanRelation(rows)
+		if err != nil {
 			return nil, nil, nil, err
 		}
 		relations = append(relations, r)
@@ -93,59 +127,221 @@ func ReadGraph(db *sql.DB) ([]Entity, []Relation, []Observation, error) {
 	return entities, relations, observations, nil
 }
 
+// EntityExists reports whether a live (not deleted) entity is named name.
+func EntityExists(db *sql.DB, name string) (bool, error) {
+	return entityExists(context.Background(), db, name)
+}
+
+func entityExists(ctx context.Context, ex execer, name string) (bool, error) {
+	var exists bool
+	err := ex.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM entities WHERE name = ? AND deleted_at IS NULL)`, name).Scan(&exists)
+	return exists, err
+}
+
+// requireEntity fails unless a live entity is named name.
+func requireEntity(ctx context.Context, ex execer, name string) error {
+	exists, err := entityExists(ctx, ex, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("entity '%s' does not exist", name)
+	}
+	return nil
+}
+
 // CreateEntity inserts a new entity
 func CreateEntity(db *sql.DB, name, entityType string) error {
-	_, err := db.Exec(
-		`INSERT OR IGNORE INTO entities(name, entity_type) VALUES(?, ?)`,
-		name, entityType,
-	)
+	_, err := CreateEntityContext(context.Background(), db, name, entityType)
 	return err
 }
 
+// CreateEntityContext inserts a new entity attributed to the provenance in
+// ctx and reports whether it did. An existing entity of the same name is
+// left untouched.
+func CreateEntityContext(ctx context.Context, db *sql.DB, name, entityType string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	created, err := createEntity(ctx, tx, FormatTime(now()), name, entityType)
+	if err != nil {
+		return false, err
+	}
+	return created, commit(db, tx)
+}
+
+// createEntity inserts an entity written at ts unless a live one of the
+// same name exists, records the creation and reports whether it inserted.
+func createEntity(ctx context.Context, tx *sql.Tx, ts, name, entityType string) (bool, error) {
+	// A deleted entity of the same name makes way for the new one for good.
+	if err := purgeEntityTombstones(ctx, tx, ts, `name = ?`, name); err != nil {
+		return false, err
+	}
+	p := ProvenanceFrom(ctx)
+	res, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO entities(name, entity_type, created_at, updated_at, source, session_id, actor)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		name, entityType, ts, ts, nullable(p.Source), nullable(p.SessionID), nullable(p.Actor),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	after := Entity{Name: name, Type: entityType, CreatedAt: ts, UpdatedAt: ts, Provenance: p}
+	if err := recordChange(ctx, tx, ts, OpCreateEntity, name, "", nil, after); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // CreateRelation inserts a new relation and returns its new ID
 func CreateRelation(db *sql.DB, from, to, relationType string) (int64, error) {
-	res, err := db.Exec(
-		`INSERT INTO relations(from_entity, to_entity, relation_type) VALUES(?, ?, ?)`,
-		from, to, relationType,
+	return CreateRelationContext(context.Background(), db, from, to, relationType)
+}
+
+// CreateRelationContext inserts a new relation attributed to the provenance
+// in ctx and returns its new ID. If the graph already has a relation of the
+// same type between the same entities, its ID is returned instead, so
+// creating a relation twice leaves one.
+func CreateRelationContext(ctx context.Context, db *sql.DB, from, to, relationType string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, name := range []string{from, to} {
+		if err := requireEntity(ctx, tx, name); err != nil {
+			return 0, err
+		}
+	}
+	var existing int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM relations
+		WHERE from_entity = ? AND to_entity = ? AND relation_type = ? AND deleted_at IS NULL
+		ORDER BY id LIMIT 1`, from, to, relationType).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	id, err := insertRelation(ctx, tx, FormatTime(now()), from, to, relationType)
+	if err != nil {
+		return 0, err
+	}
+	return id, commit(db, tx)
+}
+
+// insertRelation adds a relation written at ts and records the change.
+func insertRelation(ctx context.Context, ex execer, ts, from, to, relationType string) (int64, error) {
+	p := ProvenanceFrom(ctx)
+	res, err := ex.ExecContext(ctx,
+		`INSERT INTO relations(from_entity, to_entity, relation_type, created_at, updated_at, source, session_id, actor)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		from, to, relationType, ts, ts, nullable(p.Source), nullable(p.SessionID), nullable(p.Actor),
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.LastInsertId()
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	after := Relation{ID: id, From: from, To: to, Type: relationType, CreatedAt: ts, UpdatedAt: ts, Provenance: p}
+	if err := recordChange(ctx, ex, ts, OpCreateRelation, from, to, nil, after); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 // CreateObservation inserts a new observation and returns its new ID
 func CreateObservation(db *sql.DB, entityName, content string) (int64, error) {
-	res, err := db.Exec(
-		`INSERT INTO observations(entity_name, content) VALUES(?, ?)`,
-		entityName, content,
+	return CreateObservationContext(context.Background(), db, entityName, content)
+}
+
+// CreateObservationContext inserts a new observation attributed to the
+// provenance in ctx, marks its entity as updated and returns the new ID.
+func CreateObservationContext(ctx context.Context, db *sql.DB, entityName, content string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := requireEntity(ctx, tx, entityName); err != nil {
+		return 0, err
+	}
+	id, err := insertObservation(ctx, tx, entityName, content, FormatTime(now()))
+	if err != nil {
+		return 0, err
+	}
+	return id, commit(db, tx)
+}
+
+// insertObservation adds an observation written at ts, bumps the entity's
+// updated_at and records the change.
+func insertObservation(ctx context.Context, ex execer, entityName, content, ts string) (int64, error) {
+	p := ProvenanceFrom(ctx)
+	res, err := ex.ExecContext(ctx,
+		`INSERT INTO observations(entity_name, content, created_at, updated_at, source, session_id, actor)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		entityName, content, ts, ts, nullable(p.Source), nullable(p.SessionID), nullable(p.Actor),
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.LastInsertId()
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := ex.ExecContext(ctx, `UPDATE entities SET updated_at = ? WHERE name = ?`, ts, entityName); err != nil {
+		return 0, err
+	}
+	after := Observation{ID: id, EntityName: entityName, Content: content, CreatedAt: ts, UpdatedAt: ts, Provenance: p}
+	if err := recordChange(ctx, ex, ts, OpAddObservation, entityName, "", nil, after); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 // AddObservations adds multiple observations to existing entities
 func AddObservations(db *sql.DB, observations []struct {
 	EntityName string `json:"entityName"`
 	Contents   string `json:"contents"`
+}) ([]Observation, error) {
+	return AddObservationsContext(context.Background(), db, observations)
+}
+
+// AddObservationsContext adds multiple observations to existing entities,
+// attributed to the provenance in ctx. Either all of them are added or none.
+func AddObservationsContext(ctx context.Context, db *sql.DB, observations []struct {
+	EntityName string `json:"entityName"`
+	Contents   string `json:"contents"`
 }) ([]Observation, error) {
 	var added []Observation
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ts := FormatTime(now())
+	p := ProvenanceFrom(ctx)
 	for _, obs := range observations {
 		// Check if entity exists
-		var exists bool
-		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE name = ?)`, obs.EntityName).Scan(&exists)
-		if err != nil {
+		if err := requireEntity(ctx, tx, obs.EntityName); err != nil {
 			return nil, err
 		}
-		if !exists {
-			return nil, fmt.Errorf("entity '%s' does not exist", obs.EntityName)
-		}
 
 		// Add observation
-		id, err := CreateObservation(db, obs.EntityName, obs.Contents)
+		id, err := insertObservation(ctx, tx, obs.EntityName, obs.Contents, ts)
 		if err != nil {
 			return nil, err
 		}
@@ -154,24 +350,47 @@ func AddObservations(db *sql.DB, observations []struct {
 			ID:         id,
 			EntityName: obs.EntityName,
 			Content:    obs.Contents,
+			CreatedAt:  ts,
+			UpdatedAt:  ts,
+			Provenance: p,
 		})
 	}
 
+	if err := commit(db, tx); err != nil {
+		return nil, err
+	}
 	return added, nil
 }
 
 // DeleteEntities removes entities and their associated relations
 func DeleteEntities(db *sql.DB, entityNames []string) error {
+	return DeleteEntitiesContext(context.Background(), db, entityNames)
+}
+
+// DeleteEntitiesContext removes entities and their associated relations.
+// The rows are tombstoned rather than removed, so RestoreEntities can bring
+// them back until they are purged. The entities and everything removed with
+// them are recorded in the changelog.
+func DeleteEntitiesContext(ctx context.Context, db *sql.DB, entityNames []string) error {
 	if len(entityNames) == 0 {
 		return nil
 	}
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := deleteEntities(ctx, tx, FormatTime(now()), entityNames); err != nil {
+		return err
+	}
+	return commit(db, tx)
+}
+
+// deleteEntities tombstones the named entities and everything attached to
+// them at ts.
+func deleteEntities(ctx context.Context, tx *sql.Tx, ts string, entityNames []string) error {
 	placeholders := strings.Repeat("?,", len(entityNames))
 	placeholders = placeholders[:len(placeholders)-1] // Remove trailing comma
 
@@ -181,36 +400,144 @@ func DeleteEntities(db *sql.DB, entityNames []string) error {
 	}
 
 	// Delete relations involving these entities
-	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM relations WHERE from_entity IN (%s) OR to_entity IN (%s)`,
-		placeholders, placeholders), append(args, args...)...)
-	if err != nil {
+	if _, err := softDeleteRelations(ctx, tx, ts, fmt.Sprintf(`from_entity IN (%s) OR to_entity IN (%s)`,
+		placeholders, placeholders), append(args, args...)...); err != nil {
 		return err
 	}
 
 	// Delete observations for these entities
-	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM observations WHERE entity_name IN (%s)`, placeholders), args...)
-	if err != nil {
+	if _, err := softDeleteObservations(ctx, tx, ts, fmt.Sprintf(`entity_name IN (%s)`, placeholders), args...); err != nil {
 		return err
 	}
 
 	// Delete entities
-	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM entities WHERE name IN (%s)`, placeholders), args...)
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`UPDATE entities SET deleted_at = ?
+		WHERE name IN (%s) AND deleted_at IS NULL
+		RETURNING name, entity_type, %s`, placeholders, metaColumns("")), append([]interface{}{ts}, args...)...)
+	if err != nil {
+		return err
+	}
+	entities, err := scanEntities(rows)
 	if err != nil {
 		return err
 	}
+	for _, e := range entities {
+		if err := recordChange(ctx, tx, ts, OpDeleteEntity, e.Name, "", e, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return tx.Commit()
+// softDeleteRelations tombstones the live relations matching where at ts,
+// records their deletion and returns them as they were.
+func softDeleteRelations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) ([]Relation, error) {
+	rows, err := tx.QueryContext(ctx, `UPDATE relations SET deleted_at = ?
+		WHERE deleted_at IS NULL AND (`+where+`) RETURNING `+relationColumns, append([]interface{}{ts}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	relations, err := scanRelations(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		if err := recordChange(ctx, tx, ts, OpDeleteRelation, r.From, r.To, r, nil); err != nil {
+			return nil, err
+		}
+	}
+	return relations, nil
+}
+
+// softDeleteObservations tombstones the live observations matching where at
+// ts, records their deletion and returns them as they were.
+func softDeleteObservations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) ([]Observation, error) {
+	rows, err := tx.QueryContext(ctx, `UPDATE observations SET deleted_at = ?
+		WHERE deleted_at IS NULL AND (`+where+`) RETURNING `+observationColumns, append([]interface{}{ts}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	observations, err := scanObservations(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range observations {
+		if err := recordChange(ctx, tx, ts, OpDeleteObservation, o.EntityName, "", o, nil); err != nil {
+			return nil, err
+		}
+	}
+	return observations, nil
+}
+
+// scanEntities reads and closes rows selected as name, entity_type and
+// metaColumns.
+func scanEntities(rows *sql.Rows) ([]Entity, error) {
+	defer rows.Close()
+	var entities []Entity
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(entityTargets(&e)...); err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+// scanRelations reads and closes rows selected with relationColumns.
+func scanRelations(rows *sql.Rows) ([]Relation, error) {
+	defer rows.Close()
+	var relations []Relation
+	for rows.Next() {
+		r, err := scanRelation(rows)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, r)
+	}
+	return relations, rows.Err()
+}
+
+// scanObservations reads and closes rows selected with observationColumns.
+func scanObservations(rows *sql.Rows) ([]Observation, error) {
+	defer rows.Close()
+	var observations []Observation
+	for rows.Next() {
+		var o Observation
+		dest := append([]interface{}{&o.ID, &o.EntityName, &o.Content}, metaTargets(&o.CreatedAt, &o.UpdatedAt, &o.Provenance)...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		observations = append(observations, o)
+	}
+	return observations, rows.Err()
 }
 
 // DeleteObservations removes specific observations from entities
 func DeleteObservations(db *sql.DB, deletions []struct {
 	EntityName   string   `json:"entityName"`
 	Observations []string `json:"observations"`
+}) error {
+	return DeleteObservationsContext(context.Background(), db, deletions)
+}
+
+// DeleteObservationsContext removes specific observations from entities and
+// marks the entities that lost observations as updated.
+func DeleteObservationsContext(ctx context.Context, db *sql.DB, deletions []struct {
+	EntityName   string   `json:"entityName"`
+	Observations []string `json:"observations"`
 }) error {
 	if len(deletions) == 0 {
 		return nil
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ts := FormatTime(now())
 	for _, deletion := range deletions {
 		if len(deletion.Observations) == 0 {
 			continue
@@ -225,14 +552,19 @@ func DeleteObservations(db *sql.DB, deletions []struct {
 			args = append(args, obs)
 		}
 
-		_, err := db.Exec(fmt.Sprintf(`DELETE FROM observations WHERE entity_name = ? AND content IN (%s)`,
+		deleted, err := softDeleteObservations(ctx, tx, ts, fmt.Sprintf(`entity_name = ? AND content IN (%s)`,
 			placeholders), args...)
 		if err != nil {
 			return err
 		}
+		if len(deleted) > 0 {
+			if _, err := tx.ExecContext(ctx, `UPDATE entities SET updated_at = ? WHERE name = ?`, ts, deletion.EntityName); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	return commit(db, tx)
 }
 
 // DeleteRelations removes specific relations from the graph
@@ -240,92 +572,40 @@ func DeleteRelations(db *sql.DB, relations []struct {
 	From string `json:"from"`
 	To   string `json:"to"`
 	Type string `json:"relationType"`
+}) error {
+	return DeleteRelationsContext(context.Background(), db, relations)
+}
+
+// DeleteRelationsContext removes specific relations from the graph.
+func DeleteRelationsContext(ctx context.Context, db *sql.DB, relations []struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"relationType"`
 }) error {
 	if len(relations) == 0 {
 		return nil
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ts := FormatTime(now())
 	for _, rel := range relations {
-		_, err := db.Exec(`DELETE FROM relations WHERE from_entity = ? AND to_entity = ? AND relation_type = ?`,
-			rel.From, rel.To, rel.Type)
-		if err != nil {
+		if _, err := softDeleteRelations(ctx, tx, ts, `from_entity = ? AND to_entity = ? AND relation_type = ?`,
+			rel.From, rel.To, rel.Type); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return commit(db, tx)
 }
 
 // SearchNodes searches entities based on query string
 func SearchNodes(db *sql.DB, query string) ([]Entity, []Relation, error) {
-	searchPattern := "%" + strings.ToLower(query) + "%"
-
-	// Search entities by name, type, or observation content
-	entityQuery := `
-        SELECT DISTINCT e.name, e.entity_type 
-        FROM entities e
-        LEFT JOIN observations o ON e.name = o.entity_name
-        WHERE LOWER(e.name) LIKE ? 
-           OR LOWER(e.entity_type) LIKE ?
-           OR LOWER(o.content) LIKE ?
-    `
-
-	var entities []Entity
-	rows, err := db.Query(entityQuery, searchPattern, searchPattern, searchPattern)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var e Entity
-		if err := rows.Scan(&e.Name, &e.Type); err != nil {
-			return nil, nil, err
-		}
-		entities = append(entities, e)
-	}
-
-	// Get all relations involving the found entities
-	if len(entities) == 0 {
-		return entities, nil, nil
-	}
-
-	entityNames := make([]string, len(entities))
-	for i, e := range entities {
-		entityNames[i] = e.Name
-	}
-
-	placeholders := strings.Repeat("?,", len(entityNames))
-	placeholders = placeholders[:len(placeholders)-1]
-
-	args := make([]interface{}, len(entityNames)*2)
-	for i, name := range entityNames {
-		args[i] = name
-		args[i+len(entityNames)] = name
-	}
-
-	relationQuery := fmt.Sprintf(`
-        SELECT id, from_entity, to_entity, relation_type 
-        FROM relations 
-        WHERE from_entity IN (%s) OR to_entity IN (%s)
-    `, placeholders, placeholders)
-
-	var relations []Relation
-	rows, err = db.Query(relationQuery, args...)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var r Relation
-		if err := rows.Scan(&r.ID, &r.From, &r.To, &r.Type); err != nil {
-			return nil, nil, err
-		}
-		relations = append(relations, r)
-	}
-
-	return entities, relations, nil
+	return SearchNodesWithOptions(db, query, SearchOptions{})
 }
 
 // OpenNodes retrieves specific nodes by name
@@ -343,7 +623,7 @@ func OpenNodes(db *sql.DB, nodeNames []string) ([]Entity, []Relation, error) {
 	}
 
 	// Get requested entities
-	entityQuery := fmt.Sprintf(`SELECT name, entity_type FROM entities WHERE name IN (%s)`, placeholders)
+	entityQuery := fmt.Sprintf(`SELECT name, entity_type, %s FROM entities WHERE name IN (%s) AND deleted_at IS NULL`, metaColumns(""), placeholders)
 
 	var entities []Entity
 	rows, err := db.Query(entityQuery, args...)
@@ -354,7 +634,7 @@ func OpenNodes(db *sql.DB, nodeNames []string) ([]Entity, []Relation, error) {
 
 	for rows.Next() {
 		var e Entity
-		if err := rows.Scan(&e.Name, &e.Type); err != nil {
+		if err := rows.Scan(entityTargets(&e)...); err != nil {
 			return nil, nil, err
 		}
 		entities = append(entities, e)
@@ -366,10 +646,10 @@ func OpenNodes(db *sql.DB, nodeNames []string) ([]Entity, []Relation, error) {
 	}
 
 	relationQuery := fmt.Sprintf(`
-        SELECT id, from_entity, to_entity, relation_type 
+        SELECT %s
         FROM relations 
-        WHERE from_entity IN (%s) OR to_entity IN (%s)
-    `, placeholders, placeholders)
+        WHERE (from_entity IN (%s) OR to_entity IN (%s)) AND deleted_at IS NULL
+    `, relationColumns, placeholders, placeholders)
 
 	doubleArgs := make([]interface{}, len(args)*2)
 	copy(doubleArgs, args)
@@ -383,8 +663,8 @@ func OpenNodes(db *sql.DB, nodeNames []string) ([]Entity, []Relation, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var r Relation
-		if err := rows.Scan(&r.ID, &r.From, &r.To, &r.Type); err != nil {
+		r, err := scanRelation(rows)
+		if err != nil {
 			return nil, nil, err
 		}
 		relations = append(relations, r)
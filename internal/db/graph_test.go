@@ -66,6 +66,23 @@ func TestCreateRelation(t *testing.T) {
 			}
 		})
 	}
+
+	// Creating it again returns the same relation, until it is deleted
+	first, _ := CreateRelation(db, "Alice", "Company", "works_at")
+	if again, err := CreateRelation(db, "Alice", "Company", "works_at"); err != nil || again != first {
+		t.Errorf("Expected the existing relation %d, got %d, %v", first, again, err)
+	}
+	if _, relations, _, _ := ReadGraph(db); len(relations) != 1 {
+		t.Errorf("Expected one relation, got %+v", relations)
+	}
+	DeleteRelations(db, []struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Type string `json:"relationType"`
+	}{{From: "Alice", To: "Company", Type: "works_at"}})
+	if again, _ := CreateRelation(db, "Alice", "Company", "works_at"); again == first {
+		t.Errorf("Expected a new relation after deleting the old one, got %d again", again)
+	}
 }
 
 func TestCreateObservation(t *testing.T) {
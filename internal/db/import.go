@@ -0,0 +1,156 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gnolledgegraph/internal/db/schema"
+)
+
+// ErrInvalidImport is returned when an uploaded database is refused.
+var ErrInvalidImport = errors.New("invalid database")
+
+// defaultMaxIdleConns is database/sql's own default, restored after an
+// import has emptied the pool.
+const defaultMaxIdleConns = 2
+
+// BackupSuffix is appended to the database path to name the file an
+// import keeps as its rollback point.
+const BackupSuffix = ".bak"
+
+// ImportDatabase replaces the database behind db, which must come from
+// Open or Init, with the sqlite image in data. The image is checked
+// (sqlite header, integrity check, a knowledge graph schema no newer than
+// this binary) and migrated in a temporary file next to the live one.
+// New queries are then held back, in-flight ones are drained, and the
+// file is renamed into place, so the pool's connections all reopen on the
+// new database. The previous file is kept at the path plus BackupSuffix,
+// which is returned. If in-flight queries do not finish before ctx is
+// done, the import is abandoned and nothing changes.
+func ImportDatabase(ctx context.Context, db *sql.DB, data []byte) (string, error) {
+	c, ok := db.Driver().(*connector)
+	if !ok {
+		return "", errors.New("database was not opened by db.Open")
+	}
+	if _, err := os.Stat(c.path); err != nil {
+		return "", fmt.Errorf("live database is not a file: %w", err)
+	}
+
+	c.importing.Lock()
+	defer c.importing.Unlock()
+
+	staged, err := stageImport(c.path, data)
+	if err != nil {
+		return "", err
+	}
+	// A no-op once the staged file has been renamed into place
+	defer os.Remove(staged)
+
+	c.gate.Lock()
+	db.SetMaxIdleConns(0)
+	defer func() {
+		db.SetMaxIdleConns(defaultMaxIdleConns)
+		c.gate.Unlock()
+	}()
+	for c.conns.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for in-flight queries: %w", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	backup := c.path + BackupSuffix
+	if err := os.Rename(c.path, backup); err != nil {
+		return "", err
+	}
+	if err := os.Rename(staged, c.path); err != nil {
+		if rerr := os.Rename(backup, c.path); rerr != nil {
+			return "", fmt.Errorf("%v (and restoring %s failed: %v)", err, backup, rerr)
+		}
+		return "", err
+	}
+	c.watchers.notify(true)
+	return backup, nil
+}
+
+// stageImport validates data, writes it to a temporary file in the
+// directory of path and migrates it there.
+func stageImport(path string, data []byte) (string, error) {
+	if !bytes.HasPrefix(data, sqliteHeader) {
+		return "", fmt.Errorf("%w: not a sqlite database", ErrInvalidImport)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".import-*")
+	if err != nil {
+		return "", err
+	}
+	staged := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = checkImport(staged)
+	}
+	if err == nil {
+		var sqldb *sql.DB
+		if sqldb, err = Init(staged); err == nil {
+			err = sqldb.Close()
+		}
+	}
+	if err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	return staged, nil
+}
+
+// checkImport refuses a database file that is damaged, is not a knowledge
+// graph, or was written by a newer binary.
+func checkImport(path string) error {
+	sqldb, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer sqldb.Close()
+
+	var result string
+	if err := sqldb.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidImport, result)
+	}
+	isGraph, err := hasGraphTables(sqldb)
+	if err != nil {
+		return err
+	}
+	if !isGraph {
+		return fmt.Errorf("%w: no knowledge graph tables", ErrInvalidImport)
+	}
+	version, err := schema.Version(sqldb)
+	if err != nil {
+		return err
+	}
+	if version > schema.Latest() {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, &schema.TooNewError{Version: version, Latest: schema.Latest()})
+	}
+	return nil
+}
+
+// hasGraphTables reports whether db holds a knowledge graph, as opposed
+// to an empty or unrelated sqlite database.
+func hasGraphTables(db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'entities')`).Scan(&exists)
+	return exists, err
+}
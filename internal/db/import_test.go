@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// databaseImage returns the bytes of a fresh database holding one entity.
+func databaseImage(t *testing.T, entity string) []byte {
+	other := setupTestDB(t)
+	CreateEntity(other, entity, "person")
+	path := t.TempDir() + "/image.db"
+	if _, err := other.Exec(`VACUUM INTO ?`, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestImportDatabase(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	CreateEntity(db, "Alice", "person")
+
+	backup, err := ImportDatabase(ctx, db, databaseImage(t, "Bob"))
+	if err != nil {
+		t.Fatalf("ImportDatabase() failed: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(backup) })
+
+	// The same *sql.DB now reads and writes the imported file
+	entities, _, _, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Bob" {
+		t.Errorf("Expected only Bob after import, got %+v", entities)
+	}
+	if err := CreateEntity(db, "Carol", "person"); err != nil {
+		t.Errorf("Expected writes to work after import: %v", err)
+	}
+
+	// The previous database is kept as a rollback point
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ParseGraph(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous.Entities) != 1 || previous.Entities[0].Name != "Alice" {
+		t.Errorf("Expected Alice in the backup, got %+v", previous.Entities)
+	}
+}
+
+func TestImportDatabaseUnderLoad(t *testing.T) {
+	db := setupTestDB(t)
+	CreateEntity(db, "Alice", "person")
+	image := databaseImage(t, "Bob")
+
+	// Queries keep arriving while the file is swapped; those that need a
+	// new connection wait for the import instead of holding it back
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, _, _, err := ReadGraph(db); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	backup, err := ImportDatabase(timeout, db, image)
+	close(stop)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		t.Fatalf("Expected the import to succeed while queries run, got %v", err)
+	}
+	t.Cleanup(func() { os.Remove(backup) })
+	for err := range errs {
+		t.Errorf("Query during import failed: %v", err)
+	}
+	entities, _, _, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Bob" {
+		t.Errorf("Expected only Bob after import, got %+v", entities)
+	}
+}
+
+func TestImportDatabaseRejects(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	CreateEntity(db, "Alice", "person")
+
+	empty := t.TempDir() + "/empty.db"
+	unrelated, err := Open(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelated.Exec(`CREATE TABLE notes (body TEXT)`)
+	unrelated.Close()
+	unrelatedData, _ := os.ReadFile(empty)
+
+	tooNew := databaseImage(t, "Bob")
+	// user_version is stored big-endian at offset 60 of the header
+	tooNew[63] = 99
+
+	corrupt := databaseImage(t, "Bob")
+	for i := 100; i < len(corrupt); i++ {
+		corrupt[i] = 0xff
+	}
+
+	for name, data := range map[string][]byte{
+		"garbage":   []byte("not a database"),
+		"unrelated": unrelatedData,
+		"too new":   tooNew,
+		"corrupt":   corrupt,
+	} {
+		if _, err := ImportDatabase(ctx, db, data); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%s: expected ErrInvalidImport, got %v", name, err)
+		}
+	}
+
+	// A query that does not finish holds the import back
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := ImportDatabase(timeout, db, databaseImage(t, "Bob")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the import to time out, got %v", err)
+	}
+	tx.Rollback()
+
+	entities, _, _, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Alice" {
+		t.Errorf("Expected the live database to be untouched, got %+v", entities)
+	}
+}
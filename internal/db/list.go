@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrEntityNotFound is returned when a named entity does not exist or is
+// deleted.
+var ErrEntityNotFound = errors.New("entity not found")
+
+// DefaultListLimit is the page size of ListEntities when ListOptions.Limit
+// is not set.
+const DefaultListLimit = 100
+
+// ListOptions controls ListEntities.
+type ListOptions struct {
+	// Type restricts the list to entities of this type. Empty means all.
+	Type string
+	// After continues a previous page: only entities named after it are
+	// returned.
+	After string
+	// Limit caps the page size; values below 1 mean DefaultListLimit.
+	Limit int
+}
+
+// EntityType is an entity type in use and how many entities have it.
+type EntityType struct {
+	Type  string `json:"entityType"`
+	Count int    `json:"count"`
+}
+
+// ListEntities returns one page of live entities ordered by name, without
+// their observations. more reports whether another page follows; pass the
+// last name as ListOptions.After to get it.
+func ListEntities(db *sql.DB, opts ListOptions) (entities []Entity, more bool, err error) {
+	if opts.Limit < 1 {
+		opts.Limit = DefaultListLimit
+	}
+	query := `SELECT name, entity_type, ` + metaColumns("") + ` FROM entities WHERE deleted_at IS NULL AND name > ?`
+	args := []interface{}{opts.After}
+	if opts.Type != "" {
+		query += ` AND entity_type = ?`
+		args = append(args, opts.Type)
+	}
+	query += ` ORDER BY name LIMIT ?`
+	args = append(args, opts.Limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(entityTargets(&e)...); err != nil {
+			return nil, false, err
+		}
+		entities = append(entities, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(entities) > opts.Limit {
+		return entities[:opts.Limit], true, nil
+	}
+	return entities, false, nil
+}
+
+// EntityTypes returns the entity types of live entities, ordered by type.
+func EntityTypes(db *sql.DB) ([]EntityType, error) {
+	rows, err := db.Query(`SELECT entity_type, COUNT(*) FROM entities WHERE deleted_at IS NULL GROUP BY entity_type ORDER BY entity_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []EntityType
+	for rows.Next() {
+		var t EntityType
+		if err := rows.Scan(&t.Type, &t.Count); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, rows.Err()
+}
+
+// EntityTypesOf returns the distinct types of the named entities, deleted
+// ones included. Names without an entity are ignored.
+func EntityTypesOf(db *sql.DB, names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var types []string
+	for start := 0; start < len(names); start += maxNamesPerQuery {
+		end := start + maxNamesPerQuery
+		if end > len(names) {
+			end = len(names)
+		}
+		rows, err := db.Query(`SELECT DISTINCT entity_type FROM entities WHERE name IN (`+placeholders(end-start)+`)`, stringArgs(names[start:end])...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var t string
+			if err := rows.Scan(&t); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[t] {
+				seen[t] = true
+				types = append(types, t)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return types, nil
+}
+
+// ReadEntity returns one entity with its observations and every relation
+// it takes part in, or ErrEntityNotFound.
+func ReadEntity(db *sql.DB, name string) (*Subgraph, error) {
+	subgraph, err := ReadEntities(db, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(subgraph.Entities) == 0 {
+		return nil, ErrEntityNotFound
+	}
+	return subgraph, nil
+}
+
+// ReadEntityType returns every entity of entityType with its observations,
+// and every relation they take part in.
+func ReadEntityType(db *sql.DB, entityType string) (*Subgraph, error) {
+	rows, err := db.Query(`SELECT name FROM entities WHERE entity_type = ? AND deleted_at IS NULL ORDER BY name`, entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ReadEntities(db, names)
+}
+
+// ReadEntities returns the named entities, in order, with their
+// observations and every relation they take part in. Missing names are
+// skipped.
+func ReadEntities(db *sql.DB, names []string) (*Subgraph, error) {
+	result := &Subgraph{Entities: []Entity{}, Relations: []Relation{}}
+	for start := 0; start < len(names); start += maxNamesPerQuery {
+		end := start + maxNamesPerQuery
+		if end > len(names) {
+			end = len(names)
+		}
+		chunk := names[start:end]
+		entities, err := loadEntities(context.Background(), db, chunk)
+		if err != nil {
+			return nil, err
+		}
+		observations, err := observationsFor(context.Background(), db, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range chunk {
+			e, ok := entities[name]
+			if !ok {
+				continue
+			}
+			e.Observations = observations[name]
+			if e.Observations == nil {
+				e.Observations = []string{}
+			}
+			result.Entities = append(result.Entities, e)
+		}
+	}
+
+	relations, err := adjacentRelations(context.Background(), db, names, DirectionBoth, nil)
+	if err != nil {
+		return nil, err
+	}
+	result.Relations = append(result.Relations, relations...)
+	return result, nil
+}
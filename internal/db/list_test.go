@@ -0,0 +1,77 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListEntities(t *testing.T) {
+	db := setupTestDB(t)
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Carol", "person")
+	CreateEntity(db, "Acme", "organization")
+	CreateEntity(db, "Gone", "person")
+	DeleteEntities(db, []string{"Gone"})
+
+	var names []string
+	pages := 0
+	for after := ""; ; pages++ {
+		entities, more, err := ListEntities(db, ListOptions{After: after, Limit: 3})
+		if err != nil {
+			t.Fatalf("ListEntities() failed: %v", err)
+		}
+		for _, e := range entities {
+			names = append(names, e.Name)
+		}
+		if !more {
+			break
+		}
+		after = entities[len(entities)-1].Name
+	}
+	if pages != 1 || len(names) != 4 || names[0] != "Acme" || names[3] != "Carol" {
+		t.Errorf("Expected Acme..Carol over two pages, got %v after %d more pages", names, pages)
+	}
+
+	people, more, err := ListEntities(db, ListOptions{Type: "person"})
+	if err != nil || more || len(people) != 3 {
+		t.Errorf("Expected 3 people on one page, got %d (more %v, err %v)", len(people), more, err)
+	}
+
+	types, err := EntityTypes(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || types[0] != (EntityType{"organization", 1}) || types[1] != (EntityType{"person", 3}) {
+		t.Errorf("Unexpected entity types %+v", types)
+	}
+}
+
+func TestReadEntity(t *testing.T) {
+	db := setupTestDB(t)
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Acme", "organization")
+	CreateObservation(db, "Alice", "likes tea")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+	CreateRelation(db, "Bob", "Alice", "knows")
+
+	alice, err := ReadEntity(db, "Alice")
+	if err != nil {
+		t.Fatalf("ReadEntity() failed: %v", err)
+	}
+	if len(alice.Entities) != 1 || len(alice.Entities[0].Observations) != 1 || len(alice.Relations) != 2 {
+		t.Errorf("Expected Alice with one observation and two relations, got %+v", alice)
+	}
+	if _, err := ReadEntity(db, "Nobody"); !errors.Is(err, ErrEntityNotFound) {
+		t.Errorf("Expected ErrEntityNotFound, got %v", err)
+	}
+
+	people, err := ReadEntityType(db, "person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people.Entities) != 2 || people.Entities[0].Name != "Alice" || len(people.Relations) != 2 {
+		t.Errorf("Expected Alice and Bob with their two relations, got %+v", people)
+	}
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Defaults for FindPaths when the corresponding PathOptions field is unset.
+const (
+	DefaultMaxPathDepth = 6
+	DefaultPathTimeout  = 10 * time.Second
+)
+
+// PathOptions controls FindPaths.
+type PathOptions struct {
+	// MaxDepth is the longest path considered, in hops; values below 1 mean
+	// DefaultMaxPathDepth.
+	MaxDepth int
+	// RelationTypes restricts the relations a path may use. Empty means all.
+	RelationTypes []string
+	// Direction is DirectionOutgoing to only follow relations from→to,
+	// DirectionIncoming to only follow them backwards, or DirectionBoth
+	// (the default).
+	Direction string
+	// K is the number of paths to return, shortest first; values below 1
+	// mean 1.
+	K int
+	// Timeout bounds the whole search; zero means DefaultPathTimeout.
+	Timeout time.Duration
+}
+
+// PathHop is one step of a path. Direction is DirectionOutgoing when the
+// relation points from From to To and DirectionIncoming when the path walks
+// it backwards.
+type PathHop struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	RelationType string `json:"relationType"`
+	Direction    string `json:"direction"`
+}
+
+// Path is a simple path between two entities.
+type Path struct {
+	Entities []string  `json:"entities"`
+	Hops     []PathHop `json:"hops"`
+	Length   int       `json:"length"`
+}
+
+// FindPaths returns up to opts.K simple paths from one entity to another,
+// shortest first, using at most opts.MaxDepth hops. Paths of equal length
+// come in relation insertion order. An empty result means the entities are
+// not connected within the depth limit. The search fails with an error
+// wrapping context.DeadlineExceeded when it runs past opts.Timeout.
+func FindPaths(ctx context.Context, db *sql.DB, from, to string, opts PathOptions) ([]Path, error) {
+	if opts.MaxDepth < 1 {
+		opts.MaxDepth = DefaultMaxPathDepth
+	}
+	if opts.K < 1 {
+		opts.K = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPathTimeout
+	}
+	if opts.Direction == "" {
+		opts.Direction = DirectionBoth
+	}
+	if opts.Direction != DirectionOutgoing && opts.Direction != DirectionIncoming && opts.Direction != DirectionBoth {
+		return nil, fmt.Errorf("%w: direction %q (want outgoing, incoming or both)", ErrInvalidTraversal, opts.Direction)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	existing, err := loadEntities(ctx, db, []string{from, to})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{from, to} {
+		if _, ok := existing[name]; !ok {
+			return nil, fmt.Errorf("%w: '%s'", ErrEntityNotFound, name)
+		}
+	}
+
+	if from == to {
+		return []Path{{Entities: []string{from}, Hops: []PathHop{}}}, nil
+	}
+
+	g, err := loadPathGraph(ctx, db, from, opts)
+	if err != nil {
+		return nil, err
+	}
+	dist := g.distancesTo(to, opts.MaxDepth)
+	shortest, ok := dist[from]
+	if !ok {
+		return []Path{}, nil
+	}
+
+	// Iterative deepening from the shortest length: every branch is pruned
+	// by its distance to the target, so only branches that can still reach
+	// it within the current length are explored.
+	s := &pathSearch{ctx: ctx, graph: g, dist: dist, to: to, k: opts.K, onPath: map[string]bool{from: true}, paths: []Path{}}
+	for length := shortest; length <= opts.MaxDepth && len(s.paths) < s.k; length++ {
+		s.entities = []string{from}
+		s.hops = nil
+		if err := s.walk(from, length); err != nil {
+			return nil, err
+		}
+	}
+	return s.paths, nil
+}
+
+// pathGraph is the part of the graph within reach of a path search.
+type pathGraph struct {
+	// adjacent maps an entity to the hops leaving it, in relation order.
+	adjacent map[string][]PathHop
+}
+
+// loadPathGraph loads every relation within opts.MaxDepth hops of from, one
+// query per hop.
+func loadPathGraph(ctx context.Context, db *sql.DB, from string, opts PathOptions) (*pathGraph, error) {
+	g := &pathGraph{adjacent: make(map[string][]PathHop)}
+	seenRelation := make(map[int64]bool)
+	visited := map[string]bool{from: true}
+	frontier := []string{from}
+
+	for hop := 0; hop < opts.MaxDepth && len(frontier) > 0; hop++ {
+		relations, err := adjacentRelations(ctx, db, frontier, opts.Direction, opts.RelationTypes)
+		if err != nil {
+			return nil, fmt.Errorf("path search: %w", err)
+		}
+		var next []string
+		for _, r := range relations {
+			if seenRelation[r.ID] {
+				continue
+			}
+			seenRelation[r.ID] = true
+			if opts.Direction != DirectionIncoming {
+				g.adjacent[r.From] = append(g.adjacent[r.From], PathHop{From: r.From, To: r.To, RelationType: r.Type, Direction: DirectionOutgoing})
+			}
+			if opts.Direction != DirectionOutgoing {
+				g.adjacent[r.To] = append(g.adjacent[r.To], PathHop{From: r.To, To: r.From, RelationType: r.Type, Direction: DirectionIncoming})
+			}
+			for _, name := range []string{r.From, r.To} {
+				if !visited[name] {
+					visited[name] = true
+					next = append(next, name)
+				}
+			}
+		}
+		frontier = next
+	}
+	return g, nil
+}
+
+// distancesTo returns the hop distance from each loaded entity to target,
+// for entities within maxDepth hops of it.
+func (g *pathGraph) distancesTo(target string, maxDepth int) map[string]int {
+	// reverse adjacency: who can step onto each entity
+	reverse := make(map[string][]string)
+	for _, hops := range g.adjacent {
+		for _, h := range hops {
+			reverse[h.To] = append(reverse[h.To], h.From)
+		}
+	}
+
+	dist := map[string]int{target: 0}
+	frontier := []string{target}
+	for d := 1; d <= maxDepth && len(frontier) > 0; d++ {
+		var next []string
+		for _, name := range frontier {
+			for _, prev := range reverse[name] {
+				if _, ok := dist[prev]; !ok {
+					dist[prev] = d
+					next = append(next, prev)
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
+
+// pathSearch enumerates simple paths of an exact length.
+type pathSearch struct {
+	ctx   context.Context
+	graph *pathGraph
+	dist  map[string]int
+	to    string
+	k     int
+	steps int
+
+	onPath   map[string]bool
+	entities []string
+	hops     []PathHop
+	paths    []Path
+}
+
+func (s *pathSearch) walk(at string, remaining int) error {
+	// the search is CPU bound once the graph is loaded; check the deadline
+	// every so often rather than on each step
+	s.steps++
+	if s.steps%1024 == 0 {
+		if err := s.ctx.Err(); err != nil {
+			return fmt.Errorf("path search: %w", err)
+		}
+	}
+
+	if remaining == 0 {
+		if at == s.to {
+			s.paths = append(s.paths, Path{
+				Entities: append([]string(nil), s.entities...),
+				Hops:     append([]PathHop(nil), s.hops...),
+				Length:   len(s.hops),
+			})
+		}
+		return nil
+	}
+
+	for _, h := range s.graph.adjacent[at] {
+		if len(s.paths) >= s.k {
+			return nil
+		}
+		d, ok := s.dist[h.To]
+		if !ok || d > remaining-1 || s.onPath[h.To] {
+			continue
+		}
+		// the target can only be the last entity of a simple path
+		if h.To == s.to && remaining-1 > 0 {
+			continue
+		}
+		s.onPath[h.To] = true
+		s.entities = append(s.entities, h.To)
+		s.hops = append(s.hops, h)
+		err := s.walk(h.To, remaining-1)
+		s.entities = s.entities[:len(s.entities)-1]
+		s.hops = s.hops[:len(s.hops)-1]
+		delete(s.onPath, h.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
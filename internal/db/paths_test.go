@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindPaths(t *testing.T) {
+	db := setupTestDB(t)
+
+	// api -depends_on-> auth -owned_by-> platform_team
+	// api -owned_by-> web_team -reports_to-> platform_team
+	// platform_team -maintains-> api (cycle)
+	for _, name := range []string{"api", "auth", "web_team", "platform_team", "island"} {
+		CreateEntity(db, name, "node")
+	}
+	CreateRelation(db, "api", "auth", "depends_on")
+	CreateRelation(db, "auth", "platform_team", "owned_by")
+	CreateRelation(db, "api", "web_team", "owned_by")
+	CreateRelation(db, "web_team", "platform_team", "reports_to")
+	CreateRelation(db, "platform_team", "api", "maintains")
+
+	tests := []struct {
+		name  string
+		from  string
+		to    string
+		opts  PathOptions
+		paths [][]string
+	}{
+		{"direct", "api", "auth", PathOptions{}, [][]string{{"api", "auth"}}},
+		{"shortest via reverse edge", "api", "platform_team", PathOptions{}, [][]string{{"api", "platform_team"}}},
+		{"outgoing only", "api", "platform_team", PathOptions{Direction: DirectionOutgoing, K: 5},
+			[][]string{{"api", "auth", "platform_team"}, {"api", "web_team", "platform_team"}}},
+		{"k shortest", "api", "platform_team", PathOptions{K: 3},
+			[][]string{{"api", "platform_team"}, {"api", "auth", "platform_team"}, {"api", "web_team", "platform_team"}}},
+		{"relation filter", "api", "platform_team", PathOptions{RelationTypes: []string{"owned_by", "reports_to"}},
+			[][]string{{"api", "web_team", "platform_team"}}},
+		{"depth limit", "auth", "web_team", PathOptions{MaxDepth: 1}, nil},
+		{"unreachable", "api", "island", PathOptions{}, nil},
+		{"same entity", "api", "api", PathOptions{}, [][]string{{"api"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := FindPaths(context.Background(), db, tt.from, tt.to, tt.opts)
+			if err != nil {
+				t.Fatalf("FindPaths() failed: %v", err)
+			}
+			var got [][]string
+			for _, p := range paths {
+				if p.Length != len(p.Hops) || len(p.Entities) != len(p.Hops)+1 {
+					t.Errorf("Inconsistent path %+v", p)
+				}
+				got = append(got, p.Entities)
+			}
+			if !reflect.DeepEqual(got, tt.paths) {
+				t.Errorf("Expected paths %v, got %v", tt.paths, got)
+			}
+		})
+	}
+
+	// Hops carry relation type and direction
+	paths, err := FindPaths(context.Background(), db, "auth", "api", PathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PathHop{{From: "auth", To: "api", RelationType: "depends_on", Direction: DirectionIncoming}}
+	if len(paths) != 1 || !reflect.DeepEqual(paths[0].Hops, want) {
+		t.Errorf("Expected %+v, got %+v", want, paths)
+	}
+
+	if _, err := FindPaths(context.Background(), db, "api", "nobody", PathOptions{}); !errors.Is(err, ErrEntityNotFound) {
+		t.Errorf("Expected ErrEntityNotFound for missing entity, got %v", err)
+	}
+	if _, err := FindPaths(context.Background(), db, "api", "auth", PathOptions{Direction: "sideways"}); !errors.Is(err, ErrInvalidTraversal) {
+		t.Errorf("Expected ErrInvalidTraversal for invalid direction, got %v", err)
+	}
+}
+
+func TestFindPathsTimeout(t *testing.T) {
+	db := setupTestDB(t)
+
+	// A dense graph has far too many simple paths to enumerate
+	const n = 12
+	for i := 0; i < n; i++ {
+		CreateEntity(db, fmt.Sprintf("n%d", i), "node")
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i != j {
+				CreateRelation(db, fmt.Sprintf("n%d", i), fmt.Sprintf("n%d", j), "link")
+			}
+		}
+	}
+
+	_, err := FindPaths(context.Background(), db, "n0", "n1", PathOptions{MaxDepth: n, K: 1 << 30, Timeout: 50 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
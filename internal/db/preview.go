@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DeletePreview is what a delete would remove, worked out without removing
+// anything so that callers can ask before large deletes.
+type DeletePreview struct {
+	Entities     []string   `json:"entities"`
+	Observations int        `json:"observations"`
+	Relations    []Relation `json:"relations"`
+}
+
+// Size is the number of rows the delete would remove.
+func (p *DeletePreview) Size() int {
+	return len(p.Entities) + p.Observations + len(p.Relations)
+}
+
+// PreviewDeleteEntities returns the live entities among names, with the
+// observations and relations DeleteEntities would remove along with them.
+func PreviewDeleteEntities(db *sql.DB, names []string) (*DeletePreview, error) {
+	preview := &DeletePreview{Entities: []string{}, Relations: []Relation{}}
+	if len(names) == 0 {
+		return preview, nil
+	}
+	in := placeholders(len(names))
+	args := stringArgs(names)
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT name FROM entities WHERE name IN (%s) AND deleted_at IS NULL ORDER BY name`, in), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		preview.Entities = append(preview.Entities, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM observations WHERE entity_name IN (%s) AND deleted_at IS NULL`, in),
+		args...).Scan(&preview.Observations); err != nil {
+		return nil, err
+	}
+
+	relations, err := db.Query(fmt.Sprintf(`SELECT %s FROM relations
+		WHERE deleted_at IS NULL AND (from_entity IN (%s) OR to_entity IN (%s)) ORDER BY id`, relationColumns, in, in),
+		append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	found, err := scanRelations(relations)
+	if err != nil {
+		return nil, err
+	}
+	preview.Relations = append(preview.Relations, found...)
+	return preview, nil
+}
+
+// PreviewDeleteObservations counts the live observations DeleteObservations
+// would remove.
+func PreviewDeleteObservations(db *sql.DB, deletions []struct {
+	EntityName   string   `json:"entityName"`
+	Observations []string `json:"observations"`
+}) (*DeletePreview, error) {
+	preview := &DeletePreview{Entities: []string{}, Relations: []Relation{}}
+	for _, deletion := range deletions {
+		if len(deletion.Observations) == 0 {
+			continue
+		}
+		var count int
+		err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM observations
+			WHERE entity_name = ? AND content IN (%s) AND deleted_at IS NULL`, placeholders(len(deletion.Observations))),
+			append([]interface{}{deletion.EntityName}, stringArgs(deletion.Observations)...)...).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		preview.Observations += count
+	}
+	return preview, nil
+}
+
+// PreviewDeleteRelations returns the live relations DeleteRelations would
+// remove.
+func PreviewDeleteRelations(db *sql.DB, relations []struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"relationType"`
+}) (*DeletePreview, error) {
+	preview := &DeletePreview{Entities: []string{}, Relations: []Relation{}}
+	seen := make(map[int64]bool)
+	for _, rel := range relations {
+		rows, err := db.Query(`SELECT `+relationColumns+` FROM relations
+			WHERE from_entity = ? AND to_entity = ? AND relation_type = ? AND deleted_at IS NULL`, rel.From, rel.To, rel.Type)
+		if err != nil {
+			return nil, err
+		}
+		found, err := scanRelations(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range found {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				preview.Relations = append(preview.Relations, r)
+			}
+		}
+	}
+	return preview, nil
+}
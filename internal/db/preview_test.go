@@ -0,0 +1,65 @@
+package db
+
+import "testing"
+
+func TestPreviewDeletes(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateObservation(db, "Alice", "Plays chess")
+	CreateObservation(db, "Bob", "Likes coffee")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+	CreateRelation(db, "Bob", "Alice", "knows")
+	CreateRelation(db, "Bob", "Acme", "works_at")
+
+	preview, err := PreviewDeleteEntities(db, []string{"Alice", "Nobody"})
+	if err != nil {
+		t.Fatalf("PreviewDeleteEntities() failed: %v", err)
+	}
+	if len(preview.Entities) != 1 || preview.Entities[0] != "Alice" || preview.Observations != 2 || len(preview.Relations) != 2 {
+		t.Errorf("Expected Alice with 2 observations and 2 relations, got %+v", preview)
+	}
+	if preview.Size() != 5 {
+		t.Errorf("Expected size 5, got %d", preview.Size())
+	}
+
+	// Previews match what the delete removes
+	DeleteEntities(db, []string{"Alice"})
+	_, relations, observations, _ := ReadGraph(db)
+	if len(relations) != 1 || len(observations) != 1 {
+		t.Errorf("Expected 1 relation and 1 observation left, got %+v %+v", relations, observations)
+	}
+	if preview, _ := PreviewDeleteEntities(db, []string{"Alice"}); preview.Size() != 0 {
+		t.Errorf("Expected nothing left to delete for Alice, got %+v", preview)
+	}
+
+	observationsPreview, err := PreviewDeleteObservations(db, []struct {
+		EntityName   string   `json:"entityName"`
+		Observations []string `json:"observations"`
+	}{{EntityName: "Bob", Observations: []string{"Likes coffee", "Likes tea"}}})
+	if err != nil {
+		t.Fatalf("PreviewDeleteObservations() failed: %v", err)
+	}
+	if observationsPreview.Size() != 1 {
+		t.Errorf("Expected 1 observation, got %+v", observationsPreview)
+	}
+
+	relationsPreview, err := PreviewDeleteRelations(db, []struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Type string `json:"relationType"`
+	}{
+		{From: "Bob", To: "Acme", Type: "works_at"},
+		{From: "Bob", To: "Acme", Type: "works_at"},
+		{From: "Bob", To: "Alice", Type: "knows"},
+	})
+	if err != nil {
+		t.Fatalf("PreviewDeleteRelations() failed: %v", err)
+	}
+	if len(relationsPreview.Relations) != 1 || relationsPreview.Relations[0].To != "Acme" {
+		t.Errorf("Expected only the live Bob -> Acme relation once, got %+v", relationsPreview.Relations)
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TimeFormat is the layout of the created_at and updated_at columns:
+// fixed-width UTC, so timestamps compare correctly as strings.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// now is replaced in tests that need predictable timestamps.
+var now = time.Now
+
+// FormatTime renders t the way timestamps are stored.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime accepts the time formats clients use to filter by timestamp:
+// RFC 3339 (2024-05-07T09:00:00Z, with any offset) or a bare UTC date
+// (2024-05-07).
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or YYYY-MM-DD)", s)
+	}
+	return t, nil
+}
+
+// Provenance records who wrote a row. Source is the client name (MCP
+// clientInfo.name, or the API a REST request came through), SessionID the
+// MCP session, and Actor the authenticated user or API key fingerprint.
+type Provenance struct {
+	Source    string `json:"source,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	Actor     string `json:"actor,omitempty"`
+}
+
+type provenanceKey struct{}
+
+// WithProvenance returns a context whose writes are attributed to p. Empty
+// fields of p keep the value already carried by ctx, so a transport can set
+// the actor and a handler further down the source.
+func WithProvenance(ctx context.Context, p Provenance) context.Context {
+	merged := ProvenanceFrom(ctx)
+	if p.Source != "" {
+		merged.Source = p.Source
+	}
+	if p.SessionID != "" {
+		merged.SessionID = p.SessionID
+	}
+	if p.Actor != "" {
+		merged.Actor = p.Actor
+	}
+	return context.WithValue(ctx, provenanceKey{}, merged)
+}
+
+// ProvenanceFrom returns the provenance carried by ctx, if any.
+func ProvenanceFrom(ctx context.Context) Provenance {
+	p, _ := ctx.Value(provenanceKey{}).(Provenance)
+	return p
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// nullable stores empty provenance fields as NULL.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// metaColumns selects the timestamp and provenance columns of the table
+// aliased as alias (or unaliased when empty), in the order of metaTargets.
+func metaColumns(alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	return fmt.Sprintf(`COALESCE(%[1]screated_at, ''), COALESCE(%[1]supdated_at, ''), COALESCE(%[1]ssource, ''), COALESCE(%[1]ssession_id, ''), COALESCE(%[1]sactor, '')`, alias)
+}
+
+// metaTargets returns scan destinations matching metaColumns.
+func metaTargets(createdAt, updatedAt *string, p *Provenance) []interface{} {
+	return []interface{}{createdAt, updatedAt, &p.Source, &p.SessionID, &p.Actor}
+}
+
+// entityTargets returns scan destinations for name, entity_type and
+// metaColumns.
+func entityTargets(e *Entity) []interface{} {
+	return append([]interface{}{&e.Name, &e.Type}, metaTargets(&e.CreatedAt, &e.UpdatedAt, &e.Provenance)...)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// setClock makes now return t until the test ends.
+func setClock(t *testing.T, at time.Time) {
+	t.Helper()
+	previous := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestProvenance(t *testing.T) {
+	db := setupTestDB(t)
+
+	monday := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	setClock(t, monday)
+
+	ctx := WithProvenance(context.Background(), Provenance{Actor: "alice"})
+	ctx = WithProvenance(ctx, Provenance{Source: "claude-desktop", SessionID: "session_1"})
+	want := Provenance{Source: "claude-desktop", SessionID: "session_1", Actor: "alice"}
+	if got := ProvenanceFrom(ctx); got != want {
+		t.Fatalf("Expected merged provenance %+v, got %+v", want, got)
+	}
+
+	if _, err := CreateEntityContext(ctx, db, "Go", "language"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateEntityContext(ctx, db, "Rust", "language"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateRelationContext(ctx, db, "Go", "Rust", "competes_with"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateObservationContext(ctx, db, "Go", "Has goroutines"); err != nil {
+		t.Fatal(err)
+	}
+	// Writes without provenance still get timestamps
+	if err := CreateEntity(db, "Anonymous", "unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	entities, relations, observations, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := FormatTime(monday)
+	for _, e := range entities {
+		if e.CreatedAt != stamp || e.UpdatedAt != stamp {
+			t.Errorf("Entity %s: expected timestamps %s, got %s/%s", e.Name, stamp, e.CreatedAt, e.UpdatedAt)
+		}
+		if e.Name == "Anonymous" {
+			if e.Provenance != (Provenance{}) {
+				t.Errorf("Expected no provenance on Anonymous, got %+v", e.Provenance)
+			}
+		} else if e.Provenance != want {
+			t.Errorf("Entity %s: expected provenance %+v, got %+v", e.Name, want, e.Provenance)
+		}
+	}
+	if len(relations) != 1 || relations[0].CreatedAt != stamp || relations[0].Provenance != want {
+		t.Errorf("Expected stamped relation, got %+v", relations)
+	}
+	if len(observations) != 1 || observations[0].CreatedAt != stamp || observations[0].Provenance != want {
+		t.Errorf("Expected stamped observation, got %+v", observations)
+	}
+
+	// Adding and removing observations moves the entity's updated_at
+	tuesday := monday.Add(24 * time.Hour)
+	setClock(t, tuesday)
+	added, err := AddObservationsContext(ctx, db, []struct {
+		EntityName string `json:"entityName"`
+		Contents   string `json:"contents"`
+	}{{EntityName: "Rust", Contents: "Has a borrow checker"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added[0].CreatedAt != FormatTime(tuesday) || added[0].Provenance != want {
+		t.Errorf("Expected returned observation to be stamped, got %+v", added[0])
+	}
+
+	wednesday := tuesday.Add(24 * time.Hour)
+	setClock(t, wednesday)
+	err = DeleteObservationsContext(ctx, db, []struct {
+		EntityName   string   `json:"entityName"`
+		Observations []string `json:"observations"`
+	}{{EntityName: "Go", Observations: []string{"Has goroutines"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities, _, err = OpenNodes(db, []string{"Go", "Rust"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := map[string]string{}
+	for _, e := range entities {
+		if e.CreatedAt != stamp {
+			t.Errorf("Entity %s: created_at changed to %s", e.Name, e.CreatedAt)
+		}
+		updated[e.Name] = e.UpdatedAt
+	}
+	if updated["Go"] != FormatTime(wednesday) || updated["Rust"] != FormatTime(tuesday) {
+		t.Errorf("Expected updated_at to follow observation changes, got %v", updated)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-05-07", time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC), true},
+		{"2024-05-07T09:30:00Z", time.Date(2024, 5, 7, 9, 30, 0, 0, time.UTC), true},
+		{"2024-05-07T11:30:00+02:00", time.Date(2024, 5, 7, 9, 30, 0, 0, time.UTC), true},
+		{"last tuesday", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTime(tt.input)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseTime(%q) error = %v, want ok=%v", tt.input, err, tt.ok)
+			}
+			if tt.ok && !got.Equal(tt.want) {
+				t.Errorf("ParseTime(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+// Package schema holds the versioned SQLite schema of the knowledge graph.
+//
+// The same migrations are applied by the server (internal/db) and by the WASM
+// frontend (cmd/frontend), so this package must not import a SQLite driver.
+// The applied version is tracked in PRAGMA user_version.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Migration is one step of the schema history. Up moves the schema from
+// Version-1 to Version, Down reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// The optional FTS5 search index lives outside the migrations, since only
+// binaries built with FTS5 can create it; internal/db installs it when it
+// opens a database. Its triggers read the base tables, so a migration that
+// changes a column they use drops them first.
+const (
+	SearchTriggerEntitiesInsert     = "search_index_entities_ai"
+	SearchTriggerEntitiesUpdate     = "search_index_entities_au"
+	SearchTriggerEntitiesDelete     = "search_index_entities_ad"
+	SearchTriggerObservationsInsert = "search_index_observations_ai"
+	SearchTriggerObservationsUpdate = "search_index_observations_au"
+	SearchTriggerObservationsDelete = "search_index_observations_ad"
+)
+
+// SearchTriggers names every trigger of the search index.
+var SearchTriggers = []string{
+	SearchTriggerEntitiesInsert,
+	SearchTriggerEntitiesUpdate,
+	SearchTriggerEntitiesDelete,
+	SearchTriggerObservationsInsert,
+	SearchTriggerObservationsUpdate,
+	SearchTriggerObservationsDelete,
+}
+
+// dropSearchTriggers returns statements dropping the search index triggers
+// that exist.
+func dropSearchTriggers() []string {
+	stmts := make([]string, len(SearchTriggers))
+	for i, name := range SearchTriggers {
+		stmts[i] = `DROP TRIGGER IF EXISTS ` + name + `;`
+	}
+	return stmts
+}
+
+// Migrations lists every schema version in ascending order. Never edit an
+// entry that has been released; append a new one instead.
+var Migrations = []Migration{
+	{
+		// Databases created before versioning already have these tables.
+		// Rows pointing at missing entities were only possible because
+		// foreign keys were not enforced on every connection; they are
+		// unreachable through the API and are dropped here.
+		Version: 1,
+		Name:    "initial schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS entities (
+				name TEXT PRIMARY KEY,
+				entity_type TEXT NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS relations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				from_entity TEXT NOT NULL REFERENCES entities(name),
+				to_entity TEXT NOT NULL REFERENCES entities(name),
+				relation_type TEXT NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS observations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				entity_name TEXT NOT NULL REFERENCES entities(name),
+				content TEXT NOT NULL
+			);`,
+			`DELETE FROM relations
+				WHERE from_entity NOT IN (SELECT name FROM entities)
+				   OR to_entity NOT IN (SELECT name FROM entities);`,
+			`DELETE FROM observations WHERE entity_name NOT IN (SELECT name FROM entities);`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS observations;`,
+			`DROP TABLE IF EXISTS relations;`,
+			`DROP TABLE IF EXISTS entities;`,
+		},
+	},
+	{
+		// The server used to create relations and observations without
+		// ON DELETE CASCADE while the WASM frontend had it. Rebuild both
+		// tables so every database ends up with the cascading definition.
+		Version: 2,
+		Name:    "cascade deletes",
+		Up: []string{
+			`CREATE TABLE relations_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				from_entity TEXT NOT NULL REFERENCES entities(name) ON DELETE CASCADE,
+				to_entity TEXT NOT NULL REFERENCES entities(name) ON DELETE CASCADE,
+				relation_type TEXT NOT NULL
+			);`,
+			`INSERT INTO relations_new(id, from_entity, to_entity, relation_type)
+				SELECT id, from_entity, to_entity, relation_type FROM relations;`,
+			`DROP TABLE relations;`,
+			`ALTER TABLE relations_new RENAME TO relations;`,
+			`CREATE TABLE observations_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				entity_name TEXT NOT NULL REFERENCES entities(name) ON DELETE CASCADE,
+				content TEXT NOT NULL
+			);`,
+			`INSERT INTO observations_new(id, entity_name, content)
+				SELECT id, entity_name, content FROM observations;`,
+			`DROP TABLE observations;`,
+			`ALTER TABLE observations_new RENAME TO observations;`,
+		},
+		Down: []string{
+			`CREATE TABLE relations_old (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				from_entity TEXT NOT NULL REFERENCES entities(name),
+				to_entity TEXT NOT NULL REFERENCES entities(name),
+				relation_type TEXT NOT NULL
+			);`,
+			`INSERT INTO relations_old(id, from_entity, to_entity, relation_type)
+				SELECT id, from_entity, to_entity, relation_type FROM relations;`,
+			`DROP TABLE relations;`,
+			`ALTER TABLE relations_old RENAME TO relations;`,
+			`CREATE TABLE observations_old (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				entity_name TEXT NOT NULL REFERENCES entities(name),
+				content TEXT NOT NULL
+			);`,
+			`INSERT INTO observations_old(id, entity_name, content)
+				SELECT id, entity_name, content FROM observations;`,
+			`DROP TABLE observations;`,
+			`ALTER TABLE observations_old RENAME TO observations;`,
+		},
+	},
+	{
+		// Every row records when it was written and by whom. Timestamps are
+		// fixed-width UTC text (2006-01-02T15:04:05.000Z) so they compare
+		// correctly as strings. Rows written before this version keep NULL:
+		// their age and origin are unknown.
+		Version: 3,
+		Name:    "timestamps and provenance",
+		Up: []string{
+			`ALTER TABLE entities ADD COLUMN created_at TEXT;`,
+			`ALTER TABLE entities ADD COLUMN updated_at TEXT;`,
+			`ALTER TABLE entities ADD COLUMN source TEXT;`,
+			`ALTER TABLE entities ADD COLUMN session_id TEXT;`,
+			`ALTER TABLE entities ADD COLUMN actor TEXT;`,
+			`ALTER TABLE relations ADD COLUMN created_at TEXT;`,
+			`ALTER TABLE relations ADD COLUMN updated_at TEXT;`,
+			`ALTER TABLE relations ADD COLUMN source TEXT;`,
+			`ALTER TABLE relations ADD COLUMN session_id TEXT;`,
+			`ALTER TABLE relations ADD COLUMN actor TEXT;`,
+			`ALTER TABLE observations ADD COLUMN created_at TEXT;`,
+			`ALTER TABLE observations ADD COLUMN updated_at TEXT;`,
+			`ALTER TABLE observations ADD COLUMN source TEXT;`,
+			`ALTER TABLE observations ADD COLUMN session_id TEXT;`,
+			`ALTER TABLE observations ADD COLUMN actor TEXT;`,
+			`CREATE INDEX entities_updated_at ON entities(updated_at);`,
+		},
+		Down: []string{
+			`DROP INDEX entities_updated_at;`,
+			`ALTER TABLE observations DROP COLUMN actor;`,
+			`ALTER TABLE observations DROP COLUMN session_id;`,
+			`ALTER TABLE observations DROP COLUMN source;`,
+			`ALTER TABLE observations DROP COLUMN updated_at;`,
+			`ALTER TABLE observations DROP COLUMN created_at;`,
+			`ALTER TABLE relations DROP COLUMN actor;`,
+			`ALTER TABLE relations DROP COLUMN session_id;`,
+			`ALTER TABLE relations DROP COLUMN source;`,
+			`ALTER TABLE relations DROP COLUMN updated_at;`,
+			`ALTER TABLE relations DROP COLUMN created_at;`,
+			`ALTER TABLE entities DROP COLUMN actor;`,
+			`ALTER TABLE entities DROP COLUMN session_id;`,
+			`ALTER TABLE entities DROP COLUMN source;`,
+			`ALTER TABLE entities DROP COLUMN updated_at;`,
+			`ALTER TABLE entities DROP COLUMN created_at;`,
+		},
+	},
+	{
+		// Append-only log of every mutation. entity_name is the entity the
+		// change belongs to; relations are also filed under related_entity
+		// so they show up in both endpoints' history. before and after hold
+		// the row as JSON. There are no foreign keys: history outlives the
+		// rows it describes.
+		Version: 4,
+		Name:    "changelog",
+		Up: []string{
+			`CREATE TABLE changelog (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				at TEXT NOT NULL,
+				operation TEXT NOT NULL,
+				entity_name TEXT NOT NULL,
+				related_entity TEXT,
+				before TEXT,
+				after TEXT,
+				source TEXT,
+				session_id TEXT,
+				actor TEXT
+			);`,
+			`CREATE INDEX changelog_entity_name ON changelog(entity_name);`,
+			`CREATE INDEX changelog_related_entity ON changelog(related_entity);`,
+			`CREATE TRIGGER changelog_no_update BEFORE UPDATE ON changelog BEGIN
+				SELECT RAISE(ABORT, 'changelog is append-only');
+			END;`,
+			`CREATE TRIGGER changelog_no_delete BEFORE DELETE ON changelog BEGIN
+				SELECT RAISE(ABORT, 'changelog is append-only');
+			END;`,
+		},
+		Down: []string{
+			`DROP TABLE changelog;`,
+		},
+	},
+	{
+		// Deleting marks rows with deleted_at instead of removing them, so
+		// they can be restored until they are purged. Rows deleted together
+		// share the same timestamp.
+		Version: 5,
+		Name:    "soft delete",
+		Up: []string{
+			`ALTER TABLE entities ADD COLUMN deleted_at TEXT;`,
+			`ALTER TABLE relations ADD COLUMN deleted_at TEXT;`,
+			`ALTER TABLE observations ADD COLUMN deleted_at TEXT;`,
+			`CREATE INDEX entities_deleted_at ON entities(deleted_at);`,
+		},
+		// The full-text index triggers read deleted_at, which keeps it from
+		// being dropped. They are recreated when the server opens the
+		// database again.
+		Down: append(dropSearchTriggers(),
+			// Tombstones would otherwise come back as live rows.
+			`DELETE FROM relations WHERE deleted_at IS NOT NULL;`,
+			`DELETE FROM observations WHERE deleted_at IS NOT NULL;`,
+			`DELETE FROM entities WHERE deleted_at IS NOT NULL;`,
+			`DROP INDEX entities_deleted_at;`,
+			`ALTER TABLE observations DROP COLUMN deleted_at;`,
+			`ALTER TABLE relations DROP COLUMN deleted_at;`,
+			`ALTER TABLE entities DROP COLUMN deleted_at;`,
+		),
+	},
+	{
+		// A snapshot is a copy of the whole graph as JSON, so it stays
+		// readable whatever the schema of the tables becomes. Automatic
+		// snapshots are taken by the server and pruned; named ones are
+		// kept until deleted.
+		Version: 6,
+		Name:    "snapshots",
+		Up: []string{
+			`CREATE TABLE snapshots (
+				name TEXT PRIMARY KEY,
+				taken_at TEXT NOT NULL,
+				automatic INTEGER NOT NULL DEFAULT 0,
+				graph TEXT NOT NULL,
+				source TEXT,
+				session_id TEXT,
+				actor TEXT
+			);`,
+			`CREATE INDEX snapshots_taken_at ON snapshots(taken_at);`,
+		},
+		Down: []string{
+			`DROP TABLE snapshots;`,
+		},
+	},
+}
+
+// Latest returns the schema version this binary was built for.
+func Latest() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// TooNewError is returned when a database was written by a newer binary.
+type TooNewError struct {
+	Version int
+	Latest  int
+}
+
+func (e *TooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the latest version %d known to this binary", e.Version, e.Latest)
+}
+
+// Version reads the schema version recorded in the database.
+func Version(db *sql.DB) (int, error) {
+	var v int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// Migrate brings the database up to Latest. It refuses to touch a database
+// whose version is newer than Latest.
+func Migrate(db *sql.DB) error {
+	_, err := Up(db, Latest())
+	return err
+}
+
+// Up applies every pending migration up to and including target and returns
+// the migrations that were applied.
+func Up(db *sql.DB, target int) ([]Migration, error) {
+	current, err := Version(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > Latest() {
+		return nil, &TooNewError{Version: current, Latest: Latest()}
+	}
+	if target > Latest() {
+		return nil, fmt.Errorf("unknown schema version %d (latest is %d)", target, Latest())
+	}
+
+	var applied []Migration
+	for _, m := range Migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+		if err := apply(db, m.Up, m.Version); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Down reverts migrations until the database is at target and returns the
+// migrations that were reverted, newest first.
+func Down(db *sql.DB, target int) ([]Migration, error) {
+	current, err := Version(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > Latest() {
+		return nil, &TooNewError{Version: current, Latest: Latest()}
+	}
+	if target < 0 {
+		return nil, fmt.Errorf("invalid schema version %d", target)
+	}
+
+	var reverted []Migration
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		m := Migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		if err := apply(db, m.Down, m.Version-1); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// apply runs stmts in a single transaction and records version on success.
+// Foreign keys are switched off on the connection for the duration, as
+// recommended by SQLite for table rebuilds, and checked before committing.
+func apply(db *sql.DB, stmts []string, version int) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var fk int
+	if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&fk); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA foreign_keys = %d`, fk))
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range stmts {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	violation := rows.Next()
+	rows.Close()
+	if violation {
+		return fmt.Errorf("foreign key violations after migration")
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,151 @@
+package schema
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	tmpfile, err := os.CreateTemp("", "test_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	db, err := sql.Open("sqlite3", tmpfile.Name()+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(tmpfile.Name())
+	})
+
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	v, err := Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != Latest() {
+		t.Errorf("Expected version %d, got %d", Latest(), v)
+	}
+
+	// Running again is a no-op
+	applied, err := Up(db, Latest())
+	if err != nil {
+		t.Fatalf("Up() failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations to apply, got %d", len(applied))
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	// Schema as created by db.Init before versioning, including an orphaned
+	// observation that foreign keys would normally have prevented.
+	legacy := []string{
+		`PRAGMA foreign_keys = OFF`,
+		`CREATE TABLE entities (name TEXT PRIMARY KEY, entity_type TEXT NOT NULL)`,
+		`CREATE TABLE relations (id INTEGER PRIMARY KEY AUTOINCREMENT, from_entity TEXT NOT NULL REFERENCES entities(name), to_entity TEXT NOT NULL REFERENCES entities(name), relation_type TEXT NOT NULL)`,
+		`CREATE TABLE observations (id INTEGER PRIMARY KEY AUTOINCREMENT, entity_name TEXT NOT NULL REFERENCES entities(name), content TEXT NOT NULL)`,
+		`INSERT INTO entities(name, entity_type) VALUES ('Alice', 'person'), ('Company', 'organization')`,
+		`INSERT INTO relations(from_entity, to_entity, relation_type) VALUES ('Alice', 'Company', 'works_at')`,
+		`INSERT INTO observations(entity_name, content) VALUES ('Alice', 'engineer'), ('Ghost', 'orphan')`,
+		`PRAGMA foreign_keys = ON`,
+	}
+	conn, err := db.Conn(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range legacy {
+		if _, err := conn.ExecContext(t.Context(), s); err != nil {
+			t.Fatalf("legacy setup %q: %v", s, err)
+		}
+	}
+	conn.Close()
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+
+	var obs int
+	db.QueryRow(`SELECT COUNT(*) FROM observations`).Scan(&obs)
+	if obs != 1 {
+		t.Errorf("Expected orphan to be dropped leaving 1 observation, got %d", obs)
+	}
+
+	// Deleting an entity now cascades
+	if _, err := db.Exec(`DELETE FROM entities WHERE name = 'Alice'`); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	var rels int
+	db.QueryRow(`SELECT COUNT(*) FROM relations`).Scan(&rels)
+	db.QueryRow(`SELECT COUNT(*) FROM observations`).Scan(&obs)
+	if rels != 0 || obs != 0 {
+		t.Errorf("Expected cascade delete, got %d relations and %d observations", rels, obs)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO entities(name, entity_type) VALUES ('Alice', 'person')`); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := Down(db, Latest()-1)
+	if err != nil {
+		t.Fatalf("Down() failed: %v", err)
+	}
+	if len(reverted) != 1 {
+		t.Errorf("Expected 1 reverted migration, got %d", len(reverted))
+	}
+	if v, _ := Version(db); v != Latest()-1 {
+		t.Errorf("Expected version %d, got %d", Latest()-1, v)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() after Down() failed: %v", err)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM entities`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected data to survive down/up, got %d entities", count)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	if _, err := db.Exec(`PRAGMA user_version = 999`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Migrate(db)
+	var tooNew *TooNewError
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("Expected TooNewError, got %v", err)
+	}
+	if tooNew.Version != 999 {
+		t.Errorf("Expected version 999 in error, got %d", tooNew.Version)
+	}
+}
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gnolledgegraph/internal/db/schema"
+)
+
+// The full-text index keeps one FTS5 document per entity: its name, its type
+// and all of its observations joined by obsSeparator. Triggers keep it in
+// sync with the base tables. FTS5 is only compiled into go-sqlite3 with the
+// sqlite_fts5 build tag, so the index is optional and SearchNodes falls back
+// to LIKE matching whenever the triggers are not installed.
+const (
+	obsSeparator   = "\x1f"
+	highlightOpen  = "\x02"
+	highlightClose = "\x03"
+)
+
+// SearchOptions tunes SearchNodesWithOptions.
+type SearchOptions struct {
+	// Limit caps the number of entities returned; zero means no limit.
+	Limit int
+	// Since and Until keep only entities created or changed (observations
+	// added or removed) in [Since, Until). Zero values leave that end open.
+	// Entities written before timestamps were recorded never match a window.
+	Since time.Time
+	Until time.Time
+	// AsOf searches the graph as it was at a snapshot or time instead of
+	// the live graph; see GraphAsOf.
+	AsOf string
+}
+
+// windowFilter returns the SQL condition and arguments restricting the
+// entity aliased as e to the options' time window.
+func (opts SearchOptions) windowFilter() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if !opts.Since.IsZero() {
+		conds = append(conds, `e.updated_at >= ?`)
+		args = append(args, FormatTime(opts.Since))
+	}
+	if !opts.Until.IsZero() {
+		conds = append(conds, `e.updated_at < ?`)
+		args = append(args, FormatTime(opts.Until))
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
+
+// reindexEntitySQL rebuilds the index document of the entity named by expr.
+func reindexEntitySQL(expr string) string {
+	return fmt.Sprintf(`
+		DELETE FROM search_index WHERE name = %[1]s;
+		INSERT INTO search_index(name, entity_type, observations)
+			SELECT e.name, e.entity_type,
+				COALESCE((SELECT group_concat(content, char(31)) FROM
+					(SELECT content FROM observations WHERE entity_name = e.name AND deleted_at IS NULL ORDER BY id)), '')
+			FROM entities e WHERE e.name = %[1]s AND e.deleted_at IS NULL;`, expr)
+}
+
+// searchTriggers maps each of schema.SearchTriggers to its definition.
+var searchTriggers = map[string]string{
+	schema.SearchTriggerEntitiesInsert: `CREATE TRIGGER ` + schema.SearchTriggerEntitiesInsert + ` AFTER INSERT ON entities BEGIN` +
+		reindexEntitySQL("new.name") + `
+	END`,
+	schema.SearchTriggerEntitiesUpdate: `CREATE TRIGGER ` + schema.SearchTriggerEntitiesUpdate + ` AFTER UPDATE OF name, entity_type, deleted_at ON entities BEGIN
+		DELETE FROM search_index WHERE name = old.name;` +
+		reindexEntitySQL("new.name") + `
+	END`,
+	schema.SearchTriggerEntitiesDelete: `CREATE TRIGGER ` + schema.SearchTriggerEntitiesDelete + ` AFTER DELETE ON entities BEGIN
+		DELETE FROM search_index WHERE name = old.name;
+	END`,
+	schema.SearchTriggerObservationsInsert: `CREATE TRIGGER ` + schema.SearchTriggerObservationsInsert + ` AFTER INSERT ON observations BEGIN` +
+		reindexEntitySQL("new.entity_name") + `
+	END`,
+	schema.SearchTriggerObservationsUpdate: `CREATE TRIGGER ` + schema.SearchTriggerObservationsUpdate + ` AFTER UPDATE ON observations BEGIN` +
+		reindexEntitySQL("old.entity_name") +
+		reindexEntitySQL("new.entity_name") + `
+	END`,
+	schema.SearchTriggerObservationsDelete: `CREATE TRIGGER ` + schema.SearchTriggerObservationsDelete + ` AFTER DELETE ON observations BEGIN` +
+		reindexEntitySQL("old.entity_name") + `
+	END`,
+}
+
+// initSearchIndex creates or repairs the FTS5 index. When FTS5 is not
+// available the triggers are removed so that writes keep working on a
+// database that was indexed by an FTS5-enabled binary.
+func initSearchIndex(db *sql.DB) error {
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		for _, name := range schema.SearchTriggers {
+			if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Triggers that are missing or outdated mean the index may be stale.
+	current := true
+	for name, def := range searchTriggers {
+		var existing string
+		err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = ?`, name).Scan(&existing)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if existing != def {
+			current = false
+			break
+		}
+	}
+	if current {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`DROP TABLE IF EXISTS search_index`,
+		`CREATE VIRTUAL TABLE search_index USING fts5(name, entity_type, observations)`,
+	}
+	for name, def := range searchTriggers {
+		stmts = append(stmts, `DROP TRIGGER IF EXISTS `+name, def)
+	}
+	stmts = append(stmts, `
+		INSERT INTO search_index(name, entity_type, observations)
+			SELECT e.name, e.entity_type,
+				COALESCE((SELECT group_concat(content, char(31)) FROM
+					(SELECT content FROM observations WHERE entity_name = e.name AND deleted_at IS NULL ORDER BY id)), '')
+			FROM entities e WHERE e.deleted_at IS NULL`)
+	for _, s := range stmts {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// hasSearchIndex reports whether the FTS5 index is maintained for db.
+func hasSearchIndex(db *sql.DB) bool {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'search_index_%'`).Scan(&n)
+	return err == nil && n == len(searchTriggers)
+}
+
+// SearchNodesWithOptions searches entities by name, type and observation
+// content. With the FTS5 index the query supports phrases ("exact words"),
+// prefixes (term*) and AND/OR/NOT, results are ranked by BM25 and each
+// entity lists the observations that matched with the hits marked as
+// **term**. Without the index it falls back to substring matching. An empty
+// query lists every entity in the options' time window, most recently
+// changed first. Historical searches (opts.AsOf) always use substring
+// matching.
+func SearchNodesWithOptions(db *sql.DB, query string, opts SearchOptions) ([]Entity, []Relation, error) {
+	if opts.AsOf != "" {
+		g, err := GraphAsOf(db, opts.AsOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		entities, relations := g.Search(query, opts)
+		return entities, relations, nil
+	}
+
+	var entities []Entity
+	var err error
+	if strings.TrimSpace(query) == "" {
+		entities, err = searchRecent(db, opts)
+	} else if hasSearchIndex(db) {
+		entities, err = searchFTS(db, query, opts)
+		if err != nil && isFTSSyntaxError(err) {
+			// Treat input that is not valid FTS5 syntax as plain terms
+			entities, err = searchFTS(db, quoteFTSTerms(query), opts)
+		}
+	} else {
+		entities, err = searchLike(db, query, opts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(entities) == 0 {
+		return entities, nil, nil
+	}
+
+	names := make([]string, len(entities))
+	for i, e := range entities {
+		names[i] = e.Name
+	}
+	relations, err := relationsInvolving(db, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entities, relations, nil
+}
+
+func searchFTS(db *sql.DB, query string, opts SearchOptions) ([]Entity, error) {
+	window, windowArgs := opts.windowFilter()
+	q := `
+		SELECT e.name, e.entity_type, ` + metaColumns("e") + `,
+			bm25(search_index, 10.0, 5.0, 1.0) AS score,
+			highlight(search_index, 2, ?, ?)
+		FROM search_index
+		JOIN entities e ON e.name = search_index.name
+		WHERE search_index MATCH ? AND e.deleted_at IS NULL` + window + `
+		ORDER BY score`
+	args := append([]interface{}{highlightOpen, highlightClose, query}, windowArgs...)
+	if opts.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entities []Entity
+	for rows.Next() {
+		var e Entity
+		var bm25 float64
+		var highlighted string
+		if err := rows.Scan(append(entityTargets(&e), &bm25, &highlighted)...); err != nil {
+			return nil, err
+		}
+		// bm25 is lower for better matches; expose a score where higher is better
+		e.Score = -bm25
+		for _, obs := range strings.Split(highlighted, obsSeparator) {
+			if strings.Contains(obs, highlightOpen) {
+				obs = strings.ReplaceAll(obs, highlightOpen, "**")
+				obs = strings.ReplaceAll(obs, highlightClose, "**")
+				e.Matches = append(e.Matches, obs)
+			}
+		}
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+func searchLike(db *sql.DB, query string, opts SearchOptions) ([]Entity, error) {
+	searchPattern := "%" + strings.ToLower(query) + "%"
+
+	// Search entities by name, type, or observation content
+	window, windowArgs := opts.windowFilter()
+	q := `
+        SELECT DISTINCT e.name, e.entity_type, ` + metaColumns("e") + `
+        FROM entities e
+        LEFT JOIN observations o ON e.name = o.entity_name AND o.deleted_at IS NULL
+        WHERE e.deleted_at IS NULL AND (LOWER(e.name) LIKE ?
+           OR LOWER(e.entity_type) LIKE ?
+           OR LOWER(o.content) LIKE ?)` + window + `
+        ORDER BY e.name
+    `
+	args := append([]interface{}{searchPattern, searchPattern, searchPattern}, windowArgs...)
+	return queryEntities(db, q, args, opts.Limit)
+}
+
+// searchRecent lists entities in the time window, most recently changed first.
+func searchRecent(db *sql.DB, opts SearchOptions) ([]Entity, error) {
+	window, args := opts.windowFilter()
+	q := `
+        SELECT e.name, e.entity_type, ` + metaColumns("e") + `
+        FROM entities e
+        WHERE e.deleted_at IS NULL` + window + `
+        ORDER BY e.updated_at DESC, e.name
+    `
+	return queryEntities(db, q, args, opts.Limit)
+}
+
+// queryEntities runs a query selecting name, entity_type and metaColumns.
+func queryEntities(db *sql.DB, q string, args []interface{}, limit int) ([]Entity, error) {
+	if limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entities []Entity
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(entityTargets(&e)...); err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, rows.Err()
+}
+
+func isFTSSyntaxError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "fts5: syntax error") ||
+		strings.Contains(msg, "no such column") ||
+		strings.Contains(msg, "unterminated string")
+}
+
+// quoteFTSTerms turns arbitrary input into a query that matches every
+// whitespace-separated term literally.
+func quoteFTSTerms(query string) string {
+	fields := strings.Fields(query)
+	for i, f := range fields {
+		fields[i] = `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+	}
+	return strings.Join(fields, " ")
+}
+
+// relationsInvolving returns every relation that starts or ends at one of names.
+func relationsInvolving(db *sql.DB, names []string) ([]Relation, error) {
+	placeholders := strings.Repeat("?,", len(names))
+	placeholders = placeholders[:len(placeholders)-1]
+
+	args := make([]interface{}, len(names)*2)
+	for i, name := range names {
+		args[i] = name
+		args[i+len(names)] = name
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+        SELECT %s
+        FROM relations
+        WHERE (from_entity IN (%s) OR to_entity IN (%s)) AND deleted_at IS NULL
+    `, relationColumns, placeholders, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relations []Relation
+	for rows.Next() {
+		r, err := scanRelation(rows)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, r)
+	}
+	return relations, rows.Err()
+}
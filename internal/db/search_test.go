@@ -0,0 +1,221 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db/schema"
+)
+
+func TestSearchNodes(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Company", "organization")
+	CreateEntity(db, "Bob", "person")
+	CreateRelation(db, "Alice", "Company", "works_at")
+	CreateObservation(db, "Alice", "Alice is a software engineer")
+	CreateObservation(db, "Alice", "Alice likes hiking")
+	CreateObservation(db, "Bob", "Bob writes software documentation")
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"by name", "alice", []string{"Alice"}},
+		{"by type", "organization", []string{"Company"}},
+		{"by observation", "hiking", []string{"Alice"}},
+		{"shared observation term", "software", []string{"Alice", "Bob"}},
+		{"no match", "zebra", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entities, _, err := SearchNodes(db, tt.query)
+			if err != nil {
+				t.Fatalf("SearchNodes() failed: %v", err)
+			}
+			got := map[string]bool{}
+			for _, e := range entities {
+				if got[e.Name] {
+					t.Errorf("Entity %s returned more than once", e.Name)
+				}
+				got[e.Name] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, entities)
+			}
+			for _, name := range tt.want {
+				if !got[name] {
+					t.Errorf("Expected %s in results", name)
+				}
+			}
+		})
+	}
+
+	entities, relations, err := SearchNodes(db, "hiking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || len(relations) != 1 {
+		t.Errorf("Expected Alice with her relation, got %d entities and %d relations", len(entities), len(relations))
+	}
+
+	entities, _, err = SearchNodesWithOptions(db, "software", SearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 {
+		t.Errorf("Expected limit to cap results at 1, got %d", len(entities))
+	}
+}
+
+func TestSearchNodesFullText(t *testing.T) {
+	db := setupTestDB(t)
+	if !hasSearchIndex(db) {
+		t.Skip("FTS5 not compiled in; build with -tags sqlite_fts5")
+	}
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Software", "topic")
+	CreateObservation(db, "Alice", "Alice is a software engineer")
+	CreateObservation(db, "Alice", "Alice likes hiking")
+	CreateObservation(db, "Bob", "Bob writes documentation")
+
+	// Multi-word queries match across fields of the same entity
+	entities, _, err := SearchNodes(db, "alice hiking")
+	if err != nil {
+		t.Fatalf("SearchNodes() failed: %v", err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Alice" {
+		t.Fatalf("Expected only Alice, got %v", entities)
+	}
+	if len(entities[0].Matches) != 2 || !strings.Contains(entities[0].Matches[1], "**hiking**") {
+		t.Errorf("Expected highlighted matching observations, got %v", entities[0].Matches)
+	}
+
+	// A name match ranks above an observation match
+	entities, _, err = SearchNodes(db, "software")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].Name != "Software" {
+		t.Errorf("Expected Software ranked first, got %v", entities)
+	}
+
+	// Prefix, phrase and boolean syntax
+	for query, want := range map[string]string{
+		"docu*":                  "Bob",
+		`"software engineer"`:    "Alice",
+		"person NOT hiking":      "Bob",
+		"hiking OR nonexistent":  "Alice",
+		"c++ (unbalanced hiking": "",
+	} {
+		entities, _, err := SearchNodes(db, query)
+		if err != nil {
+			t.Errorf("SearchNodes(%q) failed: %v", query, err)
+			continue
+		}
+		if want == "" {
+			continue
+		}
+		if len(entities) != 1 || entities[0].Name != want {
+			t.Errorf("SearchNodes(%q) = %v, want %s", query, entities, want)
+		}
+	}
+
+	// The index follows deletes
+	if err := DeleteEntities(db, []string{"Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	entities, _, _ = SearchNodes(db, "documentation")
+	if len(entities) != 0 {
+		t.Errorf("Expected deleted entity to leave the index, got %v", entities)
+	}
+}
+
+func TestSearchIndexSchema(t *testing.T) {
+	if len(searchTriggers) != len(schema.SearchTriggers) {
+		t.Errorf("Expected a definition for each of %d schema triggers, got %d", len(schema.SearchTriggers), len(searchTriggers))
+	}
+	for _, name := range schema.SearchTriggers {
+		if !strings.HasPrefix(searchTriggers[name], "CREATE TRIGGER "+name+" ") {
+			t.Errorf("Expected a definition of %s, got %q", name, searchTriggers[name])
+		}
+	}
+
+	db := setupTestDB(t)
+	if !hasSearchIndex(db) {
+		t.Skip("FTS5 not compiled in; build with -tags sqlite_fts5")
+	}
+	CreateEntity(db, "Alice", "person")
+
+	// Reverting soft delete drops the triggers that read deleted_at
+	if _, err := schema.Down(db, 4); err != nil {
+		t.Fatalf("Down() failed with the search index installed: %v", err)
+	}
+	if hasSearchIndex(db) {
+		t.Error("Expected the search index triggers to be dropped")
+	}
+	if err := schema.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := initSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if entities, _, _ := SearchNodes(db, "alice"); !hasSearchIndex(db) || len(entities) != 1 {
+		t.Errorf("Expected the index back after migrating up, got %v", entities)
+	}
+}
+
+func TestSearchNodesTimeWindow(t *testing.T) {
+	db := setupTestDB(t)
+
+	monday := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	tuesday := monday.Add(24 * time.Hour)
+	wednesday := tuesday.Add(24 * time.Hour)
+
+	setClock(t, monday)
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateObservation(db, "Alice", "Alice likes tea")
+	CreateObservation(db, "Bob", "Bob likes tea")
+
+	setClock(t, wednesday)
+	CreateObservation(db, "Bob", "Bob switched to coffee")
+
+	tests := []struct {
+		name  string
+		query string
+		opts  SearchOptions
+		want  []string
+	}{
+		{"since", "tea", SearchOptions{Since: tuesday}, []string{"Bob"}},
+		{"until", "tea", SearchOptions{Until: tuesday}, []string{"Alice"}},
+		{"window excludes all", "tea", SearchOptions{Since: tuesday, Until: wednesday}, nil},
+		{"empty query lists recent changes", "", SearchOptions{Since: tuesday}, []string{"Bob"}},
+		{"empty query newest first", "", SearchOptions{Since: monday}, []string{"Bob", "Alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entities, _, err := SearchNodesWithOptions(db, tt.query, tt.opts)
+			if err != nil {
+				t.Fatalf("SearchNodesWithOptions() failed: %v", err)
+			}
+			var got []string
+			for _, e := range entities {
+				got = append(got, e.Name)
+				if e.UpdatedAt == "" {
+					t.Errorf("Expected %s to carry its timestamps", e.Name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
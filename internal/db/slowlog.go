@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"gnolledgegraph/internal/logs"
+)
+
+// DefaultSlowQueryThreshold is how long a statement may take before it is
+// logged as a slow query.
+const DefaultSlowQueryThreshold = 250 * time.Millisecond
+
+var slowQueryThreshold atomic.Int64
+
+func init() {
+	slowQueryThreshold.Store(int64(DefaultSlowQueryThreshold))
+}
+
+// SetSlowQueryThreshold sets how long a statement may take before it is
+// logged as a slow query. A negative d turns slow query logging off.
+func SetSlowQueryThreshold(d time.Duration) {
+	slowQueryThreshold.Store(int64(d))
+}
+
+// maxLoggedQuery bounds the SQL text in a slow query record.
+const maxLoggedQuery = 300
+
+// timedConn is a connection whose queries are timed. Queries are timed
+// until their rows are closed, since SQLite does most of the work while
+// rows are read.
+type timedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		checkSlowQuery(query, start)
+		return nil, err
+	}
+	if r, ok := rows.(*sqlite3.SQLiteRows); ok {
+		return &timedRows{SQLiteRows: r, query: query, start: start}, nil
+	}
+	return rows, nil
+}
+
+func (c timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer checkSlowQuery(query, time.Now())
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+type timedRows struct {
+	*sqlite3.SQLiteRows
+	query string
+	start time.Time
+}
+
+func (r *timedRows) Close() error {
+	err := r.SQLiteRows.Close()
+	checkSlowQuery(r.query, r.start)
+	return err
+}
+
+// checkSlowQuery logs query if it has been running since start for longer
+// than the slow query threshold.
+func checkSlowQuery(query string, start time.Time) {
+	threshold := time.Duration(slowQueryThreshold.Load())
+	elapsed := time.Since(start)
+	if threshold < 0 || elapsed < threshold {
+		return
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxLoggedQuery {
+		query = query[:maxLoggedQuery] + "..."
+	}
+	logs.Log(logs.Warning, "db", "slow query", map[string]interface{}{
+		"query":      query,
+		"durationMs": elapsed.Milliseconds(),
+	})
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/logs"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { SetSlowQueryThreshold(DefaultSlowQueryThreshold) })
+
+	var records []logs.Record
+	unsubscribe := logs.Subscribe(func(r logs.Record) {
+		if r.Logger == "db" && r.Message == "slow query" {
+			records = append(records, r)
+		}
+	})
+	defer unsubscribe()
+
+	// Every statement is slow with no threshold
+	SetSlowQueryThreshold(0)
+	if _, _, _, err := ReadGraph(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEntity(db, "Alice", "person"); err != nil {
+		t.Fatal(err)
+	}
+	SetSlowQueryThreshold(-1)
+	if _, _, _, err := ReadGraph(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) == 0 {
+		t.Fatal("Expected slow query records")
+	}
+	var sawSelect, sawInsert bool
+	for _, r := range records {
+		if r.Level != logs.Warning {
+			t.Errorf("Expected slow queries to be warnings, got %v", r.Level)
+		}
+		query, _ := r.Fields["query"].(string)
+		sawSelect = sawSelect || strings.HasPrefix(query, "SELECT")
+		sawInsert = sawInsert || strings.Contains(query, "INTO entities")
+		if strings.Contains(query, "\n") {
+			t.Errorf("Expected the query on one line, got %q", query)
+		}
+	}
+	if !sawSelect || !sawInsert {
+		t.Errorf("Expected both reads and writes to be timed, got %+v", records)
+	}
+}
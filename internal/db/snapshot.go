@@ -0,0 +1,617 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Graph is the complete state of the knowledge graph, as returned by
+// ReadGraph.
+type Graph struct {
+	Entities     []Entity      `json:"entities"`
+	Relations    []Relation    `json:"relations"`
+	Observations []Observation `json:"observations"`
+}
+
+// Snapshot describes a stored copy of the graph.
+type Snapshot struct {
+	Name    string `json:"name"`
+	TakenAt string `json:"takenAt"`
+	// Automatic snapshots are taken by the server on a schedule and before
+	// a restore, and are pruned after a while.
+	Automatic bool `json:"automatic"`
+	Provenance
+}
+
+// ErrInvalidAsOf is returned for an as_of value that names no snapshot and
+// is not a time.
+var ErrInvalidAsOf = errors.New("as_of is neither a snapshot nor a time")
+
+// automaticSnapshotPrefix starts the names of automatic snapshots.
+const automaticSnapshotPrefix = "auto-"
+
+// CreateSnapshot stores the current graph under name. An empty name takes
+// an automatic snapshot named after the current time.
+func CreateSnapshot(ctx context.Context, db *sql.DB, name string) (Snapshot, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer tx.Rollback()
+
+	s, err := createSnapshot(ctx, tx, FormatTime(now()), name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return s, tx.Commit()
+}
+
+func createSnapshot(ctx context.Context, tx *sql.Tx, ts, name string) (Snapshot, error) {
+	s := Snapshot{Name: name, TakenAt: ts, Provenance: ProvenanceFrom(ctx)}
+	if name == "" {
+		s.Automatic = true
+		s.Name = automaticSnapshotPrefix + ts
+		// Keep names unique when several are taken within a millisecond
+		for i := 2; ; i++ {
+			exists, err := snapshotExists(ctx, tx, s.Name)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			if !exists {
+				break
+			}
+			s.Name = fmt.Sprintf("%s%s-%d", automaticSnapshotPrefix, ts, i)
+		}
+	} else {
+		if strings.HasPrefix(name, automaticSnapshotPrefix) {
+			return Snapshot{}, fmt.Errorf("snapshot names starting with %q are reserved for automatic snapshots", automaticSnapshotPrefix)
+		}
+		exists, err := snapshotExists(ctx, tx, name)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if exists {
+			return Snapshot{}, fmt.Errorf("snapshot '%s' already exists", name)
+		}
+	}
+
+	entities, relations, observations, err := readGraph(ctx, tx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	data, err := json.Marshal(Graph{Entities: entities, Relations: relations, Observations: observations})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO snapshots(name, taken_at, automatic, graph, source, session_id, actor) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		s.Name, s.TakenAt, s.Automatic, string(data),
+		nullable(s.Source), nullable(s.SessionID), nullable(s.Actor),
+	)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return s, nil
+}
+
+func snapshotExists(ctx context.Context, ex execer, name string) (bool, error) {
+	var exists bool
+	err := ex.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM snapshots WHERE name = ?)`, name).Scan(&exists)
+	return exists, err
+}
+
+// ListSnapshots returns every snapshot, oldest first.
+func ListSnapshots(db *sql.DB) ([]Snapshot, error) {
+	rows, err := db.Query(`
+		SELECT name, taken_at, automatic, COALESCE(source, ''), COALESCE(session_id, ''), COALESCE(actor, '')
+		FROM snapshots
+		ORDER BY taken_at, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		var s Snapshot
+		if err := rows.Scan(&s.Name, &s.TakenAt, &s.Automatic, &s.Source, &s.SessionID, &s.Actor); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// LatestAutomaticSnapshot returns when the newest automatic snapshot was
+// taken, or the zero time if there is none.
+func LatestAutomaticSnapshot(db *sql.DB) (time.Time, error) {
+	var takenAt sql.NullString
+	if err := db.QueryRow(`SELECT MAX(taken_at) FROM snapshots WHERE automatic`).Scan(&takenAt); err != nil {
+		return time.Time{}, err
+	}
+	if !takenAt.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(TimeFormat, takenAt.String)
+}
+
+// LoadSnapshot returns the graph stored in the named snapshot.
+func LoadSnapshot(db *sql.DB, name string) (*Graph, error) {
+	return loadSnapshot(context.Background(), db, name)
+}
+
+func loadSnapshot(ctx context.Context, ex execer, name string) (*Graph, error) {
+	var data string
+	err := ex.QueryRowContext(ctx, `SELECT graph FROM snapshots WHERE name = ?`, name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("snapshot '%s' does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var g Graph
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, fmt.Errorf("snapshot '%s' is corrupt: %w", name, err)
+	}
+	return &g, nil
+}
+
+// PruneSnapshots removes automatic snapshots taken before cutoff and
+// returns how many were removed. Named snapshots are never pruned.
+func PruneSnapshots(ctx context.Context, db *sql.DB, cutoff time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM snapshots WHERE automatic AND taken_at < ?`, FormatTime(cutoff))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RestoreSnapshot makes the live graph match the named snapshot. The
+// current graph is saved to an automatic snapshot first, which is
+// returned, so the restore can itself be undone. The differences are
+// applied as ordinary writes: entities missing from the snapshot are
+// deleted (and stay restorable), and every change is recorded in the
+// changelog.
+func RestoreSnapshot(ctx context.Context, db *sql.DB, name string) (Snapshot, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := loadSnapshot(ctx, tx, name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	ts := FormatTime(now())
+	backup, err := createSnapshot(ctx, tx, ts, "")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	entities, relations, observations, err := readGraph(ctx, tx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	current := &Graph{Entities: entities, Relations: relations, Observations: observations}
+	if err := applyGraph(ctx, tx, ts, current, target); err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := commit(db, tx); err != nil {
+		return Snapshot{}, err
+	}
+	return backup, nil
+}
+
+// applyGraph writes the changes that turn current into target.
+func applyGraph(ctx context.Context, tx *sql.Tx, ts string, current, target *Graph) error {
+	currentTypes := make(map[string]string, len(current.Entities))
+	for _, e := range current.Entities {
+		currentTypes[e.Name] = e.Type
+	}
+	targetTypes := make(map[string]string, len(target.Entities))
+	for _, e := range target.Entities {
+		targetTypes[e.Name] = e.Type
+	}
+
+	// Entities that are gone or changed type are deleted; an entity whose
+	// type changed is then created afresh.
+	var deleted []string
+	for _, e := range current.Entities {
+		if t, ok := targetTypes[e.Name]; !ok || t != e.Type {
+			deleted = append(deleted, e.Name)
+		}
+	}
+	removed := make(map[string]bool, len(deleted))
+	for _, name := range deleted {
+		removed[name] = true
+	}
+	if len(deleted) > 0 {
+		if err := deleteEntities(ctx, tx, ts, deleted); err != nil {
+			return err
+		}
+	}
+	for _, e := range target.Entities {
+		if t, ok := currentTypes[e.Name]; !ok || t != e.Type {
+			if _, err := createEntity(ctx, tx, ts, e.Name, e.Type); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Observations are matched by entity and content.
+	currentObs := make(map[[2]string][]int64)
+	for _, o := range current.Observations {
+		if !removed[o.EntityName] {
+			key := [2]string{o.EntityName, o.Content}
+			currentObs[key] = append(currentObs[key], o.ID)
+		}
+	}
+	for _, o := range target.Observations {
+		key := [2]string{o.EntityName, o.Content}
+		if ids := currentObs[key]; len(ids) > 0 {
+			currentObs[key] = ids[1:]
+			continue
+		}
+		if _, err := insertObservation(ctx, tx, o.EntityName, o.Content, ts); err != nil {
+			return err
+		}
+	}
+	var unmatchedObs []int64
+	for _, ids := range currentObs {
+		unmatchedObs = append(unmatchedObs, ids...)
+	}
+	sort.Slice(unmatchedObs, func(i, j int) bool { return unmatchedObs[i] < unmatchedObs[j] })
+	for _, id := range unmatchedObs {
+		if _, err := softDeleteObservations(ctx, tx, ts, `id = ?`, id); err != nil {
+			return err
+		}
+	}
+
+	// Relations are matched by endpoints and type.
+	currentRels := make(map[[3]string][]int64)
+	for _, r := range current.Relations {
+		if !removed[r.From] && !removed[r.To] {
+			key := [3]string{r.From, r.To, r.Type}
+			currentRels[key] = append(currentRels[key], r.ID)
+		}
+	}
+	for _, r := range target.Relations {
+		key := [3]string{r.From, r.To, r.Type}
+		if ids := currentRels[key]; len(ids) > 0 {
+			currentRels[key] = ids[1:]
+			continue
+		}
+		if _, err := insertRelation(ctx, tx, ts, r.From, r.To, r.Type); err != nil {
+			return err
+		}
+	}
+	var unmatchedRels []int64
+	for _, ids := range currentRels {
+		unmatchedRels = append(unmatchedRels, ids...)
+	}
+	sort.Slice(unmatchedRels, func(i, j int) bool { return unmatchedRels[i] < unmatchedRels[j] })
+	for _, id := range unmatchedRels {
+		if _, err := softDeleteRelations(ctx, tx, ts, `id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GraphAsOf returns the graph as it was at asOf, which names a snapshot or
+// is a time accepted by ParseTime. For a time, the live graph is rolled
+// back through the changelog, so the answer is exact for every write made
+// through this server, including rows purged since. An empty asOf returns
+// the live graph.
+func GraphAsOf(db *sql.DB, asOf string) (*Graph, error) {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if asOf == "" {
+		entities, relations, observations, err := readGraph(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		return &Graph{Entities: entities, Relations: relations, Observations: observations}, nil
+	}
+	exists, err := snapshotExists(ctx, tx, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return loadSnapshot(ctx, tx, asOf)
+	}
+	t, err := ParseTime(asOf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q (want a snapshot name, RFC 3339 or YYYY-MM-DD)", ErrInvalidAsOf, asOf)
+	}
+	return graphAt(ctx, tx, t)
+}
+
+// ReadGraphAsOf is ReadGraph for the graph at asOf (see GraphAsOf). An
+// empty asOf reads the live graph.
+func ReadGraphAsOf(db *sql.DB, asOf string) ([]Entity, []Relation, []Observation, error) {
+	if asOf == "" {
+		return ReadGraph(db)
+	}
+	g, err := GraphAsOf(db, asOf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return g.Entities, g.Relations, g.Observations, nil
+}
+
+// OpenNodesAsOf is OpenNodes for the graph at asOf (see GraphAsOf). An
+// empty asOf reads the live graph.
+func OpenNodesAsOf(db *sql.DB, nodeNames []string, asOf string) ([]Entity, []Relation, error) {
+	if asOf == "" {
+		return OpenNodes(db, nodeNames)
+	}
+	g, err := GraphAsOf(db, asOf)
+	if err != nil {
+		return nil, nil, err
+	}
+	entities, relations := g.Open(nodeNames)
+	return entities, relations, nil
+}
+
+// graphAt undoes every change recorded after t, newest first.
+func graphAt(ctx context.Context, ex execer, t time.Time) (*Graph, error) {
+	entities, relations, observations, err := readGraph(ctx, ex)
+	if err != nil {
+		return nil, err
+	}
+	entityMap := make(map[string]Entity, len(entities))
+	for _, e := range entities {
+		entityMap[e.Name] = e
+	}
+	relationMap := make(map[int64]Relation, len(relations))
+	for _, r := range relations {
+		relationMap[r.ID] = r
+	}
+	observationMap := make(map[int64]Observation, len(observations))
+	for _, o := range observations {
+		observationMap[o.ID] = o
+	}
+
+	rows, err := ex.QueryContext(ctx, `
+		SELECT operation, COALESCE(before, ''), COALESCE(after, '')
+		FROM changelog
+		WHERE at > ?
+		ORDER BY id DESC`, FormatTime(t))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var op, before, after string
+		if err := rows.Scan(&op, &before, &after); err != nil {
+			return nil, err
+		}
+		// Purges only remove rows that were already deleted.
+		switch op {
+		case OpCreateEntity, OpRestoreEntity:
+			var e Entity
+			if err := json.Unmarshal([]byte(after), &e); err != nil {
+				return nil, err
+			}
+			delete(entityMap, e.Name)
+		case OpDeleteEntity:
+			var e Entity
+			if err := json.Unmarshal([]byte(before), &e); err != nil {
+				return nil, err
+			}
+			entityMap[e.Name] = e
+		case OpCreateRelation, OpRestoreRelation:
+			var r Relation
+			if err := json.Unmarshal([]byte(after), &r); err != nil {
+				return nil, err
+			}
+			delete(relationMap, r.ID)
+		case OpDeleteRelation:
+			var r Relation
+			if err := json.Unmarshal([]byte(before), &r); err != nil {
+				return nil, err
+			}
+			relationMap[r.ID] = r
+		case OpAddObservation, OpRestoreObservation:
+			var o Observation
+			if err := json.Unmarshal([]byte(after), &o); err != nil {
+				return nil, err
+			}
+			delete(observationMap, o.ID)
+		case OpDeleteObservation:
+			var o Observation
+			if err := json.Unmarshal([]byte(before), &o); err != nil {
+				return nil, err
+			}
+			observationMap[o.ID] = o
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := entityUpdatesAt(ctx, ex, t, entityMap); err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		Entities:     make([]Entity, 0, len(entityMap)),
+		Relations:    make([]Relation, 0, len(relationMap)),
+		Observations: make([]Observation, 0, len(observationMap)),
+	}
+	for _, o := range observationMap {
+		g.Observations = append(g.Observations, o)
+	}
+	sort.Slice(g.Observations, func(i, j int) bool { return g.Observations[i].ID < g.Observations[j].ID })
+	byEntity := make(map[string][]string)
+	for _, o := range g.Observations {
+		byEntity[o.EntityName] = append(byEntity[o.EntityName], o.Content)
+	}
+	for _, e := range entityMap {
+		e.Observations = byEntity[e.Name]
+		if e.Observations == nil {
+			e.Observations = []string{}
+		}
+		g.Entities = append(g.Entities, e)
+	}
+	sort.Slice(g.Entities, func(i, j int) bool { return g.Entities[i].Name < g.Entities[j].Name })
+	for _, r := range relationMap {
+		g.Relations = append(g.Relations, r)
+	}
+	sort.Slice(g.Relations, func(i, j int) bool { return g.Relations[i].ID < g.Relations[j].ID })
+	return g, nil
+}
+
+// entityUpdateOps are the changes that bump their entity's updated_at.
+var entityUpdateOps = []string{OpCreateEntity, OpRestoreEntity, OpAddObservation, OpDeleteObservation}
+
+// entityUpdatesAt sets the updated_at of entities changed after t back to
+// their latest change at or before t. Bumps from observation changes are
+// not undone by the rollback, as the changelog records the observation,
+// not the entity row.
+func entityUpdatesAt(ctx context.Context, ex execer, t time.Time, entityMap map[string]Entity) error {
+	cutoff := FormatTime(t)
+	stale := false
+	for _, e := range entityMap {
+		if e.UpdatedAt > cutoff {
+			stale = true
+			break
+		}
+	}
+	if !stale {
+		return nil
+	}
+
+	rows, err := ex.QueryContext(ctx, `
+		SELECT entity_name, MAX(at)
+		FROM changelog
+		WHERE at <= ? AND operation IN (`+placeholders(len(entityUpdateOps))+`)
+		GROUP BY entity_name`, append([]interface{}{cutoff}, stringArgs(entityUpdateOps)...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	latest := make(map[string]string)
+	for rows.Next() {
+		var name, at string
+		if err := rows.Scan(&name, &at); err != nil {
+			return err
+		}
+		latest[name] = at
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, e := range entityMap {
+		if e.UpdatedAt <= cutoff {
+			continue
+		}
+		if at, ok := latest[name]; ok {
+			e.UpdatedAt = at
+		} else {
+			// Written before the changelog existed
+			e.UpdatedAt = e.CreatedAt
+		}
+		entityMap[name] = e
+	}
+	return nil
+}
+
+// Search matches entities of g by name, type or observation content the
+// way SearchNodes does without the full-text index.
+func (g *Graph) Search(query string, opts SearchOptions) ([]Entity, []Relation) {
+	pattern := strings.ToLower(strings.TrimSpace(query))
+	since, until := "", ""
+	if !opts.Since.IsZero() {
+		since = FormatTime(opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		until = FormatTime(opts.Until)
+	}
+
+	var entities []Entity
+	for _, e := range g.Entities {
+		if (since != "" || until != "") && e.UpdatedAt == "" {
+			continue
+		}
+		if (since != "" && e.UpdatedAt < since) || (until != "" && e.UpdatedAt >= until) {
+			continue
+		}
+		if pattern != "" && !entityMatches(e, pattern) {
+			continue
+		}
+		e.Observations = nil
+		entities = append(entities, e)
+	}
+	if pattern == "" {
+		// Most recently changed first, like an empty live search
+		sort.SliceStable(entities, func(i, j int) bool { return entities[i].UpdatedAt > entities[j].UpdatedAt })
+	}
+	if opts.Limit > 0 && len(entities) > opts.Limit {
+		entities = entities[:opts.Limit]
+	}
+	if len(entities) == 0 {
+		return entities, nil
+	}
+	return entities, g.relationsInvolving(entities)
+}
+
+func entityMatches(e Entity, pattern string) bool {
+	if strings.Contains(strings.ToLower(e.Name), pattern) || strings.Contains(strings.ToLower(e.Type), pattern) {
+		return true
+	}
+	for _, obs := range e.Observations {
+		if strings.Contains(strings.ToLower(obs), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the named entities of g and the relations touching them.
+func (g *Graph) Open(names []string) ([]Entity, []Relation) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var entities []Entity
+	for _, e := range g.Entities {
+		if wanted[e.Name] {
+			e.Observations = nil
+			entities = append(entities, e)
+		}
+	}
+	if len(entities) == 0 {
+		return entities, nil
+	}
+	return entities, g.relationsInvolving(entities)
+}
+
+func (g *Graph) relationsInvolving(entities []Entity) []Relation {
+	names := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		names[e.Name] = true
+	}
+	var relations []Relation
+	for _, r := range g.Relations {
+		if names[r.From] || names[r.To] {
+			relations = append(relations, r)
+		}
+	}
+	return relations
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCreateAndRestore(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+
+	setClock(t, time.Date(2024, 5, 3, 17, 0, 0, 0, time.UTC))
+	friday, err := CreateSnapshot(ctx, db, "friday")
+	if err != nil {
+		t.Fatalf("CreateSnapshot() failed: %v", err)
+	}
+	if friday.Automatic || friday.TakenAt != "2024-05-03T17:00:00.000Z" {
+		t.Errorf("Unexpected snapshot %+v", friday)
+	}
+	if _, err := CreateSnapshot(ctx, db, "friday"); err == nil {
+		t.Error("Expected duplicate snapshot name to fail")
+	}
+	if _, err := CreateSnapshot(ctx, db, "auto-mine"); err == nil {
+		t.Error("Expected reserved snapshot name to fail")
+	}
+
+	// Change everything a restore has to undo
+	setClock(t, time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC))
+	DeleteEntities(db, []string{"Acme"})
+	CreateEntity(db, "Bob", "person")
+	CreateObservation(db, "Alice", "Likes coffee")
+	CreateRelation(db, "Bob", "Alice", "knows")
+
+	backup, err := RestoreSnapshot(ctx, db, "friday")
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() failed: %v", err)
+	}
+	if !backup.Automatic {
+		t.Errorf("Expected an automatic backup snapshot, got %+v", backup)
+	}
+
+	entities, relations, observations, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].Name != "Acme" || entities[1].Name != "Alice" {
+		t.Errorf("Expected Acme and Alice, got %+v", entities)
+	}
+	if len(observations) != 1 || observations[0].Content != "Likes tea" {
+		t.Errorf("Expected only the Friday observation, got %+v", observations)
+	}
+	if len(relations) != 1 || relations[0].Type != "works_at" {
+		t.Errorf("Expected only works_at, got %+v", relations)
+	}
+
+	// The pre-restore graph is one restore away
+	if _, err := RestoreSnapshot(ctx, db, backup.Name); err != nil {
+		t.Fatal(err)
+	}
+	entities, _, _, _ = ReadGraph(db)
+	if len(entities) != 2 || entities[1].Name != "Bob" {
+		t.Errorf("Expected Alice and Bob after undoing the restore, got %+v", entities)
+	}
+
+	snapshots, err := ListSnapshots(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[0].Name != "friday" {
+		t.Errorf("Expected friday and two backups, got %+v", snapshots)
+	}
+
+	// Only automatic snapshots are pruned
+	n, err := PruneSnapshots(ctx, db, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 automatic snapshots pruned, got %d", n)
+	}
+	if _, err := RestoreSnapshot(ctx, db, "missing"); err == nil {
+		t.Error("Expected restoring a missing snapshot to fail")
+	}
+}
+
+func TestGraphAsOf(t *testing.T) {
+	db := setupTestDB(t)
+
+	setClock(t, time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+
+	setClock(t, time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC))
+	CreateEntity(db, "Bob", "person")
+	CreateObservation(db, "Alice", "Likes coffee")
+	err := DeleteObservations(db, []struct {
+		EntityName   string   `json:"entityName"`
+		Observations []string `json:"observations"`
+	}{{EntityName: "Alice", Observations: []string{"Likes tea"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DeleteEntities(db, []string{"Acme"})
+
+	// Purged rows are still part of the past
+	if _, err := PurgeTombstones(context.Background(), db, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	entities, relations, observations, err := ReadGraphAsOf(db, "2024-05-03")
+	if err != nil {
+		t.Fatalf("ReadGraphAsOf() failed: %v", err)
+	}
+	if len(entities) != 2 || entities[0].Name != "Acme" || entities[1].Name != "Alice" {
+		t.Errorf("Expected Acme and Alice last Friday, got %+v", entities)
+	}
+	if obs := entities[1].Observations; len(obs) != 1 || obs[0] != "Likes tea" {
+		t.Errorf("Expected Alice to only like tea last Friday, got %v", obs)
+	}
+	if len(relations) != 1 || len(observations) != 1 {
+		t.Errorf("Expected one relation and one observation, got %+v %+v", relations, observations)
+	}
+	if want := FormatTime(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)); entities[1].UpdatedAt != want {
+		t.Errorf("Expected Alice last updated %s as of last Friday, got %s", want, entities[1].UpdatedAt)
+	}
+
+	found, _, err := SearchNodesWithOptions(db, "tea", SearchOptions{AsOf: "2024-05-03T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "Alice" {
+		t.Errorf("Expected Alice to match tea last Friday, got %+v", found)
+	}
+	// Change times are those of the past too
+	found, _, err = SearchNodesWithOptions(db, "tea", SearchOptions{AsOf: "2024-05-03T12:00:00Z", Since: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("Expected Alice unchanged since Thursday as of last Friday, got %+v", found)
+	}
+	if found, _, _ := SearchNodes(db, "tea"); len(found) != 0 {
+		t.Errorf("Expected no live match for tea, got %+v", found)
+	}
+
+	opened, rels, err := OpenNodesAsOf(db, []string{"Acme"}, "2024-05-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 1 || len(rels) != 1 {
+		t.Errorf("Expected Acme with its relation last Friday, got %+v %+v", opened, rels)
+	}
+
+	// Before anything was written
+	entities, _, _, _ = ReadGraphAsOf(db, "2024-04-01")
+	if len(entities) != 0 {
+		t.Errorf("Expected an empty graph, got %+v", entities)
+	}
+
+	// Snapshot names take precedence over times
+	CreateSnapshot(context.Background(), db, "now")
+	entities, _, _, _ = ReadGraphAsOf(db, "now")
+	if len(entities) != 2 || entities[1].Name != "Bob" {
+		t.Errorf("Expected the snapshot's Alice and Bob, got %+v", entities)
+	}
+
+	if _, _, _, err := ReadGraphAsOf(db, "last friday"); !errors.Is(err, ErrInvalidAsOf) {
+		t.Errorf("Expected ErrInvalidAsOf, got %v", err)
+	}
+}
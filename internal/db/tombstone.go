@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Deleting an entity, relation or observation sets its deleted_at instead
+// of removing the row. Reads skip these tombstones; RestoreEntities brings
+// a deleted entity back with everything that was deleted along with it,
+// and PurgeTombstones removes tombstones for good once they are old enough.
+
+// DefaultPurgeAfter is how long tombstones are kept before the server
+// purges them.
+const DefaultPurgeAfter = 30 * 24 * time.Hour
+
+// RestoreEntities undeletes entities
+func RestoreEntities(db *sql.DB, entityNames []string) ([]string, error) {
+	return RestoreEntitiesContext(context.Background(), db, entityNames)
+}
+
+// RestoreEntitiesContext undeletes entities together with the observations
+// and relations that were deleted with them, and returns the names that
+// were restored. Relations only come back once both of their endpoints are
+// live again. Names that are not deleted, or were already purged, are
+// skipped.
+func RestoreEntitiesContext(ctx context.Context, db *sql.DB, entityNames []string) ([]string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ts := FormatTime(now())
+	restored := []string{}
+	deletedAt := make(map[string]string)
+	for _, name := range entityNames {
+		var at string
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM entities WHERE name = ? AND deleted_at IS NOT NULL`, name).Scan(&at)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := tx.QueryContext(ctx, `UPDATE entities SET deleted_at = NULL, updated_at = ? WHERE name = ?
+			RETURNING name, entity_type, `+metaColumns(""), ts, name)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := scanEntities(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			if err := recordChange(ctx, tx, ts, OpRestoreEntity, e.Name, "", nil, e); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := restoreObservations(ctx, tx, ts, `entity_name = ? AND deleted_at = ?`, name, at); err != nil {
+			return nil, err
+		}
+		restored = append(restored, name)
+		deletedAt[name] = at
+	}
+
+	// Relations go last so that those between two entities restored
+	// together find both endpoints live.
+	for _, name := range restored {
+		if err := restoreRelations(ctx, tx, ts, `(from_entity = ? OR to_entity = ?) AND deleted_at = ?
+			AND from_entity IN (SELECT name FROM entities WHERE deleted_at IS NULL)
+			AND to_entity IN (SELECT name FROM entities WHERE deleted_at IS NULL)`,
+			name, name, deletedAt[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := commit(db, tx); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func restoreRelations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, `UPDATE relations SET deleted_at = NULL
+		WHERE deleted_at IS NOT NULL AND (`+where+`) RETURNING `+relationColumns, args...)
+	if err != nil {
+		return err
+	}
+	relations, err := scanRelations(rows)
+	if err != nil {
+		return err
+	}
+	for _, r := range relations {
+		if err := recordChange(ctx, tx, ts, OpRestoreRelation, r.From, r.To, nil, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreObservations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, `UPDATE observations SET deleted_at = NULL
+		WHERE deleted_at IS NOT NULL AND (`+where+`) RETURNING `+observationColumns, args...)
+	if err != nil {
+		return err
+	}
+	observations, err := scanObservations(rows)
+	if err != nil {
+		return err
+	}
+	for _, o := range observations {
+		if err := recordChange(ctx, tx, ts, OpRestoreObservation, o.EntityName, "", nil, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeTombstones permanently removes rows deleted before cutoff and
+// returns how many were removed.
+func PurgeTombstones(ctx context.Context, db *sql.DB, cutoff time.Time) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ts := FormatTime(now())
+	before := FormatTime(cutoff)
+	var total int64
+	for _, purge := range []func(context.Context, *sql.Tx, string, string, ...interface{}) (int64, error){
+		purgeRelations, purgeObservations, purgeEntities,
+	} {
+		n, err := purge(ctx, tx, ts, `deleted_at < ?`, before)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+
+	if err := commit(db, tx); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// purgeEntityTombstones permanently removes the deleted entities matching
+// where, with their deleted relations and observations.
+func purgeEntityTombstones(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) error {
+	deleted := `SELECT name FROM entities WHERE deleted_at IS NOT NULL AND (` + where + `)`
+	if _, err := purgeRelations(ctx, tx, ts, `from_entity IN (`+deleted+`) OR to_entity IN (`+deleted+`)`,
+		append(append([]interface{}{}, args...), args...)...); err != nil {
+		return err
+	}
+	if _, err := purgeObservations(ctx, tx, ts, `entity_name IN (`+deleted+`)`, args...); err != nil {
+		return err
+	}
+	_, err := purgeEntities(ctx, tx, ts, where, args...)
+	return err
+}
+
+func purgeEntities(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM entities
+		WHERE deleted_at IS NOT NULL AND (`+where+`) RETURNING name, entity_type, `+metaColumns(""), args...)
+	if err != nil {
+		return 0, err
+	}
+	entities, err := scanEntities(rows)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entities {
+		if err := recordChange(ctx, tx, ts, OpPurgeEntity, e.Name, "", e, nil); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(entities)), nil
+}
+
+func purgeRelations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM relations
+		WHERE deleted_at IS NOT NULL AND (`+where+`) RETURNING `+relationColumns, args...)
+	if err != nil {
+		return 0, err
+	}
+	relations, err := scanRelations(rows)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range relations {
+		if err := recordChange(ctx, tx, ts, OpPurgeRelation, r.From, r.To, r, nil); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(relations)), nil
+}
+
+func purgeObservations(ctx context.Context, tx *sql.Tx, ts, where string, args ...interface{}) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM observations
+		WHERE deleted_at IS NOT NULL AND (`+where+`) RETURNING `+observationColumns, args...)
+	if err != nil {
+		return 0, err
+	}
+	observations, err := scanObservations(rows)
+	if err != nil {
+		return 0, err
+	}
+	for _, o := range observations {
+		if err := recordChange(ctx, tx, ts, OpPurgeObservation, o.EntityName, "", o, nil); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(observations)), nil
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeleteAndRestoreEntities(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateObservation(db, "Alice", "Plays chess")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+	CreateRelation(db, "Bob", "Alice", "knows")
+	CreateRelation(db, "Alice", "Bob", "knows")
+
+	// An observation removed before the entity stays removed on restore
+	err := DeleteObservations(db, []struct {
+		EntityName   string   `json:"entityName"`
+		Observations []string `json:"observations"`
+	}{{EntityName: "Alice", Observations: []string{"Plays chess"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setClock(t, time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC))
+	if err := DeleteEntities(db, []string{"Alice", "Bob"}); err != nil {
+		t.Fatalf("DeleteEntities() failed: %v", err)
+	}
+
+	// Tombstones are hidden from every read
+	entities, relations, observations, err := ReadGraph(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Acme" || len(relations) != 0 || len(observations) != 0 {
+		t.Errorf("Expected only Acme after delete, got %+v %+v %+v", entities, relations, observations)
+	}
+	if found, _, _ := SearchNodes(db, "tea"); len(found) != 0 {
+		t.Errorf("Expected search to skip deleted entities, got %+v", found)
+	}
+	if found, _, _ := OpenNodes(db, []string{"Alice"}); len(found) != 0 {
+		t.Errorf("Expected open_nodes to skip deleted entities, got %+v", found)
+	}
+	if _, err := CreateObservation(db, "Alice", "Ghost"); err == nil {
+		t.Error("Expected adding an observation to a deleted entity to fail")
+	}
+
+	// Restoring one endpoint leaves relations to the other deleted
+	setClock(t, time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC))
+	restored, err := RestoreEntities(db, []string{"Alice", "Nobody"})
+	if err != nil {
+		t.Fatalf("RestoreEntities() failed: %v", err)
+	}
+	if len(restored) != 1 || restored[0] != "Alice" {
+		t.Errorf("Expected [Alice] restored, got %v", restored)
+	}
+	entities, relations, err = OpenNodes(db, []string{"Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || len(relations) != 1 || relations[0].To != "Acme" {
+		t.Errorf("Expected Alice with works_at only, got %+v %+v", entities, relations)
+	}
+	if entities[0].UpdatedAt != "2024-05-06T10:00:00.000Z" {
+		t.Errorf("Expected restore to update updated_at, got %s", entities[0].UpdatedAt)
+	}
+	entities, _, _, _ = ReadGraph(db)
+	for _, e := range entities {
+		if e.Name == "Alice" && (len(e.Observations) != 1 || e.Observations[0] != "Likes tea") {
+			t.Errorf("Expected only the observation deleted with Alice back, got %v", e.Observations)
+		}
+	}
+
+	// Restoring the other endpoint brings back the relations between them
+	if _, err := RestoreEntities(db, []string{"Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	_, relations, _, _ = ReadGraph(db)
+	if len(relations) != 3 {
+		t.Errorf("Expected all 3 relations back, got %+v", relations)
+	}
+
+	// Restoring a live entity is a no-op
+	if restored, _ := RestoreEntities(db, []string{"Alice"}); len(restored) != 0 {
+		t.Errorf("Expected nothing restored, got %v", restored)
+	}
+
+	history, _ := EntityHistory(db, "Alice")
+	last := history[len(history)-1]
+	if last.Operation != OpRestoreRelation {
+		t.Errorf("Expected restore to be recorded, got %+v", last)
+	}
+}
+
+func TestPurgeTombstones(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Acme", "company")
+	CreateObservation(db, "Alice", "Likes tea")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+
+	setClock(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if err := DeleteEntities(db, []string{"Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tombstones inside the window are kept
+	n, err := PurgeTombstones(context.Background(), db, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PurgeTombstones() failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected nothing purged, got %d", n)
+	}
+
+	n, err = PurgeTombstones(context.Background(), db, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PurgeTombstones() failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected entity, observation and relation purged, got %d", n)
+	}
+	var rows int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM entities) + (SELECT COUNT(*) FROM relations) + (SELECT COUNT(*) FROM observations)`).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("Expected only Acme left, got %d rows", rows)
+	}
+	if restored, _ := RestoreEntities(db, []string{"Alice"}); len(restored) != 0 {
+		t.Errorf("Expected purged entity not to be restorable, got %v", restored)
+	}
+
+	history, _ := EntityHistory(db, "Alice")
+	if last := history[len(history)-1]; last.Operation != OpPurgeEntity {
+		t.Errorf("Expected purge to be recorded, got %+v", last)
+	}
+}
+
+func TestCreateEntityOverTombstone(t *testing.T) {
+	db := setupTestDB(t)
+
+	CreateEntity(db, "Alice", "person")
+	CreateObservation(db, "Alice", "Likes tea")
+	if err := DeleteEntities(db, []string{"Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateEntity(db, "Alice", "robot"); err != nil {
+		t.Fatalf("CreateEntity() over a deleted entity failed: %v", err)
+	}
+	entities, _, observations, _ := ReadGraph(db)
+	if len(entities) != 1 || entities[0].Type != "robot" || len(observations) != 0 {
+		t.Errorf("Expected a fresh Alice, got %+v %+v", entities, observations)
+	}
+	if restored, _ := RestoreEntities(db, []string{"Alice"}); len(restored) != 0 {
+		t.Errorf("Expected the old Alice to be gone, got %v", restored)
+	}
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Traversal directions, relative to the entity being expanded.
+const (
+	DirectionOutgoing = "outgoing"
+	DirectionIncoming = "incoming"
+	DirectionBoth     = "both"
+)
+
+// DefaultMaxNodes caps a traversal when TraverseOptions.MaxNodes is not set.
+const DefaultMaxNodes = 1000
+
+// ErrInvalidTraversal is returned for traversal options that make no sense,
+// as opposed to a failure reading the graph.
+var ErrInvalidTraversal = errors.New("invalid traversal")
+
+// maxNamesPerQuery bounds the IN (...) lists built from entity names.
+const maxNamesPerQuery = 500
+
+// TraverseOptions controls Traverse.
+type TraverseOptions struct {
+	// Depth is the number of hops from the seeds; values below 1 mean 1.
+	Depth int
+	// RelationTypes restricts the relations that are followed and returned.
+	// Empty means all types.
+	RelationTypes []string
+	// Direction is one of DirectionOutgoing, DirectionIncoming or
+	// DirectionBoth (the default).
+	Direction string
+	// MaxNodes caps the number of entities in the result; values below 1
+	// mean DefaultMaxNodes.
+	MaxNodes int
+	// Progress, if set, is called after each hop with the number of hops
+	// done and the entities reached so far.
+	Progress func(hop, entities int)
+}
+
+// Subgraph is the result of a traversal.
+type Subgraph struct {
+	Entities  []Entity   `json:"entities"`
+	Relations []Relation `json:"relations"`
+	// Truncated is set when MaxNodes stopped the traversal early.
+	Truncated bool `json:"truncated"`
+}
+
+// Traverse returns the subgraph within opts.Depth hops of the seed entities.
+// Entities come in breadth-first order with their observations; relations are
+// all relations of the allowed types between returned entities. Seeds that
+// do not exist are ignored.
+func Traverse(db *sql.DB, seeds []string, opts TraverseOptions) (*Subgraph, error) {
+	return TraverseContext(context.Background(), db, seeds, opts)
+}
+
+// TraverseContext is Traverse, giving up with ctx's error once ctx is done.
+func TraverseContext(ctx context.Context, db *sql.DB, seeds []string, opts TraverseOptions) (*Subgraph, error) {
+	if opts.Depth < 1 {
+		opts.Depth = 1
+	}
+	if opts.MaxNodes < 1 {
+		opts.MaxNodes = DefaultMaxNodes
+	}
+	if opts.Direction == "" {
+		opts.Direction = DirectionBoth
+	}
+	if opts.Direction != DirectionOutgoing && opts.Direction != DirectionIncoming && opts.Direction != DirectionBoth {
+		return nil, fmt.Errorf("%w: direction %q (want outgoing, incoming or both)", ErrInvalidTraversal, opts.Direction)
+	}
+
+	result := &Subgraph{Entities: []Entity{}, Relations: []Relation{}}
+	if len(seeds) == 0 {
+		return result, nil
+	}
+
+	// Resolve seeds to existing entities, keeping the caller's order
+	existing, err := loadEntities(ctx, db, seeds)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	visited := make(map[string]bool)
+	var frontier []string
+	for _, name := range seeds {
+		if _, ok := existing[name]; !ok || visited[name] {
+			continue
+		}
+		if len(order) >= opts.MaxNodes {
+			result.Truncated = true
+			break
+		}
+		visited[name] = true
+		order = append(order, name)
+		frontier = append(frontier, name)
+	}
+
+	// Breadth-first expansion, one query per hop
+	for hop := 0; hop < opts.Depth && len(frontier) > 0 && !result.Truncated; hop++ {
+		relations, err := adjacentRelations(ctx, db, frontier, opts.Direction, opts.RelationTypes)
+		if err != nil {
+			return nil, err
+		}
+		inFrontier := make(map[string]bool, len(frontier))
+		for _, name := range frontier {
+			inFrontier[name] = true
+		}
+		var next []string
+		for _, r := range relations {
+			for _, neighbor := range neighborsOf(r, inFrontier, opts.Direction) {
+				if visited[neighbor] {
+					continue
+				}
+				if len(order) >= opts.MaxNodes {
+					result.Truncated = true
+					break
+				}
+				visited[neighbor] = true
+				order = append(order, neighbor)
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+		if opts.Progress != nil {
+			opts.Progress(hop+1, len(order))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(order) == 0 {
+		return result, nil
+	}
+
+	// Load entities with observations, in traversal order
+	entities, err := loadEntities(ctx, db, order)
+	if err != nil {
+		return nil, err
+	}
+	observations, err := observationsFor(ctx, db, order)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range order {
+		e := entities[name]
+		e.Observations = observations[name]
+		if e.Observations == nil {
+			e.Observations = []string{}
+		}
+		result.Entities = append(result.Entities, e)
+	}
+
+	// Relations of the allowed types inside the visited set
+	relations, err := adjacentRelations(ctx, db, order, DirectionOutgoing, opts.RelationTypes)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		if visited[r.To] {
+			result.Relations = append(result.Relations, r)
+		}
+	}
+
+	return result, nil
+}
+
+// neighborsOf returns the endpoints of r reached from the frontier when
+// walking in direction.
+func neighborsOf(r Relation, inFrontier map[string]bool, direction string) []string {
+	var out []string
+	if inFrontier[r.From] && direction != DirectionIncoming {
+		out = append(out, r.To)
+	}
+	if inFrontier[r.To] && direction != DirectionOutgoing {
+		out = append(out, r.From)
+	}
+	return out
+}
+
+// adjacentRelations returns relations leaving (outgoing), entering (incoming)
+// or touching (both) the named entities, optionally filtered by type. Names
+// are queried in chunks to stay below SQLite's bound parameter limit.
+func adjacentRelations(ctx context.Context, db *sql.DB, names []string, direction string, relationTypes []string) ([]Relation, error) {
+	var relations []Relation
+	seen := make(map[int64]bool)
+	for start := 0; start < len(names); start += maxNamesPerQuery {
+		end := start + maxNamesPerQuery
+		if end > len(names) {
+			end = len(names)
+		}
+		chunk, err := adjacentRelationsChunk(ctx, db, names[start:end], direction, relationTypes)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range chunk {
+			// with direction both, a relation can touch two chunks
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				relations = append(relations, r)
+			}
+		}
+	}
+	sort.Slice(relations, func(i, j int) bool { return relations[i].ID < relations[j].ID })
+	return relations, nil
+}
+
+func adjacentRelationsChunk(ctx context.Context, db *sql.DB, names []string, direction string, relationTypes []string) ([]Relation, error) {
+	nameList := placeholders(len(names))
+	var where string
+	var args []interface{}
+	switch direction {
+	case DirectionOutgoing:
+		where = fmt.Sprintf(`from_entity IN (%s)`, nameList)
+		args = stringArgs(names)
+	case DirectionIncoming:
+		where = fmt.Sprintf(`to_entity IN (%s)`, nameList)
+		args = stringArgs(names)
+	default:
+		where = fmt.Sprintf(`(from_entity IN (%s) OR to_entity IN (%s))`, nameList, nameList)
+		args = append(stringArgs(names), stringArgs(names)...)
+	}
+	if len(relationTypes) > 0 {
+		where += fmt.Sprintf(` AND relation_type IN (%s)`, placeholders(len(relationTypes)))
+		args = append(args, stringArgs(relationTypes)...)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT `+relationColumns+` FROM relations WHERE deleted_at IS NULL AND `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relations []Relation
+	for rows.Next() {
+		r, err := scanRelation(rows)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, r)
+	}
+	return relations, rows.Err()
+}
+
+// loadEntities maps each existing entity in names to its row, without
+// observations. Names are queried in chunks like adjacentRelations.
+func loadEntities(ctx context.Context, db *sql.DB, names []string) (map[string]Entity, error) {
+	entities := make(map[string]Entity)
+	for start := 0; start < len(names); start += maxNamesPerQuery {
+		end := start + maxNamesPerQuery
+		if end > len(names) {
+			end = len(names)
+		}
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT name, entity_type, %s FROM entities WHERE name IN (%s) AND deleted_at IS NULL`,
+			metaColumns(""), placeholders(end-start)), stringArgs(names[start:end])...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var e Entity
+			if err := rows.Scan(entityTargets(&e)...); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			entities[e.Name] = e
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entities, nil
+}
+
+// observationsFor maps each entity in names to its observation contents.
+func observationsFor(ctx context.Context, db *sql.DB, names []string) (map[string][]string, error) {
+	observations := make(map[string][]string)
+	for start := 0; start < len(names); start += maxNamesPerQuery {
+		end := start + maxNamesPerQuery
+		if end > len(names) {
+			end = len(names)
+		}
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT entity_name, content FROM observations WHERE entity_name IN (%s) AND deleted_at IS NULL ORDER BY id`,
+			placeholders(end-start)), stringArgs(names[start:end])...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name, content string
+			if err := rows.Scan(&name, &content); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			observations[name] = append(observations[name], content)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return observations, nil
+}
+
+// placeholders returns "?,?,...,?" with n markers.
+func placeholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
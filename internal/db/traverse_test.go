@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTraverse(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Alice -knows-> Bob -knows-> Carol -knows-> Dave, Alice -works_at-> Acme
+	CreateEntity(db, "Alice", "person")
+	CreateEntity(db, "Bob", "person")
+	CreateEntity(db, "Carol", "person")
+	CreateEntity(db, "Dave", "person")
+	CreateEntity(db, "Acme", "organization")
+	CreateEntity(db, "Loner", "person")
+	CreateRelation(db, "Alice", "Bob", "knows")
+	CreateRelation(db, "Bob", "Carol", "knows")
+	CreateRelation(db, "Carol", "Dave", "knows")
+	CreateRelation(db, "Alice", "Acme", "works_at")
+	CreateObservation(db, "Bob", "Bob plays chess")
+
+	tests := []struct {
+		name      string
+		seeds     []string
+		opts      TraverseOptions
+		want      []string
+		relations int
+		truncated bool
+	}{
+		{"default depth", []string{"Alice"}, TraverseOptions{}, []string{"Alice", "Bob", "Acme"}, 2, false},
+		{"two hops", []string{"Alice"}, TraverseOptions{Depth: 2}, []string{"Alice", "Bob", "Acme", "Carol"}, 3, false},
+		{"relation filter", []string{"Alice"}, TraverseOptions{Depth: 3, RelationTypes: []string{"works_at"}}, []string{"Alice", "Acme"}, 1, false},
+		{"outgoing", []string{"Carol"}, TraverseOptions{Depth: 5, Direction: DirectionOutgoing}, []string{"Carol", "Dave"}, 1, false},
+		{"incoming", []string{"Carol"}, TraverseOptions{Depth: 5, Direction: DirectionIncoming}, []string{"Carol", "Bob", "Alice"}, 2, false},
+		{"max nodes", []string{"Alice"}, TraverseOptions{Depth: 5, MaxNodes: 2}, []string{"Alice", "Bob"}, 1, true},
+		{"isolated seed", []string{"Loner"}, TraverseOptions{Depth: 3}, []string{"Loner"}, 0, false},
+		{"missing seed", []string{"Nobody"}, TraverseOptions{}, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subgraph, err := Traverse(db, tt.seeds, tt.opts)
+			if err != nil {
+				t.Fatalf("Traverse() failed: %v", err)
+			}
+			if len(subgraph.Entities) != len(tt.want) {
+				t.Fatalf("Expected entities %v, got %v", tt.want, subgraph.Entities)
+			}
+			for i, name := range tt.want {
+				if subgraph.Entities[i].Name != name {
+					t.Errorf("Entity %d: expected %s, got %s", i, name, subgraph.Entities[i].Name)
+				}
+			}
+			if len(subgraph.Relations) != tt.relations {
+				t.Errorf("Expected %d relations, got %v", tt.relations, subgraph.Relations)
+			}
+			if subgraph.Truncated != tt.truncated {
+				t.Errorf("Expected truncated=%v, got %v", tt.truncated, subgraph.Truncated)
+			}
+		})
+	}
+
+	subgraph, err := Traverse(db, []string{"Bob"}, TraverseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subgraph.Entities[0].Type != "person" || len(subgraph.Entities[0].Observations) != 1 {
+		t.Errorf("Expected Bob with type and observations, got %+v", subgraph.Entities[0])
+	}
+
+	if _, err := Traverse(db, []string{"Alice"}, TraverseOptions{Direction: "sideways"}); !errors.Is(err, ErrInvalidTraversal) {
+		t.Errorf("Expected ErrInvalidTraversal for invalid direction, got %v", err)
+	}
+
+	var hops []int
+	opts := TraverseOptions{Depth: 5, Progress: func(hop, entities int) { hops = append(hops, hop) }}
+	if _, err := TraverseContext(context.Background(), db, []string{"Alice"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 4 || hops[0] != 1 || hops[3] != 4 {
+		t.Errorf("Expected progress after hops 1 to 4, got %v", hops)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := TraverseContext(ctx, db, []string{"Alice"}, TraverseOptions{Depth: 3}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled traversal to fail with context.Canceled, got %v", err)
+	}
+}
+
+func TestTraverseLarge(t *testing.T) {
+	db := setupTestDB(t)
+
+	// More spokes than fit in one IN (...) list
+	spokes := 2*maxNamesPerQuery + 10
+	CreateEntity(db, "Hub", "place")
+	for i := 0; i < spokes; i++ {
+		name := fmt.Sprintf("Spoke %d", i)
+		CreateEntity(db, name, "place")
+		CreateObservation(db, name, "is a spoke")
+		CreateRelation(db, "Hub", name, "leads_to")
+	}
+
+	subgraph, err := Traverse(db, []string{"Hub"}, TraverseOptions{MaxNodes: spokes + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subgraph.Entities) != spokes+1 || len(subgraph.Relations) != spokes || subgraph.Truncated {
+		t.Fatalf("Expected the hub and %d spokes, got %d entities, %d relations", spokes, len(subgraph.Entities), len(subgraph.Relations))
+	}
+	for _, e := range subgraph.Entities[1:] {
+		if len(e.Observations) != 1 {
+			t.Fatalf("Expected every spoke with its observation, got %+v", e)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package logs carries the server's diagnostics as structured records.
+// Records at Info or above are written to the standard logger, as before;
+// subscribers, such as MCP sessions that asked for logs, get every record.
+package logs
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is a syslog severity, the scale MCP uses: Debug is the least
+// severe, Emergency the most.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Notice
+	Warning
+	Error
+	Critical
+	Alert
+	Emergency
+)
+
+var levelNames = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+func (l Level) String() string {
+	if l < Debug || l > Emergency {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given syslog name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// MarshalText encodes a level as its name.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level from its name.
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Record is one log entry. Logger names the part of the server it comes
+// from, such as "db", "mcp" or "sync".
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Logger  string
+	Message string
+	// Fields holds the details, if any
+	Fields map[string]interface{}
+}
+
+// String formats the record the way it is written to stderr.
+func (r Record) String() string {
+	var b strings.Builder
+	b.WriteString(r.Logger)
+	b.WriteString(": ")
+	b.WriteString(r.Message)
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, r.Fields[k])
+	}
+	return b.String()
+}
+
+// stderrLevel is the least severe level written to the standard logger.
+var stderrLevel = Info
+
+var (
+	mu          sync.Mutex
+	subscribers = make(map[int]func(Record))
+	nextID      int
+)
+
+// Subscribe calls fn with every record logged from now on, until the
+// returned func is called. fn is called on the goroutine that logs, so it
+// should not block, and must not log itself.
+func Subscribe(fn func(Record)) func() {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(subscribers, id)
+		})
+	}
+}
+
+// Log records message at level for logger, with optional fields.
+func Log(level Level, logger, message string, fields map[string]interface{}) {
+	r := Record{Time: time.Now(), Level: level, Logger: logger, Message: message, Fields: fields}
+	if level >= stderrLevel {
+		log.Print(r.String())
+	}
+	mu.Lock()
+	fns := make([]func(Record), 0, len(subscribers))
+	for _, fn := range subscribers {
+		fns = append(fns, fn)
+	}
+	mu.Unlock()
+	for _, fn := range fns {
+		fn(r)
+	}
+}
@@ -0,0 +1,49 @@
+package logs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	for _, name := range levelNames {
+		level, err := ParseLevel(name)
+		if err != nil || level.String() != name {
+			t.Errorf("%s: got %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if Debug >= Info || Error >= Emergency {
+		t.Error("Expected levels to order by severity")
+	}
+
+	data, _ := json.Marshal(map[string]Level{"level": Warning})
+	if string(data) != `{"level":"warning"}` {
+		t.Errorf("Expected levels to encode as names, got %s", data)
+	}
+	var decoded struct{ Level Level }
+	if err := json.Unmarshal([]byte(`{"level":"critical"}`), &decoded); err != nil || decoded.Level != Critical {
+		t.Errorf("Expected to decode critical, got %v, %v", decoded.Level, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var got []Record
+	unsubscribe := Subscribe(func(r Record) { got = append(got, r) })
+	Log(Debug, "test", "first", map[string]interface{}{"n": 1})
+	unsubscribe()
+	unsubscribe()
+	Log(Info, "test", "second", nil)
+
+	if len(got) != 1 {
+		t.Fatalf("Expected one record before unsubscribing, got %+v", got)
+	}
+	if r := got[0]; r.Level != Debug || r.Logger != "test" || r.Message != "first" || r.Time.IsZero() {
+		t.Errorf("Unexpected record %+v", r)
+	}
+	if s := got[0].String(); s != "test: first n=1" {
+		t.Errorf("Unexpected formatting %q", s)
+	}
+}
@@ -0,0 +1,88 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// JSON-RPC batches: an array of requests, notifications and responses sent
+// as one message, answered with an array of the responses to its requests.
+
+// IsBatch reports whether a JSON-RPC message is a batch.
+func IsBatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// HandleBatchContext handles a JSON-RPC batch and returns what to answer
+// with: the responses to its requests as a []JSONRPCResponse, a single
+// JSONRPCResponse if the batch itself is invalid, or nil if the batch held
+// only notifications and responses.
+func HandleBatchContext(ctx context.Context, database *sql.DB, data []byte) interface{} {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32700, Message: "Parse error"}}
+	}
+	if len(batch) == 0 {
+		return JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32600, Message: "Invalid Request: empty batch"}}
+	}
+	if responses := handleBatch(ctx, database, batch); len(responses) > 0 {
+		return responses
+	}
+	return nil
+}
+
+// handleBatch handles the messages of a batch in order and returns the
+// responses to its requests.
+func handleBatch(ctx context.Context, database *sql.DB, batch []json.RawMessage) []JSONRPCResponse {
+	session := sessionFrom(ctx)
+	var responses []JSONRPCResponse
+	for _, data := range batch {
+		if session.HandleResponse(data) {
+			continue
+		}
+		var req JSONRPCRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.JSONRPC != "2.0" {
+			responses = append(responses, JSONRPCResponse{
+				JSONRPC: "2.0",
+				Error:   &JSONRPCError{Code: -32600, Message: "Invalid Request"},
+			})
+			continue
+		}
+
+		var response JSONRPCResponse
+		if req.Method == "initialize" {
+			response = JSONRPCResponse{
+				JSONRPC: "2.0",
+				ID:      req.ID,
+				Error:   &JSONRPCError{Code: -32600, Message: "Invalid Request: initialize cannot be part of a batch"},
+			}
+		} else {
+			response = HandleJSONRPCMethodContext(ctx, database, req)
+		}
+		if req.ID != nil {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// batchHasRequests reports whether anything in batch is answered: its
+// requests, and messages that are neither requests, notifications nor
+// responses.
+func batchHasRequests(batch []json.RawMessage) bool {
+	for _, data := range batch {
+		var msg clientResponse
+		if json.Unmarshal(data, &msg) != nil {
+			return true
+		}
+		notification := msg.Method != "" && msg.ID == nil
+		response := msg.Method == "" && msg.ID != nil && (msg.Result != nil || msg.Error != nil)
+		if !notification && !response {
+			return true
+		}
+	}
+	return false
+}
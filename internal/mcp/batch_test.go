@@ -0,0 +1,83 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	database := setupTestDB(t)
+	ctx := context.Background()
+
+	batch := `[
+		{"jsonrpc":"2.0","id":1,"method":"ping"},
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"create_entities","arguments":{"entities":[{"name":"Alice","entityType":"person"}]}}},
+		{"jsonrpc":"1.0","id":3,"method":"ping"},
+		{"jsonrpc":"2.0","id":4,"method":"initialize","params":{"protocolVersion":"2025-06-18"}},
+		{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"open_nodes","arguments":{"names":["Alice"]}}}
+	]`
+	responses, ok := HandleBatchContext(ctx, database, []byte(batch)).([]JSONRPCResponse)
+	if !ok || len(responses) != 5 {
+		t.Fatalf("Expected 5 responses, got %+v", responses)
+	}
+	if responses[0].ID != 1.0 || responses[0].Error != nil {
+		t.Errorf("Expected ping to succeed, got %+v", responses[0])
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != -32600 {
+		t.Errorf("Expected an invalid request error, got %+v", responses[2])
+	}
+	if responses[3].ID != 4.0 || responses[3].Error == nil {
+		t.Errorf("Expected initialize to be refused in a batch, got %+v", responses[3])
+	}
+	// Messages are handled in order
+	if result := responses[4].Result.(ToolCallResult); result.IsError || !strings.Contains(result.Content[0].Text, `"name":"Alice"`) {
+		t.Errorf("Expected to open the entity created earlier in the batch, got %+v", result)
+	}
+
+	invalid := map[string]int{
+		`[]`:       -32600,
+		`[{"jsonr`: -32700,
+	}
+	for data, code := range invalid {
+		response, ok := HandleBatchContext(ctx, database, []byte(data)).(JSONRPCResponse)
+		if !ok || response.Error == nil || response.Error.Code != code {
+			t.Errorf("%s: expected error %d, got %+v", data, code, response)
+		}
+	}
+
+	if response := HandleBatchContext(ctx, database, []byte(`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`)); response != nil {
+		t.Errorf("Expected no answer to a batch of notifications, got %+v", response)
+	}
+}
+
+func TestStreamableBatch(t *testing.T) {
+	server := httptest.NewServer(newStreamableServer(setupTestDB(t)))
+	defer server.Close()
+	both := "application/json, text/event-stream"
+
+	resp := post(t, server.URL, "", both, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"test"}}}`)
+	session := resp.Header.Get(SessionHeader)
+	if resp := post(t, server.URL, session, both, `[{"jsonrpc":"2.0","method":"notifications/initialized"}]`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected 202 for a batch of notifications, got %d", resp.StatusCode)
+	}
+
+	resp = post(t, server.URL, session, "application/json", `[{"jsonrpc":"2.0","id":2,"method":"ping"},{"jsonrpc":"2.0","id":3,"method":"tools/list"}]`)
+	body, _ := io.ReadAll(resp.Body)
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(body, &responses); err != nil || len(responses) != 2 {
+		t.Fatalf("Expected an array of 2 responses, got %d %s", resp.StatusCode, body)
+	}
+	if responses[0].ID != 2.0 || responses[1].ID != 3.0 || responses[1].Error != nil {
+		t.Errorf("Expected answers to ping and tools/list, got %s", body)
+	}
+
+	if resp := post(t, server.URL, session, both, `[]`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty batch, got %d", resp.StatusCode)
+	}
+}
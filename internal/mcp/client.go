@@ -0,0 +1,352 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The client side of MCP, over the transports this server speaks: Dial
+// connects to a server at a Streamable HTTP endpoint or runs one as a
+// subprocess speaking stdio, and initializes the session; Call and
+// CallTool then send it requests.
+
+// clientCloseTimeout is how long Close waits for a stdio server to exit
+// after its stdin is closed before killing it.
+const clientCloseTimeout = 5 * time.Second
+
+var errClientClosed = errors.New("mcp client closed")
+
+// Error makes JSON-RPC errors from a server usable as Go errors.
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// ClientOptions are what a client tells the server about itself, and how
+// it takes the notifications the server sends.
+type ClientOptions struct {
+	ClientInfo   ClientInfo
+	Capabilities ClientCapabilities
+	// OnNotification, if set, is called with every notification from the
+	// server, one at a time.
+	OnNotification func(method string, params json.RawMessage)
+}
+
+// Client is an initialized MCP session with a server. It is safe for
+// concurrent use. Requests the server sends are answered with "method not
+// found", except ping.
+type Client struct {
+	opts ClientOptions
+	// send delivers a message to the server; what the server sends back
+	// goes to receive
+	send func(ctx context.Context, data []byte) error
+	// stop ends the transport
+	stop func() error
+
+	mu      sync.Mutex
+	lastID  int64
+	pending map[string]chan clientResponse
+	server  InitializeResult
+	closed  bool
+	done    chan struct{}
+	// notifyMu hands notifications to OnNotification one at a time
+	notifyMu sync.Mutex
+}
+
+func newClient(opts ClientOptions) *Client {
+	if opts.ClientInfo.Name == "" {
+		opts.ClientInfo = ClientInfo{Name: "knowledge-graph-client", Version: "1.0.0"}
+	}
+	return &Client{opts: opts, pending: make(map[string]chan clientResponse), done: make(chan struct{})}
+}
+
+// Dial connects to the MCP server at target: a Streamable HTTP endpoint
+// if target is an http(s) URL, otherwise a command line to run and speak
+// stdio with, such as "knowledge-graph --connect auto --db-path team.db".
+func Dial(ctx context.Context, target string, opts ClientOptions) (*Client, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		endpoint, err := EndpointURL(target)
+		if err != nil {
+			return nil, err
+		}
+		return DialHTTP(ctx, endpoint, opts)
+	}
+	command := strings.Fields(target)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty MCP server command")
+	}
+	return DialStdio(ctx, command[0], command[1:], opts)
+}
+
+// EndpointURL checks that s is an http(s) URL and adds the /mcp path
+// this server uses if s has none.
+func EndpointURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("not an http(s) URL: %q", s)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/mcp"
+	}
+	return u.String(), nil
+}
+
+// DialHTTP connects to the Streamable HTTP endpoint at url, such as
+// http://127.0.0.1:8080/mcp. Messages the server starts are taken from
+// the session's GET stream.
+func DialHTTP(ctx context.Context, url string, opts ClientOptions) (*Client, error) {
+	conn := &streamableConn{url: url, client: &http.Client{}}
+	c := newClient(opts)
+	c.send = func(ctx context.Context, data []byte) error {
+		return conn.post(ctx, data, c.receive)
+	}
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	c.stop = func() error {
+		stopListening()
+		conn.close()
+		return nil
+	}
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	go conn.listen(listenCtx, c.receive)
+	return c, nil
+}
+
+// DialStdio runs command with args and speaks MCP with it on its stdin and
+// stdout. Its stderr goes to ours. Close ends it.
+func DialStdio(ctx context.Context, command string, args []string, opts ClientOptions) (*Client, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	return connectStdio(ctx, stdout, stdin, opts, func() error {
+		select {
+		case <-exited:
+		case <-time.After(clientCloseTimeout):
+			cmd.Process.Kill()
+			<-exited
+		}
+		return nil
+	})
+}
+
+// ConnectStdio speaks MCP with a server reading w and writing r, one
+// message per line, such as a server started some other way. Close closes
+// w.
+func ConnectStdio(ctx context.Context, r io.Reader, w io.WriteCloser, opts ClientOptions) (*Client, error) {
+	return connectStdio(ctx, r, w, opts, nil)
+}
+
+func connectStdio(ctx context.Context, r io.Reader, w io.WriteCloser, opts ClientOptions, wait func() error) (*Client, error) {
+	c := newClient(opts)
+	out := &lineWriter{w: w}
+	c.send = func(ctx context.Context, data []byte) error {
+		return out.write(data)
+	}
+	c.stop = func() error {
+		err := w.Close()
+		if wait != nil {
+			wait()
+		}
+		return err
+	}
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readLines(r, lines, c.done)
+	}()
+	go func() {
+		for {
+			select {
+			case line := <-lines:
+				c.receive(line)
+			case <-readErr:
+				// The server going away ends the session
+				c.Close()
+				return
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// initialize agrees on a protocol version with the server.
+func (c *Client) initialize(ctx context.Context) error {
+	var result InitializeResult
+	err := c.Call(ctx, "initialize", InitializeRequest{
+		ProtocolVersion: LatestProtocolVersion,
+		Capabilities:    c.opts.Capabilities,
+		ClientInfo:      c.opts.ClientInfo,
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("initialize: %w", err)
+	}
+	if !supportedProtocolVersion(result.ProtocolVersion) {
+		return fmt.Errorf("initialize: unsupported protocol version %q", result.ProtocolVersion)
+	}
+	c.mu.Lock()
+	c.server = result
+	c.mu.Unlock()
+	return c.Notify(ctx, "notifications/initialized", nil)
+}
+
+// Server returns what the server said about itself at initialize.
+func (c *Client) Server() InitializeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.server
+}
+
+// Call sends a request and decodes its result into result, which may be
+// nil. A JSON-RPC error from the server is returned as a *JSONRPCError.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errClientClosed
+	}
+	c.lastID++
+	id := c.lastID
+	key := requestKey(id)
+	reply := make(chan clientResponse, 1)
+	c.pending[key] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if err := c.send(ctx, data); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-reply:
+		if response.Error != nil {
+			return response.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(response.Result, result)
+	case <-c.done:
+		return errClientClosed
+	case <-ctx.Done():
+		c.Notify(context.Background(), "notifications/cancelled", map[string]interface{}{"requestId": id, "reason": ctx.Err().Error()})
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	data, err := json.Marshal(JSONRPCNotification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, data)
+}
+
+// ListTools returns the tools the server offers.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var result ToolsListResult
+	if err := c.Call(ctx, "tools/list", map[string]interface{}{}, &result); err != nil {
+		return nil, err
+	}
+	return result.Tools, nil
+}
+
+// CallTool calls a tool. A tool that fails returns a result with IsError
+// set, not an error.
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]interface{}) (ToolCallResult, error) {
+	var result ToolCallResult
+	err := c.Call(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": arguments}, &result)
+	return result, err
+}
+
+// Close ends the session and the transport.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+	return c.stop()
+}
+
+// receive handles a message from the server: a response goes to the call
+// waiting for it, a notification to OnNotification, and a request is
+// answered.
+func (c *Client) receive(data []byte) {
+	var msg struct {
+		clientResponse
+		Params json.RawMessage `json:"params"`
+	}
+	if json.Unmarshal(data, &msg) != nil {
+		return
+	}
+	switch {
+	case msg.Method == "" && msg.ID != nil:
+		c.mu.Lock()
+		reply := c.pending[requestKey(msg.ID)]
+		c.mu.Unlock()
+		if reply != nil {
+			select {
+			case reply <- msg.clientResponse:
+			default:
+			}
+		}
+	case msg.Method != "" && msg.ID != nil:
+		response := JSONRPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: map[string]interface{}{}}
+		if msg.Method != "ping" {
+			response = JSONRPCResponse{JSONRPC: "2.0", ID: msg.ID, Error: &JSONRPCError{Code: -32601, Message: "Method not found: " + msg.Method}}
+		}
+		if data, err := json.Marshal(response); err == nil {
+			c.send(context.Background(), data)
+		}
+	case msg.Method != "" && c.opts.OnNotification != nil:
+		c.notifyMu.Lock()
+		defer c.notifyMu.Unlock()
+		c.opts.OnNotification(msg.Method, msg.Params)
+	}
+}
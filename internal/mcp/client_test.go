@@ -0,0 +1,103 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+func TestDialHTTP(t *testing.T) {
+	database := setupTestDB(t)
+	ts := httptest.NewServer(newStreamableServer(database))
+	defer ts.Close()
+
+	// The endpoint path is added to a bare server URL
+	client, err := Dial(context.Background(), ts.URL, ClientOptions{ClientInfo: ClientInfo{Name: "test-client", Version: "1.0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if server := client.Server(); server.ProtocolVersion != LatestProtocolVersion || server.ServerInfo.Name != "knowledge-graph-mcp" {
+		t.Errorf("Unexpected initialize result %+v", server)
+	}
+
+	tools, err := client.ListTools(context.Background())
+	if err != nil || len(tools) == 0 {
+		t.Fatalf("Expected tools, got %d, %v", len(tools), err)
+	}
+	result, err := client.CallTool(context.Background(), "create_entities", map[string]interface{}{
+		"entities": []interface{}{map[string]interface{}{"name": "Alice", "entityType": "person"}},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("Expected create_entities to succeed, got %+v, %v", result, err)
+	}
+	if changes, _ := db.EntityHistory(database, "Alice"); len(changes) != 1 || changes[0].Source != "test-client" {
+		t.Errorf("Expected Alice created by test-client, got %+v", changes)
+	}
+
+	var rpcErr *JSONRPCError
+	if err := client.Call(context.Background(), "no/such/method", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != -32601 {
+		t.Errorf("Expected method not found, got %v", err)
+	}
+
+	client.Close()
+	if err := client.Call(context.Background(), "ping", nil, nil); err != errClientClosed {
+		t.Errorf("Expected calls on a closed client to fail, got %v", err)
+	}
+}
+
+func TestConnectStdio(t *testing.T) {
+	database := setupTestDB(t)
+	toServerR, toServerW := io.Pipe()
+	fromServerR, fromServerW := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeStdio(context.Background(), database, toServerR, fromServerW)
+		fromServerW.Close()
+	}()
+
+	notifications := make(chan string, 10)
+	client, err := ConnectStdio(context.Background(), fromServerR, toServerW, ClientOptions{
+		OnNotification: func(method string, params json.RawMessage) {
+			notifications <- method
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(context.Background(), "ping", nil, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	result, err := client.CallTool(context.Background(), "create_entities", map[string]interface{}{
+		"entities": []interface{}{map[string]interface{}{"name": "Alice", "entityType": "person"}},
+	})
+	if err != nil || result.IsError || !strings.Contains(result.Content[0].Text, "Alice") {
+		t.Fatalf("Expected create_entities to succeed, got %+v, %v", result, err)
+	}
+	select {
+	case method := <-notifications:
+		if method != "notifications/resources/list_changed" {
+			t.Errorf("Expected the list to change, got %s", method)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected a notification after creating an entity")
+	}
+
+	// Closing the client ends the server's input
+	client.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected ServeStdio to end cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeStdio did not end when the client closed")
+	}
+}
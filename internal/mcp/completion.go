@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"gnolledgegraph/internal/db"
+)
+
+// Completion. completion/complete suggests values for a prompt argument or
+// a resource template variable while the user types it. Arguments are
+// completed by name: entity names, entity types and relation types come
+// from the graph, most used first.
+
+// maxCompletions is the most values one completion may return.
+const maxCompletions = 100
+
+type CompleteResult struct {
+	Completion Completion `json:"completion"`
+}
+
+type Completion struct {
+	Values  []string `json:"values"`
+	Total   int      `json:"total,omitempty"`
+	HasMore bool     `json:"hasMore,omitempty"`
+}
+
+// completedArguments maps argument names to the kind of db.Complete
+// values they take.
+var completedArguments = map[string]string{
+	"name":         db.CompleteEntityNames,
+	"entity":       db.CompleteEntityNames,
+	"entityName":   db.CompleteEntityNames,
+	"from":         db.CompleteEntityNames,
+	"to":           db.CompleteEntityNames,
+	"type":         db.CompleteEntityTypes,
+	"entityType":   db.CompleteEntityTypes,
+	"relationType": db.CompleteRelationTypes,
+}
+
+// resourceFormats are the values of the format template variable.
+var resourceFormats = []string{"json", "markdown"}
+
+func handleComplete(ctx context.Context, database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		Ref struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+			URI  string `json:"uri"`
+		} `json:"ref"`
+		Argument struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"argument"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+	if params.Argument.Name == "" {
+		return invalidParams(req, fmt.Errorf("missing argument name"))
+	}
+
+	var arguments []string
+	switch params.Ref.Type {
+	case "ref/prompt":
+		prompt := findPrompt(params.Ref.Name)
+		if prompt == nil {
+			return invalidParams(req, fmt.Errorf("unknown prompt %q", params.Ref.Name))
+		}
+		for _, a := range prompt.Arguments {
+			arguments = append(arguments, a.Name)
+		}
+	case "ref/resource":
+		template := findResourceTemplate(params.Ref.URI)
+		if template == nil {
+			return invalidParams(req, fmt.Errorf("unknown resource template %q", params.Ref.URI))
+		}
+		arguments = templateVariables(template.URITemplate)
+	default:
+		return invalidParams(req, fmt.Errorf("unknown reference type %q", params.Ref.Type))
+	}
+
+	completion := Completion{Values: []string{}}
+	name, value := params.Argument.Name, params.Argument.Value
+	for _, a := range arguments {
+		if a != name {
+			continue
+		}
+		if kind, ok := completedArguments[name]; ok {
+			values, total, err := db.Complete(ctx, database, kind, value, maxCompletions)
+			if err != nil {
+				return internalError(req, err)
+			}
+			completion = Completion{Values: values, Total: total, HasMore: total > len(values)}
+		} else if name == "format" && params.Ref.Type == "ref/resource" {
+			for _, f := range resourceFormats {
+				if strings.HasPrefix(f, strings.ToLower(value)) {
+					completion.Values = append(completion.Values, f)
+				}
+			}
+			completion.Total = len(completion.Values)
+		}
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  CompleteResult{Completion: completion},
+	}
+}
+
+// findResourceTemplate returns the resource template with the given URI
+// template, which clients may send with or without its query part.
+func findResourceTemplate(uri string) *ResourceTemplate {
+	for _, t := range resourceTemplates {
+		if t.URITemplate == uri || strings.HasPrefix(t.URITemplate, uri+"{?") {
+			return &t
+		}
+	}
+	return nil
+}
+
+// templateVariables returns the names of the variables in an RFC 6570
+// URI template, such as name and format in kg://entity/{name}{?format}.
+func templateVariables(template string) []string {
+	var names []string
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			return names
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return names
+		}
+		expr := strings.TrimLeft(template[start+1:start+end], "+#./;?&")
+		names = append(names, strings.Split(expr, ",")...)
+		template = template[start+end+1:]
+	}
+}
@@ -0,0 +1,78 @@
+package mcp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+func TestComplete(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Acme Corp", "organization")
+	db.CreateEntity(database, "Acme Labs", "organization")
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateRelation(database, "Alice", "Acme Labs", "works_at")
+
+	complete := func(ref map[string]interface{}, argument, value string) JSONRPCResponse {
+		return HandleJSONRPCMethodContext(context.Background(), database, JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "completion/complete",
+			Params: map[string]interface{}{
+				"ref":      ref,
+				"argument": map[string]interface{}{"name": argument, "value": value},
+			},
+		})
+	}
+	prompt := map[string]interface{}{"type": "ref/prompt", "name": "entity_briefing"}
+	entity := map[string]interface{}{"type": "ref/resource", "uri": "kg://entity/{name}{?format}"}
+	byType := map[string]interface{}{"type": "ref/resource", "uri": "kg://type/{entityType}"}
+
+	tests := []struct {
+		name     string
+		ref      map[string]interface{}
+		argument string
+		value    string
+		want     []string
+	}{
+		{"prompt argument", prompt, "name", "ac", []string{"Acme Labs", "Acme Corp", "Alice"}},
+		{"argument without completions", prompt, "depth", "", []string{}},
+		{"entity template", entity, "name", "al", []string{"Alice", "Acme Labs"}},
+		{"format", entity, "format", "m", []string{"markdown"}},
+		{"type template without query", byType, "entityType", "", []string{"organization", "person"}},
+		{"variable of another template", byType, "name", "a", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := complete(tt.ref, tt.argument, tt.value)
+			if response.Error != nil {
+				t.Fatalf("completion/complete failed: %+v", response.Error)
+			}
+			if got := response.Result.(CompleteResult).Completion.Values; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if completion := complete(prompt, "name", "").Result.(CompleteResult).Completion; completion.Total != 3 || completion.HasMore {
+		t.Errorf("Expected all 3 names, got %+v", completion)
+	}
+	for _, ref := range []map[string]interface{}{
+		{"type": "ref/prompt", "name": "nope"},
+		{"type": "ref/resource", "uri": "kg://colors/{name}"},
+		{"type": "ref/tool", "name": "read_graph"},
+	} {
+		if response := complete(ref, "name", ""); response.Error == nil || response.Error.Code != -32602 {
+			t.Errorf("%v: expected invalid params, got %+v", ref, response)
+		}
+	}
+
+	// Completions are declared from 2025-03-26 on
+	for version, declared := range map[string]bool{ProtocolVersion20250618: true, ProtocolVersion20250326: true, ProtocolVersion20241105: false} {
+		if got := serverCapabilities(version).Completions != nil; got != declared {
+			t.Errorf("%s: expected completions declared %v", version, declared)
+		}
+	}
+}
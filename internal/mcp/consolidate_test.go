@@ -0,0 +1,122 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+// samplingClient returns an initialized session whose client supports
+// sampling and answers every sampling/createMessage with text, recording
+// the requests it was sent.
+func samplingClient(t *testing.T, text string) (*Session, *[]CreateMessageRequest) {
+	var asked []CreateMessageRequest
+	var session *Session
+	session = NewSession(func(msg interface{}) error {
+		req, ok := msg.(JSONRPCRequest)
+		if !ok || req.Method != "sampling/createMessage" {
+			return nil
+		}
+		asked = append(asked, req.Params.(CreateMessageRequest))
+		result := CreateMessageResult{Role: "assistant", Content: ToolContent{Type: "text", Text: text}, Model: "test-model"}
+		data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+		go session.HandleResponse(data)
+		return nil
+	})
+	session.start(InitializeRequest{
+		ProtocolVersion: ProtocolVersion20250618,
+		Capabilities:    ClientCapabilities{Sampling: &struct{}{}},
+	})
+	session.initialized = true
+	t.Cleanup(session.Close)
+	return session, &asked
+}
+
+func consolidated(t *testing.T, result ToolCallResult) map[string]interface{} {
+	t.Helper()
+	if result.IsError {
+		t.Fatalf("consolidate_entity failed: %s", result.Content[0].Text)
+	}
+	data, _ := json.Marshal(result.StructuredContent)
+	var structured map[string]interface{}
+	json.Unmarshal(data, &structured)
+	return structured
+}
+
+func TestConsolidateEntity(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	for _, o := range []string{"Likes tea", "likes tea.", "Works at Acme", "likes tea with milk", "Lives in Paris"} {
+		if _, err := db.CreateObservation(database, "Alice", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents := func() []string {
+		observations, err := db.EntityObservationsContext(context.Background(), database, "Alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contents []string
+		for _, o := range observations {
+			contents = append(contents, o.Content)
+		}
+		return contents
+	}
+
+	// Without sampling, duplicates are merged
+	structured := consolidated(t, callTool(context.Background(), database, "consolidate_entity", map[string]interface{}{"name": "Alice"}))
+	if structured["method"] != "merge" || len(structured["removed"].([]interface{})) != 2 {
+		t.Errorf("Expected a merge removing two observations, got %v", structured)
+	}
+	if got, want := contents(), []string{"Works at Acme", "likes tea with milk", "Lives in Paris"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q to remain, got %q", want, got)
+	}
+
+	// With sampling, the client's model writes the observations
+	session, asked := samplingClient(t, "Here you go:\n```json\n[\"Works at Acme\", \"Lives in Paris, drinks tea with milk\"]\n```")
+	ctx := WithSession(context.Background(), session)
+	structured = consolidated(t, callTool(ctx, database, "consolidate_entity", map[string]interface{}{"name": "Alice"}))
+	if structured["method"] != "sampling" || structured["model"] != "test-model" {
+		t.Errorf("Expected the client's model to consolidate, got %v", structured)
+	}
+	if len(*asked) != 1 || !strings.Contains((*asked)[0].Messages[0].Content.Text, "likes tea with milk") {
+		t.Errorf("Expected the observations to be sent to the client, got %+v", *asked)
+	}
+	if got := contents(); !reflect.DeepEqual(got, []string{"Works at Acme", "Lives in Paris, drinks tea with milk"}) {
+		t.Errorf("Expected the sampled observations, got %q", got)
+	}
+	history, err := db.EntityHistory(database, "Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := 0
+	for _, c := range history {
+		if c.Operation == db.OpDeleteObservation {
+			deleted++
+		}
+	}
+	if deleted != 4 {
+		t.Errorf("Expected the 4 replaced observations in the history, got %d", deleted)
+	}
+
+	// An answer that is not a list falls back to the merge
+	db.CreateObservation(database, "Alice", "works at Acme")
+	session, _ = samplingClient(t, "I cannot help with that.")
+	ctx = WithSession(context.Background(), session)
+	structured = consolidated(t, callTool(ctx, database, "consolidate_entity", map[string]interface{}{"name": "Alice"}))
+	if structured["method"] != "merge" || structured["samplingError"] == nil {
+		t.Errorf("Expected a merge after a bad answer, got %v", structured)
+	}
+	if got := contents(); !reflect.DeepEqual(got, []string{"Works at Acme", "Lives in Paris, drinks tea with milk"}) {
+		t.Errorf("Expected the duplicate merged, got %q", got)
+	}
+
+	result := callTool(context.Background(), database, "consolidate_entity", map[string]interface{}{"name": "Nobody"})
+	if !result.IsError {
+		t.Errorf("Expected an error for a missing entity, got %+v", result)
+	}
+}
@@ -0,0 +1,161 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+// Confirming destructive tool calls. When the client can ask its user for
+// input (elicitation, protocol 2025-06-18), a delete that would remove more
+// than the confirm threshold of entities, observations and relations is
+// previewed to the user first, and only goes ahead once they agree.
+
+// DefaultConfirmThreshold is how many rows a delete may remove before the
+// user is asked.
+const DefaultConfirmThreshold = 10
+
+// elicitationTimeout bounds how long a tool call waits for the user.
+var elicitationTimeout = 10 * time.Minute
+
+// previewItems is how many entities and relations a confirmation lists.
+const previewItems = 10
+
+var (
+	confirmThresholdMu sync.RWMutex
+	confirmThreshold   = DefaultConfirmThreshold
+)
+
+// SetConfirmThreshold sets how many rows a delete may remove before the
+// user is asked to confirm it. 0 asks for every delete; a negative value
+// never asks.
+func SetConfirmThreshold(n int) {
+	confirmThresholdMu.Lock()
+	defer confirmThresholdMu.Unlock()
+	confirmThreshold = n
+}
+
+// ElicitRequest is the params of elicitation/create. The requested schema
+// is a flat object of primitive properties.
+type ElicitRequest struct {
+	Message         string      `json:"message"`
+	RequestedSchema InputSchema `json:"requestedSchema"`
+}
+
+// ElicitResult is the client's answer: Action is "accept", "decline" or
+// "cancel", and Content holds the user's input when accepted.
+type ElicitResult struct {
+	Action  string                 `json:"action"`
+	Content map[string]interface{} `json:"content,omitempty"`
+}
+
+var errDeclined = errors.New("the user did not confirm the delete")
+
+// canElicit reports whether the client of s can be asked for user input.
+func (s *Session) canElicit() bool {
+	return s != nil && s.send != nil && s.supports(ProtocolVersion20250618) && s.ClientCapabilities().Elicitation != nil
+}
+
+// confirmDelete asks the user to confirm the delete that preview describes
+// and returns nil if it may go ahead. Without elicitation, or for deletes
+// under the threshold, it does not ask; preview is only called when it
+// does.
+func confirmDelete(ctx context.Context, preview func() (*db.DeletePreview, error)) error {
+	session := sessionFrom(ctx)
+	confirmThresholdMu.RLock()
+	threshold := confirmThreshold
+	confirmThresholdMu.RUnlock()
+	if threshold < 0 || !session.canElicit() {
+		return nil
+	}
+
+	p, err := preview()
+	if err != nil {
+		return err
+	}
+	if p.Size() == 0 || p.Size() <= threshold {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, elicitationTimeout)
+	defer cancel()
+	data, err := session.request(ctx, "elicitation/create", ElicitRequest{
+		Message: deleteMessage(p),
+		RequestedSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"confirm": {Type: "boolean", Description: "Delete these items"},
+			},
+			Required: []string{"confirm"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not ask the user to confirm the delete, nothing was deleted: %v", err)
+	}
+	var result ElicitResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("invalid elicitation result: %v", err)
+	}
+	if result.Action != "accept" || result.Content["confirm"] != true {
+		return fmt.Errorf("%w (%s), nothing was deleted", errDeclined, result.Action)
+	}
+	return nil
+}
+
+// deleteMessage asks about a delete, listing what it removes, e.g.
+// "Delete 14 entities, 30 observations and 52 relations?".
+func deleteMessage(p *db.DeletePreview) string {
+	var counts []string
+	for _, c := range []struct {
+		n              int
+		singular, many string
+	}{
+		{len(p.Entities), "entity", "entities"},
+		{p.Observations, "observation", "observations"},
+		{len(p.Relations), "relation", "relations"},
+	} {
+		switch {
+		case c.n == 1:
+			counts = append(counts, "1 "+c.singular)
+		case c.n > 1:
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.many))
+		}
+	}
+	question := counts[len(counts)-1]
+	if len(counts) > 1 {
+		question = strings.Join(counts[:len(counts)-1], ", ") + " and " + question
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Delete %s?\n", question)
+	if len(p.Entities) > 0 {
+		b.WriteString("\nEntities:\n")
+		for i, name := range p.Entities {
+			if i == previewItems {
+				fmt.Fprintf(&b, "- and %d more\n", len(p.Entities)-previewItems)
+				break
+			}
+			fmt.Fprintf(&b, "- %s\n", name)
+		}
+	}
+	if len(p.Relations) > 0 {
+		b.WriteString("\nRelations:\n")
+		for i, r := range p.Relations {
+			if i == previewItems {
+				fmt.Fprintf(&b, "- and %d more\n", len(p.Relations)-previewItems)
+				break
+			}
+			fmt.Fprintf(&b, "- %s -[%s]-> %s\n", r.From, r.Type, r.To)
+		}
+	}
+	if len(p.Entities) > 0 {
+		b.WriteString("\nDeleted entities can be restored until they are purged.\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+// elicitingClient returns an initialized session whose client supports
+// elicitation and answers every elicitation/create with answer, recording
+// the messages it was asked.
+func elicitingClient(t *testing.T, answer ElicitResult) (*Session, *[]string) {
+	var asked []string
+	var session *Session
+	session = NewSession(func(msg interface{}) error {
+		req, ok := msg.(JSONRPCRequest)
+		if !ok || req.Method != "elicitation/create" {
+			return nil
+		}
+		asked = append(asked, req.Params.(ElicitRequest).Message)
+		data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": answer})
+		// Answer the way a transport would, after send returns
+		go session.HandleResponse(data)
+		return nil
+	})
+	session.start(InitializeRequest{
+		ProtocolVersion: ProtocolVersion20250618,
+		Capabilities:    ClientCapabilities{Elicitation: &struct{}{}},
+	})
+	session.initialized = true
+	t.Cleanup(session.Close)
+	return session, &asked
+}
+
+func seedPeople(t *testing.T, n int) *sql.DB {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Acme", "company")
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("Person %d", i)
+		db.CreateEntity(database, name, "person")
+		db.CreateObservation(database, name, "works hard")
+		db.CreateRelation(database, name, "Acme", "works_at")
+	}
+	return database
+}
+
+func callTool(ctx context.Context, database *sql.DB, name string, arguments map[string]interface{}) ToolCallResult {
+	response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": name, "arguments": arguments},
+	})
+	return response.Result.(ToolCallResult)
+}
+
+func TestConfirmDelete(t *testing.T) {
+	SetConfirmThreshold(5)
+	t.Cleanup(func() { SetConfirmThreshold(DefaultConfirmThreshold) })
+	database := seedPeople(t, 4)
+	everyone := []interface{}{"Person 0", "Person 1", "Person 2", "Person 3"}
+
+	// Declining leaves everything in place
+	session, asked := elicitingClient(t, ElicitResult{Action: "decline"})
+	result := callTool(WithSession(context.Background(), session), database, "delete_entities", map[string]interface{}{"entityNames": everyone})
+	if !result.IsError || len(*asked) != 1 {
+		t.Fatalf("Expected a declined delete to fail after one question, got %+v asked %q", result, *asked)
+	}
+	if !strings.HasPrefix((*asked)[0], "Delete 4 entities, 4 observations and 4 relations?") || !strings.Contains((*asked)[0], "- Person 0 -[works_at]-> Acme") {
+		t.Errorf("Expected the question to preview the cascade, got %q", (*asked)[0])
+	}
+	if entities, _, _, _ := db.ReadGraph(database); len(entities) != 5 {
+		t.Errorf("Expected nothing deleted, got %d entities", len(entities))
+	}
+
+	// Accepting without ticking the box is not a yes
+	session, _ = elicitingClient(t, ElicitResult{Action: "accept", Content: map[string]interface{}{"confirm": false}})
+	if result := callTool(WithSession(context.Background(), session), database, "delete_entities", map[string]interface{}{"entityNames": everyone}); !result.IsError {
+		t.Errorf("Expected an unconfirmed delete to fail, got %+v", result)
+	}
+
+	// Small deletes go ahead without asking
+	session, asked = elicitingClient(t, ElicitResult{Action: "decline"})
+	ctx := WithSession(context.Background(), session)
+	if result := callTool(ctx, database, "delete_entities", map[string]interface{}{"entityNames": everyone[:1]}); result.IsError || len(*asked) != 0 {
+		t.Errorf("Expected a small delete without asking, got %+v asked %q", result, *asked)
+	}
+
+	// So does deleting a couple of relations
+	relations := []interface{}{
+		map[string]interface{}{"from": "Person 1", "to": "Acme", "relationType": "works_at"},
+		map[string]interface{}{"from": "Person 2", "to": "Acme", "relationType": "works_at"},
+	}
+	if callTool(ctx, database, "delete_relations", map[string]interface{}{"relations": relations}); len(*asked) != 0 {
+		t.Errorf("Expected 2 relations to be deleted without asking, asked %q", *asked)
+	}
+
+	session, asked = elicitingClient(t, ElicitResult{Action: "accept", Content: map[string]interface{}{"confirm": true}})
+	result = callTool(WithSession(context.Background(), session), database, "delete_entities", map[string]interface{}{"entityNames": everyone})
+	if result.IsError || len(*asked) != 1 || !strings.HasPrefix((*asked)[0], "Delete 3 entities, 3 observations and 1 relation?") {
+		t.Errorf("Expected a confirmed delete, got %+v asked %q", result, *asked)
+	}
+	if entities, _, _, _ := db.ReadGraph(database); len(entities) != 1 {
+		t.Errorf("Expected only Acme left, got %+v", entities)
+	}
+
+	// Clients without elicitation are not asked
+	database = seedPeople(t, 4)
+	plain := NewSession(func(msg interface{}) error {
+		t.Errorf("Expected nothing sent to a client without elicitation, got %+v", msg)
+		return nil
+	})
+	plain.start(InitializeRequest{ProtocolVersion: ProtocolVersion20250618})
+	plain.initialized = true
+	if result := callTool(WithSession(context.Background(), plain), database, "delete_entities", map[string]interface{}{"entityNames": everyone}); result.IsError {
+		t.Errorf("Expected the delete to go ahead, got %+v", result)
+	}
+}
+
+func TestStreamableElicitation(t *testing.T) {
+	SetConfirmThreshold(1)
+	t.Cleanup(func() { SetConfirmThreshold(DefaultConfirmThreshold) })
+	database := seedPeople(t, 2)
+	server := httptest.NewServer(newStreamableServer(database))
+	defer server.Close()
+	both := "application/json, text/event-stream"
+
+	resp := post(t, server.URL, "", both, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"test"}}}`)
+	session := resp.Header.Get(SessionHeader)
+	post(t, server.URL, session, both, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	// The question comes on the stream of the tool call, before its result
+	resp = post(t, server.URL, session, both, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"delete_entities","arguments":{"entityNames":["Person 0","Person 1"]}}}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected the tool call to be answered with a stream, got %s", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+	_, data := readEvent(t, events)
+	var question struct {
+		ID     interface{}   `json:"id"`
+		Method string        `json:"method"`
+		Params ElicitRequest `json:"params"`
+	}
+	json.Unmarshal([]byte(data), &question)
+	if question.Method != "elicitation/create" || !strings.HasPrefix(question.Params.Message, "Delete 2 entities") {
+		t.Fatalf("Expected an elicitation request, got %s", data)
+	}
+
+	answer, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      question.ID,
+		"result":  ElicitResult{Action: "accept", Content: map[string]interface{}{"confirm": true}},
+	})
+	if resp := post(t, server.URL, session, both, string(answer)); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected 202 for the response, got %d", resp.StatusCode)
+	}
+
+	_, data = readEvent(t, events)
+	if !strings.Contains(data, `"id":2`) || !strings.Contains(data, "Successfully deleted 2 entities") {
+		t.Errorf("Expected the tool result after the answer, got %s", data)
+	}
+}
@@ -0,0 +1,211 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
+)
+
+// Federation. search_nodes and open_nodes also ask the memory servers
+// registered with Federate, and merge their answers into the local one,
+// every entity and relation tagged with the server it came from. Writes
+// only ever go to the local graph. Remote servers are connected to when
+// first asked, and again after a failure.
+
+// federationTimeout bounds waiting for a remote server.
+const federationTimeout = 10 * time.Second
+
+// FederationClientName is the client name this server gives remote
+// servers. Requests from such clients are answered from the local graph
+// only, so that servers federating with each other do not loop.
+const FederationClientName = "knowledge-graph-federation"
+
+// DefaultLocalOrigin tags the local server's entities in federated results.
+const DefaultLocalOrigin = "local"
+
+// remoteServer is a federated memory server.
+type remoteServer struct {
+	name   string
+	target string
+
+	mu     sync.Mutex
+	client *Client
+}
+
+var federation = struct {
+	sync.RWMutex
+	local   string
+	remotes []*remoteServer
+}{local: DefaultLocalOrigin}
+
+// Federate registers the memory server at target, an MCP endpoint URL or
+// command line as taken by Dial, under name. name tags its entities.
+func Federate(name, target string) error {
+	if name == "" || target == "" {
+		return errors.New("a federated server needs a name and a target")
+	}
+	federation.Lock()
+	defer federation.Unlock()
+	if name == federation.local {
+		return fmt.Errorf("%q is the name of the local server", name)
+	}
+	for _, r := range federation.remotes {
+		if r.name == name {
+			return fmt.Errorf("a server named %q is already federated", name)
+		}
+	}
+	federation.remotes = append(federation.remotes, &remoteServer{name: name, target: target})
+	return nil
+}
+
+// SetLocalOrigin sets the name that tags the local server's entities in
+// federated results, DefaultLocalOrigin unless set.
+func SetLocalOrigin(name string) {
+	federation.Lock()
+	defer federation.Unlock()
+	federation.local = name
+}
+
+// federatedServers returns the servers to ask besides the local one: none
+// for clients that are themselves federating.
+func federatedServers(ctx context.Context) (string, []*remoteServer) {
+	if session := sessionFrom(ctx); session != nil && session.ClientInfo().Name == FederationClientName {
+		return "", nil
+	}
+	federation.RLock()
+	defer federation.RUnlock()
+	return federation.local, federation.remotes
+}
+
+// federatedEntity and federatedRelation are graph records tagged with the
+// server they came from.
+type federatedEntity struct {
+	db.Entity
+	Origin string `json:"origin"`
+}
+
+type federatedRelation struct {
+	db.Relation
+	Origin string `json:"origin"`
+}
+
+type federatedGraph struct {
+	Entities  []federatedEntity   `json:"entities"`
+	Relations []federatedRelation `json:"relations"`
+}
+
+// call calls a graph tool on the remote server and returns its answer.
+func (r *remoteServer) call(ctx context.Context, tool string, arguments map[string]interface{}) (federatedGraph, error) {
+	var graph federatedGraph
+	r.mu.Lock()
+	if r.client == nil {
+		client, err := Dial(ctx, r.target, ClientOptions{ClientInfo: ClientInfo{Name: FederationClientName, Version: "1.0.0"}})
+		if err != nil {
+			r.mu.Unlock()
+			return graph, err
+		}
+		r.client = client
+	}
+	client := r.client
+	r.mu.Unlock()
+
+	result, err := client.CallTool(ctx, tool, arguments)
+	if err != nil {
+		var rpcErr *JSONRPCError
+		if !errors.As(err, &rpcErr) {
+			// Connect again next time
+			r.mu.Lock()
+			if r.client == client {
+				r.client = nil
+			}
+			r.mu.Unlock()
+			client.Close()
+		}
+		return graph, err
+	}
+	if result.IsError {
+		if len(result.Content) > 0 {
+			return graph, errors.New(result.Content[0].Text)
+		}
+		return graph, errors.New("tool call failed")
+	}
+
+	// Servers on protocol versions before structured content answer
+	// with the same JSON as text
+	data, err := json.Marshal(result.StructuredContent)
+	if result.StructuredContent == nil && len(result.Content) > 0 {
+		data, err = []byte(result.Content[0].Text), nil
+	}
+	if err != nil {
+		return graph, err
+	}
+	if err := json.Unmarshal(data, &graph); err != nil {
+		return graph, fmt.Errorf("unexpected %s result: %v", tool, err)
+	}
+	for i := range graph.Entities {
+		graph.Entities[i].Origin = r.name
+	}
+	for i := range graph.Relations {
+		graph.Relations[i].Origin = r.name
+	}
+	return graph, nil
+}
+
+// federatedGraphResult answers a search_nodes or open_nodes call with the
+// local entities and relations, plus those of the federated servers asked
+// with the same arguments. Servers that fail are listed as unreachable.
+// Without federation it is graphResult.
+func federatedGraphResult(ctx context.Context, tool string, arguments map[string]interface{}, entities []db.Entity, relations []db.Relation) (ToolCallResult, error) {
+	local, remotes := federatedServers(ctx)
+	if len(remotes) == 0 {
+		return graphResult(entities, relations)
+	}
+
+	answers := make([]federatedGraph, len(remotes))
+	errs := make([]error, len(remotes))
+	var wg sync.WaitGroup
+	for i, r := range remotes {
+		wg.Add(1)
+		go func(i int, r *remoteServer) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, federationTimeout)
+			defer cancel()
+			answers[i], errs[i] = r.call(ctx, tool, arguments)
+		}(i, r)
+	}
+
+	graph := federatedGraph{Entities: []federatedEntity{}, Relations: []federatedRelation{}}
+	for _, e := range entities {
+		graph.Entities = append(graph.Entities, federatedEntity{Entity: e, Origin: local})
+	}
+	for _, r := range relations {
+		graph.Relations = append(graph.Relations, federatedRelation{Relation: r, Origin: local})
+	}
+	wg.Wait()
+
+	unreachable := []map[string]string{}
+	for i, r := range remotes {
+		if errs[i] != nil {
+			logs.Log(logs.Warning, "federation", "remote server failed", map[string]interface{}{"origin": r.name, "tool": tool, "error": errs[i].Error()})
+			unreachable = append(unreachable, map[string]string{"origin": r.name, "error": errs[i].Error()})
+			continue
+		}
+		graph.Entities = append(graph.Entities, answers[i].Entities...)
+		graph.Relations = append(graph.Relations, answers[i].Relations...)
+	}
+
+	result := map[string]interface{}{
+		"entities":  graph.Entities,
+		"relations": graph.Relations,
+	}
+	if len(unreachable) > 0 {
+		result["unreachable"] = unreachable
+	}
+	return jsonResult(result)
+}
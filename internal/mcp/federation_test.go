@@ -0,0 +1,137 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+// federate registers a remote server for the test and forgets every
+// federated server afterwards.
+func federate(t *testing.T, name, target string) {
+	if err := Federate(name, target); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		federation.Lock()
+		defer federation.Unlock()
+		for _, r := range federation.remotes {
+			if r.client != nil {
+				r.client.Close()
+			}
+		}
+		federation.remotes = nil
+		federation.local = DefaultLocalOrigin
+	})
+}
+
+// remoteGraph serves a graph with the given people, all working at Acme.
+func remoteGraph(t *testing.T, people ...string) string {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Acme", "company")
+	for _, name := range people {
+		db.CreateEntity(database, name, "person")
+		db.CreateRelation(database, name, "Acme", "works_at")
+	}
+	ts := httptest.NewServer(newStreamableServer(database))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// origins decodes a federated result and lists its entities as
+// "origin:name", sorted.
+func origins(t *testing.T, result ToolCallResult) ([]string, map[string]interface{}) {
+	if result.IsError {
+		t.Fatalf("Expected a result, got %+v", result)
+	}
+	data, _ := json.Marshal(result.StructuredContent)
+	var structured map[string]interface{}
+	json.Unmarshal(data, &structured)
+	if err := conforms(*federatedGraphOutputSchema, structured); err != nil {
+		t.Errorf("Structured content %s does not match the output schema: %v", data, err)
+	}
+	var graph federatedGraph
+	json.Unmarshal(data, &graph)
+	var names []string
+	for _, e := range graph.Entities {
+		names = append(names, e.Origin+":"+e.Name)
+	}
+	sort.Strings(names)
+	return names, structured
+}
+
+func TestFederation(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	SetLocalOrigin("home")
+	federate(t, "team", remoteGraph(t, "Alice Smith", "Bob"))
+	federate(t, "lab", remoteGraph(t, "Alice Jones"))
+
+	names, structured := origins(t, callTool(context.Background(), database, "search_nodes", map[string]interface{}{"query": "Alice"}))
+	if strings.Join(names, ",") != "home:Alice,lab:Alice Jones,team:Alice Smith" {
+		t.Errorf("Expected matches from every server, got %v", names)
+	}
+	if _, ok := structured["unreachable"]; ok {
+		t.Errorf("Expected every server to answer, got %v", structured["unreachable"])
+	}
+	for _, r := range structured["relations"].([]interface{}) {
+		if r.(map[string]interface{})["origin"] == "home" {
+			t.Errorf("Expected relations from the remote servers only, got %v", r)
+		}
+	}
+
+	names, _ = origins(t, callTool(context.Background(), database, "open_nodes", map[string]interface{}{"names": []interface{}{"Alice", "Bob"}}))
+	if strings.Join(names, ",") != "home:Alice,team:Bob" {
+		t.Errorf("Expected open_nodes to ask every server, got %v", names)
+	}
+
+	// Writes stay local
+	callTool(context.Background(), database, "create_entities", map[string]interface{}{
+		"entities": []interface{}{map[string]interface{}{"name": "Alina", "entityType": "person"}},
+	})
+	names, _ = origins(t, callTool(context.Background(), database, "search_nodes", map[string]interface{}{"query": "Alina"}))
+	if strings.Join(names, ",") != "home:Alina" {
+		t.Errorf("Expected the new entity on the local server only, got %v", names)
+	}
+
+	// Federating servers are answered from the local graph
+	session := NewSession(func(interface{}) error { return nil })
+	session.start(InitializeRequest{ProtocolVersion: ProtocolVersion20250618, ClientInfo: ClientInfo{Name: FederationClientName}})
+	session.initialized = true
+	t.Cleanup(session.Close)
+	result := callTool(WithSession(context.Background(), session), database, "search_nodes", map[string]interface{}{"query": "Alice"})
+	if text := result.Content[0].Text; strings.Contains(text, "origin") || strings.Contains(text, "Jones") {
+		t.Errorf("Expected only local results for a federating client, got %s", text)
+	}
+}
+
+func TestFederationUnreachable(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	ts := httptest.NewServer(nil)
+	gone := ts.URL
+	ts.Close()
+	federate(t, "gone", gone)
+	federate(t, "team", remoteGraph(t, "Alice Smith"))
+
+	names, structured := origins(t, callTool(context.Background(), database, "search_nodes", map[string]interface{}{"query": "Alice"}))
+	if strings.Join(names, ",") != "local:Alice,team:Alice Smith" {
+		t.Errorf("Expected the servers that answered, got %v", names)
+	}
+	unreachable, _ := structured["unreachable"].([]interface{})
+	if len(unreachable) != 1 || unreachable[0].(map[string]interface{})["origin"] != "gone" {
+		t.Errorf("Expected the unreachable server to be listed, got %v", structured["unreachable"])
+	}
+
+	if err := Federate("team", gone); err == nil {
+		t.Error("Expected a second server named team to be refused")
+	}
+	if err := Federate("local", gone); err == nil {
+		t.Error("Expected the local server's name to be refused")
+	}
+}
@@ -1,15 +1,18 @@
 package mcp
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
 )
 
 // JSON-RPC 2.0 message types
@@ -47,7 +50,14 @@ type InitializeRequest struct {
 }
 
 type ClientCapabilities struct {
-	Sampling *struct{} `json:"sampling,omitempty"`
+	Roots        *RootsCapability       `json:"roots,omitempty"`
+	Sampling     *struct{}              `json:"sampling,omitempty"`
+	Elicitation  *struct{}              `json:"elicitation,omitempty"`
+	Experimental map[string]interface{} `json:"experimental,omitempty"`
+}
+
+type RootsCapability struct {
+	ListChanged bool `json:"listChanged,omitempty"`
 }
 
 type ClientInfo struct {
@@ -62,7 +72,12 @@ type InitializeResult struct {
 }
 
 type ServerCapabilities struct {
-	Tools *struct{} `json:"tools,omitempty"`
+	Tools     *struct{}            `json:"tools,omitempty"`
+	Resources *ResourcesCapability `json:"resources,omitempty"`
+	Prompts   *PromptsCapability   `json:"prompts,omitempty"`
+	Logging   *struct{}            `json:"logging,omitempty"`
+	// Completions is declared from protocol 2025-03-26 on
+	Completions *struct{} `json:"completions,omitempty"`
 }
 
 type ServerInfo struct {
@@ -78,17 +93,58 @@ type Tool struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
 	InputSchema InputSchema `json:"inputSchema"`
+	// OutputSchema describes StructuredContent in the tool's results. It
+	// is only listed for clients on protocol 2025-06-18 or later.
+	OutputSchema *Property `json:"outputSchema,omitempty"`
+	// Annotations are listed for clients on 2025-03-26 or later.
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations tell clients how a tool behaves, so that they can, for
+// example, ask before running destructive tools. They are hints.
+type ToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    *bool  `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool  `json:"destructiveHint,omitempty"`
+	IdempotentHint  *bool  `json:"idempotentHint,omitempty"`
+	OpenWorldHint   *bool  `json:"openWorldHint,omitempty"`
+}
+
+// readOnlyTool and writeTool annotate tools that only read the graph and
+// tools that change it. No tool reaches outside the graph.
+func readOnlyTool(title string) *ToolAnnotations {
+	return &ToolAnnotations{Title: title, ReadOnlyHint: boolPtr(true), OpenWorldHint: boolPtr(false)}
+}
+
+func writeTool(title string, destructive, idempotent bool) *ToolAnnotations {
+	return &ToolAnnotations{
+		Title:           title,
+		ReadOnlyHint:    boolPtr(false),
+		DestructiveHint: boolPtr(destructive),
+		IdempotentHint:  boolPtr(idempotent),
+		OpenWorldHint:   boolPtr(false),
+	}
 }
 
 type InputSchema struct {
-	Type       string              `json:"type"`
-	Properties map[string]Property `json:"properties"`
-	Required   []string            `json:"required"`
+	Type                 string              `json:"type"`
+	Properties           map[string]Property `json:"properties"`
+	Required             []string            `json:"required"`
+	AdditionalProperties *bool               `json:"additionalProperties,omitempty"`
 }
 
+// Property is a JSON Schema: a tool argument, an element of one, or a tool's
+// output schema.
 type Property struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
+	Type                 string              `json:"type"`
+	Description          string              `json:"description,omitempty"`
+	Enum                 []string            `json:"enum,omitempty"`
+	Minimum              *int                `json:"minimum,omitempty"`
+	Default              interface{}         `json:"default,omitempty"`
+	Items                *Property           `json:"items,omitempty"`
+	Properties           map[string]Property `json:"properties,omitempty"`
+	Required             []string            `json:"required,omitempty"`
+	AdditionalProperties *bool               `json:"additionalProperties,omitempty"`
 }
 
 type ToolCallRequest struct {
@@ -98,7 +154,11 @@ type ToolCallRequest struct {
 
 type ToolCallResult struct {
 	Content []ToolContent `json:"content"`
-	IsError bool          `json:"isError,omitempty"`
+	// StructuredContent is the result as a JSON object matching the tool's
+	// OutputSchema; Content carries the same result as text for older
+	// clients.
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	IsError           bool        `json:"isError,omitempty"`
 }
 
 type ToolContent struct {
@@ -111,9 +171,14 @@ type MCPSession struct {
 	sessionID   string
 	writer      http.ResponseWriter
 	flusher     http.Flusher
-	messageChan chan JSONRPCRequest
+	messageChan chan json.RawMessage
 	done        chan bool
-	initialized bool
+	// mu serializes writes to the stream; closed is set once the
+	// connection has ended
+	mu     sync.Mutex
+	closed bool
+	// protocol receives the client's responses to server requests
+	protocol *Session
 }
 
 type MCPSessionManager struct {
@@ -125,12 +190,22 @@ var sessionManager = &MCPSessionManager{
 	sessions: make(map[string]*MCPSession),
 }
 
-// NewMCPHandler creates a new MCP handler that supports both GET (SSE) and POST (messages)
+// validOrigin reports whether a request may reach the MCP endpoints:
+// browsers are only allowed in from pages on localhost, which blocks DNS
+// rebinding attacks.
+func validOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || strings.HasPrefix(origin, "http://localhost") || strings.HasPrefix(origin, "http://127.0.0.1")
+}
+
+// NewMCPHandler creates a handler for the legacy HTTP+SSE transport (MCP
+// 2024-11-05): GET /sse opens the stream and POST /messages delivers
+// messages with an X-Session-ID header. New clients use
+// NewStreamableHandler.
 func NewMCPHandler(database *sql.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Security: validate origin to prevent DNS rebinding attacks
-		origin := r.Header.Get("Origin")
-		if origin != "" && !strings.HasPrefix(origin, "http://localhost") && !strings.HasPrefix(origin, "http://127.0.0.1") {
+		if !validOrigin(r) {
 			http.Error(w, "invalid origin", http.StatusForbidden)
 			return
 		}
@@ -141,12 +216,6 @@ func NewMCPHandler(database *sql.DB) http.Handler {
 			handleSSEConnection(database, w, r)
 		case r.URL.Path == "/messages" && r.Method == http.MethodPost:
 			handleJSONRPCMessage(database, w, r)
-		case r.URL.Path == "/mcp" && r.Method == http.MethodGet:
-			// Legacy SSE endpoint
-			handleSSEConnection(database, w, r)
-		case r.URL.Path == "/mcp" && r.Method == http.MethodPost:
-			// Legacy messages endpoint
-			handleJSONRPCMessage(database, w, r)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -157,8 +226,7 @@ func NewMCPHandler(database *sql.DB) http.Handler {
 func NewHandler(database *sql.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Security: validate origin to prevent DNS rebinding attacks
-		origin := r.Header.Get("Origin")
-		if origin != "" && !strings.HasPrefix(origin, "http://localhost") && !strings.HasPrefix(origin, "http://127.0.0.1") {
+		if !validOrigin(r) {
 			http.Error(w, "invalid origin", http.StatusForbidden)
 			return
 		}
@@ -168,8 +236,24 @@ func NewHandler(database *sql.DB) http.Handler {
 			return
 		}
 
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad request body", http.StatusBadRequest)
+			return
+		}
+		if IsBatch(body) {
+			response := HandleBatchContext(r.Context(), database, body)
+			if response == nil {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
 		var req JSONRPCRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := json.Unmarshal(body, &req); err != nil {
 			http.Error(w, "bad JSON", http.StatusBadRequest)
 			return
 		}
@@ -180,7 +264,7 @@ func NewHandler(database *sql.DB) http.Handler {
 			return
 		}
 
-		response := HandleJSONRPCMethod(database, req)
+		response := HandleJSONRPCMethodContext(r.Context(), database, req)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
@@ -210,10 +294,14 @@ func handleSSEConnection(database *sql.DB, w http.ResponseWriter, r *http.Reques
 		sessionID:   sessionID,
 		writer:      w,
 		flusher:     flusher,
-		messageChan: make(chan JSONRPCRequest, 10),
+		messageChan: make(chan json.RawMessage, 10),
 		done:        make(chan bool),
-		initialized: false,
 	}
+	protocol := NewSession(func(msg interface{}) error {
+		return sendSSEEvent(session, "message", msg)
+	})
+	defer protocol.Close()
+	session.protocol = protocol
 
 	// Add to session manager
 	sessionManager.mu.Lock()
@@ -226,6 +314,9 @@ func handleSSEConnection(database *sql.DB, w http.ResponseWriter, r *http.Reques
 		delete(sessionManager.sessions, sessionID)
 		sessionManager.mu.Unlock()
 		close(session.messageChan)
+		session.mu.Lock()
+		session.closed = true
+		session.mu.Unlock()
 	}()
 
 	// Send session establishment event with session ID
@@ -240,24 +331,44 @@ func handleSSEConnection(database *sql.DB, w http.ResponseWriter, r *http.Reques
 	}
 	sendSSEEvent(session, "endpoint", endpointData)
 
+	// Writes made in this session are attributed to it, and to the client
+	// once it has introduced itself
+	ctx := db.WithProvenance(r.Context(), db.Provenance{SessionID: sessionID})
+	ctx = WithSession(ctx, protocol)
+
 	// Process messages and handle lifecycle
 	for {
 		select {
-		case msg := <-session.messageChan:
+		case data := <-session.messageChan:
+			// Requests and batches run concurrently, so that the client can
+			// cancel them while they run
+			if IsBatch(data) {
+				go func(ctx context.Context) {
+					if response := HandleBatchContext(ctx, database, data); response != nil {
+						sendSSEEvent(session, "message", response)
+					}
+				}(ctx)
+				continue
+			}
+			var msg JSONRPCRequest
+			json.Unmarshal(data, &msg)
+			ctx = WithClientProvenance(ctx, msg)
 			// A request is a notification if its ID is nil (absent or explicitly null).
 			// JSON-RPC 2.0 spec: Server MUST NOT reply to a Notification.
 			if msg.ID != nil {
-				response := HandleJSONRPCMethod(database, msg)
-				err := sendSSEEvent(session, "message", response)
-				if err != nil {
-					// Log error sending SSE event, e.g., client disconnected
-					// log.Printf("Error sending SSE event for session %s: %v", session.sessionID, err)
-					// Consider closing session.done here or handling client disconnect
-				}
+				go func(ctx context.Context) {
+					response := HandleJSONRPCMethodContext(ctx, database, msg)
+					err := sendSSEEvent(session, "message", response)
+					if err != nil {
+						// Log error sending SSE event, e.g., client disconnected
+						// log.Printf("Error sending SSE event for session %s: %v", session.sessionID, err)
+						// Consider closing session.done here or handling client disconnect
+					}
+				}(ctx)
 			} else {
 				// It's a notification. Process it (it might have side effects)
 				// but do not send a response back to the client.
-				_ = HandleJSONRPCMethod(database, msg)
+				_ = HandleJSONRPCMethodContext(ctx, database, msg)
 				// log.Printf("Processed notification for session %s, method: %s. No response sent.", session.sessionID, msg.Method)
 			}
 
@@ -282,16 +393,24 @@ func handleJSONRPCMessage(database *sql.DB, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var req JSONRPCRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad JSON", http.StatusBadRequest)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request body", http.StatusBadRequest)
 		return
 	}
+	// Batches are checked message by message when they are handled
+	if !IsBatch(body) {
+		var req JSONRPCRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "bad JSON", http.StatusBadRequest)
+			return
+		}
 
-	// Validate JSON-RPC 2.0
-	if req.JSONRPC != "2.0" {
-		http.Error(w, "invalid JSON-RPC version", http.StatusBadRequest)
-		return
+		// Validate JSON-RPC 2.0
+		if req.JSONRPC != "2.0" {
+			http.Error(w, "invalid JSON-RPC version", http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Find session and send message
@@ -304,9 +423,16 @@ func handleJSONRPCMessage(database *sql.DB, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Responses go straight to the request waiting for them, which may be
+	// holding up the message loop
+	if session.protocol.HandleResponse(body) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	// Send message to session for processing
 	select {
-	case session.messageChan <- req:
+	case session.messageChan <- body:
 		w.WriteHeader(http.StatusAccepted)
 	case <-time.After(5 * time.Second):
 		http.Error(w, "session busy", http.StatusServiceUnavailable)
@@ -320,6 +446,12 @@ func sendSSEEvent(session *MCPSession, eventType string, data interface{}) error
 		return err
 	}
 
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.closed {
+		return fmt.Errorf("session %s is closed", session.sessionID)
+	}
+
 	eventID := fmt.Sprintf("%d", time.Now().UnixNano())
 
 	// Write SSE event format
@@ -333,13 +465,70 @@ func sendSSEEvent(session *MCPSession, eventType string, data interface{}) error
 }
 
 func HandleJSONRPCMethod(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	return HandleJSONRPCMethodContext(context.Background(), database, req)
+}
+
+// HandleJSONRPCMethodContext is HandleJSONRPCMethod with a context; writes
+// made by tools are attributed to the provenance it carries. When ctx
+// carries a Session, requests are held to its lifecycle and can be
+// cancelled by the client.
+func HandleJSONRPCMethodContext(ctx context.Context, database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	session := sessionFrom(ctx)
+	if rpcErr := session.admit(req); rpcErr != nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error:   rpcErr,
+		}
+	}
+
+	ctx, done := session.track(ctx, req)
+	response := handleMethod(withProgress(ctx, req), database, req)
+	if done() {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &JSONRPCError{
+				Code:    requestCancelledCode,
+				Message: "Request cancelled",
+			},
+		}
+	}
+	return response
+}
+
+func handleMethod(ctx context.Context, database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 	switch req.Method {
 	case "initialize":
-		return handleInitialize(req)
+		return handleInitialize(ctx, database, req)
+	case "notifications/initialized":
+		return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID}
+	case "notifications/cancelled":
+		return handleCancelled(ctx, req)
+	case "ping":
+		return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: struct{}{}}
 	case "tools/list":
-		return handleToolsList(req)
+		return handleToolsList(ctx, req)
 	case "tools/call":
-		return handleToolCall(database, req)
+		return handleToolCall(ctx, database, req)
+	case "resources/list":
+		return handleResourcesList(database, req)
+	case "resources/templates/list":
+		return handleResourceTemplatesList(req)
+	case "resources/read":
+		return handleResourcesRead(database, req)
+	case "prompts/list":
+		return handlePromptsList(req)
+	case "prompts/get":
+		return handlePromptsGet(database, req)
+	case "resources/subscribe":
+		return handleResourcesSubscribe(ctx, req, true)
+	case "resources/unsubscribe":
+		return handleResourcesSubscribe(ctx, req, false)
+	case "logging/setLevel":
+		return handleSetLevel(ctx, req)
+	case "completion/complete":
+		return handleComplete(ctx, database, req)
 	default:
 		return JSONRPCResponse{
 			JSONRPC: "2.0",
@@ -352,14 +541,43 @@ func HandleJSONRPCMethod(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 	}
 }
 
-func handleInitialize(req JSONRPCRequest) JSONRPCResponse {
+// WithClientProvenance returns ctx attributing later writes to the client
+// named in req's clientInfo when req is an initialize request. Any other
+// request leaves ctx unchanged.
+func WithClientProvenance(ctx context.Context, req JSONRPCRequest) context.Context {
+	if req.Method != "initialize" {
+		return ctx
+	}
+	params, _ := req.Params.(map[string]interface{})
+	clientInfo, _ := params["clientInfo"].(map[string]interface{})
+	name, _ := clientInfo["name"].(string)
+	if name == "" {
+		return ctx
+	}
+	return db.WithProvenance(ctx, db.Provenance{Source: name})
+}
+
+// ServerName is the name this server gives itself at initialize.
+const ServerName = "knowledge-graph-mcp"
+
+func handleInitialize(ctx context.Context, database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	var init InitializeRequest
+	if err := decodeParams(req.Params, &init); err != nil {
+		return invalidParams(req, err)
+	}
+
+	session := sessionFrom(ctx)
+	version := session.start(init)
+	if session != nil && session.send != nil {
+		if err := watchResources(database, session); err != nil {
+			logs.Log(logs.Error, "mcp", "no change notifications for this session", map[string]interface{}{"error": err.Error()})
+		}
+	}
 	result := InitializeResult{
-		ProtocolVersion: "2024-11-05",
-		Capabilities: ServerCapabilities{
-			Tools: &struct{}{},
-		},
+		ProtocolVersion: version,
+		Capabilities:    serverCapabilities(version),
 		ServerInfo: ServerInfo{
-			Name:    "knowledge-graph-mcp",
+			Name:    ServerName,
 			Version: "1.0.0",
 		},
 	}
@@ -371,131 +589,290 @@ func handleInitialize(req JSONRPCRequest) JSONRPCResponse {
 	}
 }
 
-func handleToolsList(req JSONRPCRequest) JSONRPCResponse {
+func handleToolsList(ctx context.Context, req JSONRPCRequest) JSONRPCResponse {
 	tools := []Tool{
 		{
 			Name:        "read_graph",
 			Description: "Read the entire knowledge graph including entities, relations, and observations",
+			Annotations: readOnlyTool("Read graph"),
 			InputSchema: InputSchema{
-				Type:       "object",
-				Properties: map[string]Property{},
-				Required:   []string{},
+				Type: "object",
+				Properties: map[string]Property{
+					"asOf": asOfProperty,
+				},
+				Required:             []string{},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"entities":     arrayOf(entitySchema, ""),
+				"relations":    arrayOf(relationSchema, ""),
+				"observations": arrayOf(observationSchema, ""),
+			}, "entities", "relations", "observations"),
 		},
 		{
 			Name:        "create_entities",
 			Description: "Create multiple new entities in the knowledge graph",
+			Annotations: writeTool("Create entities", false, true),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"entities": {
-						Type:        "array",
-						Description: "Array of entity objects with name, entityType, and observations",
-					},
+					"entities": arrayOf(entityInputSchema, "Array of entity objects with name, entityType, and observations"),
 				},
-				Required: []string{"entities"},
+				Required:             []string{"entities"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"created": stringArray("Names of the entities created; existing entities are skipped"),
+			}, "created"),
 		},
 		{
 			Name:        "create_relations",
 			Description: "Create multiple new relations between entities",
+			Annotations: writeTool("Create relations", false, true),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"relations": {
-						Type:        "array",
-						Description: "Array of relation objects with from, to, and relationType",
-					},
+					"relations": arrayOf(relationInputSchema, "Array of relation objects with from, to, and relationType"),
 				},
-				Required: []string{"relations"},
+				Required:             []string{"relations"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"relationIds": arrayOf(Property{Type: "integer"}, "IDs of the relations; a relation already in the graph keeps its ID and is not created again"),
+			}, "relationIds"),
 		},
 		{
 			Name:        "add_observations",
 			Description: "Add new observations to existing entities",
+			Annotations: writeTool("Add observations", false, false),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"observations": {
-						Type:        "array",
-						Description: "Array of observation objects with entityName and contents",
-					},
+					"observations": arrayOf(observationInputSchema, "Array of observation objects with entityName and contents"),
 				},
-				Required: []string{"observations"},
+				Required:             []string{"observations"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"added": arrayOf(observationSchema, "The observations added"),
+			}, "added"),
 		},
 		{
 			Name:        "delete_entities",
-			Description: "Remove entities and their associated relations",
+			Description: "Remove entities and their associated relations. Deleted entities can be brought back with restore_entities until they are purged",
+			Annotations: writeTool("Delete entities", true, true),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"entityNames": {
-						Type:        "array",
-						Description: "Array of entity names to delete",
-					},
+					"entityNames": stringArray("Array of entity names to delete"),
 				},
-				Required: []string{"entityNames"},
+				Required:             []string{"entityNames"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"deleted": stringArray("Names of the entities deleted"),
+			}, "deleted"),
 		},
 		{
 			Name:        "delete_observations",
 			Description: "Remove specific observations from entities",
+			Annotations: writeTool("Delete observations", true, true),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"deletions": {
-						Type:        "array",
-						Description: "Array of deletion objects with entityName and observations",
-					},
+					"deletions": arrayOf(deletionInputSchema, "Array of deletion objects with entityName and observations"),
 				},
-				Required: []string{"deletions"},
+				Required:             []string{"deletions"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"processed": {Type: "integer", Description: "Number of deletions processed"},
+			}, "processed"),
 		},
 		{
 			Name:        "delete_relations",
 			Description: "Remove specific relations from the graph",
+			Annotations: writeTool("Delete relations", true, true),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"relations": {
-						Type:        "array",
-						Description: "Array of relation objects with from, to, and relationType",
-					},
+					"relations": arrayOf(relationInputSchema, "Array of relation objects with from, to, and relationType"),
 				},
-				Required: []string{"relations"},
+				Required:             []string{"relations"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"processed": {Type: "integer", Description: "Number of relations processed"},
+			}, "processed"),
 		},
 		{
 			Name:        "search_nodes",
 			Description: "Search nodes based on query",
+			Annotations: readOnlyTool("Search nodes"),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"query": {
-						Type:        "string",
-						Description: "Search string to match against entity names, types, and observation content",
+					"query": stringProperty("Search string to match against entity names, types, and observation content. Supports \"phrases\", prefix* and AND/OR/NOT when full-text search is available"),
+					"limit": {
+						Type:        "integer",
+						Description: "Maximum number of entities to return, best matches first (optional)",
+						Minimum:     intPtr(1),
 					},
+					"since": stringProperty("Only entities created or changed at or after this time, RFC 3339 or YYYY-MM-DD (optional). With an empty query, lists everything changed since then"),
+					"until": stringProperty("Only entities last changed before this time, RFC 3339 or YYYY-MM-DD (optional)"),
+					"asOf":  asOfProperty,
 				},
-				Required: []string{"query"},
+				Required:             []string{"query"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: federatedGraphOutputSchema,
 		},
 		{
 			Name:        "open_nodes",
 			Description: "Retrieve specific nodes by name",
+			Annotations: readOnlyTool("Open nodes"),
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"names": stringArray("Array of node names to retrieve"),
+					"asOf":  asOfProperty,
+				},
+				Required:             []string{"names"},
+				AdditionalProperties: boolPtr(false),
+			},
+			OutputSchema: federatedGraphOutputSchema,
+		},
+		{
+			Name:        "traverse_graph",
+			Description: "Return the subgraph within a number of hops of the given entities, including observations",
+			Annotations: readOnlyTool("Traverse graph"),
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
-					"names": {
-						Type:        "array",
-						Description: "Array of node names to retrieve",
+					"names": stringArray("Array of entity names to start from"),
+					"depth": {
+						Type:        "integer",
+						Description: "Number of hops to follow (default 1)",
+						Minimum:     intPtr(1),
+						Default:     1,
+					},
+					"relationTypes": stringArray("Only follow relations of these types (optional)"),
+					"direction":     directionProperty("Follow relations that are outgoing, incoming or both (default both)"),
+					"maxNodes": {
+						Type:        "integer",
+						Description: fmt.Sprintf("Maximum number of entities to return (default %d)", db.DefaultMaxNodes),
+						Minimum:     intPtr(1),
+						Default:     db.DefaultMaxNodes,
 					},
 				},
-				Required: []string{"names"},
+				Required:             []string{"names"},
+				AdditionalProperties: boolPtr(false),
 			},
+			OutputSchema: outputSchema(map[string]Property{
+				"entities":  arrayOf(entitySchema, "Entities in breadth-first order"),
+				"relations": arrayOf(relationSchema, ""),
+				"truncated": {Type: "boolean", Description: "Set when maxNodes stopped the traversal early"},
+			}, "entities", "relations", "truncated"),
+		},
+		{
+			Name:        "find_path",
+			Description: "Find how two entities are connected: the shortest path, or the k shortest simple paths, with each hop's relation type and direction",
+			Annotations: readOnlyTool("Find path"),
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"from": stringProperty("Name of the entity to start from"),
+					"to":   stringProperty("Name of the entity to reach"),
+					"maxDepth": {
+						Type:        "integer",
+						Description: fmt.Sprintf("Maximum path length in hops (default %d)", db.DefaultMaxPathDepth),
+						Minimum:     intPtr(1),
+						Default:     db.DefaultMaxPathDepth,
+					},
+					"relationTypes": stringArray("Only use relations of these types (optional)"),
+					"direction":     directionProperty("Follow relations outgoing, incoming or both ways (default both)"),
+					"k": {
+						Type:        "integer",
+						Description: "Number of paths to return, shortest first (default 1)",
+						Minimum:     intPtr(1),
+						Default:     1,
+					},
+				},
+				Required:             []string{"from", "to"},
+				AdditionalProperties: boolPtr(false),
+			},
+			OutputSchema: outputSchema(map[string]Property{
+				"paths": arrayOf(pathSchema, "Paths found, shortest first; empty when the entities are not connected"),
+			}, "paths"),
+		},
+		{
+			Name:        "entity_history",
+			Description: "Show the full timeline of changes to an entity, its observations and its relations, including who made each change. Works for deleted entities",
+			Annotations: readOnlyTool("Entity history"),
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"name": stringProperty("Name of the entity"),
+				},
+				Required:             []string{"name"},
+				AdditionalProperties: boolPtr(false),
+			},
+			OutputSchema: outputSchema(map[string]Property{
+				"name":    {Type: "string"},
+				"changes": arrayOf(changeSchema, "Changes, oldest first"),
+			}, "name", "changes"),
+		},
+		{
+			Name:        "restore_entities",
+			Description: "Bring back deleted entities together with the observations and relations that were deleted with them",
+			Annotations: writeTool("Restore entities", false, true),
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"entityNames": stringArray("Array of entity names to restore"),
+				},
+				Required:             []string{"entityNames"},
+				AdditionalProperties: boolPtr(false),
+			},
+			OutputSchema: outputSchema(map[string]Property{
+				"restored": stringArray("Names of the entities restored"),
+			}, "restored"),
+		},
+		{
+			Name:        "consolidate_entity",
+			Description: "Rewrite an entity's overlapping observations as a shorter list of distinct facts, with the client's model when it supports sampling and by merging duplicates otherwise. Replaced observations stay in the entity's history",
+			Annotations: writeTool("Consolidate entity", true, false),
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"name": stringProperty("Name of the entity to consolidate"),
+				},
+				Required:             []string{"name"},
+				AdditionalProperties: boolPtr(false),
+			},
+			OutputSchema: outputSchema(map[string]Property{
+				"entity":        stringProperty("Name of the entity"),
+				"method":        {Type: "string", Description: "How the observations were consolidated", Enum: []string{"sampling", "merge"}},
+				"model":         stringProperty("The client's model that wrote them, for sampling"),
+				"samplingError": stringProperty("Why sampling was not used, if the client supports it"),
+				"observations":  stringArray("The entity's observations now"),
+				"removed":       stringArray("Observations replaced"),
+				"added":         stringArray("Observations added"),
+			}, "entity", "method", "observations", "removed", "added"),
 		},
 	}
 
+	// Output schemas are new in 2025-06-18, annotations in 2025-03-26
+	session := sessionFrom(ctx)
+	for i := range tools {
+		if !session.supports(ProtocolVersion20250618) {
+			tools[i].OutputSchema = nil
+		}
+		if !session.supports(ProtocolVersion20250326) {
+			tools[i].Annotations = nil
+		}
+	}
+
 	result := ToolsListResult{Tools: tools}
 
 	return JSONRPCResponse{
@@ -505,7 +882,7 @@ func handleToolsList(req JSONRPCRequest) JSONRPCResponse {
 	}
 }
 
-func handleToolCall(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+func handleToolCall(ctx context.Context, database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 	params, ok := req.Params.(map[string]interface{})
 	if !ok {
 		return JSONRPCResponse{
@@ -537,30 +914,40 @@ func handleToolCall(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 
 	switch name {
 	case "read_graph":
-		result, err = handleReadGraphTool(database)
+		result, err = handleReadGraphTool(ctx, database, arguments)
 	case "create_entities":
-		result, err = handleCreateEntitiesToolMCP(database, arguments)
+		result, err = handleCreateEntitiesToolMCP(ctx, database, arguments)
 	case "create_relations":
-		result, err = handleCreateRelationsToolMCP(database, arguments)
+		result, err = handleCreateRelationsToolMCP(ctx, database, arguments)
 	case "add_observations":
-		result, err = handleAddObservationsToolMCP(database, arguments)
+		result, err = handleAddObservationsToolMCP(ctx, database, arguments)
 	case "delete_entities":
-		result, err = handleDeleteEntitiesToolMCP(database, arguments)
+		result, err = handleDeleteEntitiesToolMCP(ctx, database, arguments)
 	case "delete_observations":
-		result, err = handleDeleteObservationsToolMCP(database, arguments)
+		result, err = handleDeleteObservationsToolMCP(ctx, database, arguments)
 	case "delete_relations":
-		result, err = handleDeleteRelationsToolMCP(database, arguments)
+		result, err = handleDeleteRelationsToolMCP(ctx, database, arguments)
 	case "search_nodes":
-		result, err = handleSearchNodesToolMCP(database, arguments)
+		result, err = handleSearchNodesToolMCP(ctx, database, arguments)
 	case "open_nodes":
-		result, err = handleOpenNodesToolMCP(database, arguments)
+		result, err = handleOpenNodesToolMCP(ctx, database, arguments)
+	case "traverse_graph":
+		result, err = handleTraverseGraphToolMCP(ctx, database, arguments)
+	case "find_path":
+		result, err = handleFindPathToolMCP(ctx, database, arguments)
+	case "entity_history":
+		result, err = handleEntityHistoryToolMCP(ctx, database, arguments)
+	case "restore_entities":
+		result, err = handleRestoreEntitiesToolMCP(ctx, database, arguments)
+	case "consolidate_entity":
+		result, err = handleConsolidateEntityToolMCP(ctx, database, arguments)
 	// Legacy support for old endpoint names
 	case "create_entity":
-		result, err = handleCreateEntityTool(database, arguments)
+		result, err = handleCreateEntityTool(ctx, database, arguments)
 	case "create_relation":
-		result, err = handleCreateRelationTool(database, arguments)
+		result, err = handleCreateRelationTool(ctx, database, arguments)
 	case "create_observation":
-		result, err = handleCreateObservationTool(database, arguments)
+		result, err = handleCreateObservationTool(ctx, database, arguments)
 	default:
 		return JSONRPCResponse{
 			JSONRPC: "2.0",
@@ -573,6 +960,13 @@ func handleToolCall(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 	}
 
 	if err != nil {
+		// Cancelled calls are not the client's mistake
+		if ctx.Err() == nil {
+			logs.Log(logs.Warning, "mcp", "tool call failed", map[string]interface{}{
+				"tool":  name,
+				"error": err.Error(),
+			})
+		}
 		result = ToolCallResult{
 			Content: []ToolContent{{
 				Type: "text",
@@ -581,6 +975,10 @@ func handleToolCall(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 			IsError: true,
 		}
 	}
+	// Structured content is new in 2025-06-18
+	if !sessionFrom(ctx).supports(ProtocolVersion20250618) {
+		result.StructuredContent = nil
+	}
 
 	return JSONRPCResponse{
 		JSONRPC: "2.0",
@@ -589,32 +987,31 @@ func handleToolCall(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
 	}
 }
 
-func handleReadGraphTool(database *sql.DB) (ToolCallResult, error) {
-	entities, relations, observations, err := db.ReadGraph(database)
+func handleReadGraphTool(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	asOf, _ := arguments["asOf"].(string)
+	entities, relations, observations, err := db.ReadGraphAsOf(database, asOf)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
 
-	result := map[string]interface{}{
-		"entities":     entities,
-		"relations":    relations,
-		"observations": observations,
+	if entities == nil {
+		entities = []db.Entity{}
 	}
-
-	jsonData, err := json.Marshal(result)
-	if err != nil {
-		return ToolCallResult{}, err
+	if relations == nil {
+		relations = []db.Relation{}
+	}
+	if observations == nil {
+		observations = []db.Observation{}
 	}
 
-	return ToolCallResult{
-		Content: []ToolContent{{
-			Type: "text",
-			Text: string(jsonData),
-		}},
-	}, nil
+	return jsonResult(map[string]interface{}{
+		"entities":     entities,
+		"relations":    relations,
+		"observations": observations,
+	})
 }
 
-func handleCreateEntityTool(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleCreateEntityTool(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	name, nameOk := arguments["name"].(string)
 	entityType, typeOk := arguments["entity_type"].(string)
 
@@ -622,10 +1019,18 @@ func handleCreateEntityTool(database *sql.DB, arguments map[string]interface{})
 		return ToolCallResult{}, fmt.Errorf("missing required parameters: name, entity_type")
 	}
 
-	err := db.CreateEntity(database, name, entityType)
+	created, err := db.CreateEntityContext(ctx, database, name, entityType)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
+	if !created {
+		return ToolCallResult{
+			Content: []ToolContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Entity '%s' already exists", name),
+			}},
+		}, nil
+	}
 
 	return ToolCallResult{
 		Content: []ToolContent{{
@@ -635,7 +1040,7 @@ func handleCreateEntityTool(database *sql.DB, arguments map[string]interface{})
 	}, nil
 }
 
-func handleCreateRelationTool(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleCreateRelationTool(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	from, fromOk := arguments["from_entity"].(string)
 	to, toOk := arguments["to_entity"].(string)
 	relationType, typeOk := arguments["relation_type"].(string)
@@ -644,7 +1049,7 @@ func handleCreateRelationTool(database *sql.DB, arguments map[string]interface{}
 		return ToolCallResult{}, fmt.Errorf("missing required parameters: from_entity, to_entity, relation_type")
 	}
 
-	id, err := db.CreateRelation(database, from, to, relationType)
+	id, err := db.CreateRelationContext(ctx, database, from, to, relationType)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -657,7 +1062,7 @@ func handleCreateRelationTool(database *sql.DB, arguments map[string]interface{}
 	}, nil
 }
 
-func handleCreateObservationTool(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleCreateObservationTool(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	entityName, nameOk := arguments["entity_name"].(string)
 	content, contentOk := arguments["content"].(string)
 
@@ -665,7 +1070,7 @@ func handleCreateObservationTool(database *sql.DB, arguments map[string]interfac
 		return ToolCallResult{}, fmt.Errorf("missing required parameters: entity_name, content")
 	}
 
-	id, err := db.CreateObservation(database, entityName, content)
+	id, err := db.CreateObservationContext(ctx, database, entityName, content)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -678,14 +1083,20 @@ func handleCreateObservationTool(database *sql.DB, arguments map[string]interfac
 	}, nil
 }
 
-func handleCreateEntitiesToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleCreateEntitiesToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	entitiesInterface, ok := arguments["entities"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid entities parameter")
 	}
 
-	var createdEntities []string
-	for _, entityInterface := range entitiesInterface {
+	createdEntities := []string{}
+	for i, entityInterface := range entitiesInterface {
+		// Stop between entities when the client cancels
+		if err := ctx.Err(); err != nil {
+			return ToolCallResult{}, err
+		}
+		reportProgress(ctx, i, len(entitiesInterface), fmt.Sprintf("Created %d entities", len(createdEntities)))
+
 		entityMap, ok := entityInterface.(map[string]interface{})
 		if !ok {
 			continue
@@ -698,40 +1109,54 @@ func handleCreateEntitiesToolMCP(database *sql.DB, arguments map[string]interfac
 			continue
 		}
 
-		err := db.CreateEntity(database, name, entityType)
+		created, err := db.CreateEntityContext(ctx, database, name, entityType)
 		if err != nil {
-			// Continue with other entities even if one fails (spec says to ignore existing entities)
+			// Continue with other entities even if one fails
+			continue
+		}
+		// Existing entities are skipped, as the spec says, observations
+		// included, so that a retried call adds nothing twice
+		if !created {
 			continue
 		}
-
 		createdEntities = append(createdEntities, name)
 
 		// Handle observations if provided
 		if observationsInterface, obsOk := entityMap["observations"].([]interface{}); obsOk {
 			for _, obsInterface := range observationsInterface {
 				if obsStr, strOk := obsInterface.(string); strOk {
-					db.CreateObservation(database, name, obsStr)
+					if _, err := db.CreateObservationContext(ctx, database, name, obsStr); err != nil {
+						return ToolCallResult{}, fmt.Errorf("adding observations to '%s': %w", name, err)
+					}
 				}
 			}
 		}
 	}
 
+	reportProgress(ctx, len(entitiesInterface), len(entitiesInterface), fmt.Sprintf("Created %d entities", len(createdEntities)))
+
 	return ToolCallResult{
 		Content: []ToolContent{{
 			Type: "text",
 			Text: fmt.Sprintf("Successfully created %d entities: %v", len(createdEntities), createdEntities),
 		}},
+		StructuredContent: map[string]interface{}{"created": createdEntities},
 	}, nil
 }
 
-func handleCreateRelationsToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleCreateRelationsToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	relationsInterface, ok := arguments["relations"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid relations parameter")
 	}
 
-	var createdIDs []int64
-	for _, relationInterface := range relationsInterface {
+	createdIDs := []int64{}
+	for i, relationInterface := range relationsInterface {
+		if err := ctx.Err(); err != nil {
+			return ToolCallResult{}, err
+		}
+		reportProgress(ctx, i, len(relationsInterface), fmt.Sprintf("Created %d relations", len(createdIDs)))
+
 		relationMap, ok := relationInterface.(map[string]interface{})
 		if !ok {
 			continue
@@ -745,7 +1170,7 @@ func handleCreateRelationsToolMCP(database *sql.DB, arguments map[string]interfa
 			continue
 		}
 
-		id, err := db.CreateRelation(database, from, to, relationType)
+		id, err := db.CreateRelationContext(ctx, database, from, to, relationType)
 		if err != nil {
 			// Skip duplicate relations as per spec
 			continue
@@ -754,15 +1179,18 @@ func handleCreateRelationsToolMCP(database *sql.DB, arguments map[string]interfa
 		createdIDs = append(createdIDs, id)
 	}
 
+	reportProgress(ctx, len(relationsInterface), len(relationsInterface), fmt.Sprintf("Created %d relations", len(createdIDs)))
+
 	return ToolCallResult{
 		Content: []ToolContent{{
 			Type: "text",
 			Text: fmt.Sprintf("Successfully created %d relations with IDs: %v", len(createdIDs), createdIDs),
 		}},
+		StructuredContent: map[string]interface{}{"relationIds": createdIDs},
 	}, nil
 }
 
-func handleAddObservationsToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleAddObservationsToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	observationsInterface, ok := arguments["observations"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid observations parameter")
@@ -792,11 +1220,15 @@ func handleAddObservationsToolMCP(database *sql.DB, arguments map[string]interfa
 		}{EntityName: entityName, Contents: contents})
 	}
 
-	added, err := db.AddObservations(database, observations)
+	added, err := db.AddObservationsContext(ctx, database, observations)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
+	if added == nil {
+		added = []db.Observation{}
+	}
 
+	// The text stays the bare array it has always been
 	jsonData, err := json.Marshal(added)
 	if err != nil {
 		return ToolCallResult{}, err
@@ -807,23 +1239,31 @@ func handleAddObservationsToolMCP(database *sql.DB, arguments map[string]interfa
 			Type: "text",
 			Text: string(jsonData),
 		}},
+		StructuredContent: map[string]interface{}{"added": added},
 	}, nil
 }
 
-func handleDeleteEntitiesToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleDeleteEntitiesToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	entityNamesInterface, ok := arguments["entityNames"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid entityNames parameter")
 	}
 
-	var entityNames []string
+	entityNames := []string{}
 	for _, nameInterface := range entityNamesInterface {
 		if name, ok := nameInterface.(string); ok {
 			entityNames = append(entityNames, name)
 		}
 	}
 
-	err := db.DeleteEntities(database, entityNames)
+	err := confirmDelete(ctx, func() (*db.DeletePreview, error) {
+		return db.PreviewDeleteEntities(database, entityNames)
+	})
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	err = db.DeleteEntitiesContext(ctx, database, entityNames)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -833,10 +1273,11 @@ func handleDeleteEntitiesToolMCP(database *sql.DB, arguments map[string]interfac
 			Type: "text",
 			Text: fmt.Sprintf("Successfully deleted %d entities", len(entityNames)),
 		}},
+		StructuredContent: map[string]interface{}{"deleted": entityNames},
 	}, nil
 }
 
-func handleDeleteObservationsToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleDeleteObservationsToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	deletionsInterface, ok := arguments["deletions"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid deletions parameter")
@@ -873,7 +1314,14 @@ func handleDeleteObservationsToolMCP(database *sql.DB, arguments map[string]inte
 		}{EntityName: entityName, Observations: observations})
 	}
 
-	err := db.DeleteObservations(database, deletions)
+	err := confirmDelete(ctx, func() (*db.DeletePreview, error) {
+		return db.PreviewDeleteObservations(database, deletions)
+	})
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	err = db.DeleteObservationsContext(ctx, database, deletions)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -883,10 +1331,11 @@ func handleDeleteObservationsToolMCP(database *sql.DB, arguments map[string]inte
 			Type: "text",
 			Text: fmt.Sprintf("Successfully processed deletion of observations for %d entities", len(deletions)),
 		}},
+		StructuredContent: map[string]interface{}{"processed": len(deletions)},
 	}, nil
 }
 
-func handleDeleteRelationsToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleDeleteRelationsToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	relationsInterface, ok := arguments["relations"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid relations parameter")
@@ -919,7 +1368,14 @@ func handleDeleteRelationsToolMCP(database *sql.DB, arguments map[string]interfa
 		}{From: from, To: to, Type: relationType})
 	}
 
-	err := db.DeleteRelations(database, relations)
+	err := confirmDelete(ctx, func() (*db.DeletePreview, error) {
+		return db.PreviewDeleteRelations(database, relations)
+	})
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	err = db.DeleteRelationsContext(ctx, database, relations)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -929,39 +1385,40 @@ func handleDeleteRelationsToolMCP(database *sql.DB, arguments map[string]interfa
 			Type: "text",
 			Text: fmt.Sprintf("Successfully processed deletion of %d relations", len(relations)),
 		}},
+		StructuredContent: map[string]interface{}{"processed": len(relations)},
 	}, nil
 }
 
-func handleSearchNodesToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleSearchNodesToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	query, ok := arguments["query"].(string)
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid query parameter")
 	}
 
-	entities, relations, err := db.SearchNodes(database, query)
-	if err != nil {
-		return ToolCallResult{}, err
+	var opts db.SearchOptions
+	if limit, ok := arguments["limit"].(float64); ok {
+		opts.Limit = int(limit)
 	}
-
-	result := map[string]interface{}{
-		"entities":  entities,
-		"relations": relations,
+	opts.AsOf, _ = arguments["asOf"].(string)
+	for key, dest := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if value, ok := arguments[key].(string); ok && value != "" {
+			t, err := db.ParseTime(value)
+			if err != nil {
+				return ToolCallResult{}, fmt.Errorf("invalid %s parameter: %v", key, err)
+			}
+			*dest = t
+		}
 	}
 
-	jsonData, err := json.Marshal(result)
+	entities, relations, err := db.SearchNodesWithOptions(database, query, opts)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
 
-	return ToolCallResult{
-		Content: []ToolContent{{
-			Type: "text",
-			Text: string(jsonData),
-		}},
-	}, nil
+	return federatedGraphResult(ctx, "search_nodes", arguments, entities, relations)
 }
 
-func handleOpenNodesToolMCP(database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+func handleOpenNodesToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
 	namesInterface, ok := arguments["names"].([]interface{})
 	if !ok {
 		return ToolCallResult{}, fmt.Errorf("missing or invalid names parameter")
@@ -974,17 +1431,232 @@ func handleOpenNodesToolMCP(database *sql.DB, arguments map[string]interface{})
 		}
 	}
 
-	entities, relations, err := db.OpenNodes(database, names)
+	asOf, _ := arguments["asOf"].(string)
+	entities, relations, err := db.OpenNodesAsOf(database, names, asOf)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
 
-	result := map[string]interface{}{
-		"entities":  entities,
-		"relations": relations,
+	return federatedGraphResult(ctx, "open_nodes", arguments, entities, relations)
+}
+
+func handleTraverseGraphToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	namesInterface, ok := arguments["names"].([]interface{})
+	if !ok {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid names parameter")
+	}
+
+	var opts db.TraverseOptions
+	if depth, ok := arguments["depth"].(float64); ok {
+		opts.Depth = int(depth)
+	}
+	if relationTypes, ok := arguments["relationTypes"].([]interface{}); ok {
+		opts.RelationTypes = stringsArg(relationTypes)
+	}
+	if direction, ok := arguments["direction"].(string); ok {
+		opts.Direction = direction
+	}
+	if maxNodes, ok := arguments["maxNodes"].(float64); ok {
+		opts.MaxNodes = int(maxNodes)
 	}
 
-	jsonData, err := json.Marshal(result)
+	depth := opts.Depth
+	if depth < 1 {
+		depth = 1
+	}
+	opts.Progress = func(hop, entities int) {
+		reportProgress(ctx, hop, depth, fmt.Sprintf("%d entities within %d hops", entities, hop))
+	}
+
+	subgraph, err := db.TraverseContext(ctx, database, stringsArg(namesInterface), opts)
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	return jsonResult(subgraph)
+}
+
+func handleFindPathToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	from, ok := arguments["from"].(string)
+	if !ok {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid from parameter")
+	}
+	to, ok := arguments["to"].(string)
+	if !ok {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid to parameter")
+	}
+
+	var opts db.PathOptions
+	if maxDepth, ok := arguments["maxDepth"].(float64); ok {
+		opts.MaxDepth = int(maxDepth)
+	}
+	if relationTypes, ok := arguments["relationTypes"].([]interface{}); ok {
+		opts.RelationTypes = stringsArg(relationTypes)
+	}
+	if direction, ok := arguments["direction"].(string); ok {
+		opts.Direction = direction
+	}
+	if k, ok := arguments["k"].(float64); ok {
+		opts.K = int(k)
+	}
+
+	paths, err := db.FindPaths(ctx, database, from, to, opts)
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	return jsonResult(map[string]interface{}{"paths": paths})
+}
+
+func handleEntityHistoryToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	name, ok := arguments["name"].(string)
+	if !ok || name == "" {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid name parameter")
+	}
+
+	changes, err := db.EntityHistory(database, name)
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	if changes == nil {
+		changes = []db.Change{}
+	}
+
+	return jsonResult(map[string]interface{}{
+		"name":    name,
+		"changes": changes,
+	})
+}
+
+func handleRestoreEntitiesToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	entityNamesInterface, ok := arguments["entityNames"].([]interface{})
+	if !ok {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid entityNames parameter")
+	}
+
+	restored, err := db.RestoreEntitiesContext(ctx, database, stringsArg(entityNamesInterface))
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+
+	return jsonResult(map[string]interface{}{
+		"restored": restored,
+	})
+}
+
+// consolidationPrompt tells the client's model how to consolidate
+// observations.
+const consolidationPrompt = `You maintain a knowledge graph. You are given the observations recorded about one entity as a JSON array; they overlap and repeat each other. Rewrite them as a shorter list of distinct, self-contained facts: merge duplicates and near duplicates, keep every fact that is stated with its names, numbers and dates, and add nothing that is not in the observations. Answer with a JSON array of strings and nothing else.`
+
+// consolidationMaxTokens bounds the answer of the client's model.
+const consolidationMaxTokens = 4096
+
+func handleConsolidateEntityToolMCP(ctx context.Context, database *sql.DB, arguments map[string]interface{}) (ToolCallResult, error) {
+	name, ok := arguments["name"].(string)
+	if !ok || name == "" {
+		return ToolCallResult{}, fmt.Errorf("missing or invalid name parameter")
+	}
+
+	observations, err := db.EntityObservationsContext(ctx, database, name)
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+	ids := make([]int64, len(observations))
+	contents := make([]string, len(observations))
+	for i, o := range observations {
+		ids[i], contents[i] = o.ID, o.Content
+	}
+
+	// The client's model consolidates when it can, a deterministic merge
+	// otherwise
+	structured := map[string]interface{}{"entity": name, "method": "merge"}
+	consolidated := db.MergeObservations(contents)
+	if len(contents) > 1 && sessionFrom(ctx).canSample() {
+		values, model, err := sampleConsolidation(ctx, contents)
+		switch {
+		case err == nil:
+			consolidated = values
+			structured["method"], structured["model"] = "sampling", model
+		case ctx.Err() != nil:
+			return ToolCallResult{}, ctx.Err()
+		default:
+			structured["samplingError"] = err.Error()
+		}
+	}
+
+	removed, added, err := db.ReplaceObservationsContext(ctx, database, name, ids, consolidated)
+	if err != nil {
+		return ToolCallResult{}, err
+	}
+	removedContents := []string{}
+	for _, o := range removed {
+		removedContents = append(removedContents, o.Content)
+	}
+	addedContents := []string{}
+	for _, o := range added {
+		addedContents = append(addedContents, o.Content)
+	}
+	if consolidated == nil {
+		consolidated = []string{}
+	}
+	structured["observations"] = consolidated
+	structured["removed"] = removedContents
+	structured["added"] = addedContents
+
+	how := "by merging duplicates"
+	if structured["method"] == "sampling" {
+		how = "with the client's model"
+	}
+	return ToolCallResult{
+		Content: []ToolContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Consolidated %d observations of '%s' into %d %s", len(contents), name, len(consolidated), how),
+		}},
+		StructuredContent: structured,
+	}, nil
+}
+
+// sampleConsolidation asks the client's model to consolidate contents and
+// returns its observations and the model's name.
+func sampleConsolidation(ctx context.Context, contents []string) ([]string, string, error) {
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return nil, "", err
+	}
+	text, model, err := sample(ctx, CreateMessageRequest{
+		Messages: []SamplingMessage{{
+			Role:    "user",
+			Content: ToolContent{Type: "text", Text: string(data)},
+		}},
+		ModelPreferences: &ModelPreferences{IntelligencePriority: 0.8},
+		SystemPrompt:     consolidationPrompt,
+		IncludeContext:   "none",
+		MaxTokens:        consolidationMaxTokens,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	values, err := jsonStringArray(text)
+	if err != nil {
+		return nil, "", err
+	}
+	var consolidated []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			consolidated = append(consolidated, v)
+		}
+	}
+	if len(consolidated) == 0 {
+		return nil, "", fmt.Errorf("the client's model returned no observations")
+	}
+	return consolidated, model, nil
+}
+
+// jsonResult returns v, which marshals to a JSON object, as both the
+// structured content and the text of a tool result.
+func jsonResult(v interface{}) (ToolCallResult, error) {
+	jsonData, err := json.Marshal(v)
 	if err != nil {
 		return ToolCallResult{}, err
 	}
@@ -994,5 +1666,33 @@ func handleOpenNodesToolMCP(database *sql.DB, arguments map[string]interface{})
 			Type: "text",
 			Text: string(jsonData),
 		}},
+		StructuredContent: v,
 	}, nil
 }
+
+// graphResult is the result of the tools answering with entities and the
+// relations between them. Empty lists are [] rather than null so that the
+// structured content matches graphOutputSchema.
+func graphResult(entities []db.Entity, relations []db.Relation) (ToolCallResult, error) {
+	if entities == nil {
+		entities = []db.Entity{}
+	}
+	if relations == nil {
+		relations = []db.Relation{}
+	}
+	return jsonResult(map[string]interface{}{
+		"entities":  entities,
+		"relations": relations,
+	})
+}
+
+// stringsArg keeps the string elements of a JSON array argument.
+func stringsArg(values []interface{}) []string {
+	var out []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"gnolledgegraph/internal/logs"
+)
+
+// Protocol versions this server speaks, newest first. Versions are dates,
+// so a later version compares greater as a string.
+const (
+	ProtocolVersion20250618 = "2025-06-18"
+	ProtocolVersion20250326 = "2025-03-26"
+	ProtocolVersion20241105 = "2024-11-05"
+)
+
+// LatestProtocolVersion is offered to clients asking for a version this
+// server does not speak.
+const LatestProtocolVersion = ProtocolVersion20250618
+
+var supportedProtocolVersions = []string{
+	ProtocolVersion20250618,
+	ProtocolVersion20250326,
+	ProtocolVersion20241105,
+}
+
+// Session is the protocol state of one MCP connection: the version agreed
+// on at initialize, what the client said about itself, whether the client
+// has finished initializing, and what it subscribed to.
+type Session struct {
+	// send delivers a message the server starts to the client; nil when
+	// the transport cannot
+	send func(msg interface{}) error
+
+	mu                 sync.Mutex
+	protocolVersion    string
+	clientInfo         ClientInfo
+	clientCapabilities ClientCapabilities
+	initialized        bool
+	// subscriptions maps subscribed resource URIs to their resourceKey
+	subscriptions map[string]string
+	// unwatch stops change notifications for the session
+	unwatch func()
+	// logLevel and loggerLevels filter the log records sent to a session
+	// that asked for them; unlog stops them
+	logLevel     logs.Level
+	loggerLevels map[string]logs.Level
+	unlog        func()
+	// pending holds the server requests waiting for the client's
+	// response, by requestKey
+	pending       map[string]chan clientResponse
+	lastRequestID int64
+	// inflight holds the client's requests being handled, by requestKey
+	inflight map[string]*inflightRequest
+	closed   bool
+	done     chan struct{}
+}
+
+// NewSession returns the state for a connection that has not been
+// initialized yet. send writes messages the server starts, such as
+// notifications, to the client; it may be nil if the transport has no way
+// to.
+func NewSession(send func(msg interface{}) error) *Session {
+	return &Session{send: send, done: make(chan struct{})}
+}
+
+// Notify sends a notification to the client. It does nothing for
+// stateless requests, transports that cannot send, or before the client
+// has finished initializing.
+func (s *Session) Notify(method string, params interface{}) error {
+	if s == nil || s.send == nil {
+		return nil
+	}
+	s.mu.Lock()
+	ready := s.initialized && !s.closed
+	s.mu.Unlock()
+	if !ready {
+		return nil
+	}
+	return s.send(JSONRPCNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Close ends the session: no more notifications are sent, and requests
+// waiting for the client give up. Transports call it when the connection
+// goes away.
+func (s *Session) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	unwatch, unlog := s.unwatch, s.unlog
+	s.unwatch, s.unlog = nil, nil
+	s.mu.Unlock()
+	if unwatch != nil {
+		unwatch()
+	}
+	if unlog != nil {
+		unlog()
+	}
+}
+
+type sessionKey struct{}
+
+// WithSession returns ctx carrying the connection's session. Requests
+// handled without one are stateless: they are not held to the initialize
+// handshake and get the features of the latest protocol version.
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+func sessionFrom(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// ProtocolVersion returns the negotiated protocol version, or "" before
+// initialize.
+func (s *Session) ProtocolVersion() string {
+	if s == nil {
+		return LatestProtocolVersion
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVersion
+}
+
+// supports reports whether the negotiated protocol version is version or
+// later. Versions are dates, so they order as strings.
+func (s *Session) supports(version string) bool {
+	return s.ProtocolVersion() >= version
+}
+
+// ClientCapabilities returns what the client declared at initialize.
+func (s *Session) ClientCapabilities() ClientCapabilities {
+	if s == nil {
+		return ClientCapabilities{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientCapabilities
+}
+
+// ClientInfo returns the name and version the client gave at initialize.
+func (s *Session) ClientInfo() ClientInfo {
+	if s == nil {
+		return ClientInfo{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientInfo
+}
+
+// admit checks req against the lifecycle: initialize once, then nothing
+// but pings until the client sends notifications/initialized.
+func (s *Session) admit(req JSONRPCRequest) *JSONRPCError {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Method {
+	case "initialize":
+		if s.protocolVersion != "" {
+			return &JSONRPCError{Code: -32600, Message: "Invalid Request: session is already initialized"}
+		}
+	case "notifications/initialized":
+		s.initialized = s.protocolVersion != ""
+	case "ping":
+	default:
+		if !s.initialized {
+			return &JSONRPCError{Code: -32600, Message: "Invalid Request: session is not initialized"}
+		}
+	}
+	return nil
+}
+
+// start records the client's initialize request and returns the version
+// to answer with: the client's own if this server speaks it, otherwise
+// the latest.
+func (s *Session) start(init InitializeRequest) string {
+	version := negotiateProtocolVersion(init.ProtocolVersion)
+	if s == nil {
+		return version
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolVersion = version
+	s.clientInfo = init.ClientInfo
+	s.clientCapabilities = init.Capabilities
+	return version
+}
+
+func negotiateProtocolVersion(requested string) string {
+	for _, version := range supportedProtocolVersions {
+		if version == requested {
+			return version
+		}
+	}
+	return LatestProtocolVersion
+}
+
+// supportedProtocolVersion reports whether this server speaks version.
+func supportedProtocolVersion(version string) bool {
+	return negotiateProtocolVersion(version) == version
+}
+
+// serverCapabilities lists what the server offers a client on version;
+// features a version does not know about are left out.
+func serverCapabilities(version string) ServerCapabilities {
+	capabilities := ServerCapabilities{
+		Tools:     &struct{}{},
+		Resources: &ResourcesCapability{Subscribe: true, ListChanged: true},
+		Prompts:   &PromptsCapability{},
+		Logging:   &struct{}{},
+	}
+	if version >= ProtocolVersion20250326 {
+		capabilities.Completions = &struct{}{}
+	}
+	return capabilities
+}
+
+// decodeParams converts a request's generic params into v.
+func decodeParams(params interface{}, v interface{}) error {
+	if params == nil {
+		return nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,70 @@
+package mcp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInitializeNegotiatesVersion(t *testing.T) {
+	database := setupTestDB(t)
+
+	for requested, want := range map[string]string{
+		ProtocolVersion20241105: ProtocolVersion20241105,
+		ProtocolVersion20250326: ProtocolVersion20250326,
+		"1999-01-01":            LatestProtocolVersion,
+		"":                      LatestProtocolVersion,
+	} {
+		session := NewSession(nil)
+		ctx := WithSession(context.Background(), session)
+		response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "initialize",
+			Params: map[string]interface{}{
+				"protocolVersion": requested,
+				"capabilities":    map[string]interface{}{"sampling": map[string]interface{}{}},
+				"clientInfo":      map[string]interface{}{"name": "test", "version": "0.1"},
+			},
+		})
+		if response.Error != nil {
+			t.Fatalf("%q: initialize failed: %+v", requested, response.Error)
+		}
+		result := response.Result.(InitializeResult)
+		if result.ProtocolVersion != want || session.ProtocolVersion() != want {
+			t.Errorf("%q: expected version %s, got %s (session %s)", requested, want, result.ProtocolVersion, session.ProtocolVersion())
+		}
+		if session.ClientCapabilities().Sampling == nil || session.ClientInfo().Name != "test" {
+			t.Errorf("%q: expected client capabilities and info to be recorded, got %+v %+v", requested, session.ClientCapabilities(), session.ClientInfo())
+		}
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	database := setupTestDB(t)
+	ctx := WithSession(context.Background(), NewSession(nil))
+	call := func(method string) *JSONRPCError {
+		return HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: method}).Error
+	}
+
+	if err := call("tools/list"); err == nil || err.Code != -32600 {
+		t.Errorf("Expected tools/list before initialize to be rejected, got %+v", err)
+	}
+	if err := call("initialize"); err != nil {
+		t.Fatalf("initialize failed: %+v", err)
+	}
+	if err := call("tools/list"); err == nil || err.Code != -32600 {
+		t.Errorf("Expected tools/list before notifications/initialized to be rejected, got %+v", err)
+	}
+	HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+	if err := call("tools/list"); err != nil {
+		t.Errorf("Expected tools/list after initialization, got %+v", err)
+	}
+	if err := call("initialize"); err == nil || err.Code != -32600 {
+		t.Errorf("Expected a second initialize to be rejected, got %+v", err)
+	}
+
+	// Without a session every request is served
+	if response := HandleJSONRPCMethod(database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"}); response.Error != nil {
+		t.Errorf("Expected stateless tools/list to work, got %+v", response.Error)
+	}
+}
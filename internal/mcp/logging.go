@@ -0,0 +1,96 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+
+	"gnolledgegraph/internal/logs"
+)
+
+// Logging. A session that calls logging/setLevel gets the server's log
+// records at or above that level as notifications/message. setLevel may
+// also name a logger, to set the level of that logger alone; other
+// loggers keep the session's level.
+
+// LoggingMessage is the params of notifications/message.
+type LoggingMessage struct {
+	Level  logs.Level  `json:"level"`
+	Logger string      `json:"logger,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+func handleSetLevel(ctx context.Context, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		Level  string `json:"level"`
+		Logger string `json:"logger"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+	level, err := logs.ParseLevel(params.Level)
+	if err != nil {
+		return invalidParams(req, err)
+	}
+
+	session := sessionFrom(ctx)
+	if session == nil || session.send == nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &JSONRPCError{
+				Code:    -32600,
+				Message: "Invalid Request: logging needs a session that can receive notifications",
+			},
+		}
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.unlog == nil && !session.closed {
+		// Loggers without a level of their own stay quiet until the
+		// session sets one
+		session.logLevel = logs.Emergency + 1
+		session.unlog = logs.Subscribe(session.log)
+	}
+	if params.Logger != "" {
+		if session.loggerLevels == nil {
+			session.loggerLevels = make(map[string]logs.Level)
+		}
+		session.loggerLevels[params.Logger] = level
+	} else {
+		session.logLevel = level
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  struct{}{},
+	}
+}
+
+// log sends r to the client if the session asked for its logger's level.
+func (s *Session) log(r logs.Record) {
+	s.mu.Lock()
+	level, ok := s.loggerLevels[r.Logger]
+	if !ok {
+		level = s.logLevel
+	}
+	s.mu.Unlock()
+	if r.Level < level {
+		return
+	}
+
+	data := make(map[string]interface{}, len(r.Fields)+1)
+	for k, v := range r.Fields {
+		data[k] = v
+	}
+	data["message"] = r.Message
+	s.Notify("notifications/message", LoggingMessage{Level: r.Level, Logger: r.Logger, Data: data})
+}
+
+// logValidation records a request the server refused as invalid.
+func logValidation(req JSONRPCRequest, err error) {
+	logs.Log(logs.Warning, "mcp", "invalid params", map[string]interface{}{
+		"method": req.Method,
+		"error":  fmt.Sprint(err),
+	})
+}
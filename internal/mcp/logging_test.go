@@ -0,0 +1,92 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gnolledgegraph/internal/logs"
+)
+
+func TestLogging(t *testing.T) {
+	database := setupTestDB(t)
+	var mu sync.Mutex
+	var messages []LoggingMessage
+	session := NewSession(func(msg interface{}) error {
+		if n, ok := msg.(JSONRPCNotification); ok && n.Method == "notifications/message" {
+			mu.Lock()
+			messages = append(messages, n.Params.(LoggingMessage))
+			mu.Unlock()
+		}
+		return nil
+	})
+	ctx := WithSession(context.Background(), session)
+	response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "initialize", Params: map[string]interface{}{"protocolVersion": ProtocolVersion20250618}})
+	if response.Result.(InitializeResult).Capabilities.Logging == nil {
+		t.Fatal("Expected the logging capability")
+	}
+	HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+	defer session.Close()
+
+	setLevel := func(params map[string]interface{}) JSONRPCResponse {
+		return HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "logging/setLevel", Params: params})
+	}
+	received := func() []LoggingMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		got := messages
+		messages = nil
+		return got
+	}
+
+	// Nothing is sent before the client asks
+	logs.Log(logs.Error, "db", "before", nil)
+	if got := received(); len(got) != 0 {
+		t.Errorf("Expected no logs before logging/setLevel, got %+v", got)
+	}
+
+	if response := setLevel(map[string]interface{}{"level": "warning"}); response.Error != nil {
+		t.Fatalf("logging/setLevel failed: %+v", response.Error)
+	}
+	logs.Log(logs.Info, "db", "too low", nil)
+	logs.Log(logs.Warning, "db", "slow query", map[string]interface{}{"durationMs": 900})
+	got := received()
+	if len(got) != 1 || got[0].Level != logs.Warning || got[0].Logger != "db" {
+		t.Fatalf("Expected the warning only, got %+v", got)
+	}
+	if data := got[0].Data.(map[string]interface{}); data["message"] != "slow query" || data["durationMs"] != 900 {
+		t.Errorf("Expected the record's message and fields as data, got %+v", data)
+	}
+
+	// A logger can have a level of its own
+	setLevel(map[string]interface{}{"level": "debug", "logger": "sync"})
+	setLevel(map[string]interface{}{"level": "error", "logger": "db"})
+	logs.Log(logs.Debug, "sync", "exported database", nil)
+	logs.Log(logs.Warning, "db", "slow query", nil)
+	logs.Log(logs.Warning, "mcp", "invalid request", nil)
+	if got := received(); len(got) != 2 || got[0].Logger != "sync" || got[1].Logger != "mcp" {
+		t.Errorf("Expected sync debug and mcp warning logs, got %+v", got)
+	}
+
+	// Validation failures are logged
+	callTool(ctx, database, "entity_history", map[string]interface{}{})
+	if got := received(); len(got) != 1 || got[0].Logger != "mcp" {
+		t.Errorf("Expected a failed tool call to be logged, got %+v", got)
+	}
+
+	if response := setLevel(map[string]interface{}{"level": "loud"}); response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("Expected an unknown level to be invalid params, got %+v", response)
+	}
+	received()
+
+	session.Close()
+	logs.Log(logs.Emergency, "db", "after close", nil)
+	if got := received(); len(got) != 0 {
+		t.Errorf("Expected no logs after the session closed, got %+v", got)
+	}
+
+	// Stateless requests have nowhere to send logs
+	if response := HandleJSONRPCMethod(nil, JSONRPCRequest{JSONRPC: "2.0", ID: 3, Method: "logging/setLevel", Params: map[string]interface{}{"level": "info"}}); response.Error == nil {
+		t.Error("Expected logging/setLevel to fail without a session")
+	}
+}
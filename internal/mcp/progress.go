@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// In-flight requests. Each request with an ID runs under a context that
+// notifications/cancelled from the client cancels, and that tools pass on
+// to the db package. A request whose params carry _meta.progressToken can
+// report progress with notifications/progress while it runs.
+
+// requestCancelledCode answers requests the client cancelled; clients
+// ignore responses to them.
+const requestCancelledCode = -32800
+
+// progressInterval is the least time between two progress notifications
+// for the same request; the last one is always sent.
+var progressInterval = 100 * time.Millisecond
+
+// inflightRequest is a request being handled for a session.
+type inflightRequest struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// track registers req as in flight and returns the context to handle it
+// under. done unregisters it and reports whether the client cancelled it.
+func (s *Session) track(ctx context.Context, req JSONRPCRequest) (context.Context, func() bool) {
+	// initialize must not be cancelled
+	if s == nil || req.ID == nil || req.Method == "initialize" {
+		return ctx, func() bool { return false }
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	key := requestKey(req.ID)
+	inflight := &inflightRequest{cancel: cancel}
+	s.mu.Lock()
+	if s.inflight == nil {
+		s.inflight = make(map[string]*inflightRequest)
+	}
+	s.inflight[key] = inflight
+	s.mu.Unlock()
+
+	return ctx, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.inflight[key] == inflight {
+			delete(s.inflight, key)
+		}
+		cancel()
+		return inflight.cancelled
+	}
+}
+
+// cancel aborts the in-flight request with the given ID, if any.
+func (s *Session) cancel(id interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inflight := s.inflight[requestKey(id)]; inflight != nil {
+		inflight.cancelled = true
+		inflight.cancel()
+	}
+}
+
+func handleCancelled(ctx context.Context, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		RequestID interface{} `json:"requestId"`
+		Reason    string      `json:"reason"`
+	}
+	if err := decodeParams(req.Params, &params); err == nil && params.RequestID != nil {
+		if session := sessionFrom(ctx); session != nil {
+			session.cancel(params.RequestID)
+		}
+	}
+	return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID}
+}
+
+// ProgressNotification is the params of notifications/progress.
+type ProgressNotification struct {
+	ProgressToken interface{} `json:"progressToken"`
+	Progress      float64     `json:"progress"`
+	Total         float64     `json:"total,omitempty"`
+	Message       string      `json:"message,omitempty"`
+}
+
+type progressKey struct{}
+
+// progressReporter sends the progress of one request.
+type progressReporter struct {
+	session *Session
+	send    func(msg interface{}) error
+	token   interface{}
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// withProgress returns ctx that reportProgress reports to, if req asked
+// for progress and the session can send it.
+func withProgress(ctx context.Context, req JSONRPCRequest) context.Context {
+	session := sessionFrom(ctx)
+	if session == nil || session.send == nil {
+		return ctx
+	}
+	var params struct {
+		Meta struct {
+			ProgressToken interface{} `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if decodeParams(req.Params, &params) != nil || params.Meta.ProgressToken == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressReporter{
+		session: session,
+		send:    session.sender(ctx),
+		token:   params.Meta.ProgressToken,
+	})
+}
+
+// reportProgress tells the client how far the request ctx belongs to has
+// got: done of total, with total 0 if unknown. Reports come at most every
+// progressInterval, except the last.
+func reportProgress(ctx context.Context, done, total int, message string) {
+	p, _ := ctx.Value(progressKey{}).(*progressReporter)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if done != total && time.Since(p.last) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.last = time.Now()
+	p.mu.Unlock()
+
+	// Messages are new in 2025-03-26
+	if !p.session.supports(ProtocolVersion20250326) {
+		message = ""
+	}
+	p.send(JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  "notifications/progress",
+		Params: ProgressNotification{
+			ProgressToken: p.token,
+			Progress:      float64(done),
+			Total:         float64(total),
+			Message:       message,
+		},
+	})
+}
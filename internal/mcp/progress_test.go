@@ -0,0 +1,150 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	response := HandleJSONRPCMethod(nil, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "ping"})
+	if response.Error != nil || response.Result == nil {
+		t.Errorf("Expected an empty result, got %+v", response)
+	}
+
+	// ping is allowed before the session is initialized
+	session := NewSession(nil)
+	response = HandleJSONRPCMethodContext(WithSession(context.Background(), session), nil, JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "ping"})
+	if response.Error != nil {
+		t.Errorf("Expected ping to work before initialization, got %+v", response.Error)
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	SetConfirmThreshold(1)
+	t.Cleanup(func() { SetConfirmThreshold(DefaultConfirmThreshold) })
+	database := seedPeople(t, 2)
+
+	// The client never answers the confirmation, so the delete waits until
+	// it is cancelled
+	asked := make(chan struct{}, 1)
+	session := NewSession(func(msg interface{}) error {
+		if req, ok := msg.(JSONRPCRequest); ok && req.Method == "elicitation/create" {
+			asked <- struct{}{}
+		}
+		return nil
+	})
+	session.start(InitializeRequest{
+		ProtocolVersion: ProtocolVersion20250618,
+		Capabilities:    ClientCapabilities{Elicitation: &struct{}{}},
+	})
+	session.initialized = true
+	defer session.Close()
+	ctx := WithSession(context.Background(), session)
+
+	responses := make(chan JSONRPCResponse, 1)
+	go func() {
+		responses <- HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      "delete-1",
+			Method:  "tools/call",
+			Params: map[string]interface{}{
+				"name":      "delete_entities",
+				"arguments": map[string]interface{}{"entityNames": []interface{}{"Person 0", "Person 1"}},
+			},
+		})
+	}()
+	<-asked
+
+	HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]interface{}{"requestId": "delete-1", "reason": "user aborted"},
+	})
+	select {
+	case response := <-responses:
+		if response.Error == nil || response.Error.Code != requestCancelledCode {
+			t.Errorf("Expected a cancelled request, got %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelled request did not finish")
+	}
+	if result := callTool(context.Background(), database, "open_nodes", map[string]interface{}{"names": []interface{}{"Person 0"}}); !strings.Contains(result.Content[0].Text, "Person 0") {
+		t.Errorf("Expected the cancelled delete to leave Person 0, got %s", result.Content[0].Text)
+	}
+
+	// Cancelling requests that are not in flight is ignored
+	response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]interface{}{"requestId": "delete-1"},
+	})
+	if response.Error != nil {
+		t.Errorf("Expected a late cancellation to be ignored, got %+v", response.Error)
+	}
+}
+
+func TestProgressNotifications(t *testing.T) {
+	interval := progressInterval
+	progressInterval = 0
+	t.Cleanup(func() { progressInterval = interval })
+	database := seedPeople(t, 3)
+
+	var mu sync.Mutex
+	var progress []ProgressNotification
+	session := NewSession(func(msg interface{}) error {
+		if n, ok := msg.(JSONRPCNotification); ok && n.Method == "notifications/progress" {
+			mu.Lock()
+			progress = append(progress, n.Params.(ProgressNotification))
+			mu.Unlock()
+		}
+		return nil
+	})
+	session.start(InitializeRequest{ProtocolVersion: ProtocolVersion20250618})
+	session.initialized = true
+	defer session.Close()
+	ctx := WithSession(context.Background(), session)
+
+	call := func(name string, arguments map[string]interface{}, token interface{}) []ProgressNotification {
+		t.Helper()
+		mu.Lock()
+		progress = nil
+		mu.Unlock()
+		params := map[string]interface{}{"name": name, "arguments": arguments}
+		if token != nil {
+			params["_meta"] = map[string]interface{}{"progressToken": token}
+		}
+		response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/call", Params: params})
+		if response.Error != nil || response.Result.(ToolCallResult).IsError {
+			t.Fatalf("%s failed: %+v", name, response)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return progress
+	}
+
+	entities := []interface{}{}
+	for _, name := range []string{"Ann", "Ben", "Cid", "Dot"} {
+		entities = append(entities, map[string]interface{}{"name": name, "entityType": "person"})
+	}
+	got := call("create_entities", map[string]interface{}{"entities": entities}, "import")
+	if len(got) != 5 {
+		t.Fatalf("Expected progress before each entity and at the end, got %+v", got)
+	}
+	last := got[len(got)-1]
+	if last.ProgressToken != "import" || last.Progress != 4 || last.Total != 4 || last.Message != "Created 4 entities" {
+		t.Errorf("Expected the last report to be complete, got %+v", last)
+	}
+
+	got = call("traverse_graph", map[string]interface{}{"names": []interface{}{"Acme"}, "depth": 2.0}, 7.0)
+	if len(got) == 0 || got[len(got)-1].ProgressToken != 7.0 || got[len(got)-1].Total != 2 {
+		t.Errorf("Expected traversal progress out of 2 hops, got %+v", got)
+	}
+
+	// No token, no progress
+	if got := call("traverse_graph", map[string]interface{}{"names": []interface{}{"Acme"}}, nil); len(got) != 0 {
+		t.Errorf("Expected no progress without a token, got %+v", got)
+	}
+}
@@ -0,0 +1,408 @@
+package mcp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
+)
+
+// Prompts are reusable instructions that embed live graph data. The
+// built-in ones are below; teams add their own as JSON files in the
+// directory given to SetPromptsDir:
+//
+//	{
+//	  "description": "Prepare a one-on-one",
+//	  "arguments": [{"name": "person", "required": true}],
+//	  "messages": [{"role": "user", "text": "Prepare my 1:1 with {{.person}}.\n\n{{neighbors .person}}"}]
+//	}
+//
+// The prompt is named after the file unless it has a "name". Message texts
+// are Go templates over the arguments, with the functions in promptFuncs.
+// A file named like a built-in prompt replaces it.
+
+type PromptsCapability struct {
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type PromptsListResult struct {
+	Prompts    []Prompt `json:"prompts"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+type PromptMessage struct {
+	Role    string      `json:"role"`
+	Content ToolContent `json:"content"`
+}
+
+type GetPromptResult struct {
+	Description string          `json:"description,omitempty"`
+	Messages    []PromptMessage `json:"messages"`
+}
+
+// promptTemplate is a prompt and how to fill it in.
+type promptTemplate struct {
+	Prompt
+	render func(database *sql.DB, args map[string]string) ([]PromptMessage, error)
+}
+
+// errPromptArgument marks errors caused by the arguments a client passed.
+var errPromptArgument = errors.New("invalid prompt argument")
+
+var (
+	promptsDirMu sync.RWMutex
+	promptsDir   string
+)
+
+// SetPromptsDir makes the JSON prompt templates in dir available next to
+// the built-in prompts. The directory is read on every request, so edits
+// take effect without a restart.
+func SetPromptsDir(dir string) {
+	promptsDirMu.Lock()
+	defer promptsDirMu.Unlock()
+	promptsDir = dir
+}
+
+var builtinPrompts = []promptTemplate{
+	{
+		Prompt: Prompt{
+			Name:        "entity_briefing",
+			Description: "Summarize what the knowledge graph knows about an entity, with its observations and neighbors",
+			Arguments: []PromptArgument{
+				{Name: "name", Description: "Name of the entity", Required: true},
+				{Name: "depth", Description: "How many hops of neighbors to include (default 1)"},
+			},
+		},
+		render: renderEntityBriefing,
+	},
+	{
+		Prompt: Prompt{
+			Name:        "record_meeting",
+			Description: "Record meeting notes into memory as entities, relations and observations, reusing existing names and types",
+			Arguments: []PromptArgument{
+				{Name: "notes", Description: "The meeting notes or transcript", Required: true},
+				{Name: "title", Description: "Title of the meeting (optional)"},
+			},
+		},
+		render: renderRecordMeeting,
+	},
+	{
+		Prompt: Prompt{
+			Name:        "memory_hygiene",
+			Description: "Review entities whose names look like duplicates and propose how to merge them",
+		},
+		render: renderMemoryHygiene,
+	},
+}
+
+func handlePromptsList(req JSONRPCRequest) JSONRPCResponse {
+	prompts := loadPrompts()
+	result := PromptsListResult{Prompts: make([]Prompt, 0, len(prompts))}
+	for _, p := range prompts {
+		result.Prompts = append(result.Prompts, p.Prompt)
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  result,
+	}
+}
+
+func handlePromptsGet(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+
+	prompt := findPrompt(params.Name)
+	if prompt == nil {
+		return invalidParams(req, fmt.Errorf("unknown prompt %q", params.Name))
+	}
+	args := make(map[string]string, len(prompt.Arguments))
+	for _, a := range prompt.Arguments {
+		value := params.Arguments[a.Name]
+		if a.Required && value == "" {
+			return invalidParams(req, fmt.Errorf("missing required argument %q", a.Name))
+		}
+		args[a.Name] = value
+	}
+
+	messages, err := prompt.render(database, args)
+	if errors.Is(err, errPromptArgument) || errors.Is(err, db.ErrEntityNotFound) {
+		return invalidParams(req, err)
+	}
+	if err != nil {
+		return internalError(req, err)
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  GetPromptResult{Description: prompt.Description, Messages: messages},
+	}
+}
+
+// findPrompt returns the prompt with the given name, or nil.
+func findPrompt(name string) *promptTemplate {
+	for _, p := range loadPrompts() {
+		if p.Name == name {
+			return &p
+		}
+	}
+	return nil
+}
+
+// loadPrompts returns the built-in prompts and those in the prompts
+// directory, ordered by name. Files that cannot be read are logged and
+// skipped.
+func loadPrompts() []promptTemplate {
+	byName := make(map[string]promptTemplate)
+	for _, p := range builtinPrompts {
+		byName[p.Name] = p
+	}
+
+	promptsDirMu.RLock()
+	dir := promptsDir
+	promptsDirMu.RUnlock()
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			logs.Log(logs.Error, "mcp", "reading prompts", map[string]interface{}{"error": err.Error()})
+		}
+		for _, file := range files {
+			p, err := loadPromptFile(file)
+			if err != nil {
+				logs.Log(logs.Warning, "mcp", "skipping prompt file", map[string]interface{}{"file": file, "error": err.Error()})
+				continue
+			}
+			byName[p.Name] = p
+		}
+	}
+
+	prompts := make([]promptTemplate, 0, len(byName))
+	for _, p := range byName {
+		prompts = append(prompts, p)
+	}
+	sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+	return prompts
+}
+
+// loadPromptFile reads a prompt template from a JSON file.
+func loadPromptFile(file string) (promptTemplate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return promptTemplate{}, err
+	}
+	var def struct {
+		Prompt
+		Messages []struct {
+			Role string `json:"role"`
+			Text string `json:"text"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &def); err != nil {
+		return promptTemplate{}, err
+	}
+	if def.Name == "" {
+		def.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if len(def.Messages) == 0 {
+		return promptTemplate{}, errors.New("no messages")
+	}
+
+	templates := make([]*template.Template, len(def.Messages))
+	for i, m := range def.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return promptTemplate{}, fmt.Errorf("message %d: role must be user or assistant", i+1)
+		}
+		// Functions are bound to a database when the prompt is rendered
+		t, err := template.New(def.Name).Option("missingkey=zero").Funcs(promptFuncs(nil)).Parse(m.Text)
+		if err != nil {
+			return promptTemplate{}, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		templates[i] = t
+	}
+
+	return promptTemplate{
+		Prompt: def.Prompt,
+		render: func(database *sql.DB, args map[string]string) ([]PromptMessage, error) {
+			messages := make([]PromptMessage, len(templates))
+			for i, t := range templates {
+				var b bytes.Buffer
+				if err := t.Funcs(promptFuncs(database)).Execute(&b, args); err != nil {
+					return nil, err
+				}
+				messages[i] = textMessage(def.Messages[i].Role, b.String())
+			}
+			return messages, nil
+		},
+	}, nil
+}
+
+// promptFuncs are the functions prompt templates can call to embed graph
+// data as Markdown:
+//
+//	entity NAME       the entity with its observations and relations
+//	neighbors NAME    the entity and everything one hop away
+//	search QUERY      the entities search_nodes finds
+//	entityTypes       the entity types in use, comma separated
+func promptFuncs(database *sql.DB) template.FuncMap {
+	return template.FuncMap{
+		"entity": func(name string) (string, error) {
+			subgraph, err := db.ReadEntity(database, name)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", name, err)
+			}
+			return subgraphMarkdown("", subgraph), nil
+		},
+		"neighbors": func(name string) (string, error) {
+			subgraph, err := neighborhood(database, name, 1)
+			if err != nil {
+				return "", err
+			}
+			return subgraphMarkdown("Neighborhood of "+name, subgraph), nil
+		},
+		"search": func(query string) (string, error) {
+			entities, _, err := db.SearchNodes(database, query)
+			if err != nil {
+				return "", err
+			}
+			var names []string
+			for _, e := range entities {
+				names = append(names, e.Name)
+			}
+			subgraph, err := db.ReadEntities(database, names)
+			if err != nil {
+				return "", err
+			}
+			return subgraphMarkdown("Search results for "+query, subgraph), nil
+		},
+		"entityTypes": func() (string, error) {
+			return entityTypeList(database)
+		},
+	}
+}
+
+// neighborhood returns name and the entities within depth hops of it.
+func neighborhood(database *sql.DB, name string, depth int) (*db.Subgraph, error) {
+	subgraph, err := db.Traverse(database, []string{name}, db.TraverseOptions{Depth: depth})
+	if err != nil {
+		return nil, err
+	}
+	if len(subgraph.Entities) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, db.ErrEntityNotFound)
+	}
+	return subgraph, nil
+}
+
+func entityTypeList(database *sql.DB) (string, error) {
+	types, err := db.EntityTypes(database)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.Type
+	}
+	return strings.Join(names, ", "), nil
+}
+
+func textMessage(role, text string) PromptMessage {
+	return PromptMessage{Role: role, Content: ToolContent{Type: "text", Text: text}}
+}
+
+func renderEntityBriefing(database *sql.DB, args map[string]string) ([]PromptMessage, error) {
+	depth := 1
+	if args["depth"] != "" {
+		n, err := strconv.Atoi(args["depth"])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%w: depth must be a positive number", errPromptArgument)
+		}
+		depth = n
+	}
+	name := args["name"]
+	subgraph, err := neighborhood(database, name, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Summarize what we know about %s: who or what it is, the key facts, and how it relates to the entities around it. "+
+		"Point out anything that looks outdated or contradictory. Only use the knowledge graph data below.\n\n%s",
+		name, subgraphMarkdown(fmt.Sprintf("Knowledge graph around %s", name), subgraph))
+	return []PromptMessage{textMessage("user", text)}, nil
+}
+
+func renderRecordMeeting(database *sql.DB, args map[string]string) ([]PromptMessage, error) {
+	types, err := entityTypeList(database)
+	if err != nil {
+		return nil, err
+	}
+	if types == "" {
+		types = "(none yet)"
+	}
+	title := "the meeting below"
+	if args["title"] != "" {
+		title = fmt.Sprintf("the meeting %q below", args["title"])
+	}
+
+	text := fmt.Sprintf("Record %s into the knowledge graph.\n\n"+
+		"1. Use search_nodes to find the people, projects and organizations mentioned, and reuse their exact names.\n"+
+		"2. Create entities only for things that are not in the graph yet, preferring these existing entity types: %s.\n"+
+		"3. Add decisions, action items and new facts as observations on the entities they are about, one fact per observation.\n"+
+		"4. Create relations in active voice (for example attends, owns, works_on) for new connections.\n\n"+
+		"Meeting notes:\n\n%s", title, types, args["notes"])
+	return []PromptMessage{textMessage("user", text)}, nil
+}
+
+func renderMemoryHygiene(database *sql.DB, args map[string]string) ([]PromptMessage, error) {
+	groups, err := db.NearDuplicates(database)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return []PromptMessage{textMessage("user", "The knowledge graph has no entities whose names look like duplicates. Confirm that no cleanup is needed.")}, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("These groups of entities have names that differ only in case, spacing, punctuation or plural. " +
+		"For each group, read the entities with open_nodes, decide whether they are the same thing, and if so propose which name to keep " +
+		"and which observations and relations to move before deleting the others. Do not change anything until I confirm.\n\n")
+	for i, group := range groups {
+		fmt.Fprintf(&b, "%d.", i+1)
+		for j, e := range group {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, " %s (%s)", e.Name, e.Type)
+		}
+		b.WriteString("\n")
+	}
+	return []PromptMessage{textMessage("user", b.String())}, nil
+}
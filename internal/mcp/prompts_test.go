@@ -0,0 +1,77 @@
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+func TestPrompts(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateEntity(database, "Acme", "organization")
+	db.CreateEntity(database, "acme", "organization")
+	db.CreateObservation(database, "Alice", "likes tea")
+	db.CreateRelation(database, "Alice", "Acme", "works_at")
+
+	dir := t.TempDir()
+	SetPromptsDir(dir)
+	t.Cleanup(func() { SetPromptsDir("") })
+	os.WriteFile(filepath.Join(dir, "one_on_one.json"), []byte(`{
+		"description": "Prepare a one-on-one",
+		"arguments": [{"name": "person", "required": true}],
+		"messages": [{"role": "user", "text": "Prepare my 1:1 with {{.person}}.\n\n{{entity .person}}"}]
+	}`), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"messages": [{"role": "user", "text": "{{"}]}`), 0644)
+
+	get := func(name string, args map[string]interface{}) JSONRPCResponse {
+		return HandleJSONRPCMethod(database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "prompts/get", Params: map[string]interface{}{"name": name, "arguments": args}})
+	}
+	text := func(response JSONRPCResponse) string {
+		t.Helper()
+		if response.Error != nil {
+			t.Fatalf("prompts/get failed: %+v", response.Error)
+		}
+		return response.Result.(GetPromptResult).Messages[0].Content.Text
+	}
+
+	list := HandleJSONRPCMethod(database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "prompts/list"})
+	var names []string
+	for _, p := range list.Result.(PromptsListResult).Prompts {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "entity_briefing,memory_hygiene,one_on_one,record_meeting" {
+		t.Errorf("Unexpected prompts %v", names)
+	}
+
+	if briefing := text(get("entity_briefing", map[string]interface{}{"name": "Alice"})); !strings.Contains(briefing, "likes tea") || !strings.Contains(briefing, "## Acme") {
+		t.Errorf("Expected Alice's observation and neighbor in the briefing:\n%s", briefing)
+	}
+	if meeting := text(get("record_meeting", map[string]interface{}{"notes": "Bob joined"})); !strings.Contains(meeting, "organization, person") || !strings.Contains(meeting, "Bob joined") {
+		t.Errorf("Expected entity types and notes in the meeting prompt:\n%s", meeting)
+	}
+	if hygiene := text(get("memory_hygiene", nil)); !strings.Contains(hygiene, "1. Acme (organization), acme (organization)") {
+		t.Errorf("Expected the Acme duplicates:\n%s", hygiene)
+	}
+	if custom := text(get("one_on_one", map[string]interface{}{"person": "Alice"})); !strings.HasPrefix(custom, "Prepare my 1:1 with Alice.\n\n# Alice") {
+		t.Errorf("Unexpected custom prompt:\n%s", custom)
+	}
+
+	for _, bad := range []struct {
+		name string
+		args map[string]interface{}
+	}{
+		{"nope", nil},
+		{"entity_briefing", nil},
+		{"entity_briefing", map[string]interface{}{"name": "Nobody"}},
+		{"entity_briefing", map[string]interface{}{"name": "Alice", "depth": "x"}},
+		{"one_on_one", map[string]interface{}{"person": "Nobody"}},
+	} {
+		if response := get(bad.name, bad.args); response.Error == nil || response.Error.Code != -32602 {
+			t.Errorf("%s %v: expected invalid params, got %+v", bad.name, bad.args, response.Error)
+		}
+	}
+}
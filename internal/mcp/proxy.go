@@ -0,0 +1,413 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/logs"
+)
+
+// The stdio proxy: a stdio process that forwards its client's messages to
+// a server already running elsewhere, over Streamable HTTP, so that any
+// number of clients launching the binary share one server, and one writer
+// to the database.
+
+const (
+	// proxyResumes is how often a dropped response stream is picked up
+	// again with Last-Event-ID before the request is given up.
+	proxyResumes = 3
+	// proxyRetry is how long the proxy waits before reopening a dropped
+	// GET stream.
+	proxyRetry = time.Second
+	// proxyCloseTimeout bounds ending the session when the client goes.
+	proxyCloseTimeout = 5 * time.Second
+)
+
+// ProxyStdio forwards the JSON-RPC messages of a stdio client, read from r
+// one per line, to the Streamable HTTP endpoint at url, and writes what
+// the server sends back to w: responses, and the requests and
+// notifications it sends on response streams or on the session's GET
+// stream. Requests are forwarded concurrently, everything else in order.
+// When r ends, requests the server is still waiting on the client for are
+// answered with an error, the requests in progress are finished and
+// answered, and the session is ended before ProxyStdio returns nil; when
+// ctx is done the session is ended and ctx.Err() is returned.
+func ProxyStdio(ctx context.Context, url string, r io.Reader, w io.Writer) error {
+	p := &stdioProxy{
+		conn:    &streamableConn{url: url, client: &http.Client{}},
+		out:     &lineWriter{w: w},
+		pending: make(map[string]interface{}),
+	}
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() {
+		readErr <- readLines(r, lines, stop)
+	}()
+	defer close(stop)
+
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	var requests sync.WaitGroup
+	listening := false
+	for {
+		var line []byte
+		select {
+		case line = <-lines:
+		case err := <-readErr:
+			if err != nil {
+				logs.Log(logs.Error, "mcp", "stdio read failed", map[string]interface{}{"error": err.Error()})
+			}
+			p.disconnect()
+			requests.Wait()
+			stopListening()
+			p.conn.close()
+			return nil
+		case <-ctx.Done():
+			stopListening()
+			p.conn.close()
+			requests.Wait()
+			return ctx.Err()
+		}
+
+		var msg clientResponse
+		json.Unmarshal(line, &msg)
+		switch {
+		case msg.Method == "initialize":
+			// Everything after initialize needs its session
+			p.forward(ctx, line, msg.ID)
+			if !listening && p.conn.session() != "" {
+				listening = true
+				go p.conn.listen(listenCtx, p.deliver)
+			}
+		case IsBatch(line) || (msg.Method != "" && msg.ID != nil):
+			requests.Add(1)
+			go func(id interface{}) {
+				defer requests.Done()
+				p.forward(ctx, line, id)
+			}(msg.ID)
+		default:
+			// Notifications and responses to the server's requests
+			if msg.Method == "" && msg.ID != nil {
+				p.answered(msg.ID)
+			}
+			p.forward(ctx, line, nil)
+		}
+	}
+}
+
+// stdioProxy is the state of ProxyStdio.
+type stdioProxy struct {
+	conn *streamableConn
+	out  *lineWriter
+
+	mu sync.Mutex
+	// pending holds the IDs of the server's requests the client has not
+	// answered yet, by requestKey
+	pending map[string]interface{}
+	// disconnected is set once the client has gone
+	disconnected bool
+}
+
+// forward posts a client message to the server and writes what comes
+// back. A request that cannot be forwarded is answered with an error.
+func (p *stdioProxy) forward(ctx context.Context, data []byte, id interface{}) {
+	err := p.conn.post(ctx, data, p.deliver)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	logs.Log(logs.Warning, "mcp", "forwarding to the server failed", map[string]interface{}{"url": p.conn.url, "error": err.Error()})
+	if id != nil || IsBatch(data) {
+		p.write(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Error:   &JSONRPCError{Code: -32000, Message: fmt.Sprintf("forwarding to %s failed: %v", p.conn.url, err)},
+		})
+	}
+}
+
+// deliver writes a message from the server to the client, noting the
+// server's requests so that they can be answered if the client goes.
+func (p *stdioProxy) deliver(data []byte) {
+	var msg clientResponse
+	if json.Unmarshal(data, &msg) == nil && msg.Method != "" && msg.ID != nil {
+		p.mu.Lock()
+		gone := p.disconnected
+		if !gone {
+			p.pending[requestKey(msg.ID)] = msg.ID
+		}
+		p.mu.Unlock()
+		if gone {
+			p.refuse(msg.ID)
+			return
+		}
+	}
+	if err := p.out.write(data); err != nil {
+		logs.Log(logs.Error, "mcp", "stdio write failed", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+func (p *stdioProxy) write(msg interface{}) {
+	if err := p.out.send(msg); err != nil {
+		logs.Log(logs.Error, "mcp", "stdio write failed", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// answered notes that the client answered the server's request id.
+func (p *stdioProxy) answered(id interface{}) {
+	p.mu.Lock()
+	delete(p.pending, requestKey(id))
+	p.mu.Unlock()
+}
+
+// disconnect answers the server's requests that the client will not
+// answer any more, now and from now on.
+func (p *stdioProxy) disconnect() {
+	p.mu.Lock()
+	p.disconnected = true
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	for _, id := range pending {
+		p.refuse(id)
+	}
+}
+
+// refuse answers the server's request id for a client that has gone.
+func (p *stdioProxy) refuse(id interface{}) {
+	data, _ := json.Marshal(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &JSONRPCError{Code: -32000, Message: "client disconnected"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCloseTimeout)
+	defer cancel()
+	p.conn.post(ctx, data, p.deliver)
+}
+
+// streamableConn is the client side of a Streamable HTTP session.
+type streamableConn struct {
+	url    string
+	client *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func (c *streamableConn) session() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+func (c *streamableConn) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url, reader)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.sessionID != "" {
+		req.Header.Set(SessionHeader, c.sessionID)
+	}
+	if c.protocolVersion != "" {
+		req.Header.Set(ProtocolVersionHeader, c.protocolVersion)
+	}
+	c.mu.Unlock()
+	return req, nil
+}
+
+// post sends a message to the server and passes what it answers with to
+// deliver: nothing for notifications and responses, otherwise the JSON
+// response or every message of the stream opened for it. It fails when
+// the server cannot be reached or answers with something other than
+// JSON-RPC.
+func (c *streamableConn) post(ctx context.Context, data []byte, deliver func([]byte)) error {
+	req, err := c.newRequest(ctx, http.MethodPost, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if id := resp.Header.Get(SessionHeader); id != "" {
+		c.mu.Lock()
+		c.sessionID = id
+		c.mu.Unlock()
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		return nil
+	case mediaType == "text/event-stream":
+		return c.readStream(ctx, resp.Body, deliver)
+	case mediaType == "application/json":
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		c.noteVersion(body)
+		deliver(bytes.TrimSpace(body))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+// noteVersion remembers the protocol version agreed on if data is the
+// response to initialize, to send it with every request after.
+func (c *streamableConn) noteVersion(data []byte) {
+	var response struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(data, &response) == nil && response.Result.ProtocolVersion != "" {
+		c.mu.Lock()
+		c.protocolVersion = response.Result.ProtocolVersion
+		c.mu.Unlock()
+	}
+}
+
+// readStream passes the messages of a response stream to deliver until
+// the server ends it, picking it up again if it drops.
+func (c *streamableConn) readStream(ctx context.Context, body io.Reader, deliver func([]byte)) error {
+	lastEventID := ""
+	onEvent := func(id string, data []byte) {
+		if id != "" {
+			lastEventID = id
+		}
+		c.noteVersion(data)
+		deliver(data)
+	}
+	err := readEvents(body, onEvent)
+	for resumes := 0; err != nil && ctx.Err() == nil && lastEventID != "" && resumes < proxyResumes; resumes++ {
+		var resp *http.Response
+		if resp, err = c.get(ctx, lastEventID); err != nil {
+			continue
+		}
+		err = readEvents(resp.Body, onEvent)
+		resp.Body.Close()
+	}
+	return err
+}
+
+// listen passes the messages of the session's GET stream to deliver until
+// ctx is done, reopening the stream when it drops. It gives up if the
+// server has no GET stream or the session has ended.
+func (c *streamableConn) listen(ctx context.Context, deliver func([]byte)) {
+	lastEventID := ""
+	for ctx.Err() == nil {
+		resp, err := c.get(ctx, lastEventID)
+		if err == nil {
+			err = readEvents(resp.Body, func(id string, data []byte) {
+				if id != "" {
+					lastEventID = id
+				}
+				deliver(data)
+			})
+			resp.Body.Close()
+		} else if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			logs.Log(logs.Warning, "mcp", "no stream of server messages", map[string]interface{}{"url": c.url, "error": err.Error()})
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(proxyRetry):
+		}
+	}
+}
+
+// get opens the session's GET stream, resuming after lastEventID if set.
+// On failure it returns the response, if any, with its body closed.
+func (c *streamableConn) get(ctx context.Context, lastEventID string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return resp, fmt.Errorf("GET %s: %s", c.url, resp.Status)
+	}
+	return resp, nil
+}
+
+// close ends the session on the server.
+func (c *streamableConn) close() {
+	if c.session() == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCloseTimeout)
+	defer cancel()
+	req, err := c.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := c.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// readEvents passes the id and data of every event of an SSE stream to
+// fn, and returns nil when the stream ends cleanly.
+func readEvents(r io.Reader, fn func(id string, data []byte)) error {
+	reader := bufio.NewReader(r)
+	var id string
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			if data != nil {
+				fn(id, data)
+			}
+			data = nil
+		case field == "id":
+			id = value
+		case field == "data":
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+// proxyPipe starts ProxyStdio to url on pipes and returns its client end.
+func proxyPipe(t *testing.T, ctx context.Context, url string) *stdioPipe {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	p := &stdioPipe{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		p.done <- ProxyStdio(ctx, url, inR, outW)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return p
+}
+
+func TestProxyStdio(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateObservation(database, "Alice", "likes tea")
+	db.CreateObservation(database, "Alice", "likes tea with milk")
+	server := newStreamableServer(database)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	p := proxyPipe(t, context.Background(), ts.URL)
+	params, _ := json.Marshal(InitializeRequest{
+		ProtocolVersion: ProtocolVersion20250618,
+		Capabilities:    ClientCapabilities{Sampling: &struct{}{}},
+		ClientInfo:      ClientInfo{Name: "proxied", Version: "1.0"},
+	})
+	p.write(`{"jsonrpc":"2.0","id":"init","method":"initialize","params":` + string(params) + `}`)
+	if msg := p.read(); msg["id"] != "init" || msg["result"] == nil {
+		t.Fatalf("Expected the initialize result, got %v", msg)
+	}
+	p.write(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	// The server's requests reach the client and its answers the server
+	p.write(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"consolidate_entity","arguments":{"name":"Alice"}}}`)
+	request := p.read()
+	if request["method"] != "sampling/createMessage" {
+		t.Fatalf("Expected a sampling request, got %v", request)
+	}
+	p.write(`{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	if msg := p.read(); msg["id"] != 2.0 {
+		t.Fatalf("Expected the ping to be answered while sampling, got %v", msg)
+	}
+	answer, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": request["id"], "result": CreateMessageResult{
+		Role: "assistant", Content: ToolContent{Type: "text", Text: `["drinks tea with milk"]`}, Model: "test-model",
+	}})
+	p.write(string(answer))
+	if msg := p.read(); msg["id"] != 1.0 || !strings.Contains(fmt.Sprint(msg["result"]), "method:sampling") {
+		t.Fatalf("Expected the sampled consolidation, got %v", msg)
+	}
+	changes, _ := db.EntityHistory(database, "Alice")
+	if last := changes[len(changes)-1]; last.Source != "proxied" {
+		t.Errorf("Expected writes attributed to the proxied client, got %+v", last)
+	}
+
+	// Errors from the server come through as they are
+	p.write(`{"jsonrpc":"2.0","id":3,"method":`)
+	if msg, _ := p.out.ReadString('\n'); !strings.Contains(msg, `"code":-32700`) {
+		t.Errorf("Expected a parse error, got %s", msg)
+	}
+
+	// At EOF the request waiting for the client is refused and answered,
+	// then the session ends
+	db.CreateObservation(database, "Alice", "Drinks tea with milk.")
+	p.write(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"consolidate_entity","arguments":{"name":"Alice"}}}`)
+	if request := p.read(); request["method"] != "sampling/createMessage" {
+		t.Fatalf("Expected a sampling request, got %v", request)
+	}
+	p.in.Close()
+	if msg := p.read(); msg["id"] != 4.0 || !strings.Contains(fmt.Sprint(msg["result"]), "samplingError") {
+		t.Errorf("Expected the tool call to fall back to merging, got %v", msg)
+	}
+	select {
+	case err := <-p.done:
+		if err != nil {
+			t.Errorf("Expected ProxyStdio to end cleanly at EOF, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ProxyStdio did not return at EOF")
+	}
+	server.mu.Lock()
+	sessions := len(server.sessions)
+	server.mu.Unlock()
+	if sessions != 0 {
+		t.Errorf("Expected the session to be ended, got %d sessions", sessions)
+	}
+}
+
+func TestProxyStdioUnreachable(t *testing.T) {
+	ts := httptest.NewServer(nil)
+	url := ts.URL
+	ts.Close()
+
+	p := proxyPipe(t, context.Background(), url)
+	p.write(`{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	if msg := p.read(); msg["id"] != 1.0 || errorCode(msg) != -32000 {
+		t.Errorf("Expected an error for an unreachable server, got %v", msg)
+	}
+	p.in.Close()
+	if err := <-p.done; err != nil {
+		t.Errorf("Expected ProxyStdio to end cleanly at EOF, got %v", err)
+	}
+}
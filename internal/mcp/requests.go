@@ -0,0 +1,128 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Requests the server sends to the client, such as elicitation/create.
+// Transports hand every incoming message to Session.HandleResponse first,
+// which routes responses to the request waiting for them.
+
+var errCannotRequest = errors.New("the client cannot be sent requests on this connection")
+
+var errSessionClosed = errors.New("session closed")
+
+// clientResponse is a JSON-RPC response from the client. Method is only
+// decoded to tell responses from requests.
+type clientResponse struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *JSONRPCError   `json:"error"`
+}
+
+// requestKey normalizes a request ID: ours are integers, and come back
+// from the client as JSON numbers.
+func requestKey(id interface{}) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+type relatedSendKey struct{}
+
+// withRelatedSend returns ctx in which requests and notifications the
+// server sends while handling a client request go out through send
+// instead of the session's own channel, so that they arrive on the stream
+// of that request.
+func withRelatedSend(ctx context.Context, send func(msg interface{}) error) context.Context {
+	return context.WithValue(ctx, relatedSendKey{}, send)
+}
+
+// request sends method to the client and waits for its result, until ctx
+// is done or the session is closed.
+func (s *Session) request(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if s == nil || s.send == nil {
+		return nil, errCannotRequest
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errSessionClosed
+	}
+	s.lastRequestID++
+	id := s.lastRequestID
+	key := requestKey(id)
+	reply := make(chan clientResponse, 1)
+	if s.pending == nil {
+		s.pending = make(map[string]chan clientResponse)
+	}
+	s.pending[key] = reply
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+
+	send := s.sender(ctx)
+	if err := send(JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-reply:
+		if response.Error != nil {
+			return nil, fmt.Errorf("%s failed: %s (%d)", method, response.Error.Message, response.Error.Code)
+		}
+		return response.Result, nil
+	case <-s.done:
+		return nil, errSessionClosed
+	case <-ctx.Done():
+		send(JSONRPCNotification{
+			JSONRPC: "2.0",
+			Method:  "notifications/cancelled",
+			Params:  map[string]interface{}{"requestId": id, "reason": ctx.Err().Error()},
+		})
+		return nil, ctx.Err()
+	}
+}
+
+// sender returns how to send messages about the request ctx belongs to:
+// on its own stream if the transport has one, otherwise like any other
+// message of the session.
+func (s *Session) sender(ctx context.Context) func(msg interface{}) error {
+	if related, ok := ctx.Value(relatedSendKey{}).(func(msg interface{}) error); ok {
+		return related
+	}
+	return s.send
+}
+
+// HandleResponse passes data to the server request it answers and reports
+// whether it was a response at all; anything else is left to the caller
+// to handle as a request or notification. Responses nobody is waiting for
+// any more are dropped.
+func (s *Session) HandleResponse(data []byte) bool {
+	var response clientResponse
+	if json.Unmarshal(data, &response) != nil || response.Method != "" || response.ID == nil {
+		return false
+	}
+	if response.Result == nil && response.Error == nil {
+		return false
+	}
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	reply := s.pending[requestKey(response.ID)]
+	s.mu.Unlock()
+	if reply != nil {
+		select {
+		case reply <- response:
+		default:
+		}
+	}
+	return true
+}
@@ -0,0 +1,365 @@
+package mcp
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gnolledgegraph/internal/db"
+)
+
+// Resources expose the graph for clients that attach context rather than
+// call tools:
+//
+//	kg://graph                the whole graph
+//	kg://type/{entityType}    every entity of a type
+//	kg://entity/{name}        one entity
+//
+// with their observations and the relations they take part in. Contents
+// are JSON shaped like open_nodes output, or Markdown with ?format=markdown.
+
+const (
+	resourceScheme   = "kg"
+	graphResourceURI = "kg://graph"
+
+	mimeJSON     = "application/json"
+	mimeMarkdown = "text/markdown"
+)
+
+// resourcePageSize is how many entity resources resources/list returns at
+// a time.
+const resourcePageSize = db.DefaultListLimit
+
+type ResourcesCapability struct {
+	Subscribe   bool `json:"subscribe,omitempty"`
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourcesListResult struct {
+	Resources  []Resource `json:"resources"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+type ResourceTemplatesListResult struct {
+	ResourceTemplates []ResourceTemplate `json:"resourceTemplates"`
+	NextCursor        string             `json:"nextCursor,omitempty"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type ReadResourceResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+var (
+	// errResourceNotFound is answered with the MCP "resource not found"
+	// code.
+	errResourceNotFound = errors.New("resource not found")
+	errUnknownFormat    = errors.New("unknown format")
+)
+
+func entityResourceURI(name string) string {
+	return "kg://entity/" + url.PathEscape(name)
+}
+
+func typeResourceURI(entityType string) string {
+	return "kg://type/" + url.PathEscape(entityType)
+}
+
+// encodeCursor and decodeCursor keep the entity name a page ends at opaque
+// to clients.
+func encodeCursor(after string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(after))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor")
+	}
+	return string(after), nil
+}
+
+func handleResourcesList(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		Cursor string `json:"cursor"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+	after, err := decodeCursor(params.Cursor)
+	if err != nil {
+		return invalidParams(req, err)
+	}
+
+	result := ResourcesListResult{Resources: []Resource{}}
+	// The graph and its types lead the first page; entities are paged
+	if params.Cursor == "" {
+		result.Resources = append(result.Resources, Resource{
+			URI:         graphResourceURI,
+			Name:        "Knowledge graph",
+			Description: "All entities with their observations, and all relations",
+			MimeType:    mimeJSON,
+		})
+		types, err := db.EntityTypes(database)
+		if err != nil {
+			return internalError(req, err)
+		}
+		for _, t := range types {
+			result.Resources = append(result.Resources, Resource{
+				URI:         typeResourceURI(t.Type),
+				Name:        "Entities of type " + t.Type,
+				Description: fmt.Sprintf("%d %s entities with their observations and relations", t.Count, t.Type),
+				MimeType:    mimeJSON,
+			})
+		}
+	}
+
+	entities, more, err := db.ListEntities(database, db.ListOptions{After: after, Limit: resourcePageSize})
+	if err != nil {
+		return internalError(req, err)
+	}
+	for _, e := range entities {
+		result.Resources = append(result.Resources, Resource{
+			URI:         entityResourceURI(e.Name),
+			Name:        e.Name,
+			Description: e.Type,
+			MimeType:    mimeJSON,
+		})
+	}
+	if more {
+		result.NextCursor = encodeCursor(entities[len(entities)-1].Name)
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  result,
+	}
+}
+
+var resourceTemplates = []ResourceTemplate{
+	{
+		URITemplate: "kg://entity/{name}{?format}",
+		Name:        "Entity",
+		Description: "An entity with its observations and relations; format=markdown for Markdown",
+		MimeType:    mimeJSON,
+	},
+	{
+		URITemplate: "kg://type/{entityType}{?format}",
+		Name:        "Entities by type",
+		Description: "Every entity of a type with observations and relations; format=markdown for Markdown",
+		MimeType:    mimeJSON,
+	},
+}
+
+func handleResourceTemplatesList(req JSONRPCRequest) JSONRPCResponse {
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  ResourceTemplatesListResult{ResourceTemplates: resourceTemplates},
+	}
+}
+
+func handleResourcesRead(database *sql.DB, req JSONRPCRequest) JSONRPCResponse {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+	if params.URI == "" {
+		return invalidParams(req, fmt.Errorf("missing uri"))
+	}
+
+	contents, err := readResource(database, params.URI)
+	if errors.Is(err, errResourceNotFound) || errors.Is(err, db.ErrEntityNotFound) {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &JSONRPCError{
+				Code:    -32002,
+				Message: "Resource not found",
+				Data:    map[string]string{"uri": params.URI},
+			},
+		}
+	}
+	if errors.Is(err, errUnknownFormat) {
+		return invalidParams(req, err)
+	}
+	if err != nil {
+		return internalError(req, err)
+	}
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  ReadResourceResult{Contents: []ResourceContents{contents}},
+	}
+}
+
+// Resource kinds, the host part of a kg:// URI.
+const (
+	graphResource  = "graph"
+	typeResource   = "type"
+	entityResource = "entity"
+)
+
+// parseResourceURI splits a kg:// URI into its kind, the entity or type
+// name it addresses, and whether Markdown was asked for.
+func parseResourceURI(uri string) (kind, name string, markdown bool, err error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != resourceScheme {
+		return "", "", false, errResourceNotFound
+	}
+	switch format := u.Query().Get("format"); format {
+	case "", "json":
+	case "markdown", "md":
+		markdown = true
+	default:
+		return "", "", false, fmt.Errorf("%w %q (want json or markdown)", errUnknownFormat, format)
+	}
+	name = strings.TrimPrefix(u.Path, "/")
+	switch {
+	case u.Host == graphResource && name == "":
+	case (u.Host == typeResource || u.Host == entityResource) && name != "":
+	default:
+		return "", "", false, errResourceNotFound
+	}
+	return u.Host, name, markdown, nil
+}
+
+// readResource resolves a kg:// URI to its contents.
+func readResource(database *sql.DB, uri string) (ResourceContents, error) {
+	kind, name, markdown, err := parseResourceURI(uri)
+	if err != nil {
+		return ResourceContents{}, err
+	}
+
+	var subgraph *db.Subgraph
+	var title string
+	switch kind {
+	case graphResource:
+		entities, relations, _, err := db.ReadGraph(database)
+		if err != nil {
+			return ResourceContents{}, err
+		}
+		subgraph = &db.Subgraph{Entities: entities, Relations: relations}
+		if subgraph.Entities == nil {
+			subgraph.Entities = []db.Entity{}
+		}
+		if subgraph.Relations == nil {
+			subgraph.Relations = []db.Relation{}
+		}
+		title = "Knowledge Graph"
+	case typeResource:
+		if subgraph, err = db.ReadEntityType(database, name); err != nil {
+			return ResourceContents{}, err
+		}
+		if len(subgraph.Entities) == 0 {
+			return ResourceContents{}, errResourceNotFound
+		}
+		title = "Entities of type " + name
+	case entityResource:
+		if subgraph, err = db.ReadEntity(database, name); err != nil {
+			return ResourceContents{}, err
+		}
+	}
+
+	if markdown {
+		return ResourceContents{URI: uri, MimeType: mimeMarkdown, Text: subgraphMarkdown(title, subgraph)}, nil
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"entities":  subgraph.Entities,
+		"relations": subgraph.Relations,
+	})
+	if err != nil {
+		return ResourceContents{}, err
+	}
+	return ResourceContents{URI: uri, MimeType: mimeJSON, Text: string(data)}, nil
+}
+
+// subgraphMarkdown renders entities as sections listing their observations
+// and relations. A single entity without a title gets its name as the
+// heading.
+func subgraphMarkdown(title string, subgraph *db.Subgraph) string {
+	var b strings.Builder
+	level := "##"
+	if title == "" && len(subgraph.Entities) == 1 {
+		level = "#"
+	} else {
+		fmt.Fprintf(&b, "# %s\n\n", title)
+	}
+
+	relationsOf := make(map[string][]db.Relation)
+	for _, r := range subgraph.Relations {
+		relationsOf[r.From] = append(relationsOf[r.From], r)
+		if r.To != r.From {
+			relationsOf[r.To] = append(relationsOf[r.To], r)
+		}
+	}
+
+	for _, e := range subgraph.Entities {
+		fmt.Fprintf(&b, "%s %s\n\n", level, e.Name)
+		fmt.Fprintf(&b, "Type: %s\n", e.Type)
+		if len(e.Observations) > 0 {
+			b.WriteString("\nObservations:\n\n")
+			for _, o := range e.Observations {
+				fmt.Fprintf(&b, "- %s\n", o)
+			}
+		}
+		if relations := relationsOf[e.Name]; len(relations) > 0 {
+			b.WriteString("\nRelations:\n\n")
+			for _, r := range relations {
+				fmt.Fprintf(&b, "- %s -[%s]-> %s\n", r.From, r.Type, r.To)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func invalidParams(req JSONRPCRequest, err error) JSONRPCResponse {
+	logValidation(req, err)
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Error: &JSONRPCError{
+			Code:    -32602,
+			Message: "Invalid params: " + err.Error(),
+		},
+	}
+}
+
+func internalError(req JSONRPCRequest, err error) JSONRPCResponse {
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Error: &JSONRPCError{
+			Code:    -32603,
+			Message: "Internal error: " + err.Error(),
+		},
+	}
+}
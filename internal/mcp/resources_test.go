@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+func TestResources(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+	db.CreateEntity(database, "Acme Corp", "organization")
+	db.CreateObservation(database, "Alice", "likes tea")
+	db.CreateRelation(database, "Alice", "Acme Corp", "works_at")
+	for i := 0; i < resourcePageSize; i++ {
+		db.CreateEntity(database, fmt.Sprintf("Thing %03d", i), "thing")
+	}
+	call := func(method string, params map[string]interface{}) JSONRPCResponse {
+		return HandleJSONRPCMethod(database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	}
+
+	// Listing pages through every entity once, after the graph and types
+	var uris []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		response := call("resources/list", params)
+		if response.Error != nil {
+			t.Fatalf("resources/list failed: %+v", response.Error)
+		}
+		result := response.Result.(ResourcesListResult)
+		for _, r := range result.Resources {
+			uris = append(uris, r.URI)
+		}
+		if cursor = result.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := 1 + 3 + 2 + resourcePageSize; len(uris) != want {
+		t.Errorf("Expected %d resources, got %d", want, len(uris))
+	}
+	if uris[0] != "kg://graph" || uris[1] != "kg://type/organization" || uris[4] != "kg://entity/Acme%20Corp" {
+		t.Errorf("Unexpected resource order %v", uris[:5])
+	}
+	if response := call("resources/list", map[string]interface{}{"cursor": "!!"}); response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("Expected an invalid cursor to be rejected, got %+v", response.Error)
+	}
+
+	if response := call("resources/templates/list", nil); len(response.Result.(ResourceTemplatesListResult).ResourceTemplates) != 2 {
+		t.Errorf("Expected two resource templates, got %+v", response.Result)
+	}
+
+	// Reads
+	read := func(uri string) (ResourceContents, *JSONRPCError) {
+		response := call("resources/read", map[string]interface{}{"uri": uri})
+		if response.Error != nil {
+			return ResourceContents{}, response.Error
+		}
+		return response.Result.(ReadResourceResult).Contents[0], nil
+	}
+	contents, rpcErr := read("kg://entity/Alice")
+	if rpcErr != nil {
+		t.Fatalf("resources/read failed: %+v", rpcErr)
+	}
+	var alice db.Subgraph
+	if err := json.Unmarshal([]byte(contents.Text), &alice); err != nil || contents.MimeType != mimeJSON {
+		t.Fatalf("Expected JSON, got %s %s", contents.MimeType, contents.Text)
+	}
+	if len(alice.Entities) != 1 || alice.Entities[0].Observations[0] != "likes tea" || len(alice.Relations) != 1 {
+		t.Errorf("Expected Alice with her observation and relation, got %+v", alice)
+	}
+
+	contents, _ = read("kg://entity/Acme%20Corp?format=markdown")
+	if contents.MimeType != mimeMarkdown || !strings.HasPrefix(contents.Text, "# Acme Corp\n") || !strings.Contains(contents.Text, "- Alice -[works_at]-> Acme Corp") {
+		t.Errorf("Unexpected Markdown %s:\n%s", contents.MimeType, contents.Text)
+	}
+	if contents, _ = read("kg://type/person?format=markdown"); !strings.Contains(contents.Text, "## Alice") {
+		t.Errorf("Expected Alice in the person type, got:\n%s", contents.Text)
+	}
+	if contents, _ = read("kg://graph"); !strings.Contains(contents.Text, "Thing 099") {
+		t.Errorf("Expected the whole graph, got %.100s", contents.Text)
+	}
+
+	for uri, code := range map[string]int{
+		"kg://entity/Nobody":           -32002,
+		"kg://type/nothing":            -32002,
+		"file:///etc/passwd":           -32002,
+		"kg://entity/Alice?format=pdf": -32602,
+	} {
+		if _, rpcErr := read(uri); rpcErr == nil || rpcErr.Code != code {
+			t.Errorf("%s: expected error %d, got %+v", uri, code, rpcErr)
+		}
+	}
+}
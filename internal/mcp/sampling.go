@@ -0,0 +1,93 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sampling: asking the client's model for a completion with
+// sampling/createMessage. The client decides which model runs it, and may
+// show the request to its user first.
+
+// samplingTimeout bounds how long a tool call waits for the client's
+// model, and its user if they review the request.
+var samplingTimeout = 5 * time.Minute
+
+// SamplingMessage is one message of a sampling/createMessage request.
+type SamplingMessage struct {
+	Role    string      `json:"role"`
+	Content ToolContent `json:"content"`
+}
+
+// ModelPreferences hint at the model the client should pick, each
+// priority between 0 and 1.
+type ModelPreferences struct {
+	CostPriority         float64 `json:"costPriority,omitempty"`
+	SpeedPriority        float64 `json:"speedPriority,omitempty"`
+	IntelligencePriority float64 `json:"intelligencePriority,omitempty"`
+}
+
+// CreateMessageRequest is the params of sampling/createMessage.
+type CreateMessageRequest struct {
+	Messages         []SamplingMessage `json:"messages"`
+	ModelPreferences *ModelPreferences `json:"modelPreferences,omitempty"`
+	SystemPrompt     string            `json:"systemPrompt,omitempty"`
+	IncludeContext   string            `json:"includeContext,omitempty"`
+	MaxTokens        int               `json:"maxTokens"`
+}
+
+// CreateMessageResult is the client's answer to sampling/createMessage.
+type CreateMessageResult struct {
+	Role       string      `json:"role"`
+	Content    ToolContent `json:"content"`
+	Model      string      `json:"model"`
+	StopReason string      `json:"stopReason,omitempty"`
+}
+
+var errCannotSample = errors.New("the client does not support sampling")
+
+// canSample reports whether the client of s can be asked for completions.
+func (s *Session) canSample() bool {
+	return s != nil && s.send != nil && s.ClientCapabilities().Sampling != nil
+}
+
+// sample sends req to the client's model and returns the text of its answer
+// and the model that wrote it.
+func sample(ctx context.Context, req CreateMessageRequest) (string, string, error) {
+	session := sessionFrom(ctx)
+	if !session.canSample() {
+		return "", "", errCannotSample
+	}
+	ctx, cancel := context.WithTimeout(ctx, samplingTimeout)
+	defer cancel()
+	data, err := session.request(ctx, "sampling/createMessage", req)
+	if err != nil {
+		return "", "", err
+	}
+	var result CreateMessageResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", "", fmt.Errorf("invalid sampling result: %v", err)
+	}
+	if result.Content.Type != "text" {
+		return "", "", fmt.Errorf("expected text from the client's model, got %q", result.Content.Type)
+	}
+	return result.Content.Text, result.Model, nil
+}
+
+// jsonStringArray finds the JSON array of strings in a model's answer,
+// which may come with prose or a code fence around it.
+func jsonStringArray(text string) ([]string, error) {
+	start, end := strings.Index(text, "["), strings.LastIndex(text, "]")
+	if start < 0 || end < start {
+		return nil, errors.New("no JSON array in the answer")
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(text[start:end+1]), &values); err != nil {
+		return nil, fmt.Errorf("the answer is not a JSON array of strings: %v", err)
+	}
+	return values, nil
+}
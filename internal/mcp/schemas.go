@@ -0,0 +1,161 @@
+package mcp
+
+import "gnolledgegraph/internal/db"
+
+// JSON Schema building blocks for the tool list: the objects tools take as
+// arguments, and the graph records their structured output is made of.
+
+func boolPtr(b bool) *bool { return &b }
+
+func intPtr(i int) *int { return &i }
+
+// object is a schema for a JSON object. Arguments are closed objects so
+// that a misspelled field is caught by the client; results are left open.
+func object(properties map[string]Property, required ...string) Property {
+	if required == nil {
+		required = []string{}
+	}
+	return Property{Type: "object", Properties: properties, Required: required}
+}
+
+func closedObject(properties map[string]Property, required ...string) Property {
+	p := object(properties, required...)
+	p.AdditionalProperties = boolPtr(false)
+	return p
+}
+
+func arrayOf(items Property, description string) Property {
+	return Property{Type: "array", Description: description, Items: &items}
+}
+
+func stringArray(description string) Property {
+	return arrayOf(Property{Type: "string"}, description)
+}
+
+func stringProperty(description string) Property {
+	return Property{Type: "string", Description: description}
+}
+
+// outputSchema wraps an object schema for Tool.OutputSchema.
+func outputSchema(properties map[string]Property, required ...string) *Property {
+	p := object(properties, required...)
+	return &p
+}
+
+var asOfProperty = stringProperty("Answer from the graph as it was at this snapshot name or time, RFC 3339 or YYYY-MM-DD (optional)")
+
+func directionProperty(description string) Property {
+	return Property{
+		Type:        "string",
+		Description: description,
+		Enum:        []string{db.DirectionOutgoing, db.DirectionIncoming, db.DirectionBoth},
+		Default:     db.DirectionBoth,
+	}
+}
+
+// Argument elements.
+var (
+	entityInputSchema = closedObject(map[string]Property{
+		"name":         stringProperty("Unique name of the entity"),
+		"entityType":   stringProperty("Type of the entity, such as person or project"),
+		"observations": stringArray("Facts about the entity (optional)"),
+	}, "name", "entityType")
+
+	relationInputSchema = closedObject(map[string]Property{
+		"from":         stringProperty("Name of the entity the relation starts at"),
+		"to":           stringProperty("Name of the entity the relation points to"),
+		"relationType": stringProperty("Type of the relation, in active voice"),
+	}, "from", "to", "relationType")
+
+	observationInputSchema = closedObject(map[string]Property{
+		"entityName": stringProperty("Name of an existing entity"),
+		"contents":   stringProperty("The observation to add"),
+	}, "entityName", "contents")
+
+	deletionInputSchema = closedObject(map[string]Property{
+		"entityName":   stringProperty("Name of the entity"),
+		"observations": stringArray("Observations to remove"),
+	}, "entityName", "observations")
+)
+
+// withProvenance adds the fields db.Provenance contributes to a record.
+func withProvenance(properties map[string]Property) map[string]Property {
+	properties["source"] = stringProperty("Client that made the last change")
+	properties["sessionId"] = stringProperty("Session that made the last change")
+	properties["actor"] = stringProperty("User that made the last change")
+	return properties
+}
+
+// originProperty tags records in federated results.
+var originProperty = stringProperty("Memory server the record came from, when federating")
+
+// Result records, as the db package marshals them.
+var (
+	entitySchema = object(withProvenance(map[string]Property{
+		"name":         {Type: "string"},
+		"entityType":   {Type: "string"},
+		"observations": stringArray(""),
+		"score":        {Type: "number", Description: "Search relevance, when full-text search is available"},
+		"matches":      stringArray("Highlighted observations that matched the search"),
+		"createdAt":    {Type: "string"},
+		"updatedAt":    {Type: "string"},
+		"origin":       originProperty,
+	}), "name", "entityType")
+
+	relationSchema = object(withProvenance(map[string]Property{
+		"id":           {Type: "integer"},
+		"from":         {Type: "string"},
+		"to":           {Type: "string"},
+		"relationType": {Type: "string"},
+		"createdAt":    {Type: "string"},
+		"updatedAt":    {Type: "string"},
+		"origin":       originProperty,
+	}), "id", "from", "to", "relationType")
+
+	observationSchema = object(withProvenance(map[string]Property{
+		"id":          {Type: "integer"},
+		"entity_name": {Type: "string"},
+		"content":     {Type: "string"},
+		"createdAt":   {Type: "string"},
+		"updatedAt":   {Type: "string"},
+	}), "id", "entity_name", "content")
+
+	changeSchema = object(withProvenance(map[string]Property{
+		"id":            {Type: "integer"},
+		"at":            {Type: "string"},
+		"operation":     {Type: "string"},
+		"entityName":    {Type: "string"},
+		"relatedEntity": {Type: "string"},
+		"before":        {Type: "object", Description: "The row before the change; absent for creations"},
+		"after":         {Type: "object", Description: "The row after the change; absent for deletions"},
+	}), "id", "at", "operation", "entityName")
+
+	pathSchema = object(map[string]Property{
+		"entities": stringArray("Entities along the path, from first to last"),
+		"hops": arrayOf(object(map[string]Property{
+			"from":         {Type: "string"},
+			"to":           {Type: "string"},
+			"relationType": {Type: "string"},
+			"direction":    {Type: "string", Enum: []string{db.DirectionOutgoing, db.DirectionIncoming}},
+		}, "from", "to", "relationType", "direction"), ""),
+		"length": {Type: "integer"},
+	}, "entities", "hops", "length")
+)
+
+// graphOutputSchema is the output of tools returning entities with the
+// relations between them.
+var graphOutputSchema = outputSchema(map[string]Property{
+	"entities":  arrayOf(entitySchema, ""),
+	"relations": arrayOf(relationSchema, ""),
+}, "entities", "relations")
+
+// federatedGraphOutputSchema is the output of graph tools that also ask
+// federated servers.
+var federatedGraphOutputSchema = outputSchema(map[string]Property{
+	"entities":  arrayOf(entitySchema, ""),
+	"relations": arrayOf(relationSchema, ""),
+	"unreachable": arrayOf(object(map[string]Property{
+		"origin": {Type: "string"},
+		"error":  {Type: "string"},
+	}, "origin", "error"), "Federated servers that could not answer"),
+}, "entities", "relations")
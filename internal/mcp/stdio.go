@@ -0,0 +1,181 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
+)
+
+// The stdio transport: one client, one JSON-RPC message per line in each
+// direction. Lines may be of any length. Requests are handled
+// concurrently, as over HTTP, so that one waiting for the client does not
+// stop its answer from being read; notifications and responses are
+// handled in the order they arrive.
+
+// ServeStdio serves a single MCP client reading from r and writing to w,
+// usually the process's stdin and stdout, until r ends or ctx is done.
+// Messages are written whole, one per line, in the order they are sent, so
+// a request's notifications come before its response. When r ends, the
+// requests being handled are finished and answered before ServeStdio
+// returns nil; when ctx is done they are cancelled and ctx.Err() is
+// returned.
+func ServeStdio(ctx context.Context, database *sql.DB, r io.Reader, w io.Writer) error {
+	out := &lineWriter{w: w}
+	send := out.send
+	reply := func(response interface{}) {
+		if err := send(response); err != nil {
+			logs.Log(logs.Error, "mcp", "stdio write failed", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	// stdio serves a single client for the life of the process
+	ctx = db.WithProvenance(ctx, db.Provenance{
+		SessionID: fmt.Sprintf("stdio_%d", time.Now().UnixNano()),
+	})
+	session := NewSession(send)
+	ctx = WithSession(ctx, session)
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() {
+		readErr <- readLines(r, lines, stop)
+	}()
+
+	var handlers sync.WaitGroup
+	defer func() {
+		close(stop)
+		// Requests waiting for the client will not get an answer now
+		session.Close()
+		handlers.Wait()
+	}()
+	for {
+		var line []byte
+		select {
+		case line = <-lines:
+		case err := <-readErr:
+			if err != nil {
+				logs.Log(logs.Error, "mcp", "stdio read failed", map[string]interface{}{"error": err.Error()})
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// Responses to server requests go to the tool call waiting for them
+		if session.HandleResponse(line) {
+			continue
+		}
+
+		if IsBatch(line) {
+			handlers.Add(1)
+			go func(ctx context.Context) {
+				defer handlers.Done()
+				if response := HandleBatchContext(ctx, database, line); response != nil {
+					reply(response)
+				}
+			}(ctx)
+			continue
+		}
+
+		req, errResponse := parseStdioRequest(line)
+		if errResponse != nil {
+			reply(errResponse)
+			continue
+		}
+		ctx = WithClientProvenance(ctx, req)
+
+		// Notifications are handled before the next message is read, so
+		// that notifications/initialized or notifications/cancelled take
+		// effect for the requests after them
+		if req.ID == nil {
+			HandleJSONRPCMethodContext(ctx, database, req)
+			continue
+		}
+		handlers.Add(1)
+		go func(ctx context.Context) {
+			defer handlers.Done()
+			reply(HandleJSONRPCMethodContext(ctx, database, req))
+		}(ctx)
+	}
+}
+
+// lineWriter writes JSON-RPC messages one per line, each whole, in the
+// order they are sent. After a failed write it fails every write.
+type lineWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+func (l *lineWriter) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return l.write(data)
+}
+
+func (l *lineWriter) write(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if _, err := l.w.Write(append(data[:len(data):len(data)], '\n')); err != nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// readLines sends the non-blank lines of r to lines, without their line
+// endings, until stop is closed. It returns the error that ended r, or nil
+// at EOF.
+func readLines(r io.Reader, lines chan<- []byte, stop <-chan struct{}) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			select {
+			case lines <- line:
+			case <-stop:
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseStdioRequest decodes a request or notification, or returns the
+// error response for a line that is not JSON or not a JSON-RPC request.
+func parseStdioRequest(line []byte) (JSONRPCRequest, *JSONRPCResponse) {
+	var req JSONRPCRequest
+	if !json.Valid(line) {
+		return req, &JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32700, Message: "Parse error"}}
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return req, &JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32600, Message: "Invalid Request"}}
+	}
+	if req.JSONRPC != "2.0" {
+		return req, &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &JSONRPCError{Code: -32600, Message: "Invalid Request: jsonrpc must be \"2.0\""}}
+	}
+	if req.Method == "" {
+		return req, &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &JSONRPCError{Code: -32600, Message: "Invalid Request: missing method"}}
+	}
+	return req, nil
+}
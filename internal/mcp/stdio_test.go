@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+// stdioPipe is the client end of ServeStdio running on pipes.
+type stdioPipe struct {
+	t        *testing.T
+	database *sql.DB
+	in       *io.PipeWriter
+	out      *bufio.Reader
+	done     chan error
+}
+
+func serveStdioPipe(t *testing.T, ctx context.Context, capabilities ClientCapabilities) *stdioPipe {
+	database := setupTestDB(t)
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	p := &stdioPipe{t: t, database: database, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		p.done <- ServeStdio(ctx, database, inR, outW)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+
+	params, _ := json.Marshal(InitializeRequest{ProtocolVersion: ProtocolVersion20250618, Capabilities: capabilities})
+	p.write(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":` + string(params) + `}`)
+	p.read()
+	p.write(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	return p
+}
+
+func (p *stdioPipe) write(line string) {
+	p.t.Helper()
+	if _, err := io.WriteString(p.in, line+"\n"); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// read returns the next response or request the server wrote, skipping
+// notifications.
+func (p *stdioPipe) read() map[string]interface{} {
+	p.t.Helper()
+	for {
+		line, err := p.out.ReadBytes('\n')
+		if err != nil {
+			p.t.Fatalf("reading stdout: %v", err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			p.t.Fatalf("invalid message %q: %v", line, err)
+		}
+		if _, ok := msg["id"]; ok {
+			return msg
+		}
+	}
+}
+
+func errorCode(msg map[string]interface{}) float64 {
+	e, _ := msg["error"].(map[string]interface{})
+	code, _ := e["code"].(float64)
+	return code
+}
+
+func TestServeStdio(t *testing.T) {
+	p := serveStdioPipe(t, context.Background(), ClientCapabilities{})
+
+	// Lines are not limited to bufio.Scanner's 64 KiB
+	long := strings.Repeat("x", 200*1024)
+	p.write(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"create_entities","arguments":{"entities":[{"name":"Alice","entityType":"person","observations":["` + long + `"]}]}}}`)
+	if msg := p.read(); msg["id"] != 1.0 || msg["error"] != nil {
+		t.Fatalf("Expected the long request to be answered, got %v", msg)
+	}
+	if observations, err := db.EntityObservationsContext(context.Background(), p.database, "Alice"); err != nil || len(observations) != 1 || observations[0].Content != long {
+		t.Errorf("Expected the long observation to be stored, got %d observations, %v", len(observations), err)
+	}
+
+	// Blank lines are skipped, broken messages answered with errors
+	p.write("")
+	p.write(`{"jsonrpc":"2.0","id":3,"method":`)
+	if msg := p.read(); errorCode(msg) != -32700 || msg["id"] != nil {
+		t.Errorf("Expected a parse error, got %v", msg)
+	}
+	for _, line := range []string{`{"id":4,"method":"ping"}`, `{"jsonrpc":"2.0","id":4}`, `42`} {
+		p.write(line)
+		if msg := p.read(); errorCode(msg) != -32600 {
+			t.Errorf("%s: expected an invalid request error, got %v", line, msg)
+		}
+	}
+	p.write(`[{"jsonrpc":"2.0","id":5,"method":"ping"}]`)
+	if msg, _ := p.out.ReadString('\n'); msg != `[{"jsonrpc":"2.0","id":5,"result":{}}]`+"\n" {
+		t.Errorf("Expected the batch to be answered, got %s", msg)
+	}
+
+	p.in.Close()
+	select {
+	case err := <-p.done:
+		if err != nil {
+			t.Errorf("Expected ServeStdio to end cleanly at EOF, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeStdio did not return at EOF")
+	}
+}
+
+func TestServeStdioConcurrency(t *testing.T) {
+	p := serveStdioPipe(t, context.Background(), ClientCapabilities{Sampling: &struct{}{}})
+	p.write(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"create_entities","arguments":{"entities":[{"name":"Alice","entityType":"person","observations":["likes tea","likes tea with milk"]}]}}}`)
+	p.read()
+
+	// A tool call waiting for the client's model does not hold up others
+	p.write(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"consolidate_entity","arguments":{"name":"Alice"}}}`)
+	request := p.read()
+	if request["method"] != "sampling/createMessage" {
+		t.Fatalf("Expected a sampling request, got %v", request)
+	}
+	p.write(`{"jsonrpc":"2.0","id":3,"method":"ping"}`)
+	if msg := p.read(); msg["id"] != 3.0 {
+		t.Fatalf("Expected the ping to be answered first, got %v", msg)
+	}
+
+	// At EOF the waiting call is answered before ServeStdio returns
+	p.in.Close()
+	msg := p.read()
+	if msg["id"] != 2.0 || !strings.Contains(fmt.Sprint(msg["result"]), "samplingError") {
+		t.Errorf("Expected the tool call to fall back to merging, got %v", msg)
+	}
+	if err := <-p.done; err != nil {
+		t.Errorf("Expected ServeStdio to end cleanly at EOF, got %v", err)
+	}
+}
+
+func TestServeStdioCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := serveStdioPipe(t, ctx, ClientCapabilities{})
+	go io.Copy(io.Discard, p.out)
+	cancel()
+	select {
+	case err := <-p.done:
+		if err != context.Canceled {
+			t.Errorf("Expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeStdio did not return when its context was cancelled")
+	}
+}
@@ -0,0 +1,530 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+// Streamable HTTP transport (MCP 2025-03-26). A single endpoint takes
+// client messages by POST, answering each request with either a JSON body
+// or an SSE stream; GET opens a stream for messages the server starts; and
+// DELETE ends the session. Every SSE event carries an ID so a client that
+// lost a stream can pick it up again with Last-Event-ID.
+
+// SessionHeader carries the session ID assigned at initialize.
+const SessionHeader = "Mcp-Session-Id"
+
+// ProtocolVersionHeader carries the negotiated protocol version on every
+// request after initialize (MCP 2025-06-18).
+const ProtocolVersionHeader = "MCP-Protocol-Version"
+
+const (
+	// eventHistory is how many recent events a session keeps for clients
+	// resuming a stream.
+	eventHistory = 256
+	// sessionIdleTimeout is how long a session survives without requests
+	// or open streams.
+	sessionIdleTimeout = time.Hour
+	// streamKeepAlive is how often an idle stream gets an SSE comment, so
+	// proxies do not close it.
+	streamKeepAlive = 30 * time.Second
+)
+
+// standaloneStream is the stream opened by GET.
+const standaloneStream = 0
+
+// streamEvent is one message sent, or waiting to be sent, on a stream.
+type streamEvent struct {
+	id     int64
+	stream int64
+	data   json.RawMessage
+	// final marks the response that ends a POST stream
+	final bool
+}
+
+// streamableSession is the server side of one Mcp-Session-Id.
+type streamableSession struct {
+	id       string
+	protocol *Session
+
+	mu         sync.Mutex
+	provenance db.Provenance
+	lastUsed   time.Time
+	nextEvent  int64
+	nextStream int64
+	events     []streamEvent
+	// changed is closed and replaced whenever an event is published
+	changed chan struct{}
+	// closeStandalone ends the current GET stream, if any
+	closeStandalone context.CancelFunc
+	standaloneGen   int
+	closed          bool
+}
+
+type streamableServer struct {
+	database *sql.DB
+
+	mu       sync.Mutex
+	sessions map[string]*streamableSession
+}
+
+// NewStreamableHandler serves the Streamable HTTP transport on a single
+// endpoint such as /mcp.
+func NewStreamableHandler(database *sql.DB) http.Handler {
+	return newStreamableServer(database)
+}
+
+func newStreamableServer(database *sql.DB) *streamableServer {
+	return &streamableServer{
+		database: database,
+		sessions: make(map[string]*streamableSession),
+	}
+}
+
+func (s *streamableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !validOrigin(r) {
+		http.Error(w, "invalid origin", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.handlePost(w, r)
+	case http.MethodGet:
+		s.handleGet(w, r)
+	case http.MethodDelete:
+		s.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *streamableServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "reading request body failed", http.StatusBadRequest)
+		return
+	}
+	// A batch is answered like a single request, with an array
+	var msg JSONRPCRequest
+	var batch []json.RawMessage
+	if IsBatch(body) {
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSONRPCError(w, http.StatusBadRequest, nil, -32700, "Parse error")
+			return
+		}
+		if len(batch) == 0 {
+			writeJSONRPCError(w, http.StatusBadRequest, nil, -32600, "Invalid Request: empty batch")
+			return
+		}
+	} else {
+		if err := json.Unmarshal(body, &msg); err != nil {
+			writeJSONRPCError(w, http.StatusBadRequest, nil, -32700, "Parse error")
+			return
+		}
+		if msg.JSONRPC != "2.0" {
+			writeJSONRPCError(w, http.StatusBadRequest, msg.ID, -32600, "Invalid Request: jsonrpc must be \"2.0\"")
+			return
+		}
+	}
+
+	var session *streamableSession
+	if msg.Method == "initialize" && r.Header.Get(SessionHeader) == "" {
+		session = s.newSession()
+	} else {
+		var status int
+		if session, status = s.session(r); session == nil {
+			writeJSONRPCError(w, status, msg.ID, -32000, http.StatusText(status))
+			return
+		}
+		if err := checkProtocolVersion(r, session); err != "" {
+			writeJSONRPCError(w, http.StatusBadRequest, msg.ID, -32600, err)
+			return
+		}
+	}
+	w.Header().Set(SessionHeader, session.id)
+
+	// Writes are attributed to the session and its client on top of the
+	// caller identity, and finish even if the client hangs up
+	ctx := db.WithProvenance(context.WithoutCancel(r.Context()), session.getProvenance())
+	ctx = WithSession(ctx, session.protocol)
+	if msg.Method == "initialize" {
+		ctx = WithClientProvenance(ctx, msg)
+		session.setSource(db.ProvenanceFrom(ctx).Source)
+	}
+
+	// Notifications and responses are accepted without a reply
+	if batch != nil && !batchHasRequests(batch) {
+		handleBatch(ctx, s.database, batch)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if batch == nil && (msg.Method == "" || msg.ID == nil) {
+		if !session.protocol.HandleResponse(body) && msg.Method != "" {
+			HandleJSONRPCMethodContext(ctx, s.database, msg)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Answer with plain JSON unless something is sent before the response
+	// and the client can take a stream
+	acceptsJSON, acceptsSSE := accepts(r, "application/json"), accepts(r, "text/event-stream")
+	stream := session.openStream()
+	if acceptsSSE {
+		// Requests made while handling this one go out on its stream
+		ctx = withRelatedSend(ctx, func(msg interface{}) error {
+			return session.publish(stream, msg, false)
+		})
+	}
+	go func() {
+		var response interface{}
+		if batch != nil {
+			response = handleBatch(ctx, s.database, batch)
+		} else {
+			response = HandleJSONRPCMethodContext(ctx, s.database, msg)
+		}
+		session.publish(stream, response, true)
+	}()
+
+	for after := int64(0); ; {
+		events, changed, closed := session.next(stream, after)
+		for _, e := range events {
+			if acceptsSSE && (!e.final || !acceptsJSON) {
+				serveStream(r.Context(), w, session, stream, 0)
+				return
+			}
+			if e.final {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(e.data)
+				return
+			}
+			after = e.id
+		}
+		if closed {
+			http.Error(w, "session terminated", http.StatusNotFound)
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *streamableServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !accepts(r, "text/event-stream") {
+		http.Error(w, "GET needs Accept: text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	session, status := s.session(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if err := checkProtocolVersion(r, session); err != "" {
+		http.Error(w, err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set(SessionHeader, session.id)
+
+	// Resuming picks up the stream the last event was sent on; otherwise
+	// only messages from now on are sent
+	stream, after := int64(standaloneStream), session.lastEventID()
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		if resumed, ok := session.streamOf(id); ok {
+			stream, after = resumed, id
+		}
+	}
+	ctx := r.Context()
+	if stream == standaloneStream {
+		var done func()
+		ctx, done = session.replaceStandalone(ctx)
+		defer done()
+	}
+	serveStream(ctx, w, session, stream, after)
+}
+
+func (s *streamableServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session, status := s.session(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	s.mu.Lock()
+	delete(s.sessions, session.id)
+	s.mu.Unlock()
+	session.close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkProtocolVersion returns why the request's MCP-Protocol-Version
+// header does not fit the session, or "". Clients from before the header
+// existed do not send it and are let through.
+func checkProtocolVersion(r *http.Request, session *streamableSession) string {
+	version := r.Header.Get(ProtocolVersionHeader)
+	switch negotiated := session.protocol.ProtocolVersion(); {
+	case version == "":
+		return ""
+	case !supportedProtocolVersion(version):
+		return "Bad Request: unsupported " + ProtocolVersionHeader + " " + version
+	case negotiated != "" && version != negotiated:
+		return "Bad Request: " + ProtocolVersionHeader + " " + version + " does not match the negotiated version " + negotiated
+	}
+	return ""
+}
+
+// session looks up the session named by the request, or returns the
+// status to fail with: 400 without a session ID, 404 for an unknown or
+// terminated one.
+func (s *streamableServer) session(r *http.Request) (*streamableSession, int) {
+	id := r.Header.Get(SessionHeader)
+	if id == "" {
+		return nil, http.StatusBadRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	session.touch()
+	return session, 0
+}
+
+func (s *streamableServer) newSession() *streamableSession {
+	var b [16]byte
+	rand.Read(b[:])
+	session := &streamableSession{
+		id:       hex.EncodeToString(b[:]),
+		lastUsed: time.Now(),
+		changed:  make(chan struct{}),
+	}
+	// Messages the server starts go out on the GET stream
+	session.protocol = NewSession(func(msg interface{}) error {
+		return session.publish(standaloneStream, msg, false)
+	})
+	session.provenance.SessionID = session.id
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Clients do not always say goodbye
+	for id, old := range s.sessions {
+		if old.idle() > sessionIdleTimeout {
+			delete(s.sessions, id)
+			old.close()
+		}
+	}
+	s.sessions[session.id] = session
+	return session
+}
+
+func (s *streamableSession) getProvenance() db.Provenance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.provenance
+}
+
+func (s *streamableSession) setSource(source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.provenance.Source = source
+}
+
+func (s *streamableSession) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+}
+
+// idle is how long the session has gone unused; never while a GET stream
+// is open.
+func (s *streamableSession) idle() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeStandalone != nil {
+		return 0
+	}
+	return time.Since(s.lastUsed)
+}
+
+func (s *streamableSession) close() {
+	s.protocol.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.closeStandalone != nil {
+		s.closeStandalone()
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// openStream allocates a stream for the answer to one POST.
+func (s *streamableSession) openStream() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextStream++
+	return s.nextStream
+}
+
+// replaceStandalone makes the caller the session's GET stream, ending the
+// previous one: the server sends each message on one stream only, and a
+// client only reconnects once it has lost the old one. The returned func
+// is called when the stream ends.
+func (s *streamableSession) replaceStandalone(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeStandalone != nil {
+		s.closeStandalone()
+	}
+	s.closeStandalone = cancel
+	s.standaloneGen++
+	gen := s.standaloneGen
+	return ctx, func() {
+		cancel()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.standaloneGen == gen {
+			s.closeStandalone = nil
+			s.lastUsed = time.Now()
+		}
+	}
+}
+
+// publish queues msg on stream and wakes up its reader.
+func (s *streamableSession) publish(stream int64, msg interface{}, final bool) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextEvent++
+	s.events = append(s.events, streamEvent{id: s.nextEvent, stream: stream, data: data, final: final})
+	if len(s.events) > eventHistory {
+		s.events = append([]streamEvent(nil), s.events[len(s.events)-eventHistory:]...)
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return nil
+}
+
+// next returns the events on stream after the given event ID, a channel
+// that is closed when more are published, and whether the session is
+// closed.
+func (s *streamableSession) next(stream, after int64) ([]streamEvent, <-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []streamEvent
+	for _, e := range s.events {
+		if e.stream == stream && e.id > after {
+			events = append(events, e)
+		}
+	}
+	return events, s.changed, s.closed
+}
+
+// streamOf returns the stream a remembered event was sent on.
+func (s *streamableSession) streamOf(id int64) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.id == id {
+			return e.stream, true
+		}
+	}
+	return 0, false
+}
+
+func (s *streamableSession) lastEventID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextEvent
+}
+
+// serveStream writes the events on stream after the given event ID as
+// SSE until the stream's final event, the session ends or ctx is done.
+func serveStream(ctx context.Context, w http.ResponseWriter, session *streamableSession, stream, after int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, changed, closed := session.next(stream, after)
+		for _, e := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", e.id, e.data); err != nil {
+				return
+			}
+			after = e.id
+			if e.final {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+		if closed {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		case <-time.After(streamKeepAlive):
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// accepts reports whether the request's Accept header admits mediaType.
+// A missing header accepts anything.
+func accepts(r *http.Request, mediaType string) bool {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return true
+	}
+	for _, part := range strings.Split(header, ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if t == mediaType || t == "*/*" || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONRPCError answers an HTTP request with a JSON-RPC error.
+func writeJSONRPCError(w http.ResponseWriter, status int, id interface{}, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &JSONRPCError{Code: code, Message: message},
+	})
+}
@@ -0,0 +1,189 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	tmpfile, err := os.CreateTemp("", "test_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	database, err := db.Init(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		database.Close()
+		os.Remove(tmpfile.Name())
+	})
+	return database
+}
+
+// post sends one JSON-RPC message to the streamable endpoint.
+func post(t *testing.T, url, session, accept, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if session != "" {
+		req.Header.Set(SessionHeader, session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvent reads the next SSE event and returns its ID and data.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamableHTTP(t *testing.T) {
+	streamable := newStreamableServer(setupTestDB(t))
+	server := httptest.NewServer(streamable)
+	defer server.Close()
+	both := "application/json, text/event-stream"
+
+	resp := post(t, server.URL, "", both, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"test"}}}`)
+	session := resp.Header.Get(SessionHeader)
+	if resp.StatusCode != http.StatusOK || session == "" {
+		t.Fatalf("Expected a session from initialize, got %d %q", resp.StatusCode, session)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON answer, got %s", ct)
+	}
+
+	if resp := post(t, server.URL, session, both, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected 202 for a notification, got %d", resp.StatusCode)
+	}
+	if resp := post(t, server.URL, "", both, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a session, got %d", resp.StatusCode)
+	}
+	for version, status := range map[string]int{"2025-03-26": http.StatusOK, "2025-06-18": http.StatusBadRequest, "1999-01-01": http.StatusBadRequest} {
+		req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+		req.Header.Set(SessionHeader, session)
+		req.Header.Set(ProtocolVersionHeader, version)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Expected %d for %s %s, got %d", status, ProtocolVersionHeader, version, resp.StatusCode)
+		}
+	}
+	if resp := post(t, server.URL, "nope", both, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", resp.StatusCode)
+	}
+
+	// A client that only takes streams gets the response as an event
+	resp = post(t, server.URL, session, "text/event-stream", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", ct)
+	}
+	_, data := readEvent(t, bufio.NewReader(resp.Body))
+	var response JSONRPCResponse
+	if err := json.Unmarshal([]byte(data), &response); err != nil || response.Error != nil {
+		t.Errorf("Expected a tools/list result, got %s", data)
+	}
+
+	// Server-initiated messages arrive on the GET stream and can be resumed
+	get := func(lastEventID string) *bufio.Reader {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set(SessionHeader, session)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected GET stream, got %d", resp.StatusCode)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+	stream := get("")
+	streamable.mu.Lock()
+	s := streamable.sessions[session]
+	streamable.mu.Unlock()
+	waitForStandalone(t, s)
+	s.publish(standaloneStream, JSONRPCNotification{JSONRPC: "2.0", Method: "notifications/one"}, false)
+	s.publish(standaloneStream, JSONRPCNotification{JSONRPC: "2.0", Method: "notifications/two"}, false)
+	firstID, data := readEvent(t, stream)
+	if !strings.Contains(data, "notifications/one") {
+		t.Errorf("Expected the first notification, got %s", data)
+	}
+
+	resumed := get(firstID)
+	if _, data := readEvent(t, resumed); !strings.Contains(data, "notifications/two") {
+		t.Errorf("Expected the resumed stream to replay the second notification, got %s", data)
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL, nil)
+	req.Header.Set(SessionHeader, session)
+	deleted, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted.Body.Close()
+	if deleted.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 for DELETE, got %d", deleted.StatusCode)
+	}
+	if resp := post(t, server.URL, session, both, `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 after DELETE, got %d", resp.StatusCode)
+	}
+}
+
+func waitForStandalone(t *testing.T, s *streamableSession) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		open := s.closeStandalone != nil
+		s.mu.Unlock()
+		if open {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("GET stream did not open")
+}
@@ -0,0 +1,240 @@
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gnolledgegraph/internal/db"
+	"gnolledgegraph/internal/logs"
+)
+
+// Change notifications. A session whose transport can send is registered
+// with the resourceWatcher of its database at initialize. The watcher
+// turns committed writes, from any API, into
+// notifications/resources/updated for the URIs a session subscribed to,
+// and notifications/resources/list_changed when entities come or go.
+
+// resourceKey identifies what a resource URI addresses, regardless of
+// format: "graph", "type/<entityType>" or "entity/<name>".
+func resourceKey(kind, name string) string {
+	if kind == graphResource {
+		return graphResource
+	}
+	return kind + "/" + name
+}
+
+// resourceWatcher fans the changes of one database out to its sessions.
+type resourceWatcher struct {
+	database *sql.DB
+	stop     func()
+
+	mu       sync.Mutex
+	sessions map[*Session]bool
+}
+
+var (
+	resourceWatchersMu sync.Mutex
+	resourceWatchers   = make(map[*sql.DB]*resourceWatcher)
+)
+
+// watchResources sends session notifications about changes to database
+// until the session is closed.
+func watchResources(database *sql.DB, session *Session) error {
+	resourceWatchersMu.Lock()
+	defer resourceWatchersMu.Unlock()
+	w := resourceWatchers[database]
+	if w == nil {
+		w = &resourceWatcher{database: database, sessions: make(map[*Session]bool)}
+		stop, err := db.Watch(database, w.deliver)
+		if err != nil {
+			return err
+		}
+		w.stop = stop
+		resourceWatchers[database] = w
+	}
+	w.mu.Lock()
+	w.sessions[session] = true
+	w.mu.Unlock()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.unwatch = func() { unwatchResources(database, session) }
+	return nil
+}
+
+func unwatchResources(database *sql.DB, session *Session) {
+	resourceWatchersMu.Lock()
+	defer resourceWatchersMu.Unlock()
+	w := resourceWatchers[database]
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.sessions, session)
+	empty := len(w.sessions) == 0
+	w.mu.Unlock()
+	if empty {
+		w.stop()
+		delete(resourceWatchers, database)
+	}
+}
+
+// deliver works out which resources a change set touched and tells the
+// sessions that care.
+func (w *resourceWatcher) deliver(set db.ChangeSet) {
+	w.mu.Lock()
+	sessions := make([]*Session, 0, len(w.sessions))
+	for s := range w.sessions {
+		sessions = append(sessions, s)
+	}
+	w.mu.Unlock()
+
+	subscribed := make(map[*Session]map[string]string, len(sessions))
+	watchesTypes := false
+	for _, s := range sessions {
+		subscribed[s] = s.subscribed()
+		for _, key := range subscribed[s] {
+			watchesTypes = watchesTypes || strings.HasPrefix(key, typeResource+"/")
+		}
+	}
+
+	touched, listChanged := touchedResources(set)
+	if watchesTypes && !set.Reset {
+		if err := w.touchTypes(set, touched); err != nil {
+			logs.Log(logs.Error, "mcp", "resource notifications", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	for _, s := range sessions {
+		for uri, key := range subscribed[s] {
+			if set.Reset || touched[key] {
+				s.Notify("notifications/resources/updated", map[string]string{"uri": uri})
+			}
+		}
+		if listChanged {
+			s.Notify("notifications/resources/list_changed", nil)
+		}
+	}
+}
+
+// touchedResources returns the keys of the graph and entities a change
+// set touched, and whether the resource list changed.
+func touchedResources(set db.ChangeSet) (map[string]bool, bool) {
+	touched := make(map[string]bool)
+	listChanged := set.Reset
+	if len(set.Changes) > 0 {
+		touched[graphResource] = true
+	}
+	for _, c := range set.Changes {
+		touched[resourceKey(entityResource, c.EntityName)] = true
+		if c.RelatedEntity != "" {
+			touched[resourceKey(entityResource, c.RelatedEntity)] = true
+		}
+		switch c.Operation {
+		case db.OpCreateEntity, db.OpDeleteEntity, db.OpRestoreEntity, db.OpPurgeEntity:
+			listChanged = true
+		}
+	}
+	return touched, listChanged
+}
+
+// touchTypes adds the type resources of the entities a change set touched:
+// the types recorded in entity changes, and the current types of the
+// entities whose observations or relations changed.
+func (w *resourceWatcher) touchTypes(set db.ChangeSet, touched map[string]bool) error {
+	var names []string
+	for _, c := range set.Changes {
+		for _, row := range []json.RawMessage{c.Before, c.After} {
+			var e struct {
+				Type string `json:"entityType"`
+			}
+			if json.Unmarshal(row, &e) == nil && e.Type != "" {
+				touched[resourceKey(typeResource, e.Type)] = true
+			}
+		}
+		names = append(names, c.EntityName)
+		if c.RelatedEntity != "" {
+			names = append(names, c.RelatedEntity)
+		}
+	}
+	types, err := db.EntityTypesOf(w.database, names)
+	if err != nil {
+		return err
+	}
+	for _, t := range types {
+		touched[resourceKey(typeResource, t)] = true
+	}
+	return nil
+}
+
+// subscribed returns the session's subscribed URIs and their keys.
+func (s *Session) subscribed() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]string, len(s.subscriptions))
+	for uri, key := range s.subscriptions {
+		out[uri] = key
+	}
+	return out
+}
+
+func handleResourcesSubscribe(ctx context.Context, req JSONRPCRequest, subscribe bool) JSONRPCResponse {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := decodeParams(req.Params, &params); err != nil {
+		return invalidParams(req, err)
+	}
+	if params.URI == "" {
+		return invalidParams(req, fmt.Errorf("missing uri"))
+	}
+	kind, name, _, err := parseResourceURI(params.URI)
+	if errors.Is(err, errResourceNotFound) {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &JSONRPCError{
+				Code:    -32002,
+				Message: "Resource not found",
+				Data:    map[string]string{"uri": params.URI},
+			},
+		}
+	}
+	if err != nil {
+		return invalidParams(req, err)
+	}
+
+	session := sessionFrom(ctx)
+	if session == nil || session.send == nil {
+		return JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &JSONRPCError{
+				Code:    -32600,
+				Message: "Invalid Request: subscriptions need a session that can receive notifications",
+			},
+		}
+	}
+	session.mu.Lock()
+	if subscribe {
+		if session.subscriptions == nil {
+			session.subscriptions = make(map[string]string)
+		}
+		// Entities that do not exist yet can be subscribed to
+		session.subscriptions[params.URI] = resourceKey(kind, name)
+	} else {
+		delete(session.subscriptions, params.URI)
+	}
+	session.mu.Unlock()
+
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result:  struct{}{},
+	}
+}
@@ -0,0 +1,101 @@
+package mcp
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"gnolledgegraph/internal/db"
+)
+
+func TestResourceSubscriptions(t *testing.T) {
+	database := setupTestDB(t)
+	db.CreateEntity(database, "Alice", "person")
+
+	sent := make(chan JSONRPCNotification, 20)
+	session := NewSession(func(msg interface{}) error {
+		if n, ok := msg.(JSONRPCNotification); ok {
+			sent <- n
+		}
+		return nil
+	})
+	defer session.Close()
+	ctx := WithSession(context.Background(), session)
+	call := func(method string, params map[string]interface{}) JSONRPCResponse {
+		return HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	}
+	call("initialize", nil)
+	HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"})
+
+	for _, uri := range []string{"kg://entity/Alice", "kg://type/person?format=markdown", "kg://entity/Bob"} {
+		if response := call("resources/subscribe", map[string]interface{}{"uri": uri}); response.Error != nil {
+			t.Fatalf("subscribe %s failed: %+v", uri, response.Error)
+		}
+	}
+	if response := call("resources/subscribe", map[string]interface{}{"uri": "file:///etc/passwd"}); response.Error == nil || response.Error.Code != -32002 {
+		t.Errorf("Expected an unknown resource to be rejected, got %+v", response.Error)
+	}
+	// collect gathers the notifications sent for the next write
+	collect := func() []string {
+		t.Helper()
+		var got []string
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case n := <-sent:
+				entry := n.Method
+				if params, ok := n.Params.(map[string]string); ok {
+					entry += " " + params["uri"]
+				}
+				got = append(got, entry)
+			case <-time.After(200 * time.Millisecond):
+				sort.Strings(got)
+				return got
+			case <-timeout:
+				t.Fatal("timed out")
+			}
+		}
+	}
+
+	// A write made outside MCP reaches the subscribers
+	db.CreateObservation(database, "Alice", "likes tea")
+	got := collect()
+	want := []string{
+		"notifications/resources/updated kg://entity/Alice",
+		"notifications/resources/updated kg://type/person?format=markdown",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// New entities change the list; Bob is watched before he exists
+	db.CreateEntity(database, "Bob", "robot")
+	got = collect()
+	want = []string{
+		"notifications/resources/list_changed",
+		"notifications/resources/updated kg://entity/Bob",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	call("resources/unsubscribe", map[string]interface{}{"uri": "kg://entity/Alice"})
+	call("resources/unsubscribe", map[string]interface{}{"uri": "kg://type/person?format=markdown"})
+	db.CreateObservation(database, "Alice", "likes coffee")
+	if got := collect(); len(got) != 0 {
+		t.Errorf("Expected no notifications after unsubscribing, got %v", got)
+	}
+
+	// Stateless requests cannot subscribe
+	if response := HandleJSONRPCMethod(database, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "resources/subscribe", Params: map[string]interface{}{"uri": "kg://graph"}}); response.Error == nil {
+		t.Error("Expected a stateless subscribe to fail")
+	}
+
+	// Closing the session stops the notifications
+	session.Close()
+	db.CreateEntity(database, "Carol", "person")
+	if got := collect(); len(got) != 0 {
+		t.Errorf("Expected no notifications after close, got %v", got)
+	}
+}
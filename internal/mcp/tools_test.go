@@ -0,0 +1,280 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gnolledgegraph/internal/db"
+)
+
+// conforms checks value, decoded from JSON, against the parts of JSON Schema
+// the tool list uses.
+func conforms(schema Property, value interface{}) error {
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("want object, got %T", value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("missing required %q", name)
+			}
+		}
+		for name, v := range obj {
+			p, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					return fmt.Errorf("unexpected property %q", name)
+				}
+				continue
+			}
+			if err := conforms(p, v); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("want array, got %T", value)
+		}
+		for i, v := range arr {
+			if err := conforms(*schema.Items, v); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("want string, got %T", value)
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("want number, got %T", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("want boolean, got %T", value)
+		}
+	}
+	return nil
+}
+
+func listTools(t *testing.T, ctx context.Context) map[string]Tool {
+	t.Helper()
+	response := HandleJSONRPCMethodContext(ctx, nil, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	if response.Error != nil {
+		t.Fatalf("tools/list failed: %+v", response.Error)
+	}
+	tools := make(map[string]Tool)
+	for _, tool := range response.Result.(ToolsListResult).Tools {
+		tools[tool.Name] = tool
+	}
+	return tools
+}
+
+func TestToolSchemas(t *testing.T) {
+	for name, tool := range listTools(t, context.Background()) {
+		if tool.OutputSchema == nil || tool.OutputSchema.Type != "object" {
+			t.Errorf("%s: expected an object output schema, got %+v", name, tool.OutputSchema)
+		}
+		for arg, p := range tool.InputSchema.Properties {
+			if p.Type == "array" && p.Items == nil {
+				t.Errorf("%s: array argument %s has no items schema", name, arg)
+			}
+		}
+	}
+
+	// Arguments are validated against the input schema by the client
+	tools := listTools(t, context.Background())
+	entities := tools["create_entities"].InputSchema.Properties["entities"]
+	valid := []interface{}{map[string]interface{}{"name": "Alice", "entityType": "person", "observations": []interface{}{"likes tea"}}}
+	if err := conforms(entities, valid); err != nil {
+		t.Errorf("Expected valid entities to conform: %v", err)
+	}
+	misspelled := []interface{}{map[string]interface{}{"name": "Alice", "entity_type": "person"}}
+	if err := conforms(entities, misspelled); err == nil {
+		t.Error("Expected entities with a misspelled field to be rejected")
+	}
+	if direction := tools["traverse_graph"].InputSchema.Properties["direction"]; len(direction.Enum) != 3 {
+		t.Errorf("Expected direction to be an enum, got %+v", direction)
+	}
+
+	// Clients on older protocol versions do not get output schemas
+	session := NewSession(nil)
+	session.start(InitializeRequest{ProtocolVersion: ProtocolVersion20250326})
+	session.initialized = true
+	for name, tool := range listTools(t, WithSession(context.Background(), session)) {
+		if tool.OutputSchema != nil {
+			t.Errorf("%s: expected no output schema on %s", name, ProtocolVersion20250326)
+		}
+	}
+}
+
+func TestStructuredToolOutput(t *testing.T) {
+	database := setupTestDB(t)
+	tools := listTools(t, context.Background())
+
+	call := func(ctx context.Context, name string, arguments map[string]interface{}) ToolCallResult {
+		t.Helper()
+		response := HandleJSONRPCMethodContext(ctx, database, JSONRPCRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "tools/call",
+			Params:  map[string]interface{}{"name": name, "arguments": arguments},
+		})
+		if response.Error != nil {
+			t.Fatalf("%s failed: %+v", name, response.Error)
+		}
+		result := response.Result.(ToolCallResult)
+		if result.IsError {
+			t.Fatalf("%s returned an error: %s", name, result.Content[0].Text)
+		}
+		return result
+	}
+
+	calls := []struct {
+		name      string
+		arguments map[string]interface{}
+		// textIsJSON is set for tools whose text is the structured content
+		textIsJSON bool
+	}{
+		{"read_graph", map[string]interface{}{}, true},
+		{"create_entities", map[string]interface{}{"entities": []interface{}{
+			map[string]interface{}{"name": "Alice", "entityType": "person", "observations": []interface{}{"likes tea"}},
+			map[string]interface{}{"name": "Acme", "entityType": "organization"},
+			map[string]interface{}{"name": "Bob", "entityType": "person"},
+		}}, false},
+		{"create_relations", map[string]interface{}{"relations": []interface{}{
+			map[string]interface{}{"from": "Alice", "to": "Acme", "relationType": "works_at"},
+			map[string]interface{}{"from": "Bob", "to": "Acme", "relationType": "works_at"},
+		}}, false},
+		{"add_observations", map[string]interface{}{"observations": []interface{}{
+			map[string]interface{}{"entityName": "Acme", "contents": "makes anvils"},
+		}}, false},
+		{"consolidate_entity", map[string]interface{}{"name": "Acme"}, false},
+		{"read_graph", map[string]interface{}{}, true},
+		{"search_nodes", map[string]interface{}{"query": "Acme"}, true},
+		{"open_nodes", map[string]interface{}{"names": []interface{}{"Alice", "Nobody"}}, true},
+		{"traverse_graph", map[string]interface{}{"names": []interface{}{"Alice"}, "depth": 2.0}, true},
+		{"find_path", map[string]interface{}{"from": "Alice", "to": "Bob"}, true},
+		{"find_path", map[string]interface{}{"from": "Alice", "to": "Alice"}, true},
+		{"delete_observations", map[string]interface{}{"deletions": []interface{}{
+			map[string]interface{}{"entityName": "Alice", "observations": []interface{}{"likes tea"}},
+		}}, false},
+		{"delete_relations", map[string]interface{}{"relations": []interface{}{
+			map[string]interface{}{"from": "Bob", "to": "Acme", "relationType": "works_at"},
+		}}, false},
+		{"delete_entities", map[string]interface{}{"entityNames": []interface{}{"Bob"}}, false},
+		{"restore_entities", map[string]interface{}{"entityNames": []interface{}{"Bob"}}, true},
+		{"entity_history", map[string]interface{}{"name": "Alice"}, true},
+		{"entity_history", map[string]interface{}{"name": "Nobody"}, true},
+	}
+	for _, c := range calls {
+		result := call(context.Background(), c.name, c.arguments)
+		if result.StructuredContent == nil {
+			t.Errorf("%s: expected structured content", c.name)
+			continue
+		}
+		data, err := json.Marshal(result.StructuredContent)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var structured interface{}
+		json.Unmarshal(data, &structured)
+		if err := conforms(*tools[c.name].OutputSchema, structured); err != nil {
+			t.Errorf("%s: structured content %s does not match the output schema: %v", c.name, data, err)
+		}
+		if c.textIsJSON && result.Content[0].Text != string(data) {
+			t.Errorf("%s: expected the text to be the structured content, got %s", c.name, result.Content[0].Text)
+		}
+	}
+
+	// Clients on older protocol versions only get text
+	session := NewSession(nil)
+	session.start(InitializeRequest{ProtocolVersion: ProtocolVersion20250326})
+	session.initialized = true
+	result := call(WithSession(context.Background(), session), "read_graph", map[string]interface{}{})
+	if result.StructuredContent != nil || result.Content[0].Text == "" {
+		t.Errorf("Expected only text content on %s, got %+v", ProtocolVersion20250326, result)
+	}
+
+	// Errors stay text
+	response := HandleJSONRPCMethod(database, JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "entity_history", "arguments": map[string]interface{}{}},
+	})
+	if result := response.Result.(ToolCallResult); !result.IsError || result.StructuredContent != nil {
+		t.Errorf("Expected a text error, got %+v", result)
+	}
+
+	var graph struct {
+		Entities []db.Entity `json:"entities"`
+	}
+	data, _ := json.Marshal(call(context.Background(), "read_graph", map[string]interface{}{}).StructuredContent)
+	json.Unmarshal(data, &graph)
+	if len(graph.Entities) != 3 {
+		t.Errorf("Expected 3 entities in the structured graph, got %+v", graph.Entities)
+	}
+}
+
+func TestToolAnnotations(t *testing.T) {
+	for name, tool := range listTools(t, context.Background()) {
+		a := tool.Annotations
+		if a == nil || a.ReadOnlyHint == nil || a.Title == "" {
+			t.Errorf("%s: expected annotations, got %+v", name, a)
+			continue
+		}
+		destructive := a.DestructiveHint != nil && *a.DestructiveHint
+		if wantDestructive := strings.HasPrefix(name, "delete_") || name == "consolidate_entity"; destructive != wantDestructive {
+			t.Errorf("%s: expected destructiveHint %v", name, wantDestructive)
+		}
+	}
+	tools := listTools(t, context.Background())
+	if search := tools["search_nodes"].Annotations; !*search.ReadOnlyHint || search.DestructiveHint != nil {
+		t.Errorf("Expected search_nodes to be read-only, got %+v", search)
+	}
+
+	// Annotations are new in 2025-03-26
+	session := NewSession(nil)
+	session.start(InitializeRequest{ProtocolVersion: ProtocolVersion20241105})
+	session.initialized = true
+	for name, tool := range listTools(t, WithSession(context.Background(), session)) {
+		if tool.Annotations != nil {
+			t.Errorf("%s: expected no annotations on %s", name, ProtocolVersion20241105)
+		}
+	}
+}
+
+func TestCreateToolsRetry(t *testing.T) {
+	database := setupTestDB(t)
+	entities := map[string]interface{}{"entities": []interface{}{
+		map[string]interface{}{"name": "Alice", "entityType": "person", "observations": []interface{}{"likes tea"}},
+		map[string]interface{}{"name": "Acme", "entityType": "organization"},
+	}}
+	for i, want := range []string{"[Alice Acme]", "[]"} {
+		result := callTool(context.Background(), database, "create_entities", entities)
+		if created := fmt.Sprint(result.StructuredContent.(map[string]interface{})["created"]); created != want {
+			t.Errorf("Call %d: expected created %s, got %s", i+1, want, created)
+		}
+	}
+
+	relations := map[string]interface{}{"relations": []interface{}{
+		map[string]interface{}{"from": "Alice", "to": "Acme", "relationType": "works_at"},
+	}}
+	first := callTool(context.Background(), database, "create_relations", relations)
+	second := callTool(context.Background(), database, "create_relations", relations)
+	if ids := fmt.Sprint(second.StructuredContent.(map[string]interface{})["relationIds"]); ids != fmt.Sprint(first.StructuredContent.(map[string]interface{})["relationIds"]) {
+		t.Errorf("Expected a retried create_relations to return the same IDs, got %s", ids)
+	}
+
+	// Retrying adds nothing twice
+	_, graphRelations, observations, _ := db.ReadGraph(database)
+	if len(graphRelations) != 1 || len(observations) != 1 {
+		t.Errorf("Expected one relation and one observation after retries, got %+v %+v", graphRelations, observations)
+	}
+}